@@ -1,9 +1,12 @@
 package gqt_test
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/graph-guard/gqt/v4"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/parser"
 )
 
 func Benchmark(b *testing.B) {
@@ -45,3 +48,144 @@ func Benchmark(b *testing.B) {
 		}
 	}
 }
+
+var benchMatchTemplate = []byte(`query {
+	user(id: *) {
+		name
+		max 2 {
+			email
+			phone
+			address
+		}
+		orders(
+			after: *,
+			limit = $limitOrders: <= 100,
+			filter: {
+				status: ACTIVE || PENDING,
+				tags: [ ... len <= 16 ],
+			},
+		) {
+			id
+			created
+			... on DirectOrder {
+				status
+			}
+			items(after: *, limit: > 0 && < 1000 / $limitOrders) {
+				id
+				title
+			}
+		}
+	}
+}`)
+
+const benchMatchQuery = `query ($id: ID!, $limit: Int, $tags: [String!]) {
+	user(id: $id) {
+		name
+		email
+		phone
+		orders(after: "abc", limit: $limit, filter: {
+			status: ACTIVE,
+			tags: $tags,
+		}) {
+			id
+			created
+			... on DirectOrder {
+				status
+			}
+			items(after: "xyz", limit: 9) {
+				id
+				title
+			}
+		}
+	}
+}`
+
+var benchMatchVariables = map[string]any{
+	"id":    "user-42",
+	"limit": 100,
+	"tags":  []any{"new", "urgent", "gift"},
+}
+
+func BenchmarkMatch(b *testing.B) {
+	opr, _, errs := gqt.Parse(benchMatchTemplate)
+	if len(errs) > 0 {
+		b.Fatal(errs)
+	}
+	opr = gqt.Optimize(opr).(*gqt.Operation)
+	doc, err := parser.ParseQuery(&ast.Source{Input: benchMatchQuery})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("tree", func(b *testing.B) {
+		b.ReportAllocs()
+		for n := 0; n < b.N; n++ {
+			if ok, err := gqt.Match(opr, doc, "", benchMatchVariables); !ok {
+				b.Fatal("unexpected mismatch", err)
+			}
+		}
+	})
+
+	b.Run("program", func(b *testing.B) {
+		p, err := gqt.Compile(opr)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.ReportAllocs()
+		b.ResetTimer()
+		for n := 0; n < b.N; n++ {
+			if ok, err := p.Match(doc, "", benchMatchVariables); !ok {
+				b.Fatal("unexpected mismatch", err)
+			}
+		}
+	})
+}
+
+func BenchmarkCompile(b *testing.B) {
+	opr, _, errs := gqt.Parse(benchMatchTemplate)
+	if len(errs) > 0 {
+		b.Fatal(errs)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if _, err := gqt.Compile(opr); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkTemplateSet(b *testing.B) {
+	s, err := gqt.NewTemplateSet()
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < 5000; i++ {
+		src := fmt.Sprintf(
+			`query { field%d(id: *) { id name max 1 { a%d b%d } } }`,
+			i, i, i,
+		)
+		opr, _, errs := gqt.Parse([]byte(src))
+		if len(errs) > 0 {
+			b.Fatal(errs)
+		}
+		if err := s.Add(opr); err != nil {
+			b.Fatal(err)
+		}
+	}
+	doc, err := parser.ParseQuery(&ast.Source{
+		Input: `{ field4200(id: 42) { id name b4200 } }`,
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		m, err := s.Match(doc, "", nil)
+		if err != nil || len(m) != 1 {
+			b.Fatal("unexpected result", m, err)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// frame is a block enclosing the completion position.
+type frame struct {
+	// kind is either of:
+	//
+	//   • '{' selection set of typ
+	//   • '(' argument list of field
+	//   • 'o' input object of type typ
+	//   • '[' array of items of type typ
+	//   • 'p' parenthesized expression
+	kind  byte
+	typ   *ast.Definition
+	field *ast.FieldDefinition
+
+	// key is the name of the last field selected in a selection set
+	// or the last argument or object field name in a list or object.
+	key string
+
+	// typeCond is true if key is the type condition of an inline fragment.
+	typeCond bool
+
+	// max is true if key is followed by a number, e.g. "max 2",
+	// which makes it the keyword of a max, min or exactly set.
+	max bool
+}
+
+// complete returns the completion items for byte offset i of text.
+// The enclosing blocks are determined lexically, which makes completion
+// work in templates that don't parse yet.
+func (s *server) complete(text string, i int) []completionItem {
+	items := []completionItem{}
+	if s.schema == nil {
+		return items
+	}
+
+	// Skip the name that's being typed
+	for i > 0 && isNameByte(text[i-1]) {
+		i--
+	}
+
+	var stack []*frame
+	top := func() *frame {
+		if len(stack) < 1 {
+			return nil
+		}
+		return stack[len(stack)-1]
+	}
+	var root *ast.Definition // Type of the operation or fragment
+	var prev string
+	for j := 0; j < i; {
+		c := text[j]
+		tok := text[j : j+1]
+		switch {
+		case c == '#':
+			for j < i && text[j] != '\n' {
+				j++
+			}
+			continue
+		case c == '"' && strings.HasPrefix(text[j:], `"""`):
+			// Block string
+			for j += 3; j < i && !strings.HasPrefix(text[j:], `"""`); j++ {
+				if strings.HasPrefix(text[j:], `\"""`) {
+					j += 3
+				}
+			}
+			j += 3
+			prev = `"`
+			continue
+		case c == '"':
+			for j++; j < i && text[j] != '"' && text[j] != '\n'; j++ {
+				if text[j] == '\\' {
+					j++
+				}
+			}
+			j++
+			prev = `"`
+			continue
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			j++
+			continue
+		case isNameByte(c) && (c < '0' || c > '9'):
+			k := j
+			for k < i && isNameByte(text[k]) {
+				k++
+			}
+			tok = text[j:k]
+			j = k
+			f := top()
+			switch {
+			case f == nil && prev == "on":
+				root = s.schema.Types[tok]
+			case f == nil && (tok == "meta" || tok == "constraint"):
+				root = nil
+			case f == nil:
+				if prev != "fragment" && isOperationKeyword(tok) {
+					root = s.operationType(tok)
+				}
+			case f.kind == '{':
+				f.typeCond = prev == "on"
+				f.max = false
+				if !(prev == "..." && tok == "on") {
+					f.key = tok
+				}
+			case f.kind == '(' || f.kind == 'o':
+				if prev == "(" || prev == "{" || prev == "," {
+					f.key = tok
+				}
+			}
+			prev = tok
+			continue
+		case c >= '0' && c <= '9':
+			for j < i && (isNameByte(text[j]) || text[j] == '.') {
+				j++
+			}
+			if f := top(); f != nil && f.kind == '{' &&
+				(f.key == "max" || f.key == "min" || f.key == "exactly") {
+				f.max = true
+			}
+			prev = "0"
+			continue
+		case c == '.' && j+2 < len(text) && text[j:j+3] == "...":
+			tok = "..."
+			j += 3
+			prev = tok
+			continue
+		}
+		j++
+		prev = tok
+
+		f := top()
+		switch c {
+		case '{':
+			switch {
+			case f == nil:
+				stack = append(stack, &frame{kind: '{', typ: root})
+			case f.kind == '{':
+				n := &frame{kind: '{'}
+				if f.typeCond {
+					n.typ = s.schema.Types[f.key]
+				} else if f.max {
+					n.typ = f.typ
+				} else if d := fieldDef(f.typ, f.key); d != nil {
+					n.typ = s.schema.Types[d.Type.Name()]
+				}
+				stack = append(stack, n)
+			default:
+				stack = append(stack, &frame{kind: 'o', typ: s.valueType(f)})
+			}
+		case '(':
+			if f != nil && f.kind == '{' {
+				stack = append(stack, &frame{
+					kind: '(', field: fieldDef(f.typ, f.key),
+				})
+			} else {
+				stack = append(stack, &frame{kind: 'p'})
+			}
+		case '[':
+			stack = append(stack, &frame{kind: '[', typ: s.valueType(f)})
+		case '}', ')', ']':
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		}
+	}
+
+	f := top()
+	if f == nil {
+		return items
+	}
+	switch f.kind {
+	case '{':
+		if f.typ == nil || prev == "on" || prev == "..." {
+			break
+		}
+		for _, d := range f.typ.Fields {
+			if len(d.Name) > 1 && d.Name[:2] == "__" {
+				continue
+			}
+			items = append(items, completionItem{
+				Label:         d.Name,
+				Kind:          completionKindField,
+				Detail:        d.Type.String(),
+				Documentation: d.Description,
+			})
+		}
+	case '(':
+		if f.field == nil || (prev != "(" && prev != ",") {
+			break
+		}
+		for _, d := range f.field.Arguments {
+			items = append(items, completionItem{
+				Label:         d.Name,
+				Kind:          completionKindProperty,
+				Detail:        d.Type.String(),
+				Documentation: d.Description,
+			})
+		}
+	case 'o':
+		if f.typ == nil || (prev != "{" && prev != ",") {
+			break
+		}
+		for _, d := range f.typ.Fields {
+			items = append(items, completionItem{
+				Label:         d.Name,
+				Kind:          completionKindProperty,
+				Detail:        d.Type.String(),
+				Documentation: d.Description,
+			})
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Label < items[j].Label
+	})
+	return items
+}
+
+// operationType returns the root type of operation kind o.
+func (s *server) operationType(o string) *ast.Definition {
+	switch o {
+	case "query":
+		return s.schema.Query
+	case "mutation":
+		return s.schema.Mutation
+	case "subscription":
+		return s.schema.Subscription
+	}
+	return nil
+}
+
+// valueType returns the type of the values of the current argument or
+// object field of f, or the item type if f is an array.
+func (s *server) valueType(f *frame) *ast.Definition {
+	if f == nil {
+		return nil
+	}
+	switch f.kind {
+	case '(':
+		if f.field != nil {
+			if a := f.field.Arguments.ForName(f.key); a != nil {
+				return s.schema.Types[a.Type.Name()]
+			}
+		}
+	case 'o':
+		if d := fieldDef(f.typ, f.key); d != nil {
+			return s.schema.Types[d.Type.Name()]
+		}
+	case '[':
+		return f.typ
+	}
+	return nil
+}
+
+func fieldDef(t *ast.Definition, name string) *ast.FieldDefinition {
+	if t == nil {
+		return nil
+	}
+	return t.Fields.ForName(name)
+}
+
+func isOperationKeyword(s string) bool {
+	return s == "query" || s == "mutation" || s == "subscription"
+}
+
+func isNameByte(b byte) bool {
+	return b == '_' ||
+		(b >= 'a' && b <= 'z') ||
+		(b >= 'A' && b <= 'Z') ||
+		(b >= '0' && b <= '9')
+}
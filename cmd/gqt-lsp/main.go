@@ -0,0 +1,433 @@
+// Command gqt-lsp is a Language Server Protocol server for GQT templates
+// communicating over the standard input and output.
+//
+// Usage:
+//
+//	gqt-lsp [-schema file]...
+//
+// The server publishes the errors of the templates as diagnostics,
+// shows the schema types of fields, arguments, object fields and variables
+// on hover, finds the declarations of variables and completes
+// the names of fields, arguments and object fields according to the schema.
+// Files imported by templates are resolved relative to their directory.
+// All templates are parsed in schemaless mode if no schema is provided.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/graph-guard/gqt/v4"
+	gqlparser "github.com/vektah/gqlparser/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run runs the server with args until the client requests an exit
+// and returns the exit code.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	var schemas []string
+	flags := flag.NewFlagSet("gqt-lsp", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Func(
+		"schema", "GraphQL schema file (repeatable)",
+		func(s string) error {
+			schemas = append(schemas, s)
+			return nil
+		},
+	)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	s, err := newServer(schemas, stdout)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	if err := s.serve(bufio.NewReader(stdin)); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	if !s.shutdown {
+		// The client exited without requesting a shutdown
+		return 1
+	}
+	return 0
+}
+
+type server struct {
+	parser   *gqt.Parser
+	schema   *ast.Schema
+	docs     map[string]*document
+	out      io.Writer
+	shutdown bool
+}
+
+// document is an open template.
+type document struct {
+	text string
+
+	// operations is nil if the template is invalid.
+	operations []*gqt.Operation
+}
+
+// newServer creates a new server parsing templates according to
+// the schema files (if any) and writing messages to out.
+func newServer(schemaFiles []string, out io.Writer) (*server, error) {
+	sources := make([]gqt.Source, len(schemaFiles))
+	for i, name := range schemaFiles {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		sources[i] = gqt.Source{Name: name, Content: string(b)}
+	}
+	p, err := gqt.NewParser(sources)
+	if err != nil {
+		return nil, err
+	}
+	s := &server{parser: p, docs: map[string]*document{}, out: out}
+	if len(sources) > 0 {
+		in := make([]*ast.Source, len(sources))
+		for i, src := range sources {
+			in[i] = &ast.Source{Name: src.Name, Input: src.Content}
+		}
+		if s.schema, err = gqlparser.LoadSchema(in...); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// serve handles messages until the client requests an exit.
+func (s *server) serve(r *bufio.Reader) error {
+	for {
+		m, err := readMessage(r)
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if m == nil {
+			return err
+		} else if err != nil {
+			if err := s.respondErr(nil, codeParseError, err.Error()); err != nil {
+				return err
+			}
+			continue
+		}
+		if m.Method == "exit" {
+			return nil
+		}
+		result, rerr := s.handleRecover(m)
+		if m.ID == nil {
+			// Notifications aren't responded to
+			continue
+		}
+		if rerr != nil {
+			err = s.respondErr(m.ID, rerr.Code, rerr.Message)
+		} else {
+			err = writeMessage(s.out, &message{
+				ID: m.ID, Result: mustMarshal(result),
+			})
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *server) respondErr(id *json.RawMessage, code int, msg string) error {
+	return writeMessage(s.out, &message{
+		ID:    id,
+		Error: &responseError{Code: code, Message: msg},
+	})
+}
+
+// handleRecover calls handle and turns a panic into an internal error,
+// which keeps the server running when a request can't be handled.
+func (s *server) handleRecover(m *message) (result any, rerr *responseError) {
+	defer func() {
+		if r := recover(); r != nil {
+			result, rerr = nil, &responseError{
+				Code:    codeInternalError,
+				Message: fmt.Sprintf("internal error: %v", r),
+			}
+		}
+	}()
+	return s.handle(m)
+}
+
+// handle handles m and returns the result of the request.
+func (s *server) handle(m *message) (any, *responseError) {
+	if s.shutdown && m.ID != nil {
+		return nil, &responseError{
+			Code:    codeInvalidRequest,
+			Message: "server is shut down",
+		}
+	}
+	decode := func(params any) *responseError {
+		if err := json.Unmarshal(m.Params, params); err != nil {
+			return &responseError{Code: codeInvalidParams, Message: err.Error()}
+		}
+		return nil
+	}
+
+	switch m.Method {
+	case "initialize":
+		return map[string]any{
+			"capabilities": map[string]any{
+				"textDocumentSync":   1, // Full
+				"hoverProvider":      true,
+				"definitionProvider": true,
+				"completionProvider": map[string]any{
+					"triggerCharacters": []string{"{", "(", ","},
+				},
+			},
+			"serverInfo": map[string]any{"name": "gqt-lsp"},
+		}, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		var p didOpenParams
+		if err := decode(&p); err != nil {
+			return nil, err
+		}
+		s.update(p.TextDocument.URI, p.TextDocument.Text)
+		return nil, nil
+	case "textDocument/didChange":
+		var p didChangeParams
+		if err := decode(&p); err != nil {
+			return nil, err
+		}
+		if l := len(p.ContentChanges); l > 0 {
+			s.update(p.TextDocument.URI, p.ContentChanges[l-1].Text)
+		}
+		return nil, nil
+	case "textDocument/didClose":
+		var p didCloseParams
+		if err := decode(&p); err != nil {
+			return nil, err
+		}
+		delete(s.docs, p.TextDocument.URI)
+		s.publishDiagnostics(p.TextDocument.URI, "", nil)
+		return nil, nil
+	case "textDocument/hover":
+		var p textDocumentPositionParams
+		if err := decode(&p); err != nil {
+			return nil, err
+		}
+		if h := s.hover(p); h != nil {
+			return h, nil
+		}
+		return nil, nil
+	case "textDocument/definition":
+		var p textDocumentPositionParams
+		if err := decode(&p); err != nil {
+			return nil, err
+		}
+		if l := s.definition(p); l != nil {
+			return l, nil
+		}
+		return nil, nil
+	case "textDocument/completion":
+		var p textDocumentPositionParams
+		if err := decode(&p); err != nil {
+			return nil, err
+		}
+		d := s.docs[p.TextDocument.URI]
+		if d == nil {
+			return []completionItem{}, nil
+		}
+		return s.complete(d.text, offsetOf(d.text, p.Position)), nil
+	case "initialized", "$/cancelRequest", "$/setTrace",
+		"workspace/didChangeConfiguration":
+		return nil, nil
+	}
+	if m.ID == nil {
+		// Unknown notifications are ignored
+		return nil, nil
+	}
+	return nil, &responseError{
+		Code:    codeMethodNotFound,
+		Message: fmt.Sprintf("method %q not found", m.Method),
+	}
+}
+
+// update parses the new text of document uri and publishes its errors.
+// Imports are resolved in the directory of the document.
+func (s *server) update(uri, text string) {
+	defer func() {
+		if r := recover(); r != nil {
+			// Report the failure instead of the stale errors
+			s.docs[uri] = &document{text: text}
+			s.publishDiagnostics(uri, text, []gqt.Error{{
+				LocRange: gqt.LocRange{
+					Location:    gqt.Location{Line: 1, Column: 1},
+					LocationEnd: gqt.LocationEnd{LineEnd: 1, ColumnEnd: 1},
+				},
+				Msg: fmt.Sprintf("internal error: %v", r),
+			}})
+		}
+	}()
+	s.parser.SetFS(dirFS(uri))
+	doc, errs := s.parser.ParseDocument([]byte(text))
+	d := &document{text: text}
+	if doc != nil {
+		d.operations = doc.Operations
+	}
+	s.docs[uri] = d
+	s.publishDiagnostics(uri, text, errs)
+}
+
+// dirFS returns the file system of the directory of the file uri
+// or nil if uri isn't a file URI.
+func dirFS(uri string) fs.FS {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return nil
+	}
+	return os.DirFS(filepath.Dir(filepath.FromSlash(u.Path)))
+}
+
+func (s *server) publishDiagnostics(uri, text string, errs []gqt.Error) {
+	d := make([]diagnostic, len(errs))
+	for i, err := range errs {
+		r, msg := rangeOf(text, err.LocRange), err.Msg
+		if err.File != "" {
+			// Errors in imported files are reported
+			// at the start of the document
+			r, msg = rangeLSP{}, err.Error()
+		}
+		d[i] = diagnostic{
+			Range:    r,
+			Severity: severityError,
+			Source:   "gqt",
+			Message:  msg,
+		}
+	}
+	_ = writeMessage(s.out, &message{
+		Method: "textDocument/publishDiagnostics",
+		Params: mustMarshal(publishDiagnosticsParams{
+			URI: uri, Diagnostics: d,
+		}),
+	})
+}
+
+func mustMarshal(v any) json.RawMessage {
+	b, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// hover returns the schema type of the field, argument, object field or
+// variable at the position or nil if there's none.
+func (s *server) hover(p textDocumentPositionParams) *hover {
+	d := s.docs[p.TextDocument.URI]
+	if d == nil {
+		return nil
+	}
+	offset := offsetOf(d.text, p.Position)
+	var name gqt.Name
+	var t *ast.Type
+	var description string
+	switch e := d.find(offset).(type) {
+	case *gqt.SelectionField:
+		if e.Def == nil {
+			return nil
+		}
+		name, t, description = e.Name, e.Def.Type, e.Def.Description
+	case *gqt.Argument:
+		if e.Def == nil {
+			return nil
+		}
+		name, t, description = e.Name, e.Def.Type, e.Def.Description
+	case *gqt.ObjectField:
+		if e.Def == nil {
+			return nil
+		}
+		name, t, description = e.Name, e.Def.Type, e.Def.Description
+	case *gqt.Variable:
+		if t, _ = e.Declaration.GetInfo(); t == nil {
+			return nil
+		}
+		name = e.Name
+		name.Name = "$" + name.Name
+		name.LocRange = e.LocRange
+	default:
+		return nil
+	}
+	v := "```graphql\n" + name.Name + ": " + t.String() + "\n```"
+	if description != "" {
+		v += "\n\n" + description
+	}
+	return &hover{
+		Contents: markupContent{Kind: "markdown", Value: v},
+		Range:    rangeOf(d.text, name.LocRange),
+	}
+}
+
+// definition returns the location of the declaration of the variable
+// at the position or nil if there's none.
+func (s *server) definition(p textDocumentPositionParams) *location {
+	d := s.docs[p.TextDocument.URI]
+	if d == nil {
+		return nil
+	}
+	v, ok := d.find(offsetOf(d.text, p.Position)).(*gqt.Variable)
+	if !ok || v.Declaration == nil {
+		return nil
+	}
+	return &location{
+		URI:   p.TextDocument.URI,
+		Range: rangeOf(d.text, v.Declaration.LocRange),
+	}
+}
+
+// find returns the innermost field, argument, object field or variable
+// of the operations of d containing byte offset i, or nil if there's none.
+func (d *document) find(i int) (found gqt.Expression) {
+	for _, o := range d.operations {
+		if gqt.Walk(o, finder{offset: i, found: &found}); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// finder is a gqt.Visitor looking for the innermost expression
+// containing offset.
+type finder struct {
+	offset int
+	found  *gqt.Expression
+}
+
+func (f finder) Enter(e gqt.Expression) bool {
+	if l := e.GetLocation(); f.offset < l.Index || f.offset > l.IndexEnd {
+		return false
+	}
+	switch e := e.(type) {
+	case *gqt.FragmentDefinition:
+		// Locations in imported definitions refer to other files
+		return e.File == ""
+	case *gqt.ConstrAlias:
+		return e.File == ""
+	case *gqt.SelectionField, *gqt.Argument,
+		*gqt.ObjectField, *gqt.Variable:
+		*f.found = e
+	}
+	return true
+}
+
+func (f finder) Leave(gqt.Expression) {}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/graph-guard/gqt/v4"
+	"github.com/stretchr/testify/require"
+)
+
+const testSchema = `type Query {
+  "Finds a user by id"
+  user(id: ID!, filter: Filter): User
+  users(limit: Int, after: ID): [User!]!
+}
+type User { id: ID! name: String friends: [User!]! }
+input Filter { name: String age: Int }
+`
+
+const testURI = "file:///t.gqt"
+
+// session runs the server with the given schema processing the requests
+// and returns all messages written by the server.
+func session(t *testing.T, schema string, requests ...any) []message {
+	var args []string
+	if schema != "" {
+		p := filepath.Join(t.TempDir(), "schema.graphqls")
+		require.NoError(t, os.WriteFile(p, []byte(schema), 0o644))
+		args = []string{"-schema", p}
+	}
+	var in, out, errOut bytes.Buffer
+	requests = append(requests, request(0, "shutdown", nil), notify("exit", nil))
+	for _, r := range requests {
+		b, err := json.Marshal(r)
+		require.NoError(t, err)
+		fmt.Fprintf(&in, "Content-Length: %d\r\n\r\n%s", len(b), b)
+	}
+	require.Equal(t, 0, run(args, &in, &out, &errOut))
+	require.Zero(t, errOut.String())
+
+	var messages []message
+	r := bufio.NewReader(&out)
+	for {
+		m, err := readMessage(r)
+		if err != nil {
+			break
+		}
+		messages = append(messages, *m)
+	}
+	return messages
+}
+
+func itoa(i int) string {
+	b, _ := json.Marshal(i)
+	return string(b)
+}
+
+func request(id int, method string, params any) map[string]any {
+	return map[string]any{
+		"jsonrpc": "2.0", "id": id, "method": method, "params": params,
+	}
+}
+
+func notify(method string, params any) map[string]any {
+	return map[string]any{"jsonrpc": "2.0", "method": method, "params": params}
+}
+
+func open(text string) map[string]any {
+	return notify("textDocument/didOpen", map[string]any{
+		"textDocument": map[string]any{"uri": testURI, "text": text},
+	})
+}
+
+func at(id int, method string, line, character int) map[string]any {
+	return request(id, method, map[string]any{
+		"textDocument": map[string]any{"uri": testURI},
+		"position":     map[string]any{"line": line, "character": character},
+	})
+}
+
+// response returns the result of the response to request id.
+func response(t *testing.T, messages []message, id int, result any) {
+	for _, m := range messages {
+		if m.ID != nil && string(*m.ID) == itoa(id) {
+			require.Nil(t, m.Error, "unexpected error")
+			require.NoError(t, json.Unmarshal(m.Result, result))
+			return
+		}
+	}
+	t.Fatalf("no response to request %d", id)
+}
+
+func labels(items []completionItem) []string {
+	l := make([]string, len(items))
+	for i, x := range items {
+		l[i] = x.Label
+	}
+	return l
+}
+
+func TestInitialize(t *testing.T) {
+	m := session(t, "", request(1, "initialize", map[string]any{}))
+	var r struct {
+		Capabilities map[string]any `json:"capabilities"`
+	}
+	response(t, m, 1, &r)
+	require.Equal(t, true, r.Capabilities["hoverProvider"])
+	require.Equal(t, true, r.Capabilities["definitionProvider"])
+	require.Contains(t, r.Capabilities, "completionProvider")
+}
+
+func TestDiagnostics(t *testing.T) {
+	m := session(t, testSchema,
+		open("query {\n  user(id: *) { unknown }\n  users(limit: \"ü\" }"),
+	)
+	require.Equal(t, "textDocument/publishDiagnostics", m[0].Method)
+	var p publishDiagnosticsParams
+	require.NoError(t, json.Unmarshal(m[0].Params, &p))
+	require.Equal(t, testURI, p.URI)
+	require.Equal(t, []diagnostic{
+		{
+			Range: rangeLSP{
+				Start: position{Line: 1, Character: 16},
+				End:   position{Line: 1, Character: 23},
+			},
+			Severity: severityError,
+			Source:   "gqt",
+			Message:  `field "unknown" is undefined in type User`,
+		},
+		{
+			Range: rangeLSP{
+				Start: position{Line: 2, Character: 15},
+				End:   position{Line: 2, Character: 18},
+			},
+			Severity: severityError,
+			Source:   "gqt",
+			Message:  "expected type Int but received String",
+		},
+		{
+			Range: rangeLSP{
+				Start: position{Line: 2, Character: 19},
+				End:   position{Line: 2, Character: 19},
+			},
+			Severity: severityError,
+			Source:   "gqt",
+			Message: "unexpected token, " +
+				"expected comma or end of argument list",
+		},
+	}, p.Diagnostics)
+}
+
+func TestDiagnosticsRecover(t *testing.T) {
+	m := session(t, testSchema,
+		open("query { users }"),
+		notify("textDocument/didChange", map[string]any{
+			"textDocument": map[string]any{"uri": testURI},
+			"contentChanges": []map[string]any{
+				{"text": "query { users(limit=$v x, after: len < $v) }"},
+			},
+		}),
+		request(1, "initialize", map[string]any{}),
+	)
+	var p publishDiagnosticsParams
+	require.NoError(t, json.Unmarshal(m[1].Params, &p))
+	require.Equal(t, []diagnostic{
+		{
+			Range: rangeLSP{
+				Start: position{Line: 0, Character: 23},
+				End:   position{Line: 0, Character: 23},
+			},
+			Severity: severityError,
+			Source:   "gqt",
+			Message:  "unexpected token, expected colon",
+		},
+	}, p.Diagnostics)
+
+	// The server keeps responding after the change
+	var r map[string]any
+	response(t, m, 1, &r)
+}
+
+func TestDiagnosticsImport(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "common.gqt"),
+		[]byte("fragment U on User { unknown }"), 0o644))
+	uri := "file://" + filepath.ToSlash(dir) + "/t.gqt"
+	m := session(t, testSchema,
+		notify("textDocument/didOpen", map[string]any{
+			"textDocument": map[string]any{
+				"uri":  uri,
+				"text": `import "common.gqt" query { user(id: *) { ...U } }`,
+			},
+		}),
+	)
+	var p publishDiagnosticsParams
+	require.NoError(t, json.Unmarshal(m[0].Params, &p))
+	require.Equal(t, []diagnostic{
+		{
+			Severity: severityError,
+			Source:   "gqt",
+			Message:  `common.gqt:1:22: field "unknown" is undefined in type User`,
+		},
+	}, p.Diagnostics)
+}
+
+func TestHover(t *testing.T) {
+	m := session(t, testSchema,
+		open("query {\n  user(id=$id: *, filter: {age: 3}) { name }\n"+
+			"  users(after: $id) { id }\n}"),
+		at(1, "textDocument/hover", 1, 3),  // user
+		at(2, "textDocument/hover", 1, 16), // *
+		at(3, "textDocument/hover", 1, 28), // age
+		at(4, "textDocument/hover", 1, 40), // name
+		at(5, "textDocument/hover", 2, 16), // $id
+		at(6, "textDocument/hover", 0, 0),  // query
+	)
+	var h hover
+	response(t, m, 1, &h)
+	require.Equal(t, "```graphql\nuser: User\n```\n\nFinds a user by id",
+		h.Contents.Value)
+	require.Equal(t, rangeLSP{
+		Start: position{Line: 1, Character: 2},
+		End:   position{Line: 1, Character: 6},
+	}, h.Range)
+
+	response(t, m, 2, &h)
+	require.Equal(t, "```graphql\nid: ID!\n```", h.Contents.Value)
+
+	response(t, m, 3, &h)
+	require.Equal(t, "```graphql\nage: Int\n```", h.Contents.Value)
+
+	response(t, m, 4, &h)
+	require.Equal(t, "```graphql\nname: String\n```", h.Contents.Value)
+
+	response(t, m, 5, &h)
+	require.Equal(t, "```graphql\n$id: ID!\n```", h.Contents.Value)
+
+	var none *hover
+	response(t, m, 6, &none)
+	require.Nil(t, none)
+}
+
+func TestHoverImport(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "common.gqt"),
+		[]byte("fragment U on User { name }"), 0o644))
+	uri := "file://" + filepath.ToSlash(dir) + "/t.gqt"
+	m := session(t, testSchema,
+		notify("textDocument/didOpen", map[string]any{
+			"textDocument": map[string]any{
+				"uri":  uri,
+				"text": "import \"common.gqt\"\nquery { user(id: *) { ...U } }",
+			},
+		}),
+		request(1, "textDocument/hover", map[string]any{
+			"textDocument": map[string]any{"uri": uri},
+			// "query" is at the offset of "name" in the imported file
+			"position": map[string]any{"line": 1, "character": 2},
+		}),
+	)
+	var none *hover
+	response(t, m, 1, &none)
+	require.Nil(t, none)
+}
+
+func TestDefinition(t *testing.T) {
+	m := session(t, "",
+		open("query {\n  a(x=$x: *)\n  b(y: $x, z: 1)\n}"),
+		at(1, "textDocument/definition", 2, 9),
+		at(2, "textDocument/definition", 2, 15),
+	)
+	var l location
+	response(t, m, 1, &l)
+	require.Equal(t, location{
+		URI: testURI,
+		Range: rangeLSP{
+			Start: position{Line: 1, Character: 6},
+			End:   position{Line: 1, Character: 8},
+		},
+	}, l)
+
+	var none *location
+	response(t, m, 2, &none)
+	require.Nil(t, none)
+}
+
+func TestDefinitionDocument(t *testing.T) {
+	m := session(t, "",
+		open("query A { a(x=$x: *) }\nquery B { b(x=$x: *) c(y: $x) }"),
+		at(1, "textDocument/definition", 1, 27),
+	)
+	var l location
+	response(t, m, 1, &l)
+	require.Equal(t, location{
+		URI: testURI,
+		Range: rangeLSP{
+			Start: position{Line: 1, Character: 14},
+			End:   position{Line: 1, Character: 16},
+		},
+	}, l)
+}
+
+func TestCompletion(t *testing.T) {
+	text := "query {\n" +
+		"  user(id: 1, ) {\n" +
+		"    fr\n" +
+		"    friends { ... on User { n } }\n" +
+		"  }\n" +
+		"  users(limit: 2, ) { max 1 { id } }\n" +
+		"  user(filter: {name: \"x\", }) {\n" +
+		"    friends(x: \"\"\"{(\n\\\"\"\" \"\"\") {\n"
+	m := session(t, testSchema,
+		open(text),
+		at(1, "textDocument/completion", 0, 7),  // query {|
+		at(2, "textDocument/completion", 1, 14), // user(id: 1, |)
+		at(3, "textDocument/completion", 2, 6),  // fr|
+		at(4, "textDocument/completion", 3, 29), // ... on User { n|
+		at(5, "textDocument/completion", 5, 30), // max 1 {|
+		at(6, "textDocument/completion", 6, 27), // {name: "x", |
+		at(7, "textDocument/completion", 1, 10), // user(id: |
+		at(8, "textDocument/completion", 8, 11), // """) {|
+	)
+	var items []completionItem
+	response(t, m, 1, &items)
+	require.Equal(t, []string{"user", "users"}, labels(items))
+	require.Equal(t, "User", items[0].Detail)
+
+	response(t, m, 2, &items)
+	require.Equal(t, []string{"filter", "id"}, labels(items))
+	require.Equal(t, "ID!", items[1].Detail)
+
+	response(t, m, 3, &items)
+	require.Equal(t, []string{"friends", "id", "name"}, labels(items))
+
+	response(t, m, 4, &items)
+	require.Equal(t, []string{"friends", "id", "name"}, labels(items))
+
+	response(t, m, 5, &items)
+	require.Equal(t, []string{"friends", "id", "name"}, labels(items))
+
+	response(t, m, 6, &items)
+	require.Equal(t, []string{"age", "name"}, labels(items))
+
+	response(t, m, 7, &items)
+	require.Len(t, items, 0)
+
+	response(t, m, 8, &items)
+	require.Equal(t, []string{"friends", "id", "name"}, labels(items))
+}
+
+func TestCompletionFragment(t *testing.T) {
+	text := "fragment U on User { f }\n" +
+		"query { user(id: 1) { ...U } }\n"
+	m := session(t, testSchema,
+		open(text),
+		at(1, "textDocument/completion", 0, 22), // on User { f|
+		at(2, "textDocument/completion", 1, 7),  // query {|
+	)
+	var items []completionItem
+	response(t, m, 1, &items)
+	require.Equal(t, []string{"friends", "id", "name"}, labels(items))
+
+	response(t, m, 2, &items)
+	require.Equal(t, []string{"user", "users"}, labels(items))
+}
+
+func TestCompletionSchemaless(t *testing.T) {
+	m := session(t, "",
+		open("query { }"),
+		at(1, "textDocument/completion", 0, 8),
+	)
+	var items []completionItem
+	response(t, m, 1, &items)
+	require.Len(t, items, 0)
+}
+
+func TestMethodNotFound(t *testing.T) {
+	m := session(t, "", request(1, "unknown", nil))
+	require.NotNil(t, m[0].Error)
+	require.Equal(t, codeMethodNotFound, m[0].Error.Code)
+}
+
+func TestReadMessageContentLength(t *testing.T) {
+	for _, l := range []string{"-1", "1073741824"} {
+		r := bufio.NewReader(bytes.NewBufferString(
+			"Content-Length: " + l + "\r\n\r\n{}",
+		))
+		_, err := readMessage(r)
+		require.EqualError(t, err, "invalid Content-Length header: "+l)
+	}
+}
+
+func TestPosition(t *testing.T) {
+	text := "a\nü😀b\n"
+	for i, p := range map[int]position{
+		0:  {Line: 0, Character: 0},
+		2:  {Line: 1, Character: 0},
+		4:  {Line: 1, Character: 1},
+		8:  {Line: 1, Character: 3},
+		9:  {Line: 1, Character: 4},
+		10: {Line: 2, Character: 0},
+	} {
+		l := gqt.LocRange{Location: gqt.Location{Index: i, Line: p.Line + 1}}
+		require.Equal(t, p, rangeOf(text, l).Start, "offset %d", i)
+		require.Equal(t, i, offsetOf(text, p), "position %v", p)
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/graph-guard/gqt/v4"
+)
+
+// message is a JSON-RPC 2.0 request, response or notification.
+type message struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  json.RawMessage  `json:"result,omitempty"`
+	Error   *responseError   `json:"error,omitempty"`
+}
+
+type responseError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// JSON-RPC error codes.
+const (
+	codeParseError     = -32700
+	codeInternalError  = -32603
+	codeInvalidParams  = -32602
+	codeMethodNotFound = -32601
+	codeInvalidRequest = -32600
+)
+
+// maxContentLength is the maximum accepted size of a message content.
+const maxContentLength = 64 << 20
+
+// readMessage reads a message framed by a Content-Length header.
+func readMessage(r *bufio.Reader) (*message, error) {
+	h, err := textproto.NewReader(r).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	l, err := strconv.Atoi(h.Get("Content-Length"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Content-Length header: %w", err)
+	}
+	if l < 0 || l > maxContentLength {
+		return nil, fmt.Errorf("invalid Content-Length header: %d", l)
+	}
+	b := make([]byte, l)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	var m message
+	if err := json.Unmarshal(b, &m); err != nil {
+		return &message{}, err
+	}
+	return &m, nil
+}
+
+// writeMessage writes m framed by a Content-Length header.
+func writeMessage(w io.Writer, m *message) error {
+	m.JSONRPC = "2.0"
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "Content-Length: %d\r\n\r\n", len(b)); err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+type (
+	position struct {
+		Line      int `json:"line"`
+		Character int `json:"character"`
+	}
+
+	rangeLSP struct {
+		Start position `json:"start"`
+		End   position `json:"end"`
+	}
+
+	location struct {
+		URI   string   `json:"uri"`
+		Range rangeLSP `json:"range"`
+	}
+
+	textDocumentIdentifier struct {
+		URI string `json:"uri"`
+	}
+
+	textDocumentItem struct {
+		URI  string `json:"uri"`
+		Text string `json:"text"`
+	}
+
+	didOpenParams struct {
+		TextDocument textDocumentItem `json:"textDocument"`
+	}
+
+	didChangeParams struct {
+		TextDocument   textDocumentIdentifier `json:"textDocument"`
+		ContentChanges []struct {
+			Text string `json:"text"`
+		} `json:"contentChanges"`
+	}
+
+	didCloseParams struct {
+		TextDocument textDocumentIdentifier `json:"textDocument"`
+	}
+
+	textDocumentPositionParams struct {
+		TextDocument textDocumentIdentifier `json:"textDocument"`
+		Position     position               `json:"position"`
+	}
+
+	diagnostic struct {
+		Range    rangeLSP `json:"range"`
+		Severity int      `json:"severity"`
+		Source   string   `json:"source"`
+		Message  string   `json:"message"`
+	}
+
+	publishDiagnosticsParams struct {
+		URI         string       `json:"uri"`
+		Diagnostics []diagnostic `json:"diagnostics"`
+	}
+
+	markupContent struct {
+		Kind  string `json:"kind"`
+		Value string `json:"value"`
+	}
+
+	hover struct {
+		Contents markupContent `json:"contents"`
+		Range    rangeLSP      `json:"range"`
+	}
+
+	completionItem struct {
+		Label         string `json:"label"`
+		Kind          int    `json:"kind"`
+		Detail        string `json:"detail,omitempty"`
+		Documentation string `json:"documentation,omitempty"`
+	}
+)
+
+// Completion item kinds.
+const (
+	completionKindField    = 5
+	completionKindProperty = 10
+)
+
+const severityError = 1
+
+// offsetOf returns the byte offset of position p in text.
+// Positions are measured in UTF-16 code units as required by the protocol.
+func offsetOf(text string, p position) int {
+	i := 0
+	for l := 0; l < p.Line; l++ {
+		n := strings.IndexByte(text[i:], '\n')
+		if n < 0 {
+			return len(text)
+		}
+		i += n + 1
+	}
+	for c := 0; c < p.Character && i < len(text) && text[i] != '\n'; {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		if r >= 0x10000 {
+			c += 2
+		} else {
+			c++
+		}
+		i += size
+	}
+	return i
+}
+
+// rangeOf returns the range of l in text.
+func rangeOf(text string, l gqt.LocRange) rangeLSP {
+	start, end := l.UTF16([]byte(text))
+	return rangeLSP{Start: position(start), End: position(end)}
+}
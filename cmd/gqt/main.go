@@ -0,0 +1,369 @@
+// Command gqt is a command-line tool for working with GQT templates.
+//
+// Usage:
+//
+//	gqt check [-schema file]... template...
+//	gqt fmt [template...]
+//	gqt dump [-schema file]... [-json] [-operation name] template
+//	gqt match [-schema file]... [-operation name] template request
+//
+// Templates may contain multiple named operations. Files imported
+// by templates are resolved relative to their directory.
+// check validates templates and prints all errors as file:line:col: message.
+// fmt rewrites templates in canonical form preserving their comments,
+// or formats the standard input and writes the result to the standard
+// output if no files are given.
+// dump prints the abstract syntax tree of an operation as YAML or JSON.
+// match checks a JSON request file of the form
+// {"query": "...", "operationName": "...", "variables": {...}}
+// against an operation and prints the violations if it's rejected.
+// The operation is selected by name using -operation, which is required
+// only if the template contains multiple operations.
+//
+// The exit code is 1 if any template is invalid or the request is rejected
+// and 2 if the command-line arguments are invalid.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/graph-guard/gqt/v4"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/parser"
+)
+
+const usage = `usage:
+  gqt check [-schema file]... template...
+  gqt fmt [template...]
+  gqt dump [-schema file]... [-json] [-operation name] template
+  gqt match [-schema file]... [-operation name] template request
+`
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run runs the command with args and returns the exit code.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) < 1 {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+	var cmd func(c *command) int
+	switch args[0] {
+	case "check":
+		cmd = check
+	case "fmt":
+		cmd = format
+	case "dump":
+		cmd = dump
+	case "match":
+		cmd = match
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, usage)
+		return 0
+	default:
+		fmt.Fprintf(stderr, "unknown command %q\n%s", args[0], usage)
+		return 2
+	}
+	c := &command{
+		stdin:  stdin,
+		stdout: stdout,
+		stderr: stderr,
+		flags:  flag.NewFlagSet(args[0], flag.ContinueOnError),
+	}
+	c.flags.SetOutput(stderr)
+	if args[0] != "fmt" {
+		c.flags.Var(&c.schemas, "schema", "GraphQL schema file (repeatable)")
+	}
+	if args[0] == "dump" {
+		c.flags.BoolVar(&c.json, "json", false, "print JSON instead of YAML")
+	}
+	if args[0] == "dump" || args[0] == "match" {
+		c.flags.StringVar(
+			&c.operation, "operation", "", "name of the template operation",
+		)
+	}
+	if err := c.flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+	return cmd(c)
+}
+
+// command is the context of a subcommand.
+type command struct {
+	stdin          io.Reader
+	stdout, stderr io.Writer
+	flags          *flag.FlagSet
+	schemas        stringList
+	json           bool
+	operation      string
+}
+
+// stringList is a repeatable string flag.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// parser returns a parser that's schema-aware if schemas are provided.
+func (c *command) parser() (*gqt.Parser, bool) {
+	sources := make([]gqt.Source, len(c.schemas))
+	for i, name := range c.schemas {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			fmt.Fprintln(c.stderr, err)
+			return nil, false
+		}
+		sources[i] = gqt.Source{Name: name, Content: string(b)}
+	}
+	p, err := gqt.NewParser(sources)
+	if err != nil {
+		fmt.Fprintln(c.stderr, err)
+		return nil, false
+	}
+	return p, true
+}
+
+// parse parses template file name and prints all errors.
+// Imports are resolved in the directory of the template.
+// Returns nil if the template is invalid.
+func (c *command) parse(p *gqt.Parser, name string, src []byte) *gqt.Document {
+	p.SetFS(os.DirFS(filepath.Dir(name)))
+	doc, errs := p.ParseDocument(src)
+	c.printErrors(name, errs)
+	return doc
+}
+
+// selectOperation returns the operation of doc named by -operation
+// or its only operation if no name is given.
+// Returns nil and prints an error if there's no such operation.
+func (c *command) selectOperation(name string, doc *gqt.Document) *gqt.Operation {
+	if c.operation == "" {
+		if len(doc.Operations) > 1 {
+			fmt.Fprintf(c.stderr, "%s: template contains multiple "+
+				"operations, select one using -operation\n", name)
+			return nil
+		}
+		return doc.Operations[0]
+	}
+	for _, o := range doc.Operations {
+		if o.Name != nil && o.Name.Name == c.operation {
+			return o
+		}
+	}
+	fmt.Fprintf(c.stderr, "%s: operation %q not found\n", name, c.operation)
+	return nil
+}
+
+// printErrors prints the errors of template file name.
+// Errors in imported files are printed with the paths of the files.
+func (c *command) printErrors(name string, errs []gqt.Error) {
+	for _, err := range errs {
+		if err.File == "" {
+			fmt.Fprintf(c.stderr, "%s:%s\n", name, err.Error())
+			continue
+		}
+		fmt.Fprintf(c.stderr, "%s:%d:%d: %s\n",
+			importPath(name, err.File), err.Line, err.Column, err.Msg)
+	}
+}
+
+// importPath returns the path of the file imported by template file name.
+func importPath(name, file string) string {
+	return filepath.Join(filepath.Dir(name), filepath.FromSlash(file))
+}
+
+// parseFile reads and parses template file name.
+// Returns nil if the file can't be read or the template is invalid.
+func (c *command) parseFile(p *gqt.Parser, name string) *gqt.Document {
+	src, err := os.ReadFile(name)
+	if err != nil {
+		fmt.Fprintln(c.stderr, err)
+		return nil
+	}
+	return c.parse(p, name, src)
+}
+
+func check(c *command) int {
+	if c.flags.NArg() < 1 {
+		fmt.Fprint(c.stderr, usage)
+		return 2
+	}
+	p, ok := c.parser()
+	if !ok {
+		return 1
+	}
+	code := 0
+	for _, name := range c.flags.Args() {
+		if c.parseFile(p, name) == nil {
+			code = 1
+		}
+	}
+	return code
+}
+
+func format(c *command) int {
+	p, ok := c.parser()
+	if !ok {
+		return 1
+	}
+	if c.flags.NArg() < 1 {
+		src, err := io.ReadAll(c.stdin)
+		if err != nil {
+			fmt.Fprintln(c.stderr, err)
+			return 1
+		}
+		doc := c.parse(p, "<stdin>", src)
+		if doc == nil {
+			return 1
+		}
+		err = gqt.FormatDocument(c.stdout, doc, gqt.FormatOptions{})
+		if err != nil {
+			fmt.Fprintln(c.stderr, err)
+			return 1
+		}
+		return 0
+	}
+	code := 0
+	for _, name := range c.flags.Args() {
+		doc := c.parseFile(p, name)
+		if doc == nil {
+			code = 1
+			continue
+		}
+		var b bytes.Buffer
+		if err := gqt.FormatDocument(&b, doc, gqt.FormatOptions{}); err != nil {
+			fmt.Fprintln(c.stderr, err)
+			code = 1
+			continue
+		}
+		if err := writeFile(name, b.Bytes()); err != nil {
+			fmt.Fprintln(c.stderr, err)
+			code = 1
+		}
+	}
+	return code
+}
+
+// writeFile overwrites file name preserving its permissions.
+func writeFile(name string, content []byte) error {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(name, content, fi.Mode().Perm())
+}
+
+func dump(c *command) int {
+	if c.flags.NArg() != 1 {
+		fmt.Fprint(c.stderr, usage)
+		return 2
+	}
+	p, ok := c.parser()
+	if !ok {
+		return 1
+	}
+	tmpl := c.parseFile(p, c.flags.Arg(0))
+	if tmpl == nil {
+		return 1
+	}
+	opr := c.selectOperation(c.flags.Arg(0), tmpl)
+	if opr == nil {
+		return 1
+	}
+	write := gqt.WriteYAML
+	if c.json {
+		write = gqt.WriteJSON
+	}
+	if err := write(c.stdout, opr); err != nil {
+		fmt.Fprintln(c.stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// request is a GraphQL request.
+type request struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName"`
+	Variables     map[string]any `json:"variables"`
+}
+
+func match(c *command) int {
+	if c.flags.NArg() != 2 {
+		fmt.Fprint(c.stderr, usage)
+		return 2
+	}
+	p, ok := c.parser()
+	if !ok {
+		return 1
+	}
+	tmpl := c.parseFile(p, c.flags.Arg(0))
+	if tmpl == nil {
+		return 1
+	}
+	opr := c.selectOperation(c.flags.Arg(0), tmpl)
+	if opr == nil {
+		return 1
+	}
+	r, doc, err := readRequest(c.flags.Arg(1))
+	if err != nil {
+		fmt.Fprintln(c.stderr, err)
+		return 1
+	}
+	report, err := gqt.MatchReport(opr, doc, r.OperationName, r.Variables)
+	if err != nil {
+		fmt.Fprintf(c.stderr, "%s: %v\n", c.flags.Arg(1), err)
+		return 1
+	}
+	if report.Accepted() {
+		fmt.Fprintln(c.stdout, "accepted")
+		return 0
+	}
+	fmt.Fprintln(c.stdout, "rejected")
+	for _, v := range report.Violations {
+		file := c.flags.Arg(0)
+		if v.File != "" {
+			file = importPath(file, v.File)
+		}
+		l := v.Expression.GetLocation()
+		fmt.Fprintf(c.stdout, "%s:%d:%d: ", file, l.Line, l.Column)
+		if v.Path != "" {
+			fmt.Fprintf(c.stdout, "%s: ", v.Path)
+		}
+		fmt.Fprintln(c.stdout, v.Msg)
+	}
+	return 1
+}
+
+// readRequest reads and parses the JSON request file name.
+func readRequest(name string) (*request, *ast.QueryDocument, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	var r request
+	if err := d.Decode(&r); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", name, err)
+	}
+	doc, gqlErr := parser.ParseQuery(&ast.Source{Name: name, Input: r.Query})
+	if gqlErr != nil {
+		return nil, nil, errors.New(gqlErr.Error())
+	}
+	return &r, doc, nil
+}
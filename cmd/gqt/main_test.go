@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testSchema = `type Query { user(id: ID!): User }
+type User { id: ID! name: String }
+`
+
+func writeTestFile(t *testing.T, dir, name, content string) string {
+	p := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(p, []byte(content), 0o644))
+	return p
+}
+
+func runCmd(stdin string, args ...string) (code int, stdout, stderr string) {
+	var out, errOut bytes.Buffer
+	code = run(args, strings.NewReader(stdin), &out, &errOut)
+	return code, out.String(), errOut.String()
+}
+
+func TestCheck(t *testing.T) {
+	dir := t.TempDir()
+	schema := writeTestFile(t, dir, "schema.graphqls", testSchema)
+	valid := writeTestFile(t, dir, "valid.gqt", `query { user(id: *) { name } }`)
+	invalid := writeTestFile(t, dir, "invalid.gqt",
+		"query {\n  user(id: *) { unknown }\n}")
+	document := writeTestFile(t, dir, "document.gqt",
+		"query A { user(id: *) { name } }\nquery B { user(id: *) { id } }")
+
+	code, stdout, stderr := runCmd("", "check", "-schema", schema, valid)
+	require.Equal(t, 0, code)
+	require.Zero(t, stdout)
+	require.Zero(t, stderr)
+
+	code, stdout, stderr = runCmd("", "check", "-schema", schema, document)
+	require.Equal(t, 0, code)
+	require.Zero(t, stdout)
+	require.Zero(t, stderr)
+
+	code, stdout, stderr = runCmd("", "check", "-schema", schema, valid, invalid)
+	require.Equal(t, 1, code)
+	require.Zero(t, stdout)
+	require.Equal(t,
+		invalid+":2:17: field \"unknown\" is undefined in type User\n", stderr)
+
+	// Schemaless
+	code, _, stderr = runCmd("", "check", invalid)
+	require.Equal(t, 0, code)
+	require.Zero(t, stderr)
+}
+
+func TestCheckImport(t *testing.T) {
+	dir := t.TempDir()
+	schema := writeTestFile(t, dir, "schema.graphqls", testSchema)
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "lib"), 0o755))
+	writeTestFile(t, dir, "lib/common.gqt",
+		"constraint Id = len > 0\nfragment U on User { unknown }")
+	valid := writeTestFile(t, dir, "valid.gqt",
+		`import "lib/common.gqt" query { user(id: is Id) { name } }`)
+	invalid := writeTestFile(t, dir, "invalid.gqt",
+		`import "lib/common.gqt" query { user(id: is Id) { ...U } }`)
+
+	code, stdout, stderr := runCmd("", "check", "-schema", schema, valid)
+	require.Equal(t, 0, code)
+	require.Zero(t, stdout)
+	require.Zero(t, stderr)
+
+	code, stdout, stderr = runCmd("", "check", "-schema", schema, invalid)
+	require.Equal(t, 1, code)
+	require.Zero(t, stdout)
+	require.Equal(t, filepath.Join(dir, "lib", "common.gqt")+
+		":2:22: field \"unknown\" is undefined in type User\n", stderr)
+}
+
+func TestFmt(t *testing.T) {
+	dir := t.TempDir()
+	p := writeTestFile(t, dir, "t.gqt", `query{user(id:*){name}}`)
+	code, stdout, stderr := runCmd("", "fmt", p)
+	require.Equal(t, 0, code)
+	require.Zero(t, stdout)
+	require.Zero(t, stderr)
+	b, err := os.ReadFile(p)
+	require.NoError(t, err)
+	require.Equal(t, "query {\n  user(id: *) {\n    name\n  }\n}\n", string(b))
+
+	code, stdout, stderr = runCmd(`mutation{f}`, "fmt")
+	require.Equal(t, 0, code)
+	require.Equal(t, "mutation {\n  f\n}\n", stdout)
+	require.Zero(t, stderr)
+
+	code, stdout, stderr = runCmd(`query{`, "fmt")
+	require.Equal(t, 1, code)
+	require.Zero(t, stdout)
+	require.Equal(t,
+		"<stdin>:1:7: unexpected end of file, expected selection\n", stderr)
+}
+
+func TestFmtComments(t *testing.T) {
+	dir := t.TempDir()
+	p := writeTestFile(t, dir, "t.gqt", "# Users\n"+
+		"query { # Root\n"+
+		"  user(id: *) { name } # Name only\n"+
+		"  # No other fields\n"+
+		"}\n")
+	code, stdout, stderr := runCmd("", "fmt", p)
+	require.Equal(t, 0, code)
+	require.Zero(t, stdout)
+	require.Zero(t, stderr)
+	b, err := os.ReadFile(p)
+	require.NoError(t, err)
+	require.Equal(t, "# Users\n"+
+		"query {\n"+
+		"  # Root\n"+
+		"  user(id: *) {\n"+
+		"    name\n"+
+		"  } # Name only\n"+
+		"  # No other fields\n"+
+		"}\n", string(b))
+}
+
+func TestFmtDocument(t *testing.T) {
+	dir := t.TempDir()
+	p := writeTestFile(t, dir, "t.gqt", "query A{a}\nquery B{b}")
+	code, stdout, stderr := runCmd("", "fmt", p)
+	require.Equal(t, 0, code)
+	require.Zero(t, stdout)
+	require.Zero(t, stderr)
+	b, err := os.ReadFile(p)
+	require.NoError(t, err)
+	require.Equal(t, "query A {\n  a\n}\n\nquery B {\n  b\n}\n", string(b))
+}
+
+func TestDump(t *testing.T) {
+	dir := t.TempDir()
+	p := writeTestFile(t, dir, "t.gqt", `query { f }`)
+
+	code, stdout, stderr := runCmd("", "dump", p)
+	require.Equal(t, 0, code)
+	require.Zero(t, stderr)
+	require.Contains(t, stdout, "operationType: Query\n")
+
+	code, stdout, stderr = runCmd("", "dump", "-json", p)
+	require.Equal(t, 0, code)
+	require.Zero(t, stderr)
+	var m map[string]any
+	require.NoError(t, json.Unmarshal([]byte(stdout), &m))
+	require.Equal(t, "Query", m["operationType"])
+
+	document := writeTestFile(t, dir, "document.gqt",
+		"query A { a }\nmutation B { b }")
+	code, stdout, stderr = runCmd("", "dump", "-operation", "B", document)
+	require.Equal(t, 0, code)
+	require.Zero(t, stderr)
+	require.Contains(t, stdout, "operationType: Mutation\n")
+
+	code, stdout, stderr = runCmd("", "dump", document)
+	require.Equal(t, 1, code)
+	require.Zero(t, stdout)
+	require.Equal(t, document+": template contains multiple operations, "+
+		"select one using -operation\n", stderr)
+
+	code, stdout, stderr = runCmd("", "dump", "-operation", "C", document)
+	require.Equal(t, 1, code)
+	require.Zero(t, stdout)
+	require.Equal(t, document+": operation \"C\" not found\n", stderr)
+}
+
+func TestMatch(t *testing.T) {
+	dir := t.TempDir()
+	schema := writeTestFile(t, dir, "schema.graphqls", testSchema)
+	tmpl := writeTestFile(t, dir, "t.gqt", `query { user(id: != "0") { name } }`)
+	accepted := writeTestFile(t, dir, "accepted.json", `{
+		"query": "query ($id: ID!) { user(id: $id) { name } }",
+		"variables": {"id": "42"}
+	}`)
+	rejected := writeTestFile(t, dir, "rejected.json", `{
+		"query": "{ user(id: \"0\") { id } }"
+	}`)
+
+	code, stdout, stderr := runCmd("", "match", "-schema", schema, tmpl, accepted)
+	require.Equal(t, 0, code)
+	require.Equal(t, "accepted\n", stdout)
+	require.Zero(t, stderr)
+
+	code, stdout, stderr = runCmd("", "match", "-schema", schema, tmpl, rejected)
+	require.Equal(t, 1, code)
+	require.Equal(t, "rejected\n"+
+		tmpl+`:1:9: user.id: field "id" is not allowed`+"\n"+
+		tmpl+":1:18: user.id: value violates constraint\n", stdout)
+	require.Zero(t, stderr)
+
+	document := writeTestFile(t, dir, "document.gqt",
+		"query A { user(id: *) { id } }\nquery B { user(id: *) { name } }")
+	code, stdout, stderr = runCmd("",
+		"match", "-schema", schema, "-operation", "B", document, accepted)
+	require.Equal(t, 0, code)
+	require.Equal(t, "accepted\n", stdout)
+	require.Zero(t, stderr)
+
+	code, stdout, stderr = runCmd("",
+		"match", "-schema", schema, "-operation", "A", document, accepted)
+	require.Equal(t, 1, code)
+	require.Equal(t, "rejected\n"+
+		document+`:1:11: user.name: field "name" is not allowed`+"\n", stdout)
+	require.Zero(t, stderr)
+}
+
+func TestMatchImport(t *testing.T) {
+	dir := t.TempDir()
+	schema := writeTestFile(t, dir, "schema.graphqls", testSchema)
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "lib"), 0o755))
+	writeTestFile(t, dir, "lib/common.gqt",
+		"constraint Id = != \"0\"\n"+
+			"fragment U on Query { user(id: is Id) { name } }")
+	tmpl := writeTestFile(t, dir, "t.gqt",
+		`import "lib/common.gqt" query { ...U }`)
+	rejected := writeTestFile(t, dir, "rejected.json", `{
+		"query": "{ user(id: \"0\") { id } }"
+	}`)
+
+	code, stdout, stderr := runCmd("", "match", "-schema", schema, tmpl, rejected)
+	require.Equal(t, 1, code)
+	lib := filepath.Join(dir, "lib", "common.gqt")
+	require.Equal(t, "rejected\n"+
+		lib+`:2:23: user.id: field "id" is not allowed`+"\n"+
+		lib+":1:17: user.id: value violates constraint\n", stdout)
+	require.Zero(t, stderr)
+}
+
+func TestUsage(t *testing.T) {
+	code, _, stderr := runCmd("")
+	require.Equal(t, 2, code)
+	require.Equal(t, usage, stderr)
+
+	code, _, stderr = runCmd("", "unknown")
+	require.Equal(t, 2, code)
+	require.Equal(t, "unknown command \"unknown\"\n"+usage, stderr)
+
+	code, _, stderr = runCmd("", "dump")
+	require.Equal(t, 2, code)
+	require.Equal(t, usage, stderr)
+}
@@ -0,0 +1,1279 @@
+package gqt
+
+import (
+	"fmt"
+	"math"
+	"sync"
+
+	ast "github.com/vektah/gqlparser/v2/ast"
+)
+
+// Program is a template operation compiled for fast matching.
+// A Program is safe for concurrent use.
+type Program struct {
+	opType     OperationType
+	maxDepth   int
+	root       *progSet
+	vars       int
+	options    int
+	recursions int
+	states     sync.Pool
+}
+
+// Compile compiles the template operation op into a program that is
+// semantically equivalent to Match but avoids walking the abstract
+// syntax tree on every request.
+// It's recommended to Optimize the operation before compiling it.
+//
+// Compile returns an error if op contains expressions that can't be compiled.
+func Compile(op *Operation) (*Program, error) {
+	if op == nil {
+		return nil, fmt.Errorf("missing operation")
+	}
+	c := compiler{vars: make(map[*VariableDeclaration]int)}
+	root, err := c.selSet(op.SelectionSet, op.Type.String())
+	if err != nil {
+		return nil, err
+	}
+	p := &Program{
+		opType:     op.Type,
+		maxDepth:   op.MaxDepth,
+		root:       root,
+		vars:       len(c.vars),
+		options:    c.options,
+		recursions: c.recursions,
+	}
+	p.states.New = func() any {
+		return &progState{
+			maxDepth:   p.maxDepth,
+			vars:       make([]value, p.vars),
+			bound:      make([]bool, p.vars),
+			marks:      make([]uint32, p.options),
+			recursions: make([]int, p.recursions),
+		}
+	}
+	return p, nil
+}
+
+// Match is equivalent to Match called with the operation p was compiled from.
+// Match doesn't allocate memory unless an error is returned.
+func (p *Program) Match(
+	doc *ast.QueryDocument,
+	operationName string,
+	variables map[string]any,
+) (bool, error) {
+	o, err := findOperation(doc, operationName, variables)
+	if err != nil {
+		return false, err
+	}
+	if p.opType != operationType(o.Operation) {
+		return false, nil
+	}
+	s := p.states.Get().(*progState)
+	defer p.states.Put(s)
+	s.doc = doc
+	s.in = input{vars: variables, defs: o.VariableDefinitions}
+	defer s.reset()
+
+	ok := s.matchSet(p.root, p.opType.String(), o.SelectionSet)
+	if s.err != nil {
+		return false, s.err
+	}
+	if !ok {
+		return false, nil
+	}
+	for _, c := range s.checks {
+		if !c.constraint(s, c.value) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// progState is the state of a single program execution.
+// States are pooled and reused across executions.
+type progState struct {
+	doc        *ast.QueryDocument
+	in         input
+	vars       []value
+	bound      []bool
+	marks      []uint32
+	epoch      uint32
+	saved      []uint32 // Marks of the sets enclosing active recursions.
+	checks     []progCheck
+	spreads    []string
+	scratch    []value
+	keys       []progKey
+	maxDepth   int
+	depth      int   // Number of fields enclosing the current set.
+	recursions []int // Current level of every recursion.
+	err        error
+}
+
+// progKey is a response key a field was selected under
+// in the selection set matched in epoch.
+type progKey struct {
+	field *progField
+	key   string
+	epoch uint32
+}
+
+// progCheck is a deferred constraint check.
+// Constraints are checked only after all variables are bound.
+type progCheck struct {
+	constraint constraint
+	value      value
+}
+
+// reset resets s without releasing the memory it holds.
+func (s *progState) reset() {
+	for i := range s.vars {
+		s.vars[i], s.bound[i] = value{}, false
+	}
+	for i := range s.checks {
+		s.checks[i] = progCheck{}
+	}
+	for i := range s.scratch {
+		s.scratch[i] = value{}
+	}
+	keys := s.keys[:cap(s.keys)]
+	for i := range keys {
+		keys[i] = progKey{}
+	}
+	s.checks, s.spreads, s.scratch = s.checks[:0], s.spreads[:0], s.scratch[:0]
+	for i := range s.recursions {
+		s.recursions[i] = 0
+	}
+	s.keys, s.depth, s.saved = s.keys[:0], 0, s.saved[:0]
+	s.doc, s.in, s.err = nil, input{}, nil
+	if s.epoch > math.MaxUint32/2 {
+		// Reset the marks long before the epoch counter can overflow.
+		// Marks can't be reset during an execution since the marks
+		// saved by recursions would become stale.
+		for i := range s.marks {
+			s.marks[i] = 0
+		}
+		s.epoch = 0
+	}
+}
+
+// constraint returns true if v satisfies the constraint.
+type constraint func(s *progState, v value) bool
+
+// evaluator evaluates a value expression.
+// Returns false if the expression can't be evaluated.
+type evaluator func(s *progState) (value, bool)
+
+// binder binds the fields of object v to their associated variables.
+// Returns false if a variable is already bound to a different value.
+type binder func(s *progState, v value) bool
+
+// progSet is a compiled selection set.
+type progSet struct {
+	fields     map[string]*progField
+	frags      map[string]*progFrag
+	optionSets []progOptionSet
+	required   []int // Options of the required fields.
+	recurse    *progRecurse
+}
+
+// progRecurse is a compiled recursion into field.
+type progRecurse struct {
+	name  string
+	field *progField
+	max   int
+	index int // Index of the recursion level in the program state.
+}
+
+// progOptionSet is a compiled max, min or exactly set.
+type progOptionSet struct {
+	min, max int
+	options  []int
+}
+
+type progField struct {
+	option   int // Index of the set option or required field or -1.
+	alias    *Alias
+	args     []progArg
+	dirs     []progDirective
+	typeName string
+	set      *progSet
+}
+
+type progFrag struct {
+	option int // Index of the set option or -1.
+	dirs   []progDirective
+	set    *progSet
+}
+
+type progDirective struct {
+	name string
+	args []progArg
+}
+
+type progArg struct {
+	name       string
+	def        *ast.Value // Default value defined in the schema.
+	variable   int        // Index of the associated variable or -1.
+	optional   bool
+	absent     bool
+	bind       binder
+	constraint constraint
+}
+
+type progObjectField struct {
+	name       string
+	def        *ast.Value // Default value defined in the schema.
+	variable   int        // Index of the associated variable or -1.
+	optional   bool
+	absent     bool
+	bind       binder
+	constraint constraint
+}
+
+// compiler compiles templates into programs.
+type compiler struct {
+	vars       map[*VariableDeclaration]int
+	options    int
+	recursions int
+
+	// fields is the stack of the fields being compiled.
+	fields []*progField
+}
+
+func (c *compiler) variable(d *VariableDeclaration) int {
+	if d == nil {
+		return -1
+	}
+	if i, ok := c.vars[d]; ok {
+		return i
+	}
+	i := len(c.vars)
+	c.vars[d] = i
+	return i
+}
+
+// selSet compiles selection set set of type hostType.
+func (c *compiler) selSet(set SelectionSet, hostType string) (*progSet, error) {
+	p := &progSet{
+		fields: map[string]*progField{},
+		frags:  map[string]*progFrag{},
+	}
+	if err := c.selections(p, set, hostType, -1); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// selections adds the selections of set of type hostType to p.
+// of is the index of the max, min or exactly set in p if set is
+// the set of its options, otherwise of is -1.
+func (c *compiler) selections(
+	p *progSet, set SelectionSet, hostType string, of int,
+) error {
+	for _, s := range set.Selections {
+		option := -1
+		if of > -1 {
+			option = c.options
+			c.options++
+			o := &p.optionSets[of]
+			o.options = append(o.options, option)
+		}
+		switch s := s.(type) {
+		case *SelectionField:
+			if s.Required && option < 0 {
+				option = c.options
+				c.options++
+				p.required = append(p.required, option)
+			}
+			f, err := c.field(s, option)
+			if err != nil {
+				return err
+			}
+			if _, ok := p.fields[s.Name.Name]; !ok {
+				p.fields[s.Name.Name] = f
+			}
+		case *SelectionInlineFrag:
+			set, err := c.selSet(s.SelectionSet, s.TypeCondition.TypeName)
+			if err != nil {
+				return err
+			}
+			dirs, err := c.directives(s.Directives)
+			if err != nil {
+				return err
+			}
+			if _, ok := p.frags[s.TypeCondition.TypeName]; !ok {
+				p.frags[s.TypeCondition.TypeName] = &progFrag{
+					option: option,
+					dirs:   dirs,
+					set:    set,
+				}
+			}
+		case *SelectionSpread:
+			if s.merged(hostType) {
+				err := c.selections(p, s.Fragment.SelectionSet, hostType, -1)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			cond := s.Fragment.TypeCondition.TypeName
+			set, err := c.selSet(s.Fragment.SelectionSet, cond)
+			if err != nil {
+				return err
+			}
+			if _, ok := p.frags[cond]; !ok {
+				p.frags[cond] = &progFrag{option: option, set: set}
+			}
+		case *SelectionRecurse:
+			if len(c.fields) < 1 {
+				return fmt.Errorf("recursion outside of a field's selection set")
+			}
+			p.recurse = &progRecurse{
+				name:  recursiveField(s).Name.Name,
+				field: c.fields[len(c.fields)-1],
+				max:   s.Max,
+				index: c.recursions,
+			}
+			c.recursions++
+		case optionSet:
+			min, max := s.limits()
+			p.optionSets = append(p.optionSets, progOptionSet{
+				min: min, max: max,
+			})
+			err := c.selections(
+				p, *s.options(), hostType, len(p.optionSets)-1,
+			)
+			if err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unsupported selection type: %T", s)
+		}
+	}
+	return nil
+}
+
+func (c *compiler) field(f *SelectionField, option int) (*progField, error) {
+	p := &progField{
+		option:   option,
+		alias:    f.Alias,
+		typeName: fieldTypeName(f),
+	}
+	var err error
+	if p.args, err = c.arguments(f.Arguments); err != nil {
+		return nil, err
+	}
+	if p.dirs, err = c.directives(f.Directives); err != nil {
+		return nil, err
+	}
+	c.fields = append(c.fields, p)
+	p.set, err = c.selSet(f.SelectionSet, p.typeName)
+	c.fields = c.fields[:len(c.fields)-1]
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (c *compiler) directives(dirs []*Directive) ([]progDirective, error) {
+	p := make([]progDirective, len(dirs))
+	for i, d := range dirs {
+		args, err := c.arguments(d.Arguments)
+		if err != nil {
+			return nil, err
+		}
+		p[i] = progDirective{name: d.Name.Name, args: args}
+	}
+	return p, nil
+}
+
+func (c *compiler) arguments(args []*Argument) ([]progArg, error) {
+	p := make([]progArg, len(args))
+	for i, a := range args {
+		x := progArg{
+			name:     a.Name.Name,
+			variable: c.variable(a.AssociatedVariable),
+			optional: a.Optional,
+			absent:   a.Absent,
+		}
+		if a.Def != nil {
+			x.def = a.Def.DefaultValue
+		}
+		if a.Absent {
+			p[i] = x
+			continue
+		}
+		var err error
+		if x.bind, err = c.binder(a.Constraint); err != nil {
+			return nil, err
+		}
+		if x.constraint, err = c.constraint(a.Constraint); err != nil {
+			return nil, err
+		}
+		p[i] = x
+	}
+	return p, nil
+}
+
+// binder compiles the variable bindings of the object fields in e.
+// Returns nil if e contains no object fields with associated variables.
+func (c *compiler) binder(e Expression) (binder, error) {
+	switch e := e.(type) {
+	case *ConstrEquals:
+		o, ok := e.Value.(*Object)
+		if !ok {
+			return nil, nil
+		}
+		var fields []progObjectField
+		for _, f := range o.Fields {
+			b, err := c.binder(f.Constraint)
+			if err != nil {
+				return nil, err
+			}
+			if b == nil && f.AssociatedVariable == nil {
+				continue
+			}
+			fields = append(fields, progObjectField{
+				name:     f.Name.Name,
+				def:      objectFieldDefault(f),
+				variable: c.variable(f.AssociatedVariable),
+				bind:     b,
+			})
+		}
+		if len(fields) < 1 {
+			return nil, nil
+		}
+		return func(s *progState, v value) bool {
+			if v.kind != valueObject {
+				return true
+			}
+			for i := range fields {
+				f := &fields[i]
+				fv := s.objectField(v, f.name, f.def)
+				if fv.kind == valueUndefined {
+					continue
+				}
+				if f.variable > -1 && !s.bind(f.variable, fv) {
+					return false
+				}
+				if f.bind != nil && !f.bind(s, fv) {
+					return false
+				}
+			}
+			return true
+		}, nil
+	case *ExprParentheses:
+		return c.binder(e.Expression)
+	case *ConstrAlias:
+		return c.binder(e.Constraint)
+	case *ExprLogicalAnd:
+		return c.binders(e.Expressions)
+	case *ExprLogicalOr:
+		return c.binders(e.Expressions)
+	}
+	return nil, nil
+}
+
+func (c *compiler) binders(e []Expression) (binder, error) {
+	var l []binder
+	for _, e := range e {
+		b, err := c.binder(e)
+		if err != nil {
+			return nil, err
+		}
+		if b != nil {
+			l = append(l, b)
+		}
+	}
+	switch len(l) {
+	case 0:
+		return nil, nil
+	case 1:
+		return l[0], nil
+	}
+	return func(s *progState, v value) bool {
+		for _, b := range l {
+			if !b(s, v) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+func (c *compiler) constraint(e Expression) (constraint, error) {
+	switch e := e.(type) {
+	case *ConstrAny:
+		return func(*progState, value) bool { return true }, nil
+	case *ConstrEquals:
+		return c.equal(e.Value)
+	case *ConstrNotEquals:
+		eq, err := c.equal(e.Value)
+		if err != nil {
+			return nil, err
+		}
+		return func(s *progState, v value) bool { return !eq(s, v) }, nil
+	case *ConstrLess:
+		return c.compare(e.Value, false, func(c int) bool { return c < 0 })
+	case *ConstrLessOrEqual:
+		return c.compare(e.Value, false, func(c int) bool { return c <= 0 })
+	case *ConstrGreater:
+		return c.compare(e.Value, false, func(c int) bool { return c > 0 })
+	case *ConstrGreaterOrEqual:
+		return c.compare(e.Value, false, func(c int) bool { return c >= 0 })
+	case *ConstrLenEquals:
+		return c.compare(e.Value, true, func(c int) bool { return c == 0 })
+	case *ConstrLenNotEquals:
+		return c.compare(e.Value, true, func(c int) bool { return c != 0 })
+	case *ConstrLenLess:
+		return c.compare(e.Value, true, func(c int) bool { return c < 0 })
+	case *ConstrLenLessOrEqual:
+		return c.compare(e.Value, true, func(c int) bool { return c <= 0 })
+	case *ConstrLenGreater:
+		return c.compare(e.Value, true, func(c int) bool { return c > 0 })
+	case *ConstrLenGreaterOrEqual:
+		return c.compare(e.Value, true, func(c int) bool { return c >= 0 })
+	case *ConstrMatches:
+		re := e.Regexp()
+		if re == nil {
+			return nil, fmt.Errorf("invalid regular expression pattern")
+		}
+		return func(s *progState, v value) bool {
+			return v.kind == valueString && re.MatchString(v.s)
+		}, nil
+	case *ConstrIn:
+		set, ok := newValueSet(e.Values)
+		if !ok {
+			return nil, fmt.Errorf("non-constant value in set")
+		}
+		return func(s *progState, v value) bool { return set.contains(v) }, nil
+	case *ConstrNotIn:
+		set, ok := newValueSet(e.Values)
+		if !ok {
+			return nil, fmt.Errorf("non-constant value in set")
+		}
+		return func(s *progState, v value) bool { return !set.contains(v) }, nil
+	case *ConstrUnique:
+		return func(s *progState, v value) bool {
+			return v.kind == valueList && s.in.unique(v)
+		}, nil
+	case *ConstrContains:
+		eq, err := c.equal(e.Value)
+		if err != nil {
+			return nil, err
+		}
+		return func(s *progState, v value) bool {
+			if v.kind != valueList {
+				return false
+			}
+			for i, l := 0, s.in.len(v); i < l; i++ {
+				if eq(s, s.in.index(v, i)) {
+					return true
+				}
+			}
+			return false
+		}, nil
+	case *ConstrSum:
+		sum, err := c.constraint(e.Constraint)
+		if err != nil {
+			return nil, err
+		}
+		return func(s *progState, v value) bool {
+			x, ok := s.in.sum(v)
+			return ok && sum(s, x)
+		}, nil
+	case *ConstrCount:
+		where, err := c.constraint(e.Where)
+		if err != nil {
+			return nil, err
+		}
+		count, err := c.constraint(e.Constraint)
+		if err != nil {
+			return nil, err
+		}
+		return func(s *progState, v value) bool {
+			if v.kind != valueList {
+				return false
+			}
+			n := 0
+			for i, l := 0, s.in.len(v); i < l; i++ {
+				if where(s, s.in.index(v, i)) {
+					n++
+				}
+			}
+			return count(s, value{kind: valueInt, i: int64(n)})
+		}, nil
+	case *ConstrMap:
+		item, err := c.constraint(e.Constraint)
+		if err != nil {
+			return nil, err
+		}
+		return func(s *progState, v value) bool {
+			if v.kind != valueList {
+				return false
+			}
+			for i, l := 0, s.in.len(v); i < l; i++ {
+				if !item(s, s.in.index(v, i)) {
+					return false
+				}
+			}
+			return true
+		}, nil
+	case *ExprParentheses:
+		return c.constraint(e.Expression)
+	case *ConstrAlias:
+		return c.constraint(e.Constraint)
+	case *ExprLogicalAnd:
+		l, err := c.constraints(e.Expressions)
+		if err != nil {
+			return nil, err
+		}
+		return func(s *progState, v value) bool {
+			for _, c := range l {
+				if !c(s, v) {
+					return false
+				}
+			}
+			return true
+		}, nil
+	case *ExprLogicalOr:
+		l, err := c.constraints(e.Expressions)
+		if err != nil {
+			return nil, err
+		}
+		return func(s *progState, v value) bool {
+			for _, c := range l {
+				if c(s, v) {
+					return true
+				}
+			}
+			return false
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported constraint type: %T", e)
+}
+
+func (c *compiler) constraints(e []Expression) ([]constraint, error) {
+	l := make([]constraint, len(e))
+	for i, e := range e {
+		var err error
+		if l[i], err = c.constraint(e); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// equal compiles the equality check against value expression x.
+// Arrays and objects are checked item by item and field by field
+// since they can contain constraints.
+func (c *compiler) equal(x Expression) (constraint, error) {
+	switch x := x.(type) {
+	case *Array:
+		items, err := c.constraints(x.Items)
+		if err != nil {
+			return nil, err
+		}
+		return func(s *progState, v value) bool {
+			if v.kind != valueList || s.in.len(v) != len(items) {
+				return false
+			}
+			for i, item := range items {
+				if !item(s, s.in.index(v, i)) {
+					return false
+				}
+			}
+			return true
+		}, nil
+	case *Object:
+		return c.object(x)
+	}
+	ev, err := c.expr(x)
+	if err != nil {
+		return nil, err
+	}
+	return func(s *progState, v value) bool {
+		mark := len(s.scratch)
+		r, ok := ev(s)
+		ok = ok && s.in.equal(r, v)
+		s.scratch = s.scratch[:mark]
+		return ok
+	}, nil
+}
+
+func (c *compiler) object(o *Object) (constraint, error) {
+	fields := make([]progObjectField, len(o.Fields))
+	names := make(map[string]struct{}, len(o.Fields))
+	for i, f := range o.Fields {
+		fields[i] = progObjectField{
+			name:     f.Name.Name,
+			def:      objectFieldDefault(f),
+			optional: f.Optional,
+			absent:   f.Absent,
+		}
+		names[f.Name.Name] = struct{}{}
+		if f.Absent {
+			continue
+		}
+		var err error
+		if fields[i].constraint, err = c.constraint(f.Constraint); err != nil {
+			return nil, err
+		}
+	}
+	open := o.Open
+	return func(s *progState, v value) bool {
+		if v.kind != valueObject {
+			return false
+		}
+		for i := range fields {
+			f := &fields[i]
+			if f.absent {
+				if s.in.field(v, f.name).kind != valueUndefined {
+					return false
+				}
+				continue
+			}
+			fv := s.objectField(v, f.name, f.def)
+			if fv.kind == valueUndefined {
+				if f.optional {
+					continue
+				}
+				// Missing object field
+				return false
+			}
+			if !f.constraint(s, fv) {
+				return false
+			}
+		}
+		if open {
+			return true
+		}
+		ok := true
+		s.in.eachField(v, func(name string, _ value) bool {
+			// Undefined object fields are not allowed
+			_, ok = names[name]
+			return ok
+		})
+		return ok
+	}, nil
+}
+
+// compare compiles a comparison of either the number or
+// the length of the checked value to the result of value expression e.
+// is reports whether the result of the comparison satisfies the constraint.
+func (c *compiler) compare(
+	e Expression, length bool, is func(int) bool,
+) (constraint, error) {
+	ev, err := c.expr(e)
+	if err != nil {
+		return nil, err
+	}
+	return func(s *progState, v value) bool {
+		if length {
+			l, ok := s.in.length(v)
+			if !ok {
+				return false
+			}
+			v = value{kind: valueInt, i: int64(l)}
+		}
+		r, ok := ev(s)
+		if !ok || !r.isNum() || !v.isNum() {
+			return false
+		}
+		return is(compareNum(v, r))
+	}, nil
+}
+
+// expr compiles value expression e.
+func (c *compiler) expr(e Expression) (evaluator, error) {
+	switch e := e.(type) {
+	case *Number:
+		return constant(numberValue(e)), nil
+	case *String:
+		return constant(value{kind: valueString, s: e.Value}), nil
+	case *Enum:
+		return constant(value{kind: valueEnum, s: e.Value}), nil
+	case *True:
+		return constant(boolValue(true)), nil
+	case *False:
+		return constant(boolValue(false)), nil
+	case *Null:
+		return constant(value{kind: valueNull}), nil
+	case *Array:
+		items, err := c.exprs(e.Items)
+		if err != nil {
+			return nil, err
+		}
+		return func(s *progState) (value, bool) {
+			// Evaluated items are stored in the scratch space
+			// which is truncated by the consumer of the list.
+			// The slots are reserved up front since nested arrays
+			// append their items to the scratch space as well.
+			start := len(s.scratch)
+			s.scratch = append(s.scratch, make([]value, len(items))...)
+			for i, item := range items {
+				v, ok := item(s)
+				if !ok {
+					s.scratch = s.scratch[:start]
+					return value{}, false
+				}
+				s.scratch[start+i] = v
+			}
+			return value{
+				kind: valueList,
+				list: s.scratch[start : start+len(items)],
+			}, true
+		}, nil
+	case *Variable:
+		i := c.variable(e.Declaration)
+		return func(s *progState) (value, bool) {
+			return s.vars[i], s.bound[i]
+		}, nil
+	case *ConstrEquals:
+		return c.expr(e.Value)
+	case *ExprParentheses:
+		return c.expr(e.Expression)
+	case *ExprEqual:
+		return c.expr2(e.Left, e.Right, func(s *progState, l, r value) (value, bool) {
+			return boolValue(s.in.equal(l, r)), true
+		})
+	case *ExprNotEqual:
+		return c.expr2(e.Left, e.Right, func(s *progState, l, r value) (value, bool) {
+			return boolValue(!s.in.equal(l, r)), true
+		})
+	case *ExprLess:
+		return c.exprCompare(e.Left, e.Right, func(c int) bool { return c < 0 })
+	case *ExprLessOrEqual:
+		return c.exprCompare(e.Left, e.Right, func(c int) bool { return c <= 0 })
+	case *ExprGreater:
+		return c.exprCompare(e.Left, e.Right, func(c int) bool { return c > 0 })
+	case *ExprGreaterOrEqual:
+		return c.exprCompare(e.Left, e.Right, func(c int) bool { return c >= 0 })
+	case *ExprLogicalNegation:
+		x, err := c.expr(e.Expression)
+		if err != nil {
+			return nil, err
+		}
+		return func(s *progState) (value, bool) {
+			v, ok := x(s)
+			if !ok || v.kind != valueBool {
+				return value{}, false
+			}
+			return boolValue(!v.b), true
+		}, nil
+	case *ExprLogicalAnd:
+		l, err := c.exprs(e.Expressions)
+		if err != nil {
+			return nil, err
+		}
+		return func(s *progState) (value, bool) {
+			for _, x := range l {
+				v, ok := x(s)
+				if !ok || v.kind != valueBool {
+					return value{}, false
+				} else if !v.b {
+					return boolValue(false), true
+				}
+			}
+			return boolValue(true), true
+		}, nil
+	case *ExprLogicalOr:
+		l, err := c.exprs(e.Expressions)
+		if err != nil {
+			return nil, err
+		}
+		return func(s *progState) (value, bool) {
+			for _, x := range l {
+				v, ok := x(s)
+				if !ok || v.kind != valueBool {
+					return value{}, false
+				} else if v.b {
+					return boolValue(true), true
+				}
+			}
+			return boolValue(false), true
+		}, nil
+	case *ExprNumericNegation:
+		x, err := c.expr(e.Expression)
+		if err != nil {
+			return nil, err
+		}
+		return func(s *progState) (value, bool) {
+			v, ok := x(s)
+			if !ok {
+				return value{}, false
+			}
+			return negate(v)
+		}, nil
+	case *ExprCall:
+		args, err := c.exprs(e.Arguments)
+		if err != nil {
+			return nil, err
+		}
+		name := e.Function.Name
+		return func(s *progState) (value, bool) {
+			mark := len(s.scratch)
+			defer func() { s.scratch = s.scratch[:mark] }()
+			return s.in.call(name, len(args), func(i int) (value, bool) {
+				return args[i](s)
+			})
+		}, nil
+	case *ExprAddition:
+		return c.exprArithmetic(opAdd, e.AddendLeft, e.AddendRight)
+	case *ExprSubtraction:
+		return c.exprArithmetic(opSub, e.Minuend, e.Subtrahend)
+	case *ExprMultiplication:
+		return c.exprArithmetic(opMul, e.Multiplicant, e.Multiplicator)
+	case *ExprDivision:
+		return c.exprArithmetic(opDiv, e.Dividend, e.Divisor)
+	case *ExprModulo:
+		return c.exprArithmetic(opMod, e.Dividend, e.Divisor)
+	}
+	// Expressions that can't be evaluated, such as objects
+	return func(*progState) (value, bool) { return value{}, false }, nil
+}
+
+func (c *compiler) exprs(e []Expression) ([]evaluator, error) {
+	l := make([]evaluator, len(e))
+	for i, e := range e {
+		var err error
+		if l[i], err = c.expr(e); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// expr2 compiles a binary expression.
+// The scratch space used by the operands is released after fn returns.
+func (c *compiler) expr2(
+	a, b Expression, fn func(s *progState, l, r value) (value, bool),
+) (evaluator, error) {
+	l, err := c.expr(a)
+	if err != nil {
+		return nil, err
+	}
+	r, err := c.expr(b)
+	if err != nil {
+		return nil, err
+	}
+	return func(s *progState) (value, bool) {
+		mark := len(s.scratch)
+		defer func() { s.scratch = s.scratch[:mark] }()
+		lv, ok := l(s)
+		if !ok {
+			return value{}, false
+		}
+		rv, ok := r(s)
+		if !ok {
+			return value{}, false
+		}
+		return fn(s, lv, rv)
+	}, nil
+}
+
+func (c *compiler) exprCompare(
+	a, b Expression, is func(int) bool,
+) (evaluator, error) {
+	return c.expr2(a, b, func(_ *progState, l, r value) (value, bool) {
+		if !l.isNum() || !r.isNum() {
+			return value{}, false
+		}
+		return boolValue(is(compareNum(l, r))), true
+	})
+}
+
+func (c *compiler) exprArithmetic(
+	op arithmeticOp, a, b Expression,
+) (evaluator, error) {
+	return c.expr2(a, b, func(_ *progState, l, r value) (value, bool) {
+		return arithmetic(op, l, r)
+	})
+}
+
+func constant(v value) evaluator {
+	return func(*progState) (value, bool) { return v, true }
+}
+
+func objectFieldDefault(f *ObjectField) *ast.Value {
+	if f.Def != nil {
+		return f.Def.DefaultValue
+	}
+	return nil
+}
+
+func (s *progState) matchSet(
+	set *progSet, hostType string, sels ast.SelectionSet,
+) bool {
+	s.epoch++
+	epoch := s.epoch
+	mark := len(s.keys)
+	defer func() { s.keys = s.keys[:mark] }()
+	if !s.matchSelections(set, hostType, sels, epoch) {
+		return false
+	}
+	for _, o := range set.required {
+		if s.marks[o] != epoch {
+			return false
+		}
+	}
+	for _, m := range set.optionSets {
+		n := 0
+		for _, o := range m.options {
+			if s.marks[o] == epoch {
+				n++
+			}
+		}
+		if n < m.min || n > m.max {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *progState) matchSelections(
+	set *progSet, hostType string, sels ast.SelectionSet, epoch uint32,
+) bool {
+	for _, x := range sels {
+		switch x := x.(type) {
+		case *ast.Field:
+			f := set.fields[x.Name]
+			if f == nil {
+				if r := set.recurse; r != nil && r.name == x.Name {
+					if !s.recurse(r, x, epoch) {
+						return false
+					}
+					continue
+				}
+				return false
+			}
+			if f.option > -1 {
+				s.marks[f.option] = epoch
+			}
+			if f.alias != nil && !s.alias(f, x, epoch) {
+				return false
+			}
+			if !s.matchField(f, x) {
+				return false
+			}
+		case *ast.InlineFragment:
+			if !s.matchFrag(
+				set, hostType, x.TypeCondition, x.Directives,
+				x.SelectionSet, epoch,
+			) {
+				return false
+			}
+		case *ast.FragmentSpread:
+			d := s.doc.Fragments.ForName(x.Name)
+			if d == nil {
+				s.err = fmt.Errorf("fragment %q is undefined", x.Name)
+				return false
+			}
+			for _, n := range s.spreads {
+				if n == x.Name {
+					s.err = fmt.Errorf("fragment %q spreads itself", x.Name)
+					return false
+				}
+			}
+			s.spreads = append(s.spreads, x.Name)
+			ok := s.matchFrag(
+				set, hostType, d.TypeCondition, x.Directives, d.SelectionSet, epoch,
+			)
+			s.spreads = s.spreads[:len(s.spreads)-1]
+			if !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (s *progState) matchFrag(
+	set *progSet,
+	hostType, typeCond string,
+	dirs ast.DirectiveList,
+	sels ast.SelectionSet,
+	epoch uint32,
+) bool {
+	if typeCond == "" || typeCond == hostType {
+		if len(dirs) > 0 {
+			// Merged fragments may only use the directives
+			// of the fragment conditioned on the host type
+			var fragDirs []progDirective
+			if f := set.frags[hostType]; f != nil {
+				fragDirs = f.dirs
+			}
+			if !s.matchDirectives(fragDirs, dirs) {
+				return false
+			}
+		}
+		return s.matchSelections(set, hostType, sels, epoch)
+	}
+	f := set.frags[typeCond]
+	if f == nil {
+		return false
+	}
+	if f.option > -1 {
+		s.marks[f.option] = epoch
+	}
+	if !s.matchDirectives(f.dirs, dirs) {
+		return false
+	}
+	return s.matchSet(f.set, typeCond, sels)
+}
+
+func (s *progState) matchField(f *progField, rf *ast.Field) bool {
+	if s.maxDepth > 0 && s.depth >= s.maxDepth {
+		return false
+	}
+	s.depth++
+	ok := s.matchArguments(f.args, rf.Arguments) &&
+		s.matchDirectives(f.dirs, rf.Directives) &&
+		s.matchSet(f.set, f.typeName, rf.SelectionSet)
+	s.depth--
+	return ok
+}
+
+// recurse matches field rf against the field r recurses into
+// unless the recursion is already r.max levels deep.
+// The marks of the enclosing sets are restored after the recursion
+// since it matches the same sets again, overwriting their marks.
+func (s *progState) recurse(
+	r *progRecurse, rf *ast.Field, epoch uint32,
+) bool {
+	if s.recursions[r.index] >= r.max {
+		return false
+	}
+	if r.field.alias != nil && !s.alias(r.field, rf, epoch) {
+		return false
+	}
+	mark := len(s.saved)
+	s.saved = append(s.saved, s.marks...)
+	s.recursions[r.index]++
+	ok := s.matchField(r.field, rf)
+	s.recursions[r.index]--
+	copy(s.marks, s.saved[mark:])
+	s.saved = s.saved[:mark]
+	return ok
+}
+
+func (s *progState) matchDirectives(
+	dirs []progDirective, rd ast.DirectiveList,
+) bool {
+	for _, d := range rd {
+		x := findProgDirective(dirs, d.Name)
+		if x == nil || !s.matchArguments(x.args, d.Arguments) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *progState) matchArguments(
+	args []progArg, rargs ast.ArgumentList,
+) bool {
+	for _, a := range rargs {
+		if !hasArgument(args, a.Name) {
+			return false
+		}
+	}
+	for i := range args {
+		a := &args[i]
+		var v value
+		if ra := rargs.ForName(a.name); ra != nil {
+			v = s.in.fromAST(ra.Value)
+		}
+		if a.absent {
+			if v.kind != valueUndefined {
+				return false
+			}
+			continue
+		}
+		if v.kind == valueUndefined {
+			if a.def == nil {
+				if a.optional {
+					continue
+				}
+				return false
+			}
+			v = s.in.fromAST(a.def)
+		}
+		if a.variable > -1 && !s.bind(a.variable, v) {
+			return false
+		}
+		if a.bind != nil && !a.bind(s, v) {
+			return false
+		}
+		s.checks = append(s.checks, progCheck{
+			constraint: a.constraint,
+			value:      v,
+		})
+	}
+	return true
+}
+
+// alias checks the response key of field rf selected as f against
+// the alias pattern and the limit of f.
+func (s *progState) alias(f *progField, rf *ast.Field, epoch uint32) bool {
+	key := responseKey(rf)
+	if key != rf.Name && !f.alias.matches(key) {
+		return false
+	}
+	if f.alias.Limit < 1 {
+		return true
+	}
+	n := 0
+	for _, k := range s.keys {
+		if k.epoch != epoch || k.field != f {
+			continue
+		}
+		if k.key == key {
+			return true
+		}
+		n++
+	}
+	if n >= f.alias.Limit {
+		return false
+	}
+	s.keys = append(s.keys, progKey{field: f, key: key, epoch: epoch})
+	return true
+}
+
+func findProgDirective(dirs []progDirective, name string) *progDirective {
+	for i := range dirs {
+		if dirs[i].name == name {
+			return &dirs[i]
+		}
+	}
+	return nil
+}
+
+func hasArgument(args []progArg, name string) bool {
+	for i := range args {
+		if args[i].name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// bind binds v to variable i and returns true,
+// returns false if i is already bound to a different value.
+func (s *progState) bind(i int, v value) bool {
+	if s.bound[i] {
+		return s.in.equal(s.vars[i], v)
+	}
+	s.vars[i], s.bound[i] = v, true
+	return true
+}
+
+// objectField returns the value of field name of object v
+// or the default value def if the field isn't provided.
+func (s *progState) objectField(v value, name string, def *ast.Value) value {
+	fv := s.in.field(v, name)
+	if fv.kind == valueUndefined && def != nil {
+		return s.in.fromAST(def)
+	}
+	return fv
+}
@@ -3,6 +3,10 @@ package gqt
 import (
 	"bytes"
 	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
 )
 
 type source struct {
@@ -23,6 +27,16 @@ func (s source) isDigit() bool {
 		s.s[s.Index] >= '0' && s.s[s.Index] <= '9'
 }
 
+// advance returns s advanced by one byte. Columns are counted in runes,
+// the column isn't incremented for UTF-8 continuation bytes.
+func (s source) advance() source {
+	if utf8.RuneStart(s.s[s.Index]) {
+		s.Column++
+	}
+	s.Index++
+	return s
+}
+
 func (s source) peek1(b byte) bool {
 	return s.Index < len(s.s) && s.s[s.Index] == b
 }
@@ -45,8 +59,7 @@ MAIN:
 				} else if s.s[s.Index] < 0x20 {
 					return s
 				}
-				s.Index++
-				s.Column++
+				s = s.advance()
 			}
 		case ' ', '\t', '\r':
 			s.Index++
@@ -140,8 +153,7 @@ func (s source) consumeToken() (_ source, token []byte) {
 			b == ']' || b == '[' || b == '#' {
 			break
 		}
-		s.Index++
-		s.Column++
+		s = s.advance()
 	}
 	return s, i[:s.Index-ii]
 }
@@ -169,37 +181,277 @@ func (s source) consumeName() (_ source, name []byte) {
 	return s, s.s[ii:s.Index]
 }
 
-func (s source) consumeString() (n source, str []byte, ok bool) {
-	escaped := false
-	n = s
+// consumeAliasPattern consumes a name that may contain asterisks.
+func (s source) consumeAliasPattern() (_ source, pattern []byte) {
+	ii := s.Index
+	for s.Index < len(s.s) {
+		b := s.s[s.Index]
+		if b == '*' || b == '_' ||
+			(b >= 'a' && b <= 'z') ||
+			(b >= 'A' && b <= 'Z') ||
+			(b >= '0' && b <= '9' && s.Index > ii) {
+			s.Index++
+			s.Column++
+			continue
+		}
+		break
+	}
+	if s.Index == ii {
+		return s, nil
+	}
+	return s, s.s[ii:s.Index]
+}
+
+// consumeKeyword consumes keyword k if it's not
+// immediately followed by another name character.
+func (s source) consumeKeyword(k string) (_ source, ok bool) {
+	n, name := s.consumeName()
+	if string(name) != k {
+		return s, false
+	}
+	return n, true
+}
+
+// consumeAbsent consumes the keyword "absent" if it makes up
+// the entire constraint of an argument or object field.
+func (s source) consumeAbsent() (_ source, ok bool) {
+	n, ok := s.consumeKeyword("absent")
+	if !ok {
+		return s, false
+	}
+	if e := n.consumeIgnored(); !e.peek1(',') &&
+		!e.peek1(')') && !e.peek1('}') {
+		return s, false
+	}
+	return n, true
+}
 
+// consumeString consumes a string or a block string and returns its
+// decoded value. ok is false if s isn't at the start of a terminated string.
+// If the string contains invalid escape sequences then err is the error
+// at the first of them and str is nil.
+func (s source) consumeString() (n source, str []byte, ok bool, err Error) {
+	if n, ok = s.consume(`"""`); ok {
+		return s.consumeBlockString()
+	}
+	n = s
 	if n.Index >= len(n.s) || n.s[n.Index] != '"' {
-		return s, nil, false
+		return s, nil, false, Error{}
 	}
 	n.Index++
 	n.Column++
-	ii := n.Index
 
+	str = []byte{}
 	for n.Index < len(n.s) {
-		switch {
-		case n.s[n.Index] < 0x20:
-			return s, nil, false
-		case n.s[n.Index] == '"':
-			if escaped {
-				escaped = false
-			} else {
-				str = n.s[ii:n.Index]
-				n.Index++
-				n.Column++
-				return n, str, true
+		switch b := n.s[n.Index]; {
+		case b == '"':
+			n.Index++
+			n.Column++
+			if err.IsErr() {
+				return n, nil, true, err
+			}
+			return n, str, true, Error{}
+		case b < 0x20 && b != '\t':
+			return s, nil, false, Error{}
+		case b == '\\':
+			e := n
+			var r rune
+			var msg string
+			if n, r, msg = n.consumeEscape(); msg != "" {
+				if !err.IsErr() {
+					err = Error{
+						LocRange: LocRange{
+							Location:    e.Location,
+							LocationEnd: locEnd(n),
+						},
+						Msg: msg,
+					}
+				}
+				continue
+			}
+			str = utf8.AppendRune(str, r)
+		default:
+			str = append(str, b)
+			n = n.advance()
+		}
+	}
+	return s, nil, false, Error{}
+}
+
+// consumeEscape consumes the escape sequence at the backslash
+// and returns the escaped rune. If the sequence is invalid then msg
+// is the error message and n is after the invalid part of the sequence.
+func (s source) consumeEscape() (n source, r rune, msg string) {
+	n = s
+	n.Index++
+	n.Column++
+	if n.Index >= len(n.s) {
+		return n, 0, "invalid escape sequence"
+	}
+	b := n.s[n.Index]
+	if b < 0x20 {
+		// Leave control characters to the string
+		return n, 0, "invalid escape sequence"
+	}
+	n.Index++
+	n.Column++
+	switch b {
+	case '"', '\\', '/':
+		return n, rune(b), ""
+	case 'b':
+		return n, '\b', ""
+	case 'f':
+		return n, '\f', ""
+	case 'n':
+		return n, '\n', ""
+	case 'r':
+		return n, '\r', ""
+	case 't':
+		return n, '\t', ""
+	case 'u':
+	default:
+		return n, 0, "invalid escape sequence"
+	}
+
+	if n, ok := n.consume("{"); ok {
+		// Variable-width \u{X...}
+		var digits int
+		for ; n.Index < len(n.s) && isHexDigit(n.s[n.Index]); digits++ {
+			if r = r<<4 | hexValue(n.s[n.Index]); r > unicode.MaxRune {
+				r = unicode.MaxRune + 1
+			}
+			n.Index++
+			n.Column++
+		}
+		if n, ok = n.consume("}"); !ok || digits < 1 ||
+			r > unicode.MaxRune || utf16.IsSurrogate(r) {
+			return n, 0, "invalid Unicode escape sequence"
+		}
+		return n, r, ""
+	}
+
+	// Fixed-width \uXXXX
+	var ok bool
+	if n, r, ok = n.consumeHex4(); !ok {
+		return n, 0, "invalid Unicode escape sequence"
+	}
+	if !utf16.IsSurrogate(r) {
+		return n, r, ""
+	}
+	// Surrogate pair \uXXXX\uXXXX
+	if r < 0xDC00 {
+		if l, ok := n.consume(`\u`); ok {
+			if l, low, ok := l.consumeHex4(); ok &&
+				low >= 0xDC00 && low <= 0xDFFF {
+				return l, utf16.DecodeRune(r, low), ""
 			}
-		case n.s[n.Index] == '\\':
-			escaped = !escaped
 		}
+	}
+	return n, 0, "invalid Unicode escape sequence"
+}
+
+// consumeHex4 consumes 4 hexadecimal digits.
+func (s source) consumeHex4() (n source, r rune, ok bool) {
+	n = s
+	for i := 0; i < 4; i++ {
+		if n.Index >= len(n.s) || !isHexDigit(n.s[n.Index]) {
+			return n, 0, false
+		}
+		r = r<<4 | hexValue(n.s[n.Index])
 		n.Index++
 		n.Column++
 	}
-	return s, nil, false
+	return n, r, true
+}
+
+func isHexDigit(b byte) bool {
+	return (b >= '0' && b <= '9') ||
+		(b >= 'a' && b <= 'f') ||
+		(b >= 'A' && b <= 'F')
+}
+
+func hexValue(b byte) rune {
+	switch {
+	case b >= 'a':
+		return rune(b-'a') + 10
+	case b >= 'A':
+		return rune(b-'A') + 10
+	}
+	return rune(b - '0')
+}
+
+// consumeBlockString consumes a block string at the opening triple-quote
+// and returns its value with the common indentation as well as
+// leading and trailing blank lines removed.
+func (s source) consumeBlockString() (n source, str []byte, ok bool, err Error) {
+	n, _ = s.consume(`"""`)
+	var raw []byte
+	for n.Index < len(n.s) {
+		switch b := n.s[n.Index]; {
+		case b == '"':
+			if e, ok := n.consume(`"""`); ok {
+				return e, blockStringValue(raw), true, Error{}
+			}
+			raw = append(raw, b)
+			n.Index++
+			n.Column++
+		case b == '\\':
+			if e, ok := n.consume(`\"""`); ok {
+				raw = append(raw, `"""`...)
+				n = e
+				continue
+			}
+			raw = append(raw, b)
+			n.Index++
+			n.Column++
+		case b == '\n':
+			raw = append(raw, b)
+			n.Index++
+			n.Line++
+			n.Column = 1
+		case b < 0x20 && b != '\t' && b != '\r':
+			return s, nil, false, Error{}
+		default:
+			raw = append(raw, b)
+			n = n.advance()
+		}
+	}
+	return s, nil, false, Error{}
+}
+
+// blockStringValue returns the value of the raw block string
+// as defined by the GraphQL specification.
+func blockStringValue(raw []byte) []byte {
+	lines := strings.Split(
+		strings.NewReplacer("\r\n", "\n", "\r", "\n").Replace(string(raw)),
+		"\n",
+	)
+	indent := func(l string) int {
+		return len(l) - len(strings.TrimLeft(l, " \t"))
+	}
+	blank := func(l string) bool { return indent(l) == len(l) }
+	common := -1
+	for _, l := range lines[1:] {
+		if i := indent(l); !blank(l) && (common < 0 || i < common) {
+			common = i
+		}
+	}
+	if common > 0 {
+		for i := 1; i < len(lines); i++ {
+			if len(lines[i]) < common {
+				lines[i] = ""
+			} else {
+				lines[i] = lines[i][common:]
+			}
+		}
+	}
+	for len(lines) > 0 && blank(lines[0]) {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && blank(lines[len(lines)-1]) {
+		lines = lines[:len(lines)-1]
+	}
+	return []byte(strings.Join(lines, "\n"))
 }
 
 func (s source) consumeUnsignedInt() (next source, i int64, ok bool) {
@@ -214,3 +466,85 @@ func (s source) consumeUnsignedInt() (next source, i int64, ok bool) {
 	}
 	return s, i, true
 }
+
+// skipUntil skips tokens, strings and balanced brackets until either
+// a byte of terminators or an unbalanced closing curly brace is reached
+// at the current nesting level, or until the end of file is reached.
+// A closing bracket that doesn't match any of the open brackets is
+// considered unbalanced, unbalanced closing parentheses and square brackets
+// that aren't terminators are skipped.
+func (s source) skipUntil(terminators string) source {
+	var open []byte
+	for {
+		if s = s.consumeIgnored(); s.isEOF() {
+			return s
+		}
+		switch b := s.s[s.Index]; b {
+		case '(', '[', '{':
+			open = append(open, b)
+		case ')', ']', '}':
+			o := byte('(')
+			if b == ']' {
+				o = '['
+			} else if b == '}' {
+				o = '{'
+			}
+			if i := bytes.LastIndexByte(open, o); i > -1 {
+				open = open[:i]
+				break
+			}
+			if b == '}' {
+				return s
+			}
+			if len(open) < 1 && strings.IndexByte(terminators, b) > -1 {
+				return s
+			}
+		case '"':
+			if n, _, ok, _ := s.consumeString(); ok {
+				s = n
+				continue
+			}
+		default:
+			if len(open) < 1 && strings.IndexByte(terminators, b) > -1 {
+				return s
+			}
+		}
+		s = s.advance()
+	}
+}
+
+// scanComments returns the comments of src in the order of their
+// appearance skipping the number signs inside of strings.
+func scanComments(src []byte) (comments []*Comment) {
+	s := source{Location: Location{Line: 1, Column: 1}, s: src}
+	for !s.isEOF() {
+		switch s.s[s.Index] {
+		case '"':
+			if n, _, ok, _ := s.consumeString(); ok {
+				s = n
+				continue
+			}
+		case '\n':
+			s.Index++
+			s.Line++
+			s.Column = 1
+			continue
+		case '#':
+			start := s
+			for !s.isEOF() && s.s[s.Index] != '\n' {
+				s = s.advance()
+			}
+			text := bytes.TrimSuffix(src[start.Index+1:s.Index], []byte("\r"))
+			comments = append(comments, &Comment{
+				LocRange: LocRange{
+					Location:    start.Location,
+					LocationEnd: locEnd(s),
+				},
+				Text: string(text),
+			})
+			continue
+		}
+		s = s.advance()
+	}
+	return comments
+}
@@ -3,4 +3,7 @@
 // To use the schema-aware mode create a parser instance using NewParser
 // and provide all .graphqls schema files.
 // gqt.Parse will parse the template in schemaless mode.
+// Templates of multiple named operations are parsed using ParseDocument.
+// Files imported by templates are resolved in the file system set
+// using Parser.SetFS.
 package gqt
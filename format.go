@@ -0,0 +1,661 @@
+package gqt
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// FormatOptions are the options of Format.
+type FormatOptions struct {
+	// Indent is the string used for a single level of indentation.
+	// Defaults to two spaces if empty.
+	Indent string
+}
+
+// Format writes the canonical GQT source of e to w.
+// e can be any expression of the abstract syntax tree, e.g. an *Operation,
+// a selection, an argument or a constraint.
+//
+// Parentheses are preserved and additionally inserted where required by
+// the precedence of the operators, which guarantees that parsing
+// the formatted source of an operation produced by the parser
+// results in a structurally equal abstract syntax tree.
+// The formatted source of an operation reduced by Optimize parses
+// to an operation that Optimize reduces to a structurally equal one.
+//
+// The comments of an operation are preserved in the order of their
+// appearance. Comments on their own lines are written on their own lines
+// before the next selection or definition and comments following
+// a selection or a definition on the same line are kept on its line.
+// Comments inside of argument lists, constraints and other expressions
+// that don't start a line are moved before the next selection.
+func Format(w io.Writer, e Expression, opts FormatOptions) error {
+	if opts.Indent == "" {
+		opts.Indent = "  "
+	}
+	f := formatter{w: w, opts: opts}
+	o, isOperation := e.(*Operation)
+	if isOperation {
+		f.comments = o.Comments
+	}
+	f.expr(e, precLowest)
+	if isOperation {
+		f.write("\n")
+		f.remainingComments()
+	}
+	return f.err
+}
+
+// FormatDocument writes the canonical GQT source of document d to w.
+// The imports and constraint alias definitions are followed by
+// the operations and fragment definitions in the order of their appearance.
+// See Format for details.
+func FormatDocument(w io.Writer, d *Document, opts FormatOptions) error {
+	if opts.Indent == "" {
+		opts.Indent = "  "
+	}
+	f := formatter{w: w, opts: opts, comments: d.Comments}
+	f.definitions(d.Imports, d.ConstrAliases)
+
+	// Every operation holds copies of the fragments it uses
+	var defs []Expression
+	declared := map[string]struct{}{}
+	for _, o := range d.Operations {
+		defs = append(defs, o)
+		for _, x := range o.Fragments {
+			if _, ok := declared[x.Name.Name]; ok || x.File != "" {
+				continue
+			}
+			declared[x.Name.Name] = struct{}{}
+			defs = append(defs, x)
+		}
+	}
+	sort.SliceStable(defs, func(i, j int) bool {
+		return defs[i].GetLocation().Index < defs[j].GetLocation().Index
+	})
+
+	for i, e := range defs {
+		if i > 0 {
+			f.write("\n\n")
+		}
+		if o, ok := e.(*Operation); ok {
+			f.operation(o)
+		} else {
+			f.leadingComments(e.GetLocation().Index)
+			f.expr(e, precLowest)
+		}
+		next := -1
+		if i+1 < len(defs) {
+			next = defs[i+1].GetLocation().Index
+		}
+		f.trailingComment(e.GetLocation(), next)
+	}
+	f.write("\n")
+	f.remainingComments()
+	return f.err
+}
+
+// Precedence levels of expressions in ascending order.
+const (
+	precLowest = iota
+	precOr
+	precAnd
+	precEquality
+	precRelational
+	precAdditive
+	precMultiplicative
+	precUnary
+	precPrimary
+)
+
+// precedence returns the precedence level of e.
+func precedence(e Expression) int {
+	switch e.(type) {
+	case *ExprLogicalOr:
+		return precOr
+	case *ExprLogicalAnd:
+		return precAnd
+	case *ExprEqual, *ExprNotEqual:
+		return precEquality
+	case *ExprLess, *ExprLessOrEqual, *ExprGreater, *ExprGreaterOrEqual:
+		return precRelational
+	case *ExprAddition, *ExprSubtraction:
+		return precAdditive
+	case *ExprMultiplication, *ExprDivision, *ExprModulo:
+		return precMultiplicative
+	case *ExprLogicalNegation, *ExprNumericNegation:
+		return precUnary
+	}
+	return precPrimary
+}
+
+type formatter struct {
+	w        io.Writer
+	opts     FormatOptions
+	indent   int
+	comments []*Comment // Comments that aren't written yet.
+	err      error
+}
+
+func (f *formatter) write(s string) {
+	if f.err != nil {
+		return
+	}
+	_, f.err = io.WriteString(f.w, s)
+}
+
+func (f *formatter) newline() {
+	f.write("\n")
+	for i := 0; i < f.indent; i++ {
+		f.write(f.opts.Indent)
+	}
+}
+
+// expr writes e and wraps it in parentheses if its precedence
+// is lower than min.
+func (f *formatter) expr(e Expression, min int) {
+	if precedence(e) < min {
+		f.write("(")
+		defer f.write(")")
+	}
+	switch e := e.(type) {
+	case *Operation:
+		f.definitions(e.Imports, e.ConstrAliases)
+		f.operation(e)
+		f.trailingComment(e.LocRange, -1)
+		for _, d := range e.Fragments {
+			if d.File != "" {
+				// Imported
+				continue
+			}
+			f.write("\n\n")
+			f.leadingComments(d.Index)
+			f.expr(d, precLowest)
+			f.trailingComment(d.LocRange, -1)
+		}
+	case *FragmentDefinition:
+		f.write("fragment ")
+		f.write(e.Name.Name)
+		f.write(" on ")
+		f.write(e.TypeCondition.TypeName)
+		f.write(" ")
+		f.selSet(e.SelectionSet)
+	case *SelectionField:
+		if e.Alias != nil {
+			f.write(e.Alias.Pattern)
+			if e.Alias.Limit > 0 {
+				f.write("[")
+				f.write(strconv.Itoa(e.Alias.Limit))
+				f.write("]")
+			}
+			f.write(": ")
+		}
+		f.write(e.Name.Name)
+		if e.Required {
+			f.write("!")
+		}
+		if len(e.Arguments) > 0 {
+			f.write("(")
+			for i, a := range e.Arguments {
+				if i > 0 {
+					f.write(", ")
+				}
+				f.expr(a, precLowest)
+			}
+			f.write(")")
+		}
+		f.directives(e.Directives)
+		if len(e.Selections) > 0 {
+			f.write(" ")
+			f.selSet(e.SelectionSet)
+		}
+	case *SelectionInlineFrag:
+		f.write("... on ")
+		f.write(e.TypeCondition.TypeName)
+		f.directives(e.Directives)
+		f.write(" ")
+		f.selSet(e.SelectionSet)
+	case *SelectionMax:
+		f.optionSet("max", e.Limit, e.Options)
+	case *SelectionMin:
+		f.optionSet("min", e.Limit, e.Options)
+	case *SelectionExactly:
+		f.optionSet("exactly", e.Limit, e.Options)
+	case *SelectionRecurse:
+		f.write("...recurse(max: ")
+		f.write(strconv.Itoa(e.Max))
+		f.write(")")
+	case *SelectionSpread:
+		f.write("...")
+		f.write(e.Name.Name)
+	case *Directive:
+		f.write("@")
+		f.write(e.Name.Name)
+		if len(e.Arguments) > 0 {
+			f.write("(")
+			for i, a := range e.Arguments {
+				if i > 0 {
+					f.write(", ")
+				}
+				f.expr(a, precLowest)
+			}
+			f.write(")")
+		}
+	case *Argument:
+		f.write(e.Name.Name)
+		if e.Optional {
+			f.write("?")
+		}
+		if e.AssociatedVariable != nil {
+			f.write("=$")
+			f.write(e.AssociatedVariable.Name)
+		}
+		f.write(": ")
+		if e.Absent {
+			f.write("absent")
+		} else {
+			f.expr(e.Constraint, precLowest)
+		}
+	case *ObjectField:
+		f.write(e.Name.Name)
+		if e.Optional {
+			f.write("?")
+		}
+		if e.AssociatedVariable != nil {
+			f.write("=$")
+			f.write(e.AssociatedVariable.Name)
+		}
+		f.write(": ")
+		if e.Absent {
+			f.write("absent")
+		} else {
+			f.expr(e.Constraint, precLowest)
+		}
+	case *ConstrAny:
+		f.write("*")
+	case *ConstrEquals:
+		f.expr(e.Value, precEquality)
+	case *ConstrNotEquals:
+		f.constr("!= ", e.Value)
+	case *ConstrLess:
+		f.constr("< ", e.Value)
+	case *ConstrLessOrEqual:
+		f.constr("<= ", e.Value)
+	case *ConstrGreater:
+		f.constr("> ", e.Value)
+	case *ConstrGreaterOrEqual:
+		f.constr(">= ", e.Value)
+	case *ConstrLenEquals:
+		f.constr("len ", e.Value)
+	case *ConstrLenNotEquals:
+		f.constr("len != ", e.Value)
+	case *ConstrLenLess:
+		f.constr("len < ", e.Value)
+	case *ConstrLenLessOrEqual:
+		f.constr("len <= ", e.Value)
+	case *ConstrLenGreater:
+		f.constr("len > ", e.Value)
+	case *ConstrLenGreaterOrEqual:
+		f.constr("len >= ", e.Value)
+	case *ConstrMatches:
+		f.constr("~ ", e.Value)
+	case *ConstrIn:
+		f.write("in [")
+		f.list(e.Values, ", ", precLowest)
+		f.write("]")
+	case *ConstrNotIn:
+		f.write("not in [")
+		f.list(e.Values, ", ", precLowest)
+		f.write("]")
+	case *ConstrUnique:
+		f.write("unique")
+	case *ConstrContains:
+		f.constr("contains ", e.Value)
+	case *ConstrSum:
+		f.write("sum ")
+		f.expr(e.Constraint, precPrimary)
+	case *ConstrCount:
+		f.write("count where (")
+		f.expr(e.Where, precLowest)
+		f.write(") ")
+		f.expr(e.Constraint, precPrimary)
+	case *ConstrMap:
+		f.write("[...")
+		f.expr(e.Constraint, precLowest)
+		f.write("]")
+	case *ConstrAlias:
+		f.write("is ")
+		f.write(e.Name.Name)
+	case *ExprParentheses:
+		f.write("(")
+		f.expr(e.Expression, precLowest)
+		f.write(")")
+	case *ExprLogicalOr:
+		f.list(e.Expressions, " || ", precAnd)
+	case *ExprLogicalAnd:
+		f.list(e.Expressions, " && ", precEquality)
+	case *ExprEqual:
+		f.binary(e.Left, " == ", e.Right, precRelational, precRelational)
+	case *ExprNotEqual:
+		f.binary(e.Left, " != ", e.Right, precRelational, precRelational)
+	case *ExprLess:
+		f.binary(e.Left, " < ", e.Right, precAdditive, precAdditive)
+	case *ExprLessOrEqual:
+		f.binary(e.Left, " <= ", e.Right, precAdditive, precAdditive)
+	case *ExprGreater:
+		f.binary(e.Left, " > ", e.Right, precAdditive, precAdditive)
+	case *ExprGreaterOrEqual:
+		f.binary(e.Left, " >= ", e.Right, precAdditive, precAdditive)
+	case *ExprAddition:
+		f.binary(
+			e.AddendLeft, " + ", e.AddendRight,
+			precAdditive, precMultiplicative,
+		)
+	case *ExprSubtraction:
+		f.binary(
+			e.Minuend, " - ", e.Subtrahend,
+			precAdditive, precMultiplicative,
+		)
+	case *ExprMultiplication:
+		f.binary(
+			e.Multiplicant, " * ", e.Multiplicator,
+			precMultiplicative, precUnary,
+		)
+	case *ExprDivision:
+		f.binary(
+			e.Dividend, " / ", e.Divisor,
+			precMultiplicative, precUnary,
+		)
+	case *ExprModulo:
+		f.binary(
+			e.Dividend, " % ", e.Divisor,
+			precMultiplicative, precUnary,
+		)
+	case *ExprLogicalNegation:
+		f.write("!")
+		f.expr(e.Expression, precPrimary)
+	case *ExprNumericNegation:
+		f.write("-")
+		if _, ok := e.Expression.(*Number); ok {
+			// Prevent the negation from being parsed as a negative number
+			f.write("(")
+			defer f.write(")")
+		}
+		f.expr(e.Expression, precPrimary)
+	case *ExprCall:
+		f.write(e.Function.Name)
+		f.write("(")
+		f.list(e.Arguments, ", ", precLowest)
+		f.write(")")
+	case *Variable:
+		f.write("$")
+		f.write(e.Name.Name)
+	case *Number:
+		f.write(e.Value)
+	case *String:
+		f.write(quote(e.Value))
+	case *Enum:
+		f.write(e.Value)
+	case *True:
+		f.write("true")
+	case *False:
+		f.write("false")
+	case *Null:
+		f.write("null")
+	case *Array:
+		f.write("[")
+		f.list(e.Items, ", ", precLowest)
+		f.write("]")
+	case *Object:
+		f.write("{")
+		for i, x := range e.Fields {
+			if i > 0 {
+				f.write(", ")
+			}
+			f.expr(x, precLowest)
+		}
+		if e.Open {
+			if len(e.Fields) > 0 {
+				f.write(", ")
+			}
+			f.write("...")
+		}
+		f.write("}")
+	default:
+		if f.err == nil {
+			f.err = fmt.Errorf("unsupported expression type: %T", e)
+		}
+	}
+}
+
+// operation writes the metadata header, the type, the name,
+// the max depth and the selection set of o.
+func (f *formatter) operation(o *Operation) {
+	if o.Metadata != nil {
+		f.leadingComments(o.Metadata.Index)
+		f.metadata(o.Metadata)
+		f.trailingComment(o.Metadata.LocRange, o.Index)
+		f.newline()
+	}
+	f.leadingComments(o.Index)
+	switch o.Type {
+	case OperationTypeQuery:
+		f.write("query")
+	case OperationTypeMutation:
+		f.write("mutation")
+	case OperationTypeSubscription:
+		f.write("subscription")
+	}
+	if o.Name != nil {
+		f.write(" ")
+		f.write(o.Name.Name)
+	}
+	if o.MaxDepth > 0 {
+		f.write(" maxDepth ")
+		f.write(strconv.Itoa(o.MaxDepth))
+	}
+	f.write(" ")
+	f.selSet(o.SelectionSet)
+}
+
+func (f *formatter) selSet(s SelectionSet) {
+	f.write("{")
+	f.indent++
+	for i, x := range s.Selections {
+		l := x.GetLocation()
+		for f.commentBefore(l.Index) {
+			f.newline()
+			f.comment()
+		}
+		f.newline()
+		f.expr(x, precLowest)
+		next := s.IndexEnd
+		if i+1 < len(s.Selections) {
+			next = s.Selections[i+1].GetLocation().Index
+		}
+		f.trailingComment(l, next)
+	}
+	for f.commentBefore(s.IndexEnd) {
+		f.newline()
+		f.comment()
+	}
+	f.indent--
+	f.newline()
+	f.write("}")
+}
+
+// commentBefore returns true if the next comment
+// that isn't written yet starts before index.
+func (f *formatter) commentBefore(index int) bool {
+	return len(f.comments) > 0 && f.comments[0].Index < index
+}
+
+// comment writes the next comment.
+func (f *formatter) comment() {
+	f.write("#")
+	f.write(f.comments[0].Text)
+	f.comments = f.comments[1:]
+}
+
+// leadingComments writes the comments starting before index
+// each on its own line at the start of a line.
+func (f *formatter) leadingComments(index int) {
+	for f.commentBefore(index) {
+		f.comment()
+		f.newline()
+	}
+}
+
+// trailingComment writes the next comment on the same line if it follows
+// the expression at l on the line the expression ends on
+// and starts before index, which is ignored if negative.
+func (f *formatter) trailingComment(l LocRange, index int) {
+	if len(f.comments) < 1 {
+		return
+	}
+	c := f.comments[0]
+	if c.Index >= l.IndexEnd && c.Line == l.LineEnd &&
+		(index < 0 || c.Index < index) {
+		f.write(" ")
+		f.comment()
+	}
+}
+
+// remainingComments writes all comments that aren't written yet
+// each on its own line.
+func (f *formatter) remainingComments() {
+	for len(f.comments) > 0 {
+		f.comment()
+		f.write("\n")
+	}
+}
+
+// metadata writes the metadata header of an operation.
+func (f *formatter) metadata(m *Metadata) {
+	f.write("meta {")
+	f.indent++
+	if m.Description != "" {
+		f.newline()
+		f.write("description: ")
+		f.write(quote(m.Description))
+	}
+	if m.Owner != "" {
+		f.newline()
+		f.write("owner: ")
+		f.write(quote(m.Owner))
+	}
+	if m.Tags != nil {
+		f.newline()
+		f.write("tags: [")
+		for i, t := range m.Tags {
+			if i > 0 {
+				f.write(", ")
+			}
+			f.write(quote(t))
+		}
+		f.write("]")
+	}
+	f.indent--
+	f.newline()
+	f.write("}")
+}
+
+// definitions writes the imports and the constraint alias definitions
+// preceding an operation each followed by an empty line.
+func (f *formatter) definitions(
+	imports []*Import, aliases []*ConstrAliasDefinition,
+) {
+	for i, x := range imports {
+		f.leadingComments(x.Index)
+		f.write("import ")
+		f.write(quote(x.Path))
+		f.trailingComment(x.LocRange, -1)
+		f.newline()
+		if i == len(imports)-1 {
+			f.newline()
+		}
+	}
+	for i, d := range aliases {
+		f.leadingComments(d.Index)
+		f.write("constraint ")
+		f.write(d.Name.Name)
+		f.write(" = ")
+		f.expr(d.Constraint, precLowest)
+		f.trailingComment(d.LocRange, -1)
+		f.newline()
+		if i == len(aliases)-1 {
+			f.newline()
+		}
+	}
+}
+
+// optionSet writes a max, min or exactly set.
+func (f *formatter) optionSet(kind string, limit int, options SelectionSet) {
+	f.write(kind)
+	f.write(" ")
+	f.write(strconv.Itoa(limit))
+	f.write(" ")
+	f.selSet(options)
+}
+
+func (f *formatter) directives(l []*Directive) {
+	for _, d := range l {
+		f.write(" ")
+		f.expr(d, precLowest)
+	}
+}
+
+func (f *formatter) constr(operator string, value Expression) {
+	f.write(operator)
+	f.expr(value, precEquality)
+}
+
+func (f *formatter) binary(
+	left Expression, operator string, right Expression,
+	minLeft, minRight int,
+) {
+	f.expr(left, minLeft)
+	f.write(operator)
+	f.expr(right, minRight)
+}
+
+func (f *formatter) list(l []Expression, separator string, min int) {
+	for i, x := range l {
+		if i > 0 {
+			f.write(separator)
+		}
+		f.expr(x, min)
+	}
+}
+
+// quote returns s as a GQT string escaping quotes, backslashes
+// and control characters.
+func quote(s string) string {
+	b := make([]byte, 0, len(s)+2)
+	b = append(b, '"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"', '\\':
+			b = append(b, '\\', c)
+		case '\b':
+			b = append(b, `\b`...)
+		case '\f':
+			b = append(b, `\f`...)
+		case '\n':
+			b = append(b, `\n`...)
+		case '\r':
+			b = append(b, `\r`...)
+		case '\t':
+			b = append(b, `\t`...)
+		default:
+			if c < 0x20 {
+				b = append(b, fmt.Sprintf(`\u%04x`, c)...)
+				continue
+			}
+			b = append(b, c)
+		}
+	}
+	return string(append(b, '"'))
+}
@@ -0,0 +1,401 @@
+package gqt_test
+
+import (
+	"bytes"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/graph-guard/gqt/v4"
+	"github.com/graph-guard/gqt/v4/internal/test"
+	"github.com/stretchr/testify/require"
+	yaml "gopkg.in/yaml.v3"
+)
+
+func TestFormat(t *testing.T) {
+	type T struct {
+		template string
+		opts     gqt.FormatOptions
+		expect   string
+	}
+	f := test.New(t, func(t *testing.T, x T) {
+		opr, _, errs := gqt.Parse([]byte(x.template))
+		require.Len(t, errs, 0, "unexpected errors: %v", errs)
+		var b bytes.Buffer
+		require.NoError(t, gqt.Format(&b, opr, x.opts))
+		require.Equal(t, x.expect, b.String())
+	})
+
+	f(T{
+		template: `query{user(id:*){name}}`,
+		expect: "query {\n" +
+			"  user(id: *) {\n" +
+			"    name\n" +
+			"  }\n" +
+			"}\n",
+	})
+	f(T{
+		template: `mutation{a(x=$x:<10,y:len>=2&&[...len<3]){b}}`,
+		opts:     gqt.FormatOptions{Indent: "\t"},
+		expect: "mutation {\n" +
+			"\ta(x=$x: < 10, y: len >= 2 && [...len < 3]) {\n" +
+			"\t\tb\n" +
+			"\t}\n" +
+			"}\n",
+	})
+	f(T{
+		template: `subscription { a { max 1 { b ... on C { d } } } }`,
+		expect: "subscription {\n" +
+			"  a {\n" +
+			"    max 1 {\n" +
+			"      b\n" +
+			"      ... on C {\n" +
+			"        d\n" +
+			"      }\n" +
+			"    }\n" +
+			"  }\n" +
+			"}\n",
+	})
+	f(T{
+		template: `query { a(
+			a=$a: 1 + 2*3 - (4-5),
+			b: {c=$c:  "x",d:[1,null,  true] , e: FOO},
+			c: len 2 || len!=3 && (> 1 || <= -1),
+			d: !($a==1) == false,
+			e: -$a,
+		) }`,
+		expect: "query {\n" +
+			"  a(" +
+			"a=$a: 1 + 2 * 3 - (4 - 5), " +
+			`b: {c=$c: "x", d: [1, null, true], e: FOO}, ` +
+			"c: len 2 || len != 3 && (> 1 || <= -1), " +
+			"d: !($a == 1) == false, " +
+			"e: -$a" +
+			")\n" +
+			"}\n",
+	})
+	f(T{
+		template: `query{a(x=$x:*,y:<min( 1,abs(-$x) ,len($x)*2))}`,
+		expect: "query {\n" +
+			"  a(x=$x: *, y: < min(1, abs(-$x), len($x) * 2))\n" +
+			"}\n",
+	})
+	f(T{
+		template: `query{a(x:in[A,B],y:not  in [1,"b" , null],z:~"^a")}`,
+		expect: "query {\n" +
+			`  a(x: in [A, B], y: not in [1, "b", null], z: ~ "^a")` + "\n" +
+			"}\n",
+	})
+	f(T{
+		template: `query{a_*[ 2 ] :a{b} *:c  x:d}`,
+		expect: "query {\n" +
+			"  a_*[2]: a {\n" +
+			"    b\n" +
+			"  }\n" +
+			"  *: c\n" +
+			"  x: d\n" +
+			"}\n",
+	})
+	f(T{
+		template: `query{a(x ? :1,y :absent,z?=$z:{a? : *,b:absent})}`,
+		expect: "query {\n" +
+			"  a(x?: 1, y: absent, z?=$z: {a?: *, b: absent})\n" +
+			"}\n",
+	})
+	f(T{
+		template: `query{a !(x:1){id  !min 1{b c}exactly 1{d e}}}`,
+		expect: "query {\n" +
+			"  a!(x: 1) {\n" +
+			"    id!\n" +
+			"    min 1 {\n" +
+			"      b\n" +
+			"      c\n" +
+			"    }\n" +
+			"    exactly 1 {\n" +
+			"      d\n" +
+			"      e\n" +
+			"    }\n" +
+			"  }\n" +
+			"}\n",
+	})
+	f(T{
+		template: `query   maxDepth  4{a{b ... recurse ( max : 2 )}}`,
+		expect: "query maxDepth 4 {\n" +
+			"  a {\n" +
+			"    b\n" +
+			"    ...recurse(max: 2)\n" +
+			"  }\n" +
+			"}\n",
+	})
+	f(T{
+		template: `query maxDepth{a}`,
+		expect: "query maxDepth {\n" +
+			"  a\n" +
+			"}\n",
+	})
+	f(T{
+		template: `meta{tags:["a" "b"]owner:"x"}query  Q{a}`,
+		expect: "meta {\n" +
+			"  owner: \"x\"\n" +
+			"  tags: [\"a\", \"b\"]\n" +
+			"}\n" +
+			"query Q {\n" +
+			"  a\n" +
+			"}\n",
+	})
+	f(T{
+		template: `fragment F on T{b}query{a{... G}}fragment G on T{c...F}`,
+		expect: "query {\n" +
+			"  a {\n" +
+			"    ...G\n" +
+			"  }\n" +
+			"}\n" +
+			"\n" +
+			"fragment F on T {\n" +
+			"  b\n" +
+			"}\n" +
+			"\n" +
+			"fragment G on T {\n" +
+			"  c\n" +
+			"  ...F\n" +
+			"}\n",
+	})
+	f(T{
+		template: `constraint Id=len>0  constraint Ids=[...is Id]` +
+			`query{a(x:is Id,y:is Ids||null)}`,
+		expect: "constraint Id = len > 0\n" +
+			"constraint Ids = [...is Id]\n" +
+			"\n" +
+			"query {\n" +
+			"  a(x: is Id, y: is Ids || null)\n" +
+			"}\n",
+	})
+	f(T{
+		template: `query{a(x:1)@b  @c(y ? :*){...on T@d{e@f}}}`,
+		expect: "query {\n" +
+			"  a(x: 1) @b @c(y?: *) {\n" +
+			"    ... on T @d {\n" +
+			"      e @f\n" +
+			"    }\n" +
+			"  }\n" +
+			"}\n",
+	})
+	f(T{
+		template: `query{a(x:{a:1,...},y:{ ... , },z:[...{b:*,...}])}`,
+		expect: "query {\n" +
+			"  a(x: {a: 1, ...}, y: {...}, z: [...{b: *, ...}])\n" +
+			"}\n",
+	})
+	f(T{
+		template: `query{a(x:unique&&contains [1,2],` +
+			`y:sum(>1||<0)&&count where(len>1)<=2)}`,
+		expect: "query {\n" +
+			"  a(x: unique && contains [1, 2], " +
+			"y: sum (> 1 || < 0) && count where (len > 1) <= 2)\n" +
+			"}\n",
+	})
+	f(T{
+		template: "# head\n" +
+			"constraint A = > 1 # alias\n" +
+			"query { # open\n" +
+			"  a(x: # inside\n" +
+			"    is A) # after a\n" +
+			"  # before b\n" +
+			"  b { ...F } d(s: \"# not a comment\")\n" +
+			"  # end of set\n" +
+			"} # after query\n" +
+			"fragment F on T { x } # after F\n" +
+			"# tail",
+		expect: "# head\n" +
+			"constraint A = > 1 # alias\n" +
+			"\n" +
+			"query {\n" +
+			"  # open\n" +
+			"  a(x: is A)\n" +
+			"  # inside\n" +
+			"  # after a\n" +
+			"  # before b\n" +
+			"  b {\n" +
+			"    ...F\n" +
+			"  }\n" +
+			"  d(s: \"# not a comment\")\n" +
+			"  # end of set\n" +
+			"} # after query\n" +
+			"\n" +
+			"fragment F on T {\n" +
+			"  x\n" +
+			"} # after F\n" +
+			"# tail\n",
+	})
+	f(T{
+		template: `query { a(x: "\u0041\"\\\t\u0001", y: """` +
+			"\n  b\n    c\n" + `""") }`,
+		expect: "query {\n" +
+			`  a(x: "A\"\\\t\u0001", y: "b\n  c")` + "\n" +
+			"}\n",
+	})
+}
+
+func TestFormatDocument(t *testing.T) {
+	doc, errs := gqt.ParseDocument([]byte(`# Users
+constraint Id=len>0
+query GetUser{user(id:is Id){...U}} # By id
+fragment U on User{id name}
+# All users
+meta{owner:"x"}query GetUsers maxDepth 3{users{...U}}`))
+	require.Len(t, errs, 0, "unexpected errors: %v", errs)
+	var b bytes.Buffer
+	require.NoError(t, gqt.FormatDocument(&b, doc, gqt.FormatOptions{}))
+	require.Equal(t, "# Users\n"+
+		"constraint Id = len > 0\n"+
+		"\n"+
+		"query GetUser {\n"+
+		"  user(id: is Id) {\n"+
+		"    ...U\n"+
+		"  }\n"+
+		"} # By id\n"+
+		"\n"+
+		"fragment U on User {\n"+
+		"  id\n"+
+		"  name\n"+
+		"}\n"+
+		"\n"+
+		"# All users\n"+
+		"meta {\n"+
+		"  owner: \"x\"\n"+
+		"}\n"+
+		"query GetUsers maxDepth 3 {\n"+
+		"  users {\n"+
+		"    ...U\n"+
+		"  }\n"+
+		"}\n", b.String())
+
+	// Formatting must be idempotent
+	formatted, errs := gqt.ParseDocument(b.Bytes())
+	require.Len(t, errs, 0, "formatted:\n%s", b.String())
+	var b2 bytes.Buffer
+	require.NoError(t, gqt.FormatDocument(&b2, formatted, gqt.FormatOptions{}))
+	require.Equal(t, b.String(), b2.String())
+}
+
+func TestFormatPrecedence(t *testing.T) {
+	// The optimizer removes parentheses, which must be
+	// reinserted where required by operator precedence.
+	opr, _, errs := gqt.Parse([]byte(`query { a(
+		a=$a: *,
+		b: ($a + 1) * 2,
+		c: $a - ($a - 1),
+		d: -($a + 1),
+		e: ($a > 1) == true,
+		f: true == ($a == 1 || $a == 2),
+	) }`))
+	require.Len(t, errs, 0, "unexpected errors: %v", errs)
+	var b bytes.Buffer
+	require.NoError(t, gqt.Format(&b, gqt.Optimize(opr), gqt.FormatOptions{}))
+	require.Equal(t, "query {\n"+
+		"  a("+
+		"a=$a: *, "+
+		"b: ($a + 1) * 2, "+
+		"c: $a - ($a - 1), "+
+		"d: -($a + 1), "+
+		"e: $a > 1 == true, "+
+		"f: true == ($a == 1 || $a == 2)"+
+		")\n"+
+		"}\n", b.String())
+}
+
+func TestFormatExpression(t *testing.T) {
+	opr, _, errs := gqt.Parse([]byte(`query { a(x: > 1 && < 10) }`))
+	require.Len(t, errs, 0, "unexpected errors: %v", errs)
+	var b bytes.Buffer
+	a := opr.Selections[0].(*gqt.SelectionField).Arguments[0]
+	require.NoError(t, gqt.Format(&b, a.Constraint, gqt.FormatOptions{}))
+	require.Equal(t, "> 1 && < 10", b.String())
+}
+
+// TestFormatRoundTrip makes sure that parsing the formatted
+// source of all valid test templates results in a structurally
+// equal abstract syntax tree.
+func TestFormatRoundTrip(t *testing.T) {
+	d, err := fs.ReadDir(testsFS, "tests")
+	require.NoError(t, err)
+
+	for _, do := range d {
+		fileName := do.Name()
+		if do.IsDir() || !strings.HasSuffix(fileName, ".yml") {
+			continue
+		}
+		f, err := testsFS.ReadFile(filepath.Join("tests", fileName))
+		require.NoError(t, err, "reading YAML test file")
+		t.Run(strings.TrimSuffix(fileName, ".yml"), func(t *testing.T) {
+			var ts struct {
+				Template string `yaml:"template"`
+			}
+			require.NoError(t, yaml.Unmarshal(f, &ts))
+			opr, _, errs := gqt.Parse([]byte(ts.Template))
+			if len(errs) > 0 {
+				t.Skip("invalid template")
+			}
+
+			var b bytes.Buffer
+			require.NoError(t, gqt.Format(&b, opr, gqt.FormatOptions{}))
+			formatted, _, errs := gqt.Parse(b.Bytes())
+			require.Len(t, errs, 0, "formatted:\n%s", b.String())
+			require.Equal(t, structure(t, opr), structure(t, formatted),
+				"formatted:\n%s", b.String())
+			require.Equal(t, commentTexts(opr), commentTexts(formatted),
+				"formatted:\n%s", b.String())
+
+			// Formatting must be idempotent
+			var b2 bytes.Buffer
+			require.NoError(t, gqt.Format(&b2, formatted, gqt.FormatOptions{}))
+			require.Equal(t, b.String(), b2.String())
+
+			// The formatted source of an optimized operation must parse
+			// and optimize to the same operation
+			optimized := gqt.Optimize(opr).(*gqt.Operation)
+			var bo bytes.Buffer
+			require.NoError(t, gqt.Format(&bo, optimized, gqt.FormatOptions{}))
+			reparsed, _, errs := gqt.Parse(bo.Bytes())
+			require.Len(t, errs, 0, "formatted optimized:\n%s", bo.String())
+			require.Equal(t,
+				structure(t, optimized),
+				structure(t, gqt.Optimize(reparsed).(*gqt.Operation)),
+				"formatted optimized:\n%s", bo.String())
+		})
+	}
+}
+
+// commentTexts returns the texts of the comments of o.
+func commentTexts(o *gqt.Operation) []string {
+	t := make([]string, len(o.Comments))
+	for i, c := range o.Comments {
+		t[i] = c.Text
+	}
+	return t
+}
+
+// structure returns the YAML representation of o without locations.
+func structure(t *testing.T, o *gqt.Operation) any {
+	var b bytes.Buffer
+	require.NoError(t, gqt.WriteYAML(&b, o))
+	var m any
+	require.NoError(t, yaml.Unmarshal(b.Bytes(), &m))
+	var strip func(any)
+	strip = func(x any) {
+		switch x := x.(type) {
+		case map[string]any:
+			delete(x, "location")
+			for _, v := range x {
+				strip(v)
+			}
+		case []any:
+			for _, v := range x {
+				strip(v)
+			}
+		}
+	}
+	strip(m)
+	return m
+}
@@ -1,8 +1,13 @@
 package gqt
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"io/fs"
+	fspath "path"
+	"regexp"
+	"regexp/syntax"
 	"sort"
 	"strconv"
 	"strings"
@@ -34,6 +39,8 @@ func (t OperationType) String() string {
 
 type (
 	// Location defines the start location of an expression.
+	// Index is the byte offset in the source, Line and Column are 1-based
+	// and Column is counted in runes.
 	Location struct{ Index, Line, Column int }
 
 	// LocationEnd defines the end location of an expression.
@@ -45,6 +52,11 @@ type (
 		LocationEnd
 	}
 
+	// UTF16Position is a 0-based line and a 0-based character offset
+	// in UTF-16 code units within the line, as used by
+	// the Language Server Protocol.
+	UTF16Position struct{ Line, Character int }
+
 	// Expression can be either of:
 	//
 	//   • *Operation
@@ -61,7 +73,15 @@ type (
 	//   • *ConstrLenLessOrEqual
 	//   • *ConstrLenGreater
 	//   • *ConstrLenGreaterOrEqual
+	//   • *ConstrMatches
+	//   • *ConstrIn
+	//   • *ConstrNotIn
+	//   • *ConstrUnique
+	//   • *ConstrContains
+	//   • *ConstrSum
+	//   • *ConstrCount
 	//   • *ConstrMap
+	//   • *ConstrAlias
 	//   • *ExprParentheses
 	//   • *ExprModulo
 	//   • *ExprDivision
@@ -70,6 +90,7 @@ type (
 	//   • *ExprSubtraction
 	//   • *ExprLogicalNegation
 	//   • *ExprNumericNegation
+	//   • *ExprCall
 	//   • *ExprEqual
 	//   • *ExprNotEqual
 	//   • *ExprLess
@@ -92,7 +113,13 @@ type (
 	//   • *ObjectField
 	//   • *SelectionField
 	//   • *SelectionMax
+	//   • *SelectionMin
+	//   • *SelectionExactly
+	//   • *SelectionRecurse
+	//   • *SelectionSpread
+	//   • *FragmentDefinition
 	//   • *Argument
+	//   • *Directive
 	Expression interface {
 		GetParent() Expression
 		GetLocation() LocRange
@@ -104,16 +131,105 @@ type (
 	//   • *SelectionField
 	//   • *SelectionInlineFrag
 	//   • *SelectionMax
+	//   • *SelectionMin
+	//   • *SelectionExactly
+	//   • *SelectionRecurse
+	//   • *SelectionSpread
 	Selection Expression
 
+	// Document is a template document of one or more operations.
+	Document struct {
+		Operations []*Operation
+
+		// Imports and ConstrAliases are the imports and constraint
+		// alias definitions of the document, see Operation.
+		Imports       []*Import
+		ConstrAliases []*ConstrAliasDefinition
+
+		// Comments are the comments of the document, see Operation.
+		Comments []*Comment
+	}
+
 	// Operation is the root of the abstract syntax tree of an operation.
 	Operation struct {
 		LocRange
 		Type OperationType
+
+		// Name is nil if the operation is anonymous.
+		Name *Name
+
+		// Metadata is the optional header of the operation.
+		Metadata *Metadata
+
+		// Imports and ConstrAliases are the imports and the constraint
+		// alias definitions of the template in the order of their
+		// appearance, excluding those of imported files. In documents
+		// these are held by the Document.
+		Imports       []*Import
+		ConstrAliases []*ConstrAliasDefinition
+
+		// MaxDepth is the maximum number of nested field selections
+		// ("query maxDepth 5 {...}") or 0 if unlimited.
+		// Fields selected through fragments count at the depth
+		// of the fragment.
+		MaxDepth int
+
 		SelectionSet
+
+		// Fragments are the fragment definitions of the template
+		// declared before or after the operation including those
+		// of imported files. In documents these are copies
+		// of the definitions used by the operation.
+		Fragments []*FragmentDefinition
+
+		// Variables are the variables declared in the operation
+		// and its fragments.
+		Variables map[string]*VariableDeclaration
+
+		// Comments are the comments of the template in the order
+		// of their appearance, excluding those of imported files.
+		// In documents these are held by the Document.
+		Comments []*Comment
+
 		Def *ast.Definition
 	}
 
+	// Comment is a comment of a template ("# text").
+	// Comments aren't part of the abstract syntax tree,
+	// they're kept for Format to preserve them.
+	Comment struct {
+		LocRange
+
+		// Text is the text following the number sign.
+		Text string
+	}
+
+	// Metadata is the header of an operation describing it to tooling
+	// ("meta { description: "..." owner: "..." tags: ["..."] }").
+	Metadata struct {
+		LocRange
+		Description string
+		Owner       string
+		Tags        []string
+	}
+
+	// Import is an import of a file of fragment and constraint alias
+	// definitions ("import "common.gqt"").
+	Import struct {
+		LocRange
+		Path string
+	}
+
+	// ConstrAliasDefinition is a named constraint
+	// ("constraint Name = ..."). Constraint isn't part of the tree
+	// and its variables aren't linked to declarations, every reference
+	// to the alias holds a copy of it instead, see ConstrAlias.
+	ConstrAliasDefinition struct {
+		LocRange
+		Name
+		Constraint Expression
+	}
+
 	Name struct {
 		LocRange
 		Name string
@@ -124,11 +240,43 @@ type (
 		LocRange
 		Name
 		Parent Expression
+
+		// Alias restricts the aliases the field may be selected under.
+		// Alias is nil if the field may be selected under any alias
+		// any number of times.
+		Alias *Alias
+
+		// Required is true if the field must be selected ("id!").
+		Required bool
+
 		ArgumentList
+
+		// Directives are the directives the field may be selected with.
+		Directives []*Directive
+
 		SelectionSet
 		Def *ast.FieldDefinition
 	}
 
+	// Alias is the alias pattern of a field selection ("a_*[2]: field").
+	// Every occurrence of the field in a request, including its aliased
+	// duplicates, is checked against the argument constraints and
+	// the selection set of the field individually. Variables associated
+	// with the arguments must be bound to the same value in all of them.
+	Alias struct {
+		LocRange
+
+		// Pattern is the pattern aliases must match where
+		// an asterisk matches any sequence of characters.
+		// Fields selected without an alias always match.
+		Pattern string
+
+		// Limit is the maximum number of distinct response keys
+		// (aliases or the field name if there's none) the field may
+		// be selected under or 0 if unlimited.
+		Limit int
+	}
+
 	// SelectionSet is a selection set.
 	SelectionSet struct {
 		LocRange
@@ -147,8 +295,30 @@ type (
 		Name
 		Parent             Expression
 		AssociatedVariable *VariableDeclaration
-		Constraint         Expression
-		Def                *ast.ArgumentDefinition
+
+		// Optional is true if the argument may be omitted ("name?:").
+		// An omitted optional argument isn't constrained unless
+		// its schema definition provides a default value.
+		Optional bool
+
+		// Absent is true if the argument must not be provided
+		// ("name: absent"), in which case Constraint is nil.
+		Absent bool
+
+		Constraint Expression
+		Def        *ast.ArgumentDefinition
+	}
+
+	// Directive is a directive allowed on a field or an inline fragment
+	// ("@name(arguments)"). Directives used in a request must be declared
+	// in the template and their arguments are constrained like the arguments
+	// of a field.
+	Directive struct {
+		LocRange
+		Name
+		Parent Expression
+		ArgumentList
+		Def *ast.DirectiveDefinition
 	}
 
 	// ConstrAny is the any value constraint (*)
@@ -247,6 +417,70 @@ type (
 		Value  Expression
 	}
 
+	// ConstrMatches is the regular expression constraint (~)
+	// for String values. The pattern uses the RE2 syntax
+	// and matches anywhere in the value unless anchored.
+	ConstrMatches struct {
+		LocRange
+		Parent Expression
+		Value  Expression
+
+		re *regexp.Regexp
+	}
+
+	// ConstrIn is the set membership constraint (in)
+	// accepting any of the constant scalar Values.
+	ConstrIn struct {
+		LocRange
+		Parent Expression
+		Values []Expression
+
+		set valueSet
+	}
+
+	// ConstrNotIn is the set membership constraint (not in)
+	// rejecting all of the constant scalar Values.
+	ConstrNotIn struct {
+		LocRange
+		Parent Expression
+		Values []Expression
+
+		set valueSet
+	}
+
+	// ConstrUnique is the array constraint (unique) requiring
+	// all items to be distinct. Items are compared deeply,
+	// which means that nested arrays are equal if all of their items are.
+	ConstrUnique struct {
+		LocRange
+		Parent Expression
+	}
+
+	// ConstrContains is the array constraint (contains) requiring
+	// at least one of the items to be equal to Value.
+	ConstrContains struct {
+		LocRange
+		Parent Expression
+		Value  Expression
+	}
+
+	// ConstrSum is the array constraint (sum) applying Constraint
+	// to the sum of the items, which must all be numbers.
+	ConstrSum struct {
+		LocRange
+		Parent     Expression
+		Constraint Expression
+	}
+
+	// ConstrCount is the array constraint (count where) applying
+	// Constraint to the number of items satisfying Where.
+	ConstrCount struct {
+		LocRange
+		Parent     Expression
+		Where      Expression
+		Constraint Expression
+	}
+
 	// ConstrMap maps a constraint to all items of the array.
 	ConstrMap struct {
 		LocRange
@@ -254,6 +488,19 @@ type (
 		Constraint Expression
 	}
 
+	// ConstrAlias is a reference to a named constraint ("is Name").
+	// Constraint is a copy of the aliased constraint.
+	ConstrAlias struct {
+		LocRange
+		Name
+		Parent Expression
+
+		// File is the name of the imported file defining the alias
+		// or empty if it's defined in the template itself.
+		File       string
+		Constraint Expression
+	}
+
 	// ExprParentheses is an expression enclosed by parentheses.
 	ExprParentheses struct {
 		LocRange
@@ -278,6 +525,25 @@ type (
 		Float      bool
 	}
 
+	// ExprCall is a call of one of the built-in functions:
+	//
+	//   • min(a, ...) and max(a, ...) return the smallest and
+	//     the greatest of the numeric arguments.
+	//   • abs(a) returns the absolute value of the number a.
+	//   • ceil(a) and floor(a) round the number a up and down
+	//     to the nearest integer.
+	//   • len(a) returns the length of the string or array a.
+	//
+	// At the beginning of a constraint len always starts a length
+	// constraint, thus "len(3)" there is equivalent to "len 3".
+	ExprCall struct {
+		LocRange
+		Parent    Expression
+		Function  Name
+		Arguments []Expression
+		Float     bool
+	}
+
 	// ExprModulo is a modulo expression using the operator "%".
 	ExprModulo struct {
 		LocRange
@@ -454,10 +720,15 @@ type (
 	}
 
 	// Object is an input object constraint.
+	// A closed object only accepts the fields listed in Fields.
+	// An open object (ending with "...") accepts any additional fields
+	// and doesn't require the input object type's required fields
+	// to be listed.
 	Object struct {
 		LocRange
 		Parent  Expression
 		Fields  []*ObjectField
+		Open    bool
 		TypeDef *ast.Definition
 	}
 
@@ -467,8 +738,18 @@ type (
 		Name
 		Parent             Expression
 		AssociatedVariable *VariableDeclaration
-		Constraint         Expression
-		Def                *ast.FieldDefinition
+
+		// Optional is true if the field may be omitted ("name?:").
+		// An omitted optional field isn't constrained unless
+		// its schema definition provides a default value.
+		Optional bool
+
+		// Absent is true if the field must not be provided
+		// ("name: absent"), in which case Constraint is nil.
+		Absent bool
+
+		Constraint Expression
+		Def        *ast.FieldDefinition
 	}
 
 	// Variable is a named value placeholder.
@@ -487,15 +768,79 @@ type (
 		Options SelectionSet
 	}
 
+	// SelectionMin is the min selection set requiring at least
+	// Limit of its options to be selected ("min 1 { a b }").
+	SelectionMin struct {
+		LocRange
+		Parent  Expression
+		Limit   int
+		Options SelectionSet
+	}
+
+	// SelectionExactly is the exactly selection set requiring
+	// exactly Limit of its options to be selected ("exactly 1 { a b }").
+	SelectionExactly struct {
+		LocRange
+		Parent  Expression
+		Limit   int
+		Options SelectionSet
+	}
+
+	// SelectionRecurse allows the selection set of the closest enclosing
+	// field to repeat itself ("...recurse(max: 3)"), which means that
+	// the field may be selected again inside its own selection set
+	// matching the same template up to Max levels deep.
+	// Variables can't be declared inside the repeated field since
+	// every level would bind them to different values.
+	SelectionRecurse struct {
+		LocRange
+		Parent Expression
+		Max    int
+	}
+
 	// SelectionInlineFrag is an inline fragment.
 	SelectionInlineFrag struct {
 		LocRange
 		Parent        Expression
 		TypeCondition TypeCondition
+
+		// Directives are the directives the fragment may be used with.
+		Directives []*Directive
+
+		SelectionSet
+	}
+
+	// FragmentDefinition is a named fragment
+	// ("fragment UserSummary on User { id name }").
+	FragmentDefinition struct {
+		LocRange
+		Name
+		Parent        Expression
+		TypeCondition TypeCondition
 		SelectionSet
+
+		// File is the name of the imported file defining the fragment
+		// or empty if it's defined in the template itself.
+		File string
+	}
+
+	// SelectionSpread is a spread of a named fragment ("...UserSummary").
+	// The selections of the fragment are merged into the selection set
+	// containing the spread if the fragment is conditioned on the type of
+	// the selection set or if the type is unknown in schemaless mode.
+	// Otherwise the spread is equivalent to an inline fragment with
+	// the type condition and the selections of the fragment.
+	SelectionSpread struct {
+		LocRange
+		Name
+		Parent Expression
+
+		// Fragment is the definition of the spread fragment,
+		// which is resolved after parsing.
+		Fragment *FragmentDefinition
 	}
 
-	// TypeCondition is the type condition inside an inline fragment.
+	// TypeCondition is the type condition of a fragment.
 	TypeCondition struct {
 		LocRange
 		TypeName string
@@ -517,7 +862,15 @@ func (e *ConstrLenLess) GetParent() Expression           { return e.Parent }
 func (e *ConstrLenLessOrEqual) GetParent() Expression    { return e.Parent }
 func (e *ConstrLenGreater) GetParent() Expression        { return e.Parent }
 func (e *ConstrLenGreaterOrEqual) GetParent() Expression { return e.Parent }
+func (e *ConstrMatches) GetParent() Expression           { return e.Parent }
+func (e *ConstrIn) GetParent() Expression                { return e.Parent }
+func (e *ConstrNotIn) GetParent() Expression             { return e.Parent }
+func (e *ConstrUnique) GetParent() Expression            { return e.Parent }
+func (e *ConstrContains) GetParent() Expression          { return e.Parent }
+func (e *ConstrSum) GetParent() Expression               { return e.Parent }
+func (e *ConstrCount) GetParent() Expression             { return e.Parent }
 func (e *ConstrMap) GetParent() Expression               { return e.Parent }
+func (e *ConstrAlias) GetParent() Expression             { return e.Parent }
 
 func (e *ExprParentheses) GetParent() Expression    { return e.Parent }
 func (e *ExprModulo) GetParent() Expression         { return e.Parent }
@@ -528,6 +881,7 @@ func (e *ExprSubtraction) GetParent() Expression    { return e.Parent }
 
 func (e *ExprLogicalNegation) GetParent() Expression { return e.Parent }
 func (e *ExprNumericNegation) GetParent() Expression { return e.Parent }
+func (e *ExprCall) GetParent() Expression            { return e.Parent }
 func (e *ExprEqual) GetParent() Expression           { return e.Parent }
 func (e *ExprNotEqual) GetParent() Expression        { return e.Parent }
 func (e *ExprLess) GetParent() Expression            { return e.Parent }
@@ -551,7 +905,13 @@ func (e *SelectionInlineFrag) GetParent() Expression { return e.Parent }
 func (e *ObjectField) GetParent() Expression         { return e.Parent }
 func (e *SelectionField) GetParent() Expression      { return e.Parent }
 func (e *SelectionMax) GetParent() Expression        { return e.Parent }
+func (e *SelectionMin) GetParent() Expression        { return e.Parent }
+func (e *SelectionExactly) GetParent() Expression    { return e.Parent }
+func (e *SelectionRecurse) GetParent() Expression    { return e.Parent }
+func (e *SelectionSpread) GetParent() Expression     { return e.Parent }
+func (e *FragmentDefinition) GetParent() Expression  { return e.Parent }
 func (e *Argument) GetParent() Expression            { return e.Parent }
+func (e *Directive) GetParent() Expression           { return e.Parent }
 
 func (e *Operation) GetLocation() LocRange               { return e.LocRange }
 func (e *ExprParentheses) GetLocation() LocRange         { return e.LocRange }
@@ -568,6 +928,13 @@ func (e *ConstrLenLess) GetLocation() LocRange           { return e.LocRange }
 func (e *ConstrLenLessOrEqual) GetLocation() LocRange    { return e.LocRange }
 func (e *ConstrLenGreater) GetLocation() LocRange        { return e.LocRange }
 func (e *ConstrLenGreaterOrEqual) GetLocation() LocRange { return e.LocRange }
+func (e *ConstrMatches) GetLocation() LocRange           { return e.LocRange }
+func (e *ConstrIn) GetLocation() LocRange                { return e.LocRange }
+func (e *ConstrNotIn) GetLocation() LocRange             { return e.LocRange }
+func (e *ConstrUnique) GetLocation() LocRange            { return e.LocRange }
+func (e *ConstrContains) GetLocation() LocRange          { return e.LocRange }
+func (e *ConstrSum) GetLocation() LocRange               { return e.LocRange }
+func (e *ConstrCount) GetLocation() LocRange             { return e.LocRange }
 func (e *ExprModulo) GetLocation() LocRange              { return e.LocRange }
 func (e *ExprDivision) GetLocation() LocRange            { return e.LocRange }
 func (e *ExprMultiplication) GetLocation() LocRange      { return e.LocRange }
@@ -575,6 +942,7 @@ func (e *ExprAddition) GetLocation() LocRange            { return e.LocRange }
 func (e *ExprSubtraction) GetLocation() LocRange         { return e.LocRange }
 func (e *ExprLogicalNegation) GetLocation() LocRange     { return e.LocRange }
 func (e *ExprNumericNegation) GetLocation() LocRange     { return e.LocRange }
+func (e *ExprCall) GetLocation() LocRange                { return e.LocRange }
 func (e *ExprEqual) GetLocation() LocRange               { return e.LocRange }
 func (e *ExprNotEqual) GetLocation() LocRange            { return e.LocRange }
 func (e *ExprLess) GetLocation() LocRange                { return e.LocRange }
@@ -591,13 +959,20 @@ func (e *Null) GetLocation() LocRange                    { return e.LocRange }
 func (e *Enum) GetLocation() LocRange                    { return e.LocRange }
 func (e *Array) GetLocation() LocRange                   { return e.LocRange }
 func (e *ConstrMap) GetLocation() LocRange               { return e.LocRange }
+func (e *ConstrAlias) GetLocation() LocRange             { return e.LocRange }
 func (e *Object) GetLocation() LocRange                  { return e.LocRange }
 func (e *Variable) GetLocation() LocRange                { return e.LocRange }
 func (e *SelectionInlineFrag) GetLocation() LocRange     { return e.LocRange }
 func (e *ObjectField) GetLocation() LocRange             { return e.LocRange }
 func (e *SelectionField) GetLocation() LocRange          { return e.LocRange }
 func (e *SelectionMax) GetLocation() LocRange            { return e.LocRange }
+func (e *SelectionMin) GetLocation() LocRange            { return e.LocRange }
+func (e *SelectionExactly) GetLocation() LocRange        { return e.LocRange }
+func (e *SelectionRecurse) GetLocation() LocRange        { return e.LocRange }
+func (e *SelectionSpread) GetLocation() LocRange         { return e.LocRange }
+func (e *FragmentDefinition) GetLocation() LocRange      { return e.LocRange }
 func (e *Argument) GetLocation() LocRange                { return e.LocRange }
+func (e *Directive) GetLocation() LocRange               { return e.LocRange }
 
 func (e *Operation) IsFloat() bool               { return false }
 func (e *ConstrAny) IsFloat() bool               { return false }
@@ -613,7 +988,15 @@ func (e *ConstrLenLess) IsFloat() bool           { return false }
 func (e *ConstrLenLessOrEqual) IsFloat() bool    { return false }
 func (e *ConstrLenGreater) IsFloat() bool        { return false }
 func (e *ConstrLenGreaterOrEqual) IsFloat() bool { return false }
+func (e *ConstrMatches) IsFloat() bool           { return false }
+func (e *ConstrIn) IsFloat() bool                { return false }
+func (e *ConstrNotIn) IsFloat() bool             { return false }
+func (e *ConstrUnique) IsFloat() bool            { return false }
+func (e *ConstrContains) IsFloat() bool          { return false }
+func (e *ConstrSum) IsFloat() bool               { return false }
+func (e *ConstrCount) IsFloat() bool             { return false }
 func (e *ConstrMap) IsFloat() bool               { return false }
+func (e *ConstrAlias) IsFloat() bool             { return e.Constraint.IsFloat() }
 
 func (e *ExprParentheses) IsFloat() bool    { return e.Expression.IsFloat() }
 func (e *ExprModulo) IsFloat() bool         { return e.Float }
@@ -624,6 +1007,7 @@ func (e *ExprSubtraction) IsFloat() bool    { return e.Float }
 
 func (e *ExprLogicalNegation) IsFloat() bool { return false }
 func (e *ExprNumericNegation) IsFloat() bool { return e.Expression.IsFloat() }
+func (e *ExprCall) IsFloat() bool            { return e.Float }
 func (e *ExprEqual) IsFloat() bool           { return false }
 func (e *ExprNotEqual) IsFloat() bool        { return false }
 func (e *ExprLess) IsFloat() bool            { return false }
@@ -648,6 +1032,12 @@ func (e *SelectionInlineFrag) IsFloat() bool { return false }
 func (e *ObjectField) IsFloat() bool         { return false }
 func (e *SelectionField) IsFloat() bool      { return false }
 func (e *SelectionMax) IsFloat() bool        { return false }
+func (e *SelectionMin) IsFloat() bool        { return false }
+func (e *SelectionExactly) IsFloat() bool    { return false }
+func (e *SelectionRecurse) IsFloat() bool    { return false }
+func (e *SelectionSpread) IsFloat() bool     { return false }
+func (e *FragmentDefinition) IsFloat() bool  { return false }
+func (e *Directive) IsFloat() bool           { return false }
 
 func (e *Operation) TypeDesignation() string {
 	return e.Type.String()
@@ -715,10 +1105,65 @@ func (e *ConstrLenGreaterOrEqual) TypeDesignation() string {
 	return typeDesignationLen(e)
 }
 
+func (e *ConstrMatches) TypeDesignation() string {
+	switch p := e.GetParent().(type) {
+	case *Argument:
+		if p.Def != nil {
+			return p.Def.Type.String()
+		}
+	case *ObjectField:
+		if p.Def != nil {
+			return p.Def.Type.String()
+		}
+	}
+	return "String"
+}
+
+// Regexp returns the compiled pattern of the constraint
+// or nil if the pattern isn't a valid regular expression.
+func (e *ConstrMatches) Regexp() *regexp.Regexp {
+	if e.re != nil {
+		return e.re
+	}
+	if s, ok := e.Value.(*String); ok {
+		re, _ := regexp.Compile(s.Value)
+		return re
+	}
+	return nil
+}
+
+func (e *ConstrIn) TypeDesignation() string {
+	return typeDesignationSet(e, e.Values)
+}
+
+func (e *ConstrNotIn) TypeDesignation() string {
+	return typeDesignationSet(e, e.Values)
+}
+
+func (e *ConstrUnique) TypeDesignation() string {
+	return typeDesignationCollection(e)
+}
+
+func (e *ConstrContains) TypeDesignation() string {
+	return typeDesignationCollection(e)
+}
+
+func (e *ConstrSum) TypeDesignation() string {
+	return typeDesignationCollection(e)
+}
+
+func (e *ConstrCount) TypeDesignation() string {
+	return typeDesignationCollection(e)
+}
+
 func (e *ConstrMap) TypeDesignation() string {
 	return e.Constraint.TypeDesignation()
 }
 
+func (e *ConstrAlias) TypeDesignation() string {
+	return e.Constraint.TypeDesignation()
+}
+
 func (e *ExprParentheses) TypeDesignation() string {
 	return e.Expression.TypeDesignation()
 }
@@ -765,6 +1210,13 @@ func (e *ExprNumericNegation) TypeDesignation() string {
 	}
 	return "Int"
 }
+
+func (e *ExprCall) TypeDesignation() string {
+	if e.Float {
+		return "Float"
+	}
+	return "Int"
+}
 func (e *ExprEqual) TypeDesignation() string          { return "Boolean" }
 func (e *ExprNotEqual) TypeDesignation() string       { return "Boolean" }
 func (e *ExprLess) TypeDesignation() string           { return "Boolean" }
@@ -878,15 +1330,22 @@ func (e *Object) TypeDesignation() string {
 		b.WriteString(f.Name.Name)
 		b.WriteByte(':')
 		b.WriteString(f.Constraint.TypeDesignation())
-		if i+1 < len(e.Fields) {
+		if i+1 < len(e.Fields) || e.Open {
 			b.WriteByte(',')
 		}
 	}
+	if e.Open {
+		b.WriteString("...")
+	}
 	b.WriteByte('}')
 	return b.String()
 }
 
 func (e *Variable) TypeDesignation() string {
+	if e.Declaration == nil {
+		// Undefined variable
+		return "*"
+	}
 	switch p := e.Declaration.Parent.(type) {
 	case *Argument:
 		if p.Def != nil {
@@ -906,12 +1365,74 @@ func (e *Variable) TypeDesignation() string {
 }
 
 func (e *Argument) TypeDesignation() string {
+	if e.Absent {
+		return "absent"
+	}
 	return e.Constraint.TypeDesignation()
 }
 func (e *SelectionInlineFrag) TypeDesignation() string { return "" }
 func (e *ObjectField) TypeDesignation() string         { return "" }
 func (e *SelectionField) TypeDesignation() string      { return "" }
 func (e *SelectionMax) TypeDesignation() string        { return "" }
+func (e *SelectionMin) TypeDesignation() string        { return "" }
+func (e *SelectionExactly) TypeDesignation() string    { return "" }
+func (e *SelectionRecurse) TypeDesignation() string    { return "" }
+func (e *SelectionSpread) TypeDesignation() string     { return "" }
+func (e *FragmentDefinition) TypeDesignation() string  { return "" }
+func (e *Directive) TypeDesignation() string           { return "" }
+
+// merged returns true if the selections of the fragment are merged into
+// the selection set of type hostType containing the spread.
+func (e *SelectionSpread) merged(hostType string) bool {
+	return hostType == "" || e.Fragment.TypeCondition.TypeName == hostType
+}
+
+// selSetType returns the name of the type the selection set of host
+// is matched against or an empty string if it's unknown.
+func selSetType(host Expression) string {
+	switch h := host.(type) {
+	case *Operation:
+		return h.Type.String()
+	case *SelectionField:
+		return fieldTypeName(h)
+	case *SelectionInlineFrag:
+		return h.TypeCondition.TypeName
+	case *FragmentDefinition:
+		return h.TypeCondition.TypeName
+	}
+	return ""
+}
+
+// optionSet is a selection set of options limiting the number
+// of options that may be selected, which is either of:
+//   - *SelectionMax
+//   - *SelectionMin
+//   - *SelectionExactly
+type optionSet interface {
+	Selection
+	options() *SelectionSet
+
+	// kind returns the keyword the set is declared with.
+	kind() string
+
+	// limits returns the minimum and maximum number of options
+	// that may be selected.
+	limits() (min, max int)
+}
+
+func (e *SelectionMax) options() *SelectionSet     { return &e.Options }
+func (e *SelectionMin) options() *SelectionSet     { return &e.Options }
+func (e *SelectionExactly) options() *SelectionSet { return &e.Options }
+
+func (e *SelectionMax) kind() string     { return "max" }
+func (e *SelectionMin) kind() string     { return "min" }
+func (e *SelectionExactly) kind() string { return "exactly" }
+
+func (e *SelectionMax) limits() (min, max int) { return 0, e.Limit }
+func (e *SelectionMin) limits() (min, max int) {
+	return e.Limit, len(e.Options.Selections)
+}
+func (e *SelectionExactly) limits() (min, max int) { return e.Limit, e.Limit }
 
 type VariableDeclaration struct {
 	LocRange
@@ -930,11 +1451,14 @@ type VariableDeclaration struct {
 }
 
 // GetInfo returns the schema-type and constraint expression of the value
-// behind the variable.
+// behind the variable. Returns nil and nil if v is nil.
 func (v *VariableDeclaration) GetInfo() (
 	schemaType *ast.Type,
 	constr Expression,
 ) {
+	if v == nil {
+		return nil, nil
+	}
 	switch p := v.Parent.(type) {
 	case *Argument:
 		constr = p.Constraint
@@ -964,11 +1488,80 @@ func (n *Number) Int() (f int, ok bool) {
 
 // Parser is a GQT parser.
 type Parser struct {
-	schema   *ast.Schema
-	enumVal  map[string]*ast.Definition
-	varDecls map[string]*VariableDeclaration
-	varRefs  []*Variable
-	errors   []Error
+	schema  *ast.Schema
+	enumVal map[string]*ast.Definition
+	vars    *scope // Variables of the operation being parsed.
+	errors  []Error
+
+	// incomplete holds the fields and objects of which arguments
+	// or object fields were skipped while recovering from syntax errors.
+	incomplete map[Expression]struct{}
+
+	// invalidFrags holds the fragment definitions with invalid
+	// type conditions.
+	invalidFrags map[*FragmentDefinition]struct{}
+
+	// fsys is the file system imports are resolved in.
+	fsys fs.FS
+
+	// file is the name of the imported file being parsed or validated,
+	// which is empty for the template itself.
+	file string
+
+	// imported holds the names of the files imported by the template
+	// directly or through other files.
+	imported map[string]struct{}
+
+	// aliases holds the constraint aliases of the template
+	// and the imported files by name.
+	aliases map[string]*constrAlias
+
+	// fragSources holds the sources of the imported fragment definitions.
+	fragSources map[*FragmentDefinition]source
+
+	// imports and constrAliases hold the imports and the constraint
+	// alias definitions of the template itself.
+	imports       []*Import
+	constrAliases []*ConstrAliasDefinition
+}
+
+// constrAlias is the source of the constraint of an alias defined in file.
+type constrAlias struct {
+	file string
+	src  source
+}
+
+// scope holds the variable declarations and references of an operation.
+type scope struct {
+	decls map[string]*VariableDeclaration
+	refs  []*Variable
+
+	// declared holds the declarations in the order of their appearance.
+	declared []*VariableDeclaration
+}
+
+func newScope() *scope {
+	return &scope{decls: make(map[string]*VariableDeclaration)}
+}
+
+// declare adds def to the scope unless a variable
+// of the same name is already declared.
+func (s *scope) declare(def *VariableDeclaration) (ok bool) {
+	if _, ok := s.decls[def.Name]; ok {
+		return false
+	}
+	s.decls[def.Name] = def
+	s.declared = append(s.declared, def)
+	return true
+}
+
+// discard removes the declarations added after the first n,
+// which belong to a span that's skipped during error recovery.
+func (s *scope) discard(n int) {
+	for _, d := range s.declared[n:] {
+		delete(s.decls, d.Name)
+	}
+	s.declared = s.declared[:n]
 }
 
 // Source is a GraphQL schema source file.
@@ -979,8 +1572,8 @@ type Source struct {
 
 func newParser() *Parser {
 	return &Parser{
-		enumVal:  make(map[string]*ast.Definition, 0),
-		varDecls: make(map[string]*VariableDeclaration),
+		enumVal: make(map[string]*ast.Definition, 0),
+		vars:    newScope(),
 	}
 }
 
@@ -1017,6 +1610,14 @@ func NewParser(schema []Source) (*Parser, error) {
 	return p, nil
 }
 
+// SetFS sets the file system in which the paths of the files imported
+// by templates are resolved ("import "common.gqt""). Paths are relative
+// to the directory of the importing file, which for the parsed template
+// itself is the root of fsys. Templates can't import files if fsys is nil.
+func (p *Parser) SetFS(fsys fs.FS) {
+	p.fsys = fsys
+}
+
 // Parse parses the template in schemaless mode
 // and returns its abstract syntax tree.
 func Parse(src []byte) (
@@ -1028,15 +1629,24 @@ func Parse(src []byte) (
 }
 
 // Parse parses the template and returns its abstract syntax tree.
+// Parse recovers from syntax errors by skipping the erroneous selection,
+// argument, object field, array item, set value or function argument
+// and returns all syntax errors as well as the semantic errors
+// of the parts that were parsed successfully.
+// The template must contain exactly one operation, use ParseDocument
+// to parse templates of multiple operations.
+//
+// The operation may be preceded by imports of files of fragment
+// and constraint alias definitions, see SetFS. The imported fragments
+// are appended to the fragment definitions of the operation
+// and needn't be used. Constraint aliases ("constraint Name = ...")
+// must be defined before they're referred to ("is Name").
 func (p *Parser) Parse(src []byte) (
 	operation *Operation,
 	variables map[string]*VariableDeclaration,
 	errors []Error,
 ) {
-	p.errors = p.errors[:0]
-	p.varDecls = make(map[string]*VariableDeclaration)
-	p.varRefs = make([]*Variable, 0)
-
+	p.reset()
 	s := source{
 		Location: Location{
 			Line:   1,
@@ -1046,70 +1656,818 @@ func (p *Parser) Parse(src []byte) (
 	}
 
 	s = s.consumeIgnored()
-	o := &Operation{LocRange: locRange(s.Location)}
-
-	var tok []byte
-	si := s
-	s, tok = s.consumeToken()
-
-	if string(tok) == "query" {
-		o.Type = OperationTypeQuery
-	} else if string(tok) == "mutation" {
-		o.Type = OperationTypeMutation
-	} else if string(tok) == "subscription" {
-		o.Type = OperationTypeSubscription
-	} else {
-		p.errUnexpTok(
-			si, "expected query, mutation, or subscription "+
-				"operation definition",
-		)
-		return nil, nil, p.errors
+	var fragments []*FragmentDefinition
+	if s, fragments = p.parseDefinitions(s); s.stop() {
+		return nil, nil, p.uniqueErrors()
 	}
-
-	s = s.consumeIgnored()
-	if s, o.SelectionSet = p.parseSelectionSet(s); s.stop() {
-		return nil, nil, p.errors
+	var o *Operation
+	if s, o = p.parseOperation(s); s.stop() {
+		return nil, nil, p.uniqueErrors()
 	}
-	o.LocationEnd = o.SelectionSet.LocationEnd
+	o.Fragments = fragments
 
 	s = s.consumeIgnored()
+	if s, fragments = p.parseDefinitions(s); s.stop() {
+		return nil, nil, p.uniqueErrors()
+	}
+	o.Fragments = append(o.Fragments, fragments...)
+	o.Imports, o.ConstrAliases = p.imports, p.constrAliases
+	o.Comments = scanComments(src)
 	if !s.isEOF() {
 		p.errUnexpTok(s, "expected end of file")
 	}
 
-	for _, sel := range o.Selections {
-		setParent(sel, o)
-	}
-
-	// Link variable declarations and references
-	for _, r := range p.varRefs {
-		if v, ok := p.varDecls[r.Name.Name]; !ok {
-			p.newErr(r.LocRange, "undefined variable")
-			return nil, nil, p.errors
-		} else {
-			r.Declaration = v
-			v.References = append(v.References, r)
-		}
-	}
-
-	p.setTypes(o)
-	p.validate(o)
-
+	p.check(o, len(p.errors) > 0)
 	if len(p.errors) > 0 {
-		o = nil
-		p.varDecls = nil
-		sort.Slice(p.errors, func(i, j int) bool {
-			return p.errors[i].Index < p.errors[j].Index
-		})
+		return nil, nil, p.uniqueErrors()
 	}
-
-	return o, p.varDecls, p.errors
+	return o, o.Variables, p.errors
 }
 
-func (p *Parser) setTypes(o *Operation) {
-	if p.schema != nil {
-		switch o.Type {
-		case OperationTypeQuery:
+// ParseDocument parses a template document in schemaless mode
+// and returns its abstract syntax tree.
+func ParseDocument(src []byte) (document *Document, errors []Error) {
+	return newParser().ParseDocument(src)
+}
+
+// ParseDocument parses a template document of one or more operations,
+// fragment definitions, constraint alias definitions and imports
+// in any order. Operations must be named
+// unless the document contains only one. Every operation has its own
+// variables and receives its own copies of the fragment definitions
+// it uses. Errors in fragments used by multiple operations
+// are reported once. See Parse for details.
+func (p *Parser) ParseDocument(src []byte) (document *Document, errors []Error) {
+	p.reset()
+	s := source{
+		Location: Location{
+			Line:   1,
+			Column: 1,
+		},
+		s: src,
+	}
+
+	// Parse all definitions with the variables of every definition
+	// in a separate scope and record where the fragments start
+	var opr []*Operation
+	var scopes []*scope
+	defs := map[string]*FragmentDefinition{}
+	var fragments []*FragmentDefinition
+	starts := map[*FragmentDefinition]source{}
+	define := func(d *FragmentDefinition, start source) {
+		if _, decl := defs[d.Name.Name]; decl {
+			p.inFile(d.File, func() {
+				p.newErr(d.Name.LocRange, fmt.Sprintf(
+					"redeclared fragment %q", d.Name.Name,
+				))
+			})
+			return
+		}
+		defs[d.Name.Name] = d
+		fragments = append(fragments, d)
+		starts[d] = start
+	}
+	for s = s.consumeIgnored(); !s.isEOF(); s = s.consumeIgnored() {
+		p.vars = newScope()
+		switch _, tok := s.consumeToken(); string(tok) {
+		case "fragment":
+			start := s
+			var d *FragmentDefinition
+			if s, d = p.parseFragmentDef(s); s.stop() {
+				return nil, p.uniqueErrors()
+			}
+			define(d, start)
+			continue
+		case "constraint":
+			if s = p.parseConstrAliasDef(s); s.stop() {
+				return nil, p.uniqueErrors()
+			}
+			continue
+		case "import":
+			var imported []*FragmentDefinition
+			if s, imported = p.parseImport(s); s.stop() {
+				return nil, p.uniqueErrors()
+			}
+			for _, d := range imported {
+				define(d, p.fragSources[d])
+			}
+			continue
+		}
+		var o *Operation
+		if s, o = p.parseOperation(s); s.stop() {
+			return nil, p.uniqueErrors()
+		}
+		opr = append(opr, o)
+		scopes = append(scopes, p.vars)
+	}
+	if len(opr) < 1 {
+		p.errUnexpTok(s, "expected query, mutation, or subscription "+
+			"operation definition")
+		return nil, p.uniqueErrors()
+	}
+
+	names := make(map[string]struct{}, len(opr))
+	for _, o := range opr {
+		if o.Name == nil {
+			if len(opr) > 1 {
+				p.newErr(locRange(o.Location), "anonymous operation "+
+					"must be the only operation in the document")
+			}
+			continue
+		}
+		if _, decl := names[o.Name.Name]; decl {
+			p.newErr(o.Name.LocRange, fmt.Sprintf(
+				"redeclared operation %q", o.Name.Name,
+			))
+			continue
+		}
+		names[o.Name.Name] = struct{}{}
+	}
+
+	// Reparse the fragments used by every operation in its scope
+	syntaxErrs := len(p.errors) > 0
+	used := map[*FragmentDefinition]struct{}{}
+	for i, o := range opr {
+		p.vars = scopes[i]
+		for _, d := range usedFragments(o, defs) {
+			used[d] = struct{}{}
+			var c *FragmentDefinition
+			p.inFile(d.File, func() { _, c = p.parseFragmentDef(starts[d]) })
+			o.Fragments = append(o.Fragments, c)
+		}
+		// Check every operation to report the errors of all of them
+		p.check(o, syntaxErrs)
+	}
+	for _, d := range fragments {
+		// Imported fragments may be used by other templates
+		if _, ok := used[d]; !ok && d.File == "" {
+			p.newErr(d.Name.LocRange, fmt.Sprintf(
+				"fragment %q is unused", d.Name.Name,
+			))
+		}
+	}
+
+	if len(p.errors) > 0 {
+		return nil, p.uniqueErrors()
+	}
+	return &Document{
+		Operations:    opr,
+		Imports:       p.imports,
+		ConstrAliases: p.constrAliases,
+		Comments:      scanComments(src),
+	}, nil
+}
+
+// usedFragments returns the definitions of the fragments spread in o
+// directly or through other fragments in the order of defs.
+func usedFragments(
+	o *Operation, defs map[string]*FragmentDefinition,
+) []*FragmentDefinition {
+	used := map[*FragmentDefinition]struct{}{}
+	var visit func(e Expression)
+	visit = func(e Expression) {
+		Walk(e, inspector(func(e Expression) bool {
+			s, ok := e.(*SelectionSpread)
+			if !ok {
+				return true
+			}
+			d := defs[s.Name.Name]
+			if _, visited := used[d]; d == nil || visited {
+				return true
+			}
+			used[d] = struct{}{}
+			visit(d)
+			return true
+		}))
+	}
+	visit(o)
+	l := make([]*FragmentDefinition, 0, len(used))
+	for d := range used {
+		l = append(l, d)
+	}
+	sort.Slice(l, func(i, j int) bool { return l[i].Index < l[j].Index })
+	return l
+}
+
+// reset prepares the parser for parsing a new template.
+func (p *Parser) reset() {
+	p.errors = p.errors[:0]
+	p.vars = newScope()
+	p.incomplete = make(map[Expression]struct{})
+	p.invalidFrags = make(map[*FragmentDefinition]struct{})
+	p.file = ""
+	p.imported = make(map[string]struct{})
+	p.aliases = make(map[string]*constrAlias)
+	p.fragSources = make(map[*FragmentDefinition]source)
+	p.imports, p.constrAliases = nil, nil
+}
+
+// parseOperation parses an operation definition including its optional
+// metadata header and name and its variables into the current scope.
+func (p *Parser) parseOperation(s source) (source, *Operation) {
+	var m *Metadata
+	if _, tok := s.consumeToken(); string(tok) == "meta" {
+		if s, m = p.parseMetadata(s); s.stop() {
+			return stop(), nil
+		}
+		s = s.consumeIgnored()
+	}
+	o := &Operation{LocRange: locRange(s.Location), Metadata: m}
+
+	var tok []byte
+	si := s
+	s, tok = s.consumeToken()
+
+	if string(tok) == "query" {
+		o.Type = OperationTypeQuery
+	} else if string(tok) == "mutation" {
+		o.Type = OperationTypeMutation
+	} else if string(tok) == "subscription" {
+		o.Type = OperationTypeSubscription
+	} else {
+		p.errUnexpTok(
+			si, "expected query, mutation, or subscription "+
+				"operation definition",
+		)
+		return stop(), nil
+	}
+
+	s = s.consumeIgnored()
+	if n, name := s.consumeName(); name != nil &&
+		(string(name) != "maxDepth" || isOperationName(n)) {
+		o.Name = &Name{
+			LocRange: LocRange{
+				Location:    s.Location,
+				LocationEnd: locEnd(n),
+			},
+			Name: string(name),
+		}
+		s = n.consumeIgnored()
+	}
+
+	if n, tok := s.consumeToken(); string(tok) == "maxDepth" {
+		s = n.consumeIgnored()
+		lBeforeDepth := s.Location
+		var depth int64
+		var ok bool
+		if s, depth, ok = s.consumeUnsignedInt(); !ok || depth < 1 {
+			p.newErr(
+				locRange(lBeforeDepth),
+				"max depth must be an unsigned integer greater 0",
+			)
+			return stop(), nil
+		}
+		o.MaxDepth = int(depth)
+		s = s.consumeIgnored()
+	}
+
+	if s, o.SelectionSet = p.parseSelectionSet(s, o); s.stop() {
+		return stop(), nil
+	}
+	o.LocationEnd = o.SelectionSet.LocationEnd
+	o.Variables = p.vars.decls
+	return s, o
+}
+
+// isOperationName returns true if the name "maxDepth" preceding s
+// is the name of an operation rather than the max depth keyword,
+// which is the case if it's followed by a selection set or another name.
+func isOperationName(s source) bool {
+	s = s.consumeIgnored()
+	if s.peek1('{') {
+		return true
+	}
+	_, name := s.consumeName()
+	return name != nil
+}
+
+// parseMetadata parses the metadata header of an operation
+// ("meta { description: "..." owner: "..." tags: ["..."] }").
+func (p *Parser) parseMetadata(s source) (source, *Metadata) {
+	m := &Metadata{LocRange: locRange(s.Location)}
+	s, _ = s.consumeToken()
+	s = s.consumeIgnored()
+
+	var ok bool
+	if s, ok = s.consume("{"); !ok {
+		p.errUnexpTok(s, "expected metadata")
+		return stop(), nil
+	}
+	declared := map[string]struct{}{}
+	for {
+		s = s.consumeIgnored()
+		if s, ok = s.consume("}"); ok {
+			break
+		}
+
+		lBeforeName := s.Location
+		var name []byte
+		if s, name = s.consumeName(); name == nil {
+			p.errUnexpTok(s, "expected metadata field name")
+			return stop(), nil
+		}
+		l := LocRange{Location: lBeforeName, LocationEnd: locEnd(s)}
+		if _, decl := declared[string(name)]; decl {
+			p.newErr(l, fmt.Sprintf(
+				"redeclared metadata field %q", string(name),
+			))
+		}
+		declared[string(name)] = struct{}{}
+
+		s = s.consumeIgnored()
+		if s, ok = s.consume(":"); !ok {
+			p.errUnexpTok(s, "expected colon")
+			return stop(), nil
+		}
+		s = s.consumeIgnored()
+
+		switch string(name) {
+		case "description":
+			s, m.Description = p.parseMetadataString(s)
+		case "owner":
+			s, m.Owner = p.parseMetadataString(s)
+		case "tags":
+			if s, ok = s.consume("["); !ok {
+				p.errUnexpTok(s, "expected array of strings")
+				return stop(), nil
+			}
+			m.Tags = []string{}
+			for {
+				s = s.consumeIgnored()
+				if s, ok = s.consume("]"); ok {
+					break
+				}
+				var tag string
+				if s, tag = p.parseMetadataString(s); s.stop() {
+					return stop(), nil
+				}
+				m.Tags = append(m.Tags, tag)
+				s = s.consumeIgnored()
+				s, _ = s.consume(",")
+			}
+		default:
+			p.newErr(l, fmt.Sprintf(
+				"unknown metadata field %q", string(name),
+			))
+			return stop(), nil
+		}
+		if s.stop() {
+			return stop(), nil
+		}
+		s = s.consumeIgnored()
+		s, _ = s.consume(",")
+	}
+	m.LocationEnd = locEnd(s)
+	return s, m
+}
+
+// parseMetadataString parses a string value of a metadata field.
+func (p *Parser) parseMetadataString(s source) (source, string) {
+	n, str, ok, err := s.consumeString()
+	if !ok {
+		p.errUnexpTok(s, "expected string")
+		return stop(), ""
+	}
+	if err.IsErr() {
+		p.newErr(err.LocRange, err.Msg)
+	}
+	return n, string(str)
+}
+
+// check links the variable references of the operation being parsed
+// to their declarations, resolves the fragment spreads of o and
+// validates it. syntaxErrs must be true if the template contains
+// syntax errors. References to undefined variables are left unlinked
+// and their values are assumed to be of any type.
+func (p *Parser) check(o *Operation, syntaxErrs bool) {
+	for _, sel := range o.Selections {
+		setParent(sel, o)
+	}
+	for _, d := range o.Fragments {
+		setParent(d, o)
+	}
+
+	// Link variable declarations and references
+	for _, r := range p.vars.refs {
+		if v, ok := p.vars.decls[r.Name.Name]; !ok {
+			// Skip reporting undefined variables in templates with
+			// syntax errors since the declaration may have been skipped
+			if !syntaxErrs {
+				p.newErr(r.LocRange, "undefined variable")
+			}
+		} else {
+			r.Declaration = v
+			v.References = append(v.References, r)
+		}
+	}
+
+	p.resolveFragments(o)
+	p.setTypes(o)
+	p.validate(o)
+}
+
+// parseDefinitions parses all consecutive fragment definitions,
+// constraint alias definitions and imports and returns the defined
+// and imported fragments.
+func (p *Parser) parseDefinitions(
+	s source,
+) (_ source, defs []*FragmentDefinition) {
+	for {
+		switch _, tok := s.consumeToken(); string(tok) {
+		case "fragment":
+			start := s
+			var d *FragmentDefinition
+			if s, d = p.parseFragmentDef(s); s.stop() {
+				return stop(), nil
+			}
+			if p.file != "" {
+				p.fragSources[d] = start
+			}
+			defs = append(defs, d)
+		case "constraint":
+			if s = p.parseConstrAliasDef(s); s.stop() {
+				return stop(), nil
+			}
+		case "import":
+			var imported []*FragmentDefinition
+			if s, imported = p.parseImport(s); s.stop() {
+				return stop(), nil
+			}
+			defs = append(defs, imported...)
+		default:
+			return s, defs
+		}
+		s = s.consumeIgnored()
+	}
+}
+
+// parseConstrAliasDef parses a constraint alias definition
+// ("constraint Name = ...").
+func (p *Parser) parseConstrAliasDef(s source) source {
+	d := &ConstrAliasDefinition{LocRange: locRange(s.Location)}
+	s, _ = s.consumeToken()
+	s = s.consumeIgnored()
+
+	lBeforeName := s.Location
+	var name []byte
+	if s, name = s.consumeName(); name == nil {
+		p.errUnexpTok(s, "expected constraint alias name")
+		return stop()
+	}
+	d.Name = Name{
+		LocRange: LocRange{
+			Location:    lBeforeName,
+			LocationEnd: locEnd(s),
+		},
+		Name: string(name),
+	}
+	a := &constrAlias{file: p.file}
+
+	s = s.consumeIgnored()
+	var ok bool
+	if s, ok = s.consume("="); !ok {
+		p.errUnexpTok(s, "expected '='")
+		return stop()
+	}
+	s = s.consumeIgnored()
+	a.src = s
+
+	// The constraint is parsed again wherever the alias is referred to,
+	// the variables it refers to are resolved there
+	vars := p.vars
+	p.vars = newScope()
+	s, d.Constraint = p.parseConstrLogicalOr(s, expectConstraint)
+	p.vars = vars
+	if s.stop() {
+		return stop()
+	}
+	d.LocationEnd = d.Constraint.GetLocation().LocationEnd
+	p.setTypesExpr(d.Constraint, nil)
+	if p.file == "" {
+		p.constrAliases = append(p.constrAliases, d)
+	}
+
+	if _, decl := p.aliases[d.Name.Name]; decl {
+		p.newErr(d.Name.LocRange, fmt.Sprintf(
+			"redeclared constraint alias %q", d.Name.Name,
+		))
+		return s
+	}
+	p.aliases[d.Name.Name] = a
+	return s
+}
+
+// parseConstrAlias parses the name of a constraint alias reference
+// ("is Name") and a copy of the aliased constraint. si is the source
+// before the keyword "is".
+func (p *Parser) parseConstrAlias(
+	si, s source,
+	expect expect,
+) (source, Expression) {
+	lBeforeName := s.Location
+	var name []byte
+	if s, name = s.consumeName(); name == nil {
+		p.errUnexpTok(s, "expected constraint alias name")
+		return stop(), nil
+	}
+	e := &ConstrAlias{
+		LocRange: LocRange{
+			Location:    si.Location,
+			LocationEnd: locEnd(s),
+		},
+		Name: Name{
+			LocRange: LocRange{
+				Location:    lBeforeName,
+				LocationEnd: locEnd(s),
+			},
+			Name: string(name),
+		},
+	}
+	a := p.aliases[e.Name.Name]
+	if a == nil {
+		p.newErr(e.Name.LocRange, fmt.Sprintf(
+			"undefined constraint alias %q", e.Name.Name,
+		))
+		return stop(), nil
+	}
+	e.File = a.file
+
+	var c source
+	p.inFile(a.file, func() {
+		c, e.Constraint = p.parseConstrLogicalOr(a.src, expect)
+	})
+	if c.stop() {
+		return stop(), nil
+	}
+	setParent(e.Constraint, e)
+	return s.consumeIgnored(), e
+}
+
+// parseImport parses an import ("import "common.gqt"") and the imported
+// file unless it was already imported. Returns the fragments defined
+// in the imported file and the files it imports.
+func (p *Parser) parseImport(s source) (source, []*FragmentDefinition) {
+	l := s.Location
+	s, _ = s.consumeToken()
+	s = s.consumeIgnored()
+
+	n, str, ok, err := s.consumeString()
+	if !ok {
+		p.errUnexpTok(s, "expected import path")
+		return stop(), nil
+	} else if err.IsErr() {
+		p.newErr(err.LocRange, err.Msg)
+		return stop(), nil
+	}
+	lr := LocRange{Location: l, LocationEnd: locEnd(n)}
+	if p.file == "" {
+		p.imports = append(p.imports, &Import{LocRange: lr, Path: string(str)})
+	}
+
+	// Resolve the path relative to the directory of the importing file
+	name := fspath.Join(fspath.Dir(p.file), string(str))
+	switch {
+	case len(str) < 1 || str[0] == '/' || !fs.ValidPath(name):
+		p.newErr(lr, fmt.Sprintf("invalid import path %q", string(str)))
+		return n, nil
+	case p.fsys == nil:
+		p.newErr(lr, fmt.Sprintf(
+			"can't import %q: no file system", string(str),
+		))
+		return n, nil
+	}
+	if _, ok := p.imported[name]; ok {
+		return n, nil
+	}
+	p.imported[name] = struct{}{}
+
+	src, rerr := fs.ReadFile(p.fsys, name)
+	if rerr != nil {
+		var perr *fs.PathError
+		if errors.As(rerr, &perr) {
+			rerr = perr.Err
+		}
+		p.newErr(lr, fmt.Sprintf("can't import %q: %v", string(str), rerr))
+		return n, nil
+	}
+
+	var defs []*FragmentDefinition
+	p.inFile(name, func() { defs = p.parseImported(src) })
+	return n, defs
+}
+
+// parseImported parses the imported file src, which may contain only
+// imports, fragment definitions and constraint alias definitions.
+func (p *Parser) parseImported(src []byte) []*FragmentDefinition {
+	s := source{
+		Location: Location{
+			Line:   1,
+			Column: 1,
+		},
+		s: src,
+	}
+	s, defs := p.parseDefinitions(s.consumeIgnored())
+	if s.stop() {
+		return nil
+	}
+	if !s.isEOF() {
+		p.errUnexpTok(s, "expected import, fragment, "+
+			"or constraint definition")
+		return nil
+	}
+	return defs
+}
+
+// parseFragmentDef parses a fragment definition
+// ("fragment Name on Type { ... }").
+func (p *Parser) parseFragmentDef(s source) (source, *FragmentDefinition) {
+	d := &FragmentDefinition{LocRange: locRange(s.Location), File: p.file}
+	s, _ = s.consumeToken()
+	s = s.consumeIgnored()
+
+	lBeforeName := s.Location
+	var name []byte
+	if s, name = s.consumeName(); name == nil {
+		p.errUnexpTok(s, "expected fragment name")
+		return stop(), nil
+	}
+	d.Name = Name{
+		LocRange: LocRange{
+			Location:    lBeforeName,
+			LocationEnd: locEnd(s),
+		},
+		Name: string(name),
+	}
+	if d.Name.Name == "on" || d.Name.Name == "recurse" {
+		p.newErr(d.Name.LocRange, fmt.Sprintf(
+			"invalid fragment name %q", d.Name.Name,
+		))
+		return stop(), nil
+	}
+
+	s = s.consumeIgnored()
+	sp := s
+	var tok []byte
+	if s, tok = s.consumeToken(); string(tok) != "on" {
+		p.errUnexpTok(sp, "expected keyword 'on'")
+		return stop(), nil
+	}
+
+	s = s.consumeIgnored()
+	sBeforeName := s
+	if s, name = s.consumeName(); len(name) < 1 {
+		p.errUnexpTok(s, "expected type condition")
+		return stop(), nil
+	}
+	d.TypeCondition = TypeCondition{
+		LocRange: LocRange{
+			Location:    sBeforeName.Location,
+			LocationEnd: locEnd(s),
+		},
+		TypeName: string(name),
+	}
+
+	s = s.consumeIgnored()
+	if s, d.SelectionSet = p.parseSelectionSet(s, d); s.stop() {
+		return stop(), nil
+	}
+	for _, sel := range d.Selections {
+		setParent(sel, d)
+	}
+	d.LocationEnd = d.SelectionSet.LocationEnd
+	return s, d
+}
+
+// resolveFragments links the fragment spreads of o to their definitions
+// and reports undefined, redeclared, unused and cyclic fragments.
+func (p *Parser) resolveFragments(o *Operation) {
+	defs := make(map[string]*FragmentDefinition, len(o.Fragments))
+	for _, d := range o.Fragments {
+		if _, decl := defs[d.Name.Name]; decl {
+			p.inFile(d.File, func() {
+				p.newErr(d.Name.LocRange, fmt.Sprintf(
+					"redeclared fragment %q", d.Name.Name,
+				))
+			})
+			continue
+		}
+		defs[d.Name.Name] = d
+	}
+
+	used := make(map[*FragmentDefinition]struct{}, len(defs))
+	Walk(o, inspector(func(e Expression) bool {
+		s, ok := e.(*SelectionSpread)
+		if !ok {
+			return true
+		}
+		if s.Fragment = defs[s.Name.Name]; s.Fragment == nil {
+			p.inFile(fileOf(s), func() {
+				p.newErr(s.LocRange, fmt.Sprintf(
+					"fragment %q is undefined", s.Name.Name,
+				))
+			})
+			return true
+		}
+		used[s.Fragment] = struct{}{}
+		return true
+	}))
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[*FragmentDefinition]int, len(defs))
+	var visit func(d *FragmentDefinition)
+	visit = func(d *FragmentDefinition) {
+		state[d] = visiting
+		Walk(d, inspector(func(e Expression) bool {
+			s, ok := e.(*SelectionSpread)
+			if !ok || s.Fragment == nil {
+				return true
+			}
+			switch state[s.Fragment] {
+			case unvisited:
+				visit(s.Fragment)
+			case visiting:
+				p.inFile(d.File, func() {
+					p.newErr(s.LocRange, fmt.Sprintf(
+						"cyclic spread of fragment %q", s.Name.Name,
+					))
+				})
+				p.inFile(s.Fragment.File, func() {
+					p.newErr(s.Fragment.Name.LocRange, fmt.Sprintf(
+						"fragment %q spreads itself", s.Name.Name,
+					))
+				})
+			}
+			return true
+		}))
+		state[d] = visited
+	}
+	for _, d := range o.Fragments {
+		if defs[d.Name.Name] != d {
+			continue
+		}
+		// Imported fragments may be used by other templates
+		if _, ok := used[d]; !ok && d.File == "" {
+			p.newErr(d.Name.LocRange, fmt.Sprintf(
+				"fragment %q is unused", d.Name.Name,
+			))
+		}
+		if state[d] == unvisited {
+			visit(d)
+		}
+	}
+}
+
+// fileOf returns the name of the imported file defining e
+// or an empty string if e is defined in the template itself.
+func fileOf(e Expression) string {
+	for x := e; x != nil; x = x.GetParent() {
+		switch x := x.(type) {
+		case *FragmentDefinition:
+			return x.File
+		case *ConstrAlias:
+			// The constraint of an alias is defined by the alias
+			if x != e {
+				return x.File
+			}
+		}
+	}
+	return ""
+}
+
+// sortedErrors sorts the errors by their file
+// and their position in the file.
+func (p *Parser) sortedErrors() []Error {
+	sort.SliceStable(p.errors, func(i, j int) bool {
+		if p.errors[i].File != p.errors[j].File {
+			return p.errors[i].File < p.errors[j].File
+		}
+		return p.errors[i].Index < p.errors[j].Index
+	})
+	return p.errors
+}
+
+// uniqueErrors returns the sorted errors without duplicates.
+func (p *Parser) uniqueErrors() []Error {
+	reported := make(map[Error]struct{}, len(p.errors))
+	unique := p.errors[:0]
+	for _, err := range p.sortedErrors() {
+		if _, ok := reported[err]; !ok {
+			reported[err] = struct{}{}
+			unique = append(unique, err)
+		}
+	}
+	p.errors = unique
+	return p.errors
+}
+
+func (p *Parser) setTypes(o *Operation) {
+	if p.schema != nil {
+		switch o.Type {
+		case OperationTypeQuery:
 			o.Def = p.schema.Query
 		case OperationTypeMutation:
 			o.Def = p.schema.Mutation
@@ -1122,6 +2480,23 @@ func (p *Parser) setTypes(o *Operation) {
 		fields = o.Def.Fields
 	}
 	p.setTypesSelSet(o.SelectionSet, fields)
+
+	for _, d := range o.Fragments {
+		p.inFile(d.File, func() { p.setTypesFragment(d) })
+	}
+}
+
+func (p *Parser) setTypesFragment(d *FragmentDefinition) {
+	if p.schema == nil {
+		p.setTypesSelSet(d.SelectionSet, nil)
+		return
+	}
+	d.TypeCondition.TypeDef = p.schema.Types[d.TypeCondition.TypeName]
+	var fields ast.FieldList
+	if d.TypeCondition.TypeDef != nil {
+		fields = d.TypeCondition.TypeDef.Fields
+	}
+	p.setTypesSelSet(d.SelectionSet, fields)
 }
 
 func (p *Parser) setTypesSelSet(s SelectionSet, defs []*ast.FieldDefinition) {
@@ -1141,24 +2516,14 @@ func (p *Parser) setTypesSelSet(s SelectionSet, defs []*ast.FieldDefinition) {
 				if t := p.schema.Types[getTypeName(s.Def.Type)]; t != nil {
 					p.setTypesSelSet(s.SelectionSet, t.Fields)
 				}
-				for _, a := range s.Arguments {
-					a.Def = s.Def.Arguments.ForName(a.Name.Name)
-					if a.Def == nil {
-						continue
-					}
-					var exp *ast.Type
-					if a.Def.Type != nil {
-						exp = a.Def.Type
-					}
-					p.setTypesExpr(a.Constraint, exp)
-				}
+				p.setTypesArgs(s.Arguments, s.Def.Arguments, true)
 			} else {
 				p.setTypesSelSet(s.SelectionSet, nil)
-				for _, a := range s.Arguments {
-					p.setTypesExpr(a.Constraint, nil)
-				}
+				p.setTypesArgs(s.Arguments, nil, false)
 			}
+			p.setTypesDirectives(s.Directives)
 		case *SelectionInlineFrag:
+			p.setTypesDirectives(s.Directives)
 			if p.schema != nil {
 				s.TypeCondition.TypeDef = p.schema.Types[s.TypeCondition.TypeName]
 				var fields ast.FieldList
@@ -1169,13 +2534,48 @@ func (p *Parser) setTypesSelSet(s SelectionSet, defs []*ast.FieldDefinition) {
 			} else {
 				p.setTypesSelSet(s.SelectionSet, nil)
 			}
-		case *SelectionMax:
-			p.setTypesSelSet(s.Options, defs)
+		case optionSet:
+			p.setTypesSelSet(*s.options(), defs)
+		}
+	}
+}
+
+// setTypesArgs sets the types of args according to
+// their definitions defs if defined is true.
+func (p *Parser) setTypesArgs(
+	args []*Argument, defs ast.ArgumentDefinitionList, defined bool,
+) {
+	for _, a := range args {
+		if !defined {
+			p.setTypesExpr(a.Constraint, nil)
+			continue
+		}
+		a.Def = defs.ForName(a.Name.Name)
+		if a.Def == nil {
+			continue
+		}
+		p.setTypesExpr(a.Constraint, a.Def.Type)
+	}
+}
+
+func (p *Parser) setTypesDirectives(dirs []*Directive) {
+	for _, d := range dirs {
+		if p.schema != nil {
+			d.Def = p.schema.Directives[d.Name.Name]
+		}
+		if d.Def != nil {
+			p.setTypesArgs(d.Arguments, d.Def.Arguments, true)
+		} else {
+			p.setTypesArgs(d.Arguments, nil, false)
 		}
 	}
 }
 
 func (p *Parser) setTypesExpr(e Expression, exp *ast.Type) {
+	if e == nil {
+		// Absent arguments and object fields have no constraint
+		return
+	}
 	type S struct {
 		Expr   Expression
 		Expect *ast.Type
@@ -1218,12 +2618,47 @@ func (p *Parser) setTypesExpr(e Expression, exp *ast.Type) {
 			push(e.Value, nil)
 		case *ConstrLenLessOrEqual:
 			push(e.Value, nil)
+		case *ConstrMatches:
+			push(e.Value, nil)
+		case *ConstrIn:
+			for _, v := range e.Values {
+				push(v, exp)
+			}
+		case *ConstrNotIn:
+			for _, v := range e.Values {
+				push(v, exp)
+			}
+		case *ConstrUnique:
+		case *ConstrContains:
+			var et *ast.Type
+			if exp != nil {
+				et = exp.Elem
+			}
+			push(e.Value, et)
+		case *ConstrSum:
+			var et *ast.Type
+			if exp != nil {
+				et = mustMakeExpectNumNonNull(exp.Elem)
+			}
+			push(e.Constraint, et)
+		case *ConstrCount:
+			var et, ct *ast.Type
+			if exp != nil {
+				et = exp.Elem
+			}
+			if p.schema != nil {
+				ct = typeIntNotNull
+			}
+			push(e.Where, et)
+			push(e.Constraint, ct)
 		case *ConstrMap:
 			var et *ast.Type
 			if exp != nil {
 				et = exp.Elem
 			}
 			push(e.Constraint, et)
+		case *ConstrAlias:
+			p.inFile(e.File, func() { p.setTypesExpr(e.Constraint, exp) })
 		case *ExprParentheses:
 			push(e.Expression, exp)
 		case *ExprEqual:
@@ -1236,6 +2671,10 @@ func (p *Parser) setTypesExpr(e Expression, exp *ast.Type) {
 			push(e.Expression, exp)
 		case *ExprNumericNegation:
 			push(e.Expression, exp)
+		case *ExprCall:
+			for _, a := range e.Arguments {
+				push(a, nil)
+			}
 		case *ExprLogicalOr:
 			for _, e := range e.Expressions {
 				push(e, exp)
@@ -1457,7 +2896,6 @@ func (p *Parser) validate(o *Operation) (ok bool) {
 			}
 			def = p.schema.Query
 		}
-		return p.validateSelSet(o, def)
 	case OperationTypeMutation:
 		if p.schema != nil {
 			if p.schema.Mutation == nil {
@@ -1466,7 +2904,6 @@ func (p *Parser) validate(o *Operation) (ok bool) {
 			}
 			def = p.schema.Mutation
 		}
-		return p.validateSelSet(o, def)
 	case OperationTypeSubscription:
 		if p.schema != nil {
 			if p.schema.Subscription == nil {
@@ -1475,9 +2912,14 @@ func (p *Parser) validate(o *Operation) (ok bool) {
 			}
 			def = p.schema.Subscription
 		}
-		return p.validateSelSet(o, def)
+	default:
+		panic(fmt.Sprintf("unhandled operation type: %v", o.Type))
 	}
-	panic(fmt.Sprintf("unhandled operation type: %v", o.Type))
+	ok = p.validateFragments(o)
+	if !p.validateSelSet(o, def) {
+		ok = false
+	}
+	return ok
 }
 
 func (p *Parser) validateSelSet(
@@ -1500,10 +2942,18 @@ func (p *Parser) validateSelSet(
 	case *SelectionInlineFrag:
 		s, l = h.SelectionSet, h.Location
 		hostTypeName = h.TypeCondition.TypeName
+	case *FragmentDefinition:
+		s, l = h.SelectionSet, h.Location
+		hostTypeName = h.TypeCondition.TypeName
 	default:
 		panic(fmt.Errorf("unsupported type: %T", host))
 	}
 
+	if _, ok := p.incomplete[host]; ok && len(s.Selections) < 1 {
+		// Selections were dropped while recovering from syntax errors
+		return false
+	}
+
 	if s.Location.Index != 0 && len(s.Selections) < 1 {
 		p.newErr(s.LocRange, "empty selection set")
 		return false
@@ -1520,7 +2970,8 @@ func (p *Parser) validateSelSet(
 
 	fields := map[string]struct{}{}
 	typeConds := map[string]struct{}{}
-	maxBlock := false
+	optionSets := map[string]struct{}{}
+	recursion := false
 
 	for _, s := range s.Selections {
 		switch s := s.(type) {
@@ -1532,6 +2983,11 @@ func (p *Parser) validateSelSet(
 			}
 			fields[s.Name.Name] = struct{}{}
 
+			if !p.validateDepth(s) {
+				ok = false
+				continue
+			}
+
 			var def *ast.FieldDefinition
 			if expect != nil {
 				def = expect.Fields.ForName(s.Name.Name)
@@ -1558,15 +3014,58 @@ func (p *Parser) validateSelSet(
 			}
 			typeConds[s.TypeCondition.TypeName] = struct{}{}
 
+			p.validateDirectives(s.Directives, ast.LocationInlineFragment)
 			p.validateInlineFrag(s, expect)
-		case *SelectionMax:
-			if maxBlock {
-				p.errRedeclMax(s)
+		case *SelectionRecurse:
+			if recursion {
+				ok = false
+				p.newErr(s.LocRange, "redeclared recursion")
+				continue
+			}
+			recursion = true
+			if !p.validateRecurse(s, expect, fields) {
+				ok = false
+			}
+		case *SelectionSpread:
+			if !p.validateSpread(s, expect) {
+				ok = false
+				continue
+			}
+			d := s.Fragment
+			if !s.merged(selSetType(host)) {
+				if _, decl := typeConds[d.TypeCondition.TypeName]; decl {
+					ok = false
+					p.newErr(s.LocRange, "redeclared condition for type "+
+						d.TypeCondition.TypeName)
+					continue
+				}
+				typeConds[d.TypeCondition.TypeName] = struct{}{}
+				continue
+			}
+			for _, x := range d.Selections {
+				f, isField := x.(*SelectionField)
+				if !isField {
+					continue
+				}
+				if _, decl := fields[f.Name.Name]; decl {
+					ok = false
+					p.newErr(s.LocRange, fmt.Sprintf(
+						"fragment %q redeclares field %q",
+						s.Name.Name, f.Name.Name,
+					))
+					continue
+				}
+				fields[f.Name.Name] = struct{}{}
+			}
+		case optionSet:
+			kind := s.kind()
+			if _, decl := optionSets[kind]; decl {
+				p.errRedeclOptionSet(s)
 				continue
 			}
 
-			maxBlock = true
-			for _, s := range s.Options.Selections {
+			optionSets[kind] = struct{}{}
+			for _, s := range s.options().Selections {
 				switch s := s.(type) {
 				case *SelectionField:
 					if _, decl := fields[s.Name.Name]; decl {
@@ -1578,7 +3077,19 @@ func (p *Parser) validateSelSet(
 
 					if s.Name.Name == "__typename" {
 						ok = false
-						p.errTypenameInMax(s)
+						p.errTypenameInOptionSet(s, kind)
+						continue
+					}
+					if s.Required {
+						ok = false
+						p.newErr(s.LocRange, fmt.Sprintf(
+							"required field %q can't be a %s set option",
+							s.Name.Name, kind,
+						))
+						continue
+					}
+					if !p.validateDepth(s) {
+						ok = false
 						continue
 					}
 					var def *ast.FieldDefinition
@@ -1604,12 +3115,30 @@ func (p *Parser) validateSelSet(
 					}
 					typeConds[s.TypeCondition.TypeName] = struct{}{}
 
+					if !p.validateDirectives(
+						s.Directives, ast.LocationInlineFragment,
+					) {
+						ok = false
+					}
 					if !p.validateInlineFrag(s, expect) {
 						ok = false
 						continue
 					}
-				case *SelectionMax:
-					p.errNestedMaxSet(s)
+				case optionSet:
+					p.errNestedOptionSet(s)
+					ok = false
+					continue
+				case *SelectionRecurse:
+					p.newErr(
+						s.LocRange, "recursion can't be a "+kind+" set option",
+					)
+					ok = false
+					continue
+				case *SelectionSpread:
+					p.newErr(
+						s.LocRange,
+						"fragment spread can't be a "+kind+" set option",
+					)
 					ok = false
 					continue
 				}
@@ -1619,18 +3148,141 @@ func (p *Parser) validateSelSet(
 	return ok
 }
 
+// validateDepth returns false and reports f if it's nested deeper
+// than the max depth of the operation allows.
+func (p *Parser) validateDepth(f *SelectionField) (ok bool) {
+	depth := 0
+	for e := Expression(f); e != nil; e = e.GetParent() {
+		switch e := e.(type) {
+		case *SelectionField:
+			depth++
+		case *Operation:
+			if e.MaxDepth > 0 && depth > e.MaxDepth {
+				p.newErr(f.LocRange, fmt.Sprintf(
+					"field %q exceeds max depth %d", f.Name.Name, e.MaxDepth,
+				))
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// validateRecurse validates recursion r inside a selection set of
+// type expect where fields holds the names of the declared fields.
+func (p *Parser) validateRecurse(
+	r *SelectionRecurse,
+	expect *ast.Definition,
+	fields map[string]struct{},
+) (ok bool) {
+	f := recursiveField(r)
+	if f == nil {
+		p.newErr(r.LocRange, "recursion outside of a field's selection set")
+		return false
+	}
+	if _, decl := fields[f.Name.Name]; decl {
+		p.newErr(r.LocRange, fmt.Sprintf("redeclared field %q", f.Name.Name))
+		return false
+	}
+	fields[f.Name.Name] = struct{}{}
+	if expect != nil && f.Def != nil &&
+		expect.Fields.ForName(f.Name.Name) != f.Def {
+		p.newErr(r.LocRange, fmt.Sprintf(
+			"can't recurse into field %q in type %s",
+			f.Name.Name, expect.Name,
+		))
+		return false
+	}
+	ok = true
+	for _, v := range declaredVariables(f) {
+		ok = false
+		p.inFile(fileOf(v.Parent), func() {
+			p.newErr(v.LocRange, fmt.Sprintf(
+				"variable %q can't be declared in field %q repeated "+
+					"by recursion", v.Name, f.Name.Name,
+			))
+		})
+	}
+	return ok
+}
+
+// declaredVariables returns the variables declared in the tree under e
+// including the fragments spread in it.
+func declaredVariables(e Expression) (l []*VariableDeclaration) {
+	visited := map[*FragmentDefinition]struct{}{}
+	var visit func(e Expression)
+	visit = func(e Expression) {
+		Walk(e, inspector(func(e Expression) bool {
+			switch e := e.(type) {
+			case *Argument:
+				if e.AssociatedVariable != nil {
+					l = append(l, e.AssociatedVariable)
+				}
+			case *ObjectField:
+				if e.AssociatedVariable != nil {
+					l = append(l, e.AssociatedVariable)
+				}
+			case *SelectionSpread:
+				if _, ok := visited[e.Fragment]; !ok && e.Fragment != nil {
+					visited[e.Fragment] = struct{}{}
+					visit(e.Fragment)
+				}
+			}
+			return true
+		}))
+	}
+	visit(e)
+	return l
+}
+
 func (p *Parser) validateField(
 	host *ast.Definition,
 	f *SelectionField,
 	expect *ast.FieldDefinition,
 ) (ok bool) {
 	if f.ArgumentList.Location.Index != 0 && len(f.Arguments) < 1 {
-		p.newErr(f.ArgumentList.LocRange, "empty argument list")
+		if _, incomplete := p.incomplete[f]; !incomplete {
+			p.newErr(f.ArgumentList.LocRange, "empty argument list")
+		}
 		return false
 	}
 
-	byName := make(map[string]*Argument, len(f.Arguments))
-	for _, a := range f.Arguments {
+	var defs ast.ArgumentDefinitionList
+	if expect != nil {
+		defs = expect.Arguments
+	}
+	ok = p.validateArguments(f, f.ArgumentList, defs, expect != nil,
+		func(a *Argument) { p.errUndefArg(a, f, host.Name) },
+	)
+	if !p.validateDirectives(f.Directives, ast.LocationField) {
+		ok = false
+	}
+
+	var def *ast.Definition
+	if p.schema != nil {
+		def = p.schema.Types[getTypeName(expect.Type)]
+	}
+	if !p.validateSelSet(f, def) {
+		ok = false
+	}
+	return ok
+}
+
+// validateArguments validates the non-empty argument list of host,
+// which is either a field or a directive, against the argument
+// definitions defs if checkDefs is true. undefined is called for
+// every argument that isn't defined in defs.
+func (p *Parser) validateArguments(
+	host Expression,
+	list ArgumentList,
+	defs ast.ArgumentDefinitionList,
+	checkDefs bool,
+	undefined func(*Argument),
+) (ok bool) {
+	_, incomplete := p.incomplete[host]
+	ok = true
+	byName := make(map[string]*Argument, len(list.Arguments))
+	for _, a := range list.Arguments {
 		if _, found := byName[a.Name.Name]; found {
 			p.newErr(a.LocRange, fmt.Sprintf(
 				"redeclared argument %q", a.Name.Name,
@@ -1641,22 +3293,30 @@ func (p *Parser) validateField(
 		byName[a.Name.Name] = a
 	}
 
-	if expect != nil {
-		for _, a := range f.Arguments {
+	if checkDefs {
+		for _, a := range list.Arguments {
 			// Check undefined arguments
-			if ad := expect.Arguments.ForName(a.Name.Name); ad == nil {
-				p.errUndefArg(a, f, host.Name)
+			if ad := defs.ForName(a.Name.Name); ad == nil {
+				undefined(a)
+				ok = false
+			} else if (a.Optional || a.Absent) &&
+				ad.Type.NonNull && ad.DefaultValue == nil {
+				p.errRequiredArg(a, ad)
 				ok = false
 			}
 		}
 
 		// Check required arguments
-		for _, a := range expect.Arguments {
+		for _, a := range defs {
+			if incomplete {
+				// Arguments were dropped while recovering from syntax errors
+				break
+			}
 			if a.Type.NonNull && a.DefaultValue == nil {
 				if _, found := byName[a.Name]; !found {
-					l := f.ArgumentList.LocRange
-					if len(f.Arguments) < 1 {
-						l = f.LocRange
+					l := list.LocRange
+					if len(list.Arguments) < 1 {
+						l = host.GetLocation()
 					}
 					p.errMissingArg(l, a)
 					ok = false
@@ -1667,6 +3327,9 @@ func (p *Parser) validateField(
 
 	// Check constraints
 	for _, a := range byName {
+		if a.Absent {
+			continue
+		}
 		var exp *ast.Type
 		if a.Def != nil {
 			exp = a.Def.Type
@@ -1676,17 +3339,72 @@ func (p *Parser) validateField(
 			ok = false
 		}
 	}
+	return ok
+}
 
-	var def *ast.Definition
-	if p.schema != nil {
-		def = p.schema.Types[getTypeName(expect.Type)]
-	}
-	if !p.validateSelSet(f, def) {
-		ok = false
+// validateDirectives validates the directives declared on a field
+// or an inline fragment at location l.
+func (p *Parser) validateDirectives(
+	dirs []*Directive, l ast.DirectiveLocation,
+) (ok bool) {
+	ok = true
+	declared := make(map[string]struct{}, len(dirs))
+	for _, d := range dirs {
+		if _, found := declared[d.Name.Name]; found {
+			p.newErr(d.LocRange, fmt.Sprintf(
+				"redeclared directive %q", "@"+d.Name.Name,
+			))
+			ok = false
+			continue
+		}
+		declared[d.Name.Name] = struct{}{}
+
+		if d.ArgumentList.Location.Index != 0 && len(d.Arguments) < 1 {
+			if _, incomplete := p.incomplete[d]; !incomplete {
+				p.newErr(d.ArgumentList.LocRange, "empty argument list")
+			}
+			ok = false
+			continue
+		}
+
+		var defs ast.ArgumentDefinitionList
+		if p.schema != nil {
+			if d.Def == nil {
+				p.newErr(d.Name.LocRange, fmt.Sprintf(
+					"directive %q is undefined", "@"+d.Name.Name,
+				))
+				ok = false
+				continue
+			}
+			if !hasDirectiveLocation(d.Def, l) {
+				p.newErr(d.Name.LocRange, fmt.Sprintf(
+					"directive %q can't be used on %s", "@"+d.Name.Name, l,
+				))
+				ok = false
+				continue
+			}
+			defs = d.Def.Arguments
+		}
+		if !p.validateArguments(d, d.ArgumentList, defs, p.schema != nil,
+			func(a *Argument) { p.errUndefDirectiveArg(a, d) },
+		) {
+			ok = false
+		}
 	}
 	return ok
 }
 
+func hasDirectiveLocation(
+	d *ast.DirectiveDefinition, l ast.DirectiveLocation,
+) bool {
+	for _, x := range d.Locations {
+		if x == l {
+			return true
+		}
+	}
+	return false
+}
+
 func (p *Parser) validateExpr(
 	// hostPath defines the path to the origin argument
 	// can contain any of:
@@ -1784,12 +3502,109 @@ func (p *Parser) validateExpr(
 				break TYPESWITCH
 			}
 			push(val, nil)
+		case *ConstrMatches:
+			if expect != nil && !p.expectationIsString(expect) {
+				p.errCantApplyConstrMatches(e, expect)
+				ok = false
+				break TYPESWITCH
+			}
+			str, isStr := e.Value.(*String)
+			if !isStr {
+				p.errExpectedPattern(e.Value)
+				ok = false
+				break TYPESWITCH
+			}
+			re, err := regexp.Compile(str.Value)
+			if err != nil {
+				p.errInvalidPattern(str, err)
+				ok = false
+				break TYPESWITCH
+			}
+			e.re = re
+		case *ConstrIn, *ConstrNotIn:
+			var values []Expression
+			switch e := e.(type) {
+			case *ConstrIn:
+				values = e.Values
+			case *ConstrNotIn:
+				values = e.Values
+			}
+
+			if len(values) < 1 {
+				p.newErr(e.GetLocation(), "empty set")
+				ok = false
+				break TYPESWITCH
+			}
+			set := make(valueSet, len(values))
+			for _, v := range values {
+				x, isConst := constantValue(v)
+				if !isConst {
+					p.errExpectedSetValue(v)
+					ok = false
+					continue
+				}
+				if !set.add(x) {
+					p.newErr(v.GetLocation(), "duplicate value in set")
+					ok = false
+				}
+			}
+			if !ok {
+				break TYPESWITCH
+			}
+			switch e := e.(type) {
+			case *ConstrIn:
+				e.set = set
+			case *ConstrNotIn:
+				e.set = set
+			}
+			for i := len(values) - 1; i >= 0; i-- {
+				push(values[i], expect)
+			}
+		case *ConstrUnique, *ConstrContains, *ConstrSum, *ConstrCount:
+			if expect != nil && !p.expectationIsArray(expect) {
+				p.errCantApplyConstrCollection(e, expect)
+				ok = false
+				break TYPESWITCH
+			}
+			var elem *ast.Type
+			if expect != nil {
+				elem = expect.Elem
+			}
+			switch e := e.(type) {
+			case *ConstrContains:
+				push(e.Value, elem)
+			case *ConstrSum:
+				if elem != nil && !p.expectationIsNum(elem) {
+					p.newErr(e.LocRange, "collection constraint 'sum' "+
+						"(sum of items) only supports arrays of "+
+						"type Float and type Int, "+
+						"it can't be applied to type "+expect.String())
+					ok = false
+					break TYPESWITCH
+				}
+				if elem == nil {
+					// Schemaless sums are numbers of either type
+					elem = typeFloatNotNull
+				}
+				push(e.Constraint, mustMakeExpectNumNonNull(elem))
+			case *ConstrCount:
+				push(e.Constraint, typeIntNotNull)
+				push(e.Where, elem)
+			}
 		case *ConstrMap:
 			var exp *ast.Type
 			if expect != nil {
 				exp = expect.Elem
 			}
 			push(e.Constraint, exp)
+		case *ConstrAlias:
+			// Report the errors of the aliased constraint
+			// in the file defining the alias
+			p.inFile(e.File, func() {
+				if !p.validateExpr(pathToOriginArg, e.Constraint, expect) {
+					ok = false
+				}
+			})
 		case *ExprParentheses:
 			push(e.Expression, expect)
 		case *ExprEqual, *ExprNotEqual:
@@ -1846,6 +3661,47 @@ func (p *Parser) validateExpr(
 				break TYPESWITCH
 			}
 			push(e.Expression, mustMakeExpectNumNonNull(expect))
+		case *ExprCall:
+			if expect != nil && !p.expectationIsNum(expect) {
+				p.errUnexpType(expect, e)
+				ok = false
+				break TYPESWITCH
+			}
+			minArgs, maxArgs, defined := builtinArity(e.Function.Name)
+			if !defined {
+				p.newErr(e.Function.LocRange, fmt.Sprintf(
+					"undefined function %q", e.Function.Name,
+				))
+				ok = false
+				break TYPESWITCH
+			} else if len(e.Arguments) < minArgs ||
+				(maxArgs > 0 && len(e.Arguments) > maxArgs) {
+				p.errFuncArgCount(e, minArgs, maxArgs)
+				ok = false
+				break TYPESWITCH
+			}
+			for _, a := range e.Arguments {
+				if e.Function.Name == "len" {
+					if !p.isString(a) && !p.isArray(a) {
+						p.errExpectedStringOrArray(a)
+						ok = false
+					}
+					continue
+				}
+				if _, found := find[*Null](a); found {
+					p.errExpectedNumGotNull(a.GetLocation())
+					ok = false
+				} else if !p.isNumeric(a) {
+					p.errExpectedNum(a)
+					ok = false
+				}
+			}
+			if !ok {
+				break TYPESWITCH
+			}
+			for i := len(e.Arguments) - 1; i >= 0; i-- {
+				push(e.Arguments[i], nil)
+			}
 		case *ExprLogicalOr:
 			objEncountered := false
 			for _, e := range e.Expressions {
@@ -2008,6 +3864,10 @@ func (p *Parser) validateExpr(
 				push(e.Items[i], exp)
 			}
 		case *Variable:
+			if e.Declaration == nil || e.Declaration.Parent == nil {
+				// The declaration was skipped during error recovery
+				break
+			}
 			for _, o := range pathToOriginArg {
 				if o == e.Declaration.Parent {
 					ok = false
@@ -2106,12 +3966,25 @@ func (p *Parser) validateObject(
 					p.errUndefField(f, exp.NamedType)
 					continue
 				}
+				if (f.Optional || f.Absent) &&
+					fd.Type.NonNull && fd.DefaultValue == nil {
+					ok = false
+					p.errRequiredInputField(f, fd)
+				}
 				fieldNames[f.Name.Name] = struct{}{}
 				validFields = append(validFields, f)
 			}
 
 			// Check required fields
 			for _, f := range d.Fields {
+				if o.Open {
+					// Required fields may be left unspecified
+					break
+				}
+				if _, ok := p.incomplete[o]; ok {
+					// Fields were dropped while recovering from syntax errors
+					break
+				}
 				if f.Type.NonNull && f.DefaultValue == nil {
 					if _, exists := fieldNames[f.Name]; !exists {
 						ok = false
@@ -2124,6 +3997,9 @@ func (p *Parser) validateObject(
 
 	// Check constraints
 	for _, f := range validFields {
+		if f.Absent {
+			continue
+		}
 		var exp *ast.Type
 		if f.Def != nil {
 			exp = f.Def.Type
@@ -2141,7 +4017,7 @@ func (p *Parser) validateObject(
 }
 
 func (p *Parser) errUncompVal(e Expression) {
-	p.errors = append(p.errors, Error{
+	p.addErr(Error{
 		LocRange: e.GetLocation(),
 		Msg:      "uncomparable value of type " + e.TypeDesignation(),
 	})
@@ -2155,7 +4031,7 @@ func (p *Parser) errConstrSelf(v *Variable) {
 	case *ObjectField:
 		on, name = "object field", v.Name.Name
 	}
-	p.errors = append(p.errors, Error{
+	p.addErr(Error{
 		LocRange: v.LocRange,
 		Msg: fmt.Sprintf(
 			"illegal self-reference of %s %q through variable %q in constraint",
@@ -2169,35 +4045,35 @@ func (p *Parser) errUnexpTok(s source, msg string) {
 	if s.Index >= len(s.s) {
 		prefix = "unexpected end of file, "
 	}
-	p.errors = append(p.errors, Error{
+	p.addErr(Error{
 		LocRange: locRange(s.Location),
 		Msg:      prefix + msg,
 	})
 }
 
-func (p *Parser) errTypenameInMax(f *SelectionField) {
-	p.errors = append(p.errors, Error{
+func (p *Parser) errTypenameInOptionSet(f *SelectionField, kind string) {
+	p.addErr(Error{
 		LocRange: f.LocRange,
-		Msg:      "avoid __typename in max sets",
+		Msg:      "avoid __typename in " + kind + " sets",
 	})
 }
 
-func (p *Parser) errNestedMaxSet(s *SelectionMax) {
-	p.errors = append(p.errors, Error{
-		LocRange: s.LocRange,
-		Msg:      "nested max set",
+func (p *Parser) errNestedOptionSet(s optionSet) {
+	p.addErr(Error{
+		LocRange: s.GetLocation(),
+		Msg:      "nested " + s.kind() + " set",
 	})
 }
 
-func (p *Parser) errRedeclMax(s *SelectionMax) {
-	p.errors = append(p.errors, Error{
-		LocRange: s.LocRange,
-		Msg:      "redeclared max set",
+func (p *Parser) errRedeclOptionSet(s optionSet) {
+	p.addErr(Error{
+		LocRange: s.GetLocation(),
+		Msg:      "redeclared " + s.kind() + " set",
 	})
 }
 
 func (p *Parser) errUndefType(l LocRange, name string) {
-	p.errors = append(p.errors, Error{
+	p.addErr(Error{
 		LocRange: l,
 		Msg:      "type " + name + " is undefined in schema",
 	})
@@ -2212,7 +4088,7 @@ func errFieldTypenameCantHaveSels() string {
 }
 
 func (p *Parser) errUndefField(f *ObjectField, hostTypeName string) {
-	p.errors = append(p.errors, Error{
+	p.addErr(Error{
 		LocRange: f.LocRange,
 		Msg: fmt.Sprintf(
 			"field %q is undefined in type %s",
@@ -2226,7 +4102,7 @@ func (p *Parser) errUndefArg(
 	f *SelectionField,
 	hostTypeName string,
 ) {
-	p.errors = append(p.errors, Error{
+	p.addErr(Error{
 		LocRange: a.LocRange,
 		Msg: fmt.Sprintf(
 			"argument %q is undefined on field %q in type %s",
@@ -2235,8 +4111,18 @@ func (p *Parser) errUndefArg(
 	})
 }
 
+func (p *Parser) errUndefDirectiveArg(a *Argument, d *Directive) {
+	p.addErr(Error{
+		LocRange: a.LocRange,
+		Msg: fmt.Sprintf(
+			"argument %q is undefined on directive %q",
+			a.Name.Name, "@"+d.Name.Name,
+		),
+	})
+}
+
 func (p *Parser) errMismatchingTypes(l LocRange, left, right Expression) {
-	p.errors = append(p.errors, Error{
+	p.addErr(Error{
 		LocRange: l,
 		Msg: "mismatching types " +
 			left.TypeDesignation() +
@@ -2246,7 +4132,7 @@ func (p *Parser) errMismatchingTypes(l LocRange, left, right Expression) {
 }
 
 func (p *Parser) errCompareWithNull(l LocRange, e, null Expression) {
-	p.errors = append(p.errors, Error{
+	p.addErr(Error{
 		LocRange: l,
 		Msg: "mismatching types " +
 			e.TypeDesignation() +
@@ -2286,12 +4172,27 @@ func (p *Parser) errFloatOverflow(n *Number) {
 }
 
 func (p *Parser) newErr(l LocRange, msg string) {
-	p.errors = append(p.errors, Error{
+	p.addErr(Error{
 		LocRange: l,
 		Msg:      msg,
 	})
 }
 
+// addErr records err as an error in the current file.
+func (p *Parser) addErr(err Error) {
+	err.File = p.file
+	p.errors = append(p.errors, err)
+}
+
+// inFile calls fn with file as the current file,
+// to which the errors reported by fn are attributed.
+func (p *Parser) inFile(file string, fn func()) {
+	prev := p.file
+	p.file = file
+	fn()
+	p.file = prev
+}
+
 type expect int8
 
 const (
@@ -2301,7 +4202,11 @@ const (
 	expectValueInArray      expect = 3
 )
 
-func (p *Parser) parseSelectionSet(s source) (source, SelectionSet) {
+// parseSelectionSet parses the selection set of host.
+// Selections that fail to parse are skipped and host is marked incomplete.
+func (p *Parser) parseSelectionSet(
+	s source, host Expression,
+) (source, SelectionSet) {
 	selset := SelectionSet{LocRange: locRange(s.Location)}
 	var ok bool
 	if s, ok = s.consume("{"); !ok {
@@ -2311,7 +4216,6 @@ func (p *Parser) parseSelectionSet(s source) (source, SelectionSet) {
 
 	for {
 		s = s.consumeIgnored()
-		var name []byte
 
 		if s.isEOF() {
 			p.errUnexpTok(s, "expected selection")
@@ -2323,122 +4227,355 @@ func (p *Parser) parseSelectionSet(s source) (source, SelectionSet) {
 			break
 		}
 
-		s = s.consumeIgnored()
-
-		if _, ok = s.consume("..."); ok {
-			var fragInline *SelectionInlineFrag
-			if s, fragInline = p.parseInlineFrag(s); s.stop() {
+		sBeforeSel := s
+		var sel Selection
+		if s, sel = p.parseSelection(s); s.stop() {
+			// Recover by skipping to the end of the selection set
+			p.incomplete[host] = struct{}{}
+			if s = sBeforeSel.skipUntil(""); s.isEOF() {
 				return stop(), SelectionSet{}
 			}
-			selset.Selections = append(selset.Selections, fragInline)
 			continue
 		}
+		selset.Selections = append(selset.Selections, sel)
+	}
 
-		lBeforeName := s.Location
-		sel := &SelectionField{LocRange: locRange(s.Location)}
-		if s, name = s.consumeName(); name == nil {
-			p.errUnexpTok(s, "expected selection")
-			return stop(), SelectionSet{}
+	return s, selset
+}
+
+func (p *Parser) parseSelection(s source) (source, Selection) {
+	var ok bool
+	var name []byte
+
+	if _, ok = s.consume("..."); ok {
+		if n, r, ok := p.parseRecurse(s); ok {
+			if n.stop() {
+				return stop(), nil
+			}
+			return n, r
 		}
-		sel.Name = Name{
-			LocRange: LocRange{
-				Location:    lBeforeName,
-				LocationEnd: locEnd(s),
-			},
-			Name: string(name),
+		if n, sp, ok := p.parseSpread(s); ok {
+			return n, sp
 		}
-		sel.LocationEnd = locEnd(s)
+		var fragInline *SelectionInlineFrag
+		if s, fragInline = p.parseInlineFrag(s); s.stop() {
+			return stop(), nil
+		}
+		return s, fragInline
+	}
 
-		s = s.consumeIgnored()
+	sel := &SelectionField{LocRange: locRange(s.Location)}
+	if n, a, ok := p.parseAlias(s); ok {
+		if n.stop() {
+			return stop(), nil
+		}
+		sel.Alias, s = a, n
+	}
 
-		if sel.Name.Name == "max" {
-			if s.isEOF() {
-				p.errUnexpTok(s, "expected max set")
-				return stop(), SelectionSet{}
+	lBeforeName := s.Location
+	if s, name = s.consumeName(); name == nil {
+		p.errUnexpTok(s, "expected selection")
+		return stop(), nil
+	}
+	sel.Name = Name{
+		LocRange: LocRange{
+			Location:    lBeforeName,
+			LocationEnd: locEnd(s),
+		},
+		Name: string(name),
+	}
+	sel.LocationEnd = locEnd(s)
+
+	s = s.consumeIgnored()
+
+	if isOptionSetKeyword(sel.Name.Name) && sel.Alias == nil {
+		if s.isEOF() {
+			p.errUnexpTok(s, "expected "+sel.Name.Name+" set")
+			return stop(), nil
+		}
+
+		if b := s.s[s.Index]; b == '-' || b == '"' || b == '[' {
+			// Prevent an invalid limit from being parsed as a field
+			p.errUnexpTok(s, "expected selection")
+			return stop(), nil
+		}
+
+		lBeforeMaxNum := s.Location
+		var maxNum int64
+		if s, maxNum, ok = s.consumeUnsignedInt(); ok {
+			// Max, min or exactly set
+			s = s.consumeIgnored()
+
+			if maxNum < 1 {
+				p.newErr(
+					locRange(lBeforeMaxNum),
+					"limit of options must be an unsigned integer greater 0",
+				)
+				return stop(), nil
 			}
 
-			lBeforeMaxNum := s.Location
-			var maxNum int64
-			if s, maxNum, ok = s.consumeUnsignedInt(); ok {
-				// Max
-				s = s.consumeIgnored()
+			var e optionSet
+			switch sel.Name.Name {
+			case "max":
+				e = &SelectionMax{Limit: int(maxNum)}
+			case "min":
+				e = &SelectionMin{Limit: int(maxNum)}
+			default:
+				e = &SelectionExactly{Limit: int(maxNum)}
+			}
+			options := e.options()
+			sBeforeOptionsBlock := s
+			if s, *options = p.parseSelectionSet(s, e); s.stop() {
+				return stop(), nil
+			}
+			setLocRange(e, LocRange{
+				Location:    lBeforeName,
+				LocationEnd: options.LocationEnd,
+			})
+
+			if _, ok := p.incomplete[e]; ok {
+				// Skip checking options that were partially dropped
+			} else if len(options.Selections) < 2 {
+				p.newErr(
+					locRange(sBeforeOptionsBlock.Location),
+					e.kind()+" set must have at least 2 selection options",
+				)
+			} else if maxNum > int64(len(options.Selections)-1) {
+				p.newErr(
+					locRange(lBeforeMaxNum),
+					e.kind()+" limit exceeds number of options-1",
+				)
+			}
 
-				if maxNum < 1 {
-					p.newErr(
-						locRange(lBeforeMaxNum),
-						"limit of options must be an unsigned integer greater 0",
-					)
-					return stop(), SelectionSet{}
-				}
+			for _, s := range options.Selections {
+				setParent(s, e)
+			}
+			return s, e
+		}
+	}
+
+	if s, ok = s.consume("!"); ok {
+		sel.Required = true
+		sel.LocationEnd = locEnd(s)
+		s = s.consumeIgnored()
+	}
+
+	if s.peek1('(') {
+		if sel.Name.Name == "__typename" {
+			p.newErr(locRange(s.Location), errFieldTypenameCantHaveArgs())
+			return stop(), nil
+		}
+		if s, sel.ArgumentList = p.parseArguments(s, sel); s.stop() {
+			return stop(), nil
+		}
+		for _, arg := range sel.Arguments {
+			setParent(arg, sel)
+		}
+		sel.LocationEnd = sel.ArgumentList.LocationEnd
+	}
 
-				var options SelectionSet
-				sBeforeOptionsBlock := s
-				if s, options = p.parseSelectionSet(s); s.stop() {
-					return stop(), SelectionSet{}
-				}
+	s = s.consumeIgnored()
 
-				if len(options.Selections) < 2 {
-					p.newErr(
-						locRange(sBeforeOptionsBlock.Location),
-						"max set must have at least 2 selection options",
-					)
-				} else if maxNum > int64(len(options.Selections)-1) {
-					p.newErr(
-						locRange(lBeforeMaxNum),
-						"max limit exceeds number of options-1",
-					)
-				}
+	if s.peek1('@') {
+		if s, sel.Directives = p.parseDirectives(s, sel); s.stop() {
+			return stop(), nil
+		}
+		sel.LocationEnd = sel.Directives[len(sel.Directives)-1].LocationEnd
+	}
 
-				e := &SelectionMax{
-					LocRange: LocRange{
-						Location:    lBeforeName,
-						LocationEnd: options.LocationEnd,
-					},
-					Limit:   int(maxNum),
-					Options: options,
-				}
-				for _, s := range e.Options.Selections {
-					setParent(s, e)
-				}
-				selset.Selections = append(selset.Selections, e)
-				continue
-			}
+	if s.peek1('{') {
+		if sel.Name.Name == "__typename" {
+			p.newErr(locRange(s.Location), errFieldTypenameCantHaveSels())
+			return stop(), nil
 		}
 
-		if s.peek1('(') {
-			if sel.Name.Name == "__typename" {
-				p.newErr(locRange(s.Location), errFieldTypenameCantHaveArgs())
-				return stop(), SelectionSet{}
-			}
-			if s, sel.ArgumentList = p.parseArguments(s); s.stop() {
-				return stop(), SelectionSet{}
-			}
-			for _, arg := range sel.Arguments {
-				setParent(arg, sel)
-			}
-			sel.LocationEnd = sel.ArgumentList.LocationEnd
+		if s, sel.SelectionSet = p.parseSelectionSet(s, sel); s.stop() {
+			return stop(), nil
+		}
+		for _, sub := range sel.Selections {
+			setParent(sub, sel)
 		}
+		sel.LocationEnd = sel.SelectionSet.LocationEnd
+	}
+	return s, sel
+}
+
+// isOptionSetKeyword returns true if name is the keyword of
+// a max, min or exactly set.
+func isOptionSetKeyword(name string) bool {
+	return name == "max" || name == "min" || name == "exactly"
+}
+
+// parseAlias parses the alias pattern and the optional limit
+// of a field selection ("pattern[limit]:").
+// ok is false if s isn't at the start of an alias.
+func (p *Parser) parseAlias(s source) (_ source, a *Alias, ok bool) {
+	si := s
+	var pattern []byte
+	if s, pattern = s.consumeAliasPattern(); pattern == nil {
+		return si, nil, false
+	}
+	a = &Alias{
+		LocRange: LocRange{
+			Location:    si.Location,
+			LocationEnd: locEnd(s),
+		},
+		Pattern: string(pattern),
+	}
+	s = s.consumeIgnored()
 
+	if s, ok = s.consume("["); ok {
+		s = s.consumeIgnored()
+		lBeforeLimit := s.Location
+		var limit int64
+		if s, limit, ok = s.consumeUnsignedInt(); !ok || limit < 1 {
+			p.newErr(
+				locRange(lBeforeLimit),
+				"alias limit must be an unsigned integer greater 0",
+			)
+			return stop(), nil, true
+		}
+		s = s.consumeIgnored()
+		if s, ok = s.consume("]"); !ok {
+			p.errUnexpTok(s, "expected end of alias limit")
+			return stop(), nil, true
+		}
+		a.Limit = int(limit)
+		a.LocationEnd = locEnd(s)
 		s = s.consumeIgnored()
+		if s, ok = s.consume(":"); !ok {
+			p.errUnexpTok(s, "expected colon")
+			return stop(), nil, true
+		}
+		return s.consumeIgnored(), a, true
+	}
+
+	if s, ok = s.consume(":"); !ok {
+		// Not an alias
+		return si, nil, false
+	}
+	return s.consumeIgnored(), a, true
+}
+
+// matches returns true if alias matches the pattern of a.
+func (a *Alias) matches(alias string) bool {
+	// Match the pattern segment by segment backtracking
+	// to the last asterisk on mismatch.
+	p, s := a.Pattern, alias
+	star, next := -1, 0
+	i, j := 0, 0
+	for j < len(s) {
+		switch {
+		case i < len(p) && p[i] == '*':
+			star, next = i, j
+			i++
+		case i < len(p) && p[i] == s[j]:
+			i++
+			j++
+		case star > -1:
+			next++
+			i, j = star+1, next
+		default:
+			return false
+		}
+	}
+	for i < len(p) && p[i] == '*' {
+		i++
+	}
+	return i == len(p)
+}
 
-		if s.peek1('{') {
-			if sel.Name.Name == "__typename" {
-				p.newErr(locRange(s.Location), errFieldTypenameCantHaveSels())
-				return stop(), SelectionSet{}
-			}
+// parseRecurse parses the recursion "...recurse(max: limit)".
+// ok is false if s isn't at the start of a recursion.
+func (p *Parser) parseRecurse(
+	s source,
+) (_ source, r *SelectionRecurse, ok bool) {
+	si := s
+	if s, ok = s.consume("..."); !ok {
+		return si, nil, false
+	}
+	s = s.consumeIgnored()
+	var tok []byte
+	if s, tok = s.consumeToken(); string(tok) != "recurse" {
+		return si, nil, false
+	}
+	r = &SelectionRecurse{LocRange: locRange(si.Location)}
 
-			if s, sel.SelectionSet = p.parseSelectionSet(s); s.stop() {
-				return stop(), SelectionSet{}
-			}
-			for _, sub := range sel.Selections {
-				setParent(sub, sel)
-			}
-			sel.LocationEnd = sel.SelectionSet.LocationEnd
+	s = s.consumeIgnored()
+	if s, ok = s.consume("("); !ok {
+		p.errUnexpTok(s, "expected opening parenthesis")
+		return stop(), nil, true
+	}
+	s = s.consumeIgnored()
+	sBeforeKeyword := s
+	if s, tok = s.consumeName(); string(tok) != "max" {
+		p.errUnexpTok(sBeforeKeyword, "expected keyword 'max'")
+		return stop(), nil, true
+	}
+	s = s.consumeIgnored()
+	if s, ok = s.consume(":"); !ok {
+		p.errUnexpTok(s, "expected colon")
+		return stop(), nil, true
+	}
+	s = s.consumeIgnored()
+	lBeforeLimit := s.Location
+	var limit int64
+	if s, limit, ok = s.consumeUnsignedInt(); !ok || limit < 1 {
+		p.newErr(
+			locRange(lBeforeLimit),
+			"recursion limit must be an unsigned integer greater 0",
+		)
+		return stop(), nil, true
+	}
+	s = s.consumeIgnored()
+	if s, ok = s.consume(")"); !ok {
+		p.errUnexpTok(s, "expected closing parenthesis")
+		return stop(), nil, true
+	}
+	r.Max = int(limit)
+	r.LocationEnd = locEnd(s)
+	return s, r, true
+}
+
+// recursiveField returns the field r recurses into, which is
+// the closest field enclosing r, or nil if there's none.
+func recursiveField(r *SelectionRecurse) *SelectionField {
+	for e := r.Parent; e != nil; e = e.GetParent() {
+		if f, ok := e.(*SelectionField); ok {
+			return f
 		}
-		selset.Selections = append(selset.Selections, sel)
 	}
+	return nil
+}
 
-	return s, selset
+// parseSpread parses a fragment spread ("...Name").
+// ok is false if s isn't at the start of a spread.
+func (p *Parser) parseSpread(
+	s source,
+) (_ source, sp *SelectionSpread, ok bool) {
+	si := s
+	if s, ok = s.consume("..."); !ok {
+		return si, nil, false
+	}
+	s = s.consumeIgnored()
+	lBeforeName := s.Location
+	var name []byte
+	if s, name = s.consumeName(); name == nil || string(name) == "on" {
+		return si, nil, false
+	}
+	return s, &SelectionSpread{
+		LocRange: LocRange{
+			Location:    si.Location,
+			LocationEnd: locEnd(s),
+		},
+		Name: Name{
+			LocRange: LocRange{
+				Location:    lBeforeName,
+				LocationEnd: locEnd(s),
+			},
+			Name: string(name),
+		},
+	}, true
 }
 
 func (p *Parser) parseInlineFrag(s source) (source, *SelectionInlineFrag) {
@@ -2478,8 +4615,16 @@ func (p *Parser) parseInlineFrag(s source) (source, *SelectionInlineFrag) {
 	}
 
 	s = s.consumeIgnored()
+	if s.peek1('@') {
+		if s, inlineFrag.Directives = p.parseDirectives(
+			s, inlineFrag,
+		); s.stop() {
+			return stop(), nil
+		}
+	}
+
 	var selset SelectionSet
-	if s, selset = p.parseSelectionSet(s); s.stop() {
+	if s, selset = p.parseSelectionSet(s, inlineFrag); s.stop() {
 		return stop(), nil
 	}
 	for _, sel := range selset.Selections {
@@ -2491,7 +4636,55 @@ func (p *Parser) parseInlineFrag(s source) (source, *SelectionInlineFrag) {
 	return s, inlineFrag
 }
 
-func (p *Parser) parseArguments(s source) (source, ArgumentList) {
+// parseDirectives parses the directives of host, which is either
+// a field or an inline fragment.
+func (p *Parser) parseDirectives(
+	s source, host Expression,
+) (source, []*Directive) {
+	var dirs []*Directive
+	for {
+		si := s
+		var ok bool
+		if s, ok = s.consume("@"); !ok {
+			return si, dirs
+		}
+
+		d := &Directive{LocRange: locRange(si.Location), Parent: host}
+		lBeforeName := s.Location
+		var name []byte
+		if s, name = s.consumeName(); name == nil {
+			p.errUnexpTok(s, "expected directive name")
+			return stop(), nil
+		}
+		d.Name = Name{
+			LocRange: LocRange{
+				Location:    lBeforeName,
+				LocationEnd: locEnd(s),
+			},
+			Name: string(name),
+		}
+		d.LocationEnd = locEnd(s)
+
+		s = s.consumeIgnored()
+		if s.peek1('(') {
+			if s, d.ArgumentList = p.parseArguments(s, d); s.stop() {
+				return stop(), nil
+			}
+			for _, arg := range d.Arguments {
+				setParent(arg, d)
+			}
+			d.LocationEnd = d.ArgumentList.LocationEnd
+			s = s.consumeIgnored()
+		}
+		dirs = append(dirs, d)
+	}
+}
+
+// parseArguments parses the argument list of host.
+// Arguments that fail to parse are skipped and host is marked incomplete.
+func (p *Parser) parseArguments(
+	s source, host Expression,
+) (source, ArgumentList) {
 	si := s
 	var ok bool
 	if s, ok = s.consume("("); !ok {
@@ -2502,7 +4695,6 @@ func (p *Parser) parseArguments(s source) (source, ArgumentList) {
 	list := ArgumentList{LocRange: locRange(si.Location)}
 	for {
 		s = s.consumeIgnored()
-		var name []byte
 
 		if s.isEOF() {
 			p.errUnexpTok(s, "expected argument")
@@ -2514,91 +4706,143 @@ func (p *Parser) parseArguments(s source) (source, ArgumentList) {
 			break
 		}
 
-		s = s.consumeIgnored()
+		sBeforeArg := s
+		declared := len(p.vars.declared)
+		var arg *Argument
+		if s, arg = p.parseArgument(s); s.stop() {
+			// Recover by skipping to the next argument
+			p.vars.discard(declared)
+			p.incomplete[host] = struct{}{}
+			s = sBeforeArg.skipUntil(",)")
+		} else {
+			list.Arguments = append(list.Arguments, arg)
+			s = s.consumeIgnored()
+			if !s.peek1(',') && !s.peek1(')') {
+				p.errUnexpTok(s, "expected comma or end of argument list")
+				p.incomplete[host] = struct{}{}
+				s = s.skipUntil(",)")
+			}
+		}
 
-		sBeforeName := s
-		arg := &Argument{LocRange: locRange(s.Location)}
-		if s, name = s.consumeName(); name == nil {
-			p.errUnexpTok(s, "expected argument name")
+		if s.isEOF() {
 			return stop(), ArgumentList{}
 		}
-		arg.Name = Name{
-			LocRange: LocRange{
-				Location:    sBeforeName.Location,
-				LocationEnd: locEnd(s),
-			},
-			Name: string(name),
+		if s, ok = s.consume(","); ok {
+			continue
+		}
+		if s, ok = s.consume(")"); ok {
+			list.LocationEnd = locEnd(s)
+			break
 		}
+		// Reached the end of the enclosing selection set
+		list.LocationEnd = locEnd(s)
+		break
+	}
 
-		s = s.consumeIgnored()
+	return s, list
+}
 
-		if s, ok = s.consume("="); ok {
-			// Has an associated variable name
-			s = s.consumeIgnored()
+func (p *Parser) parseArgument(s source) (source, *Argument) {
+	var ok bool
+	var name []byte
 
-			sBeforeDollar := s
-			if s, ok = s.consume("$"); !ok {
-				p.errUnexpTok(s, "expected variable name")
-				return stop(), ArgumentList{}
-			}
+	sBeforeName := s
+	arg := &Argument{LocRange: locRange(s.Location)}
+	if s, name = s.consumeName(); name == nil {
+		p.errUnexpTok(s, "expected argument name")
+		return stop(), nil
+	}
+	arg.Name = Name{
+		LocRange: LocRange{
+			Location:    sBeforeName.Location,
+			LocationEnd: locEnd(s),
+		},
+		Name: string(name),
+	}
 
-			var name []byte
-			if s, name = s.consumeName(); name == nil {
-				p.errUnexpTok(s, "expected variable name")
-				return stop(), ArgumentList{}
-			}
+	s = s.consumeIgnored()
 
-			def := &VariableDeclaration{
-				LocRange: LocRange{
-					Location:    sBeforeDollar.Location,
-					LocationEnd: locEnd(s),
-				},
-				Parent: arg,
-				Name:   string(name),
-			}
-			arg.AssociatedVariable = def
-			if _, ok := p.varDecls[def.Name]; ok {
-				p.errRedeclVar(def)
-			} else {
-				p.varDecls[def.Name] = def
-			}
-			s = s.consumeIgnored()
-		}
+	if s, ok = s.consume("?"); ok {
+		arg.Optional = true
+		s = s.consumeIgnored()
+	}
 
-		if s, ok = s.consume(":"); !ok {
-			p.errUnexpTok(s, "expected colon")
-			return stop(), ArgumentList{}
-		}
+	declared := len(p.vars.declared)
+	if s, ok = s.consume("="); ok {
+		// Has an associated variable name
 		s = s.consumeIgnored()
-		if s.isEOF() {
-			p.errUnexpTok(s, "expected constraint")
-			return stop(), ArgumentList{}
+
+		sBeforeDollar := s
+		if s, ok = s.consume("$"); !ok {
+			p.errUnexpTok(s, "expected variable name")
+			return stop(), nil
 		}
 
-		var expr Expression
-		if s, expr = p.parseConstrLogicalOr(
-			s, expectConstraint,
-		); s.stop() {
-			return s, ArgumentList{}
+		var name []byte
+		if s, name = s.consumeName(); name == nil {
+			p.errUnexpTok(s, "expected variable name")
+			return stop(), nil
 		}
-		setParent(expr, arg)
-		arg.Constraint = expr
-		arg.LocationEnd = expr.GetLocation().LocationEnd
-		list.Arguments = append(list.Arguments, arg)
-		s = s.consumeIgnored()
 
-		if s, ok = s.consume(","); !ok {
-			if s, ok = s.consume(")"); !ok {
-				p.errUnexpTok(s, "expected comma or end of argument list")
-				return stop(), ArgumentList{}
-			}
-			list.LocationEnd = locEnd(s)
-			break
+		def := &VariableDeclaration{
+			LocRange: LocRange{
+				Location:    sBeforeDollar.Location,
+				LocationEnd: locEnd(s),
+			},
+			Parent: arg,
+			Name:   string(name),
+		}
+		arg.AssociatedVariable = def
+		if !p.vars.declare(def) {
+			p.errRedeclVar(def)
 		}
 		s = s.consumeIgnored()
 	}
 
-	return s, list
+	if s, ok = s.consume(":"); !ok {
+		p.errUnexpTok(s, "expected colon")
+		return stop(), nil
+	}
+	s = s.consumeIgnored()
+	if s.isEOF() {
+		p.errUnexpTok(s, "expected constraint")
+		return stop(), nil
+	}
+
+	if n, ok := s.consumeAbsent(); ok {
+		arg.Absent = true
+		arg.LocationEnd = locEnd(n)
+		p.checkAbsent(arg.LocRange, arg.Optional, arg.AssociatedVariable)
+		// The variable has no value to refer to
+		p.vars.discard(declared)
+		return n.consumeIgnored(), arg
+	}
+
+	var expr Expression
+	if s, expr = p.parseConstrLogicalOr(
+		s, expectConstraint,
+	); s.stop() {
+		return stop(), nil
+	}
+	setParent(expr, arg)
+	arg.Constraint = expr
+	arg.LocationEnd = expr.GetLocation().LocationEnd
+	return s, arg
+}
+
+// checkAbsent reports the markers and variables that can't be
+// combined with an absent argument or object field at l.
+func (p *Parser) checkAbsent(
+	l LocRange, optional bool, v *VariableDeclaration,
+) {
+	if optional {
+		p.newErr(l, "absent value can't be optional")
+	}
+	if v != nil {
+		p.newErr(v.LocRange, fmt.Sprintf(
+			"variable %q can't be associated with an absent value", v.Name,
+		))
+	}
 }
 
 func (p *Parser) parseValue(
@@ -2615,6 +4859,7 @@ func (p *Parser) parseValue(
 	var str []byte
 	var num *Number
 	var ok bool
+	var err Error
 	if s, ok = s.consume("("); ok {
 		e := &ExprParentheses{LocRange: locRange(l)}
 
@@ -2654,12 +4899,15 @@ func (p *Parser) parseValue(
 				Name: string(name),
 			},
 		}
-		p.varRefs = append(p.varRefs, v)
+		p.vars.refs = append(p.vars.refs, v)
 
 		s = s.consumeIgnored()
 
 		return s, v
-	} else if s, str, ok = s.consumeString(); ok {
+	} else if s, str, ok, err = s.consumeString(); ok {
+		if err.IsErr() {
+			p.newErr(err.LocRange, err.Msg)
+		}
 		return s, &String{
 			LocRange: LocRange{
 				Location:    l,
@@ -2685,19 +4933,28 @@ func (p *Parser) parseValue(
 				break
 			}
 
+			sBeforeItem := s
+			declared := len(p.vars.declared)
 			var expr Expression
-			if s, expr = p.parseConstrLogicalOr(s, expectConstraintInArray); s.stop() {
-				return stop(), nil
+			if s, expr = p.parseConstrLogicalOr(
+				s, expectConstraintInArray,
+			); s.stop() {
+				// Recover by skipping to the next item
+				p.vars.discard(declared)
+				s = sBeforeItem.skipUntil(",]")
+			} else {
+				setParent(expr, e)
+				e.Items = append(e.Items, expr)
+				s = s.consumeIgnored()
+				if !s.peek1(',') && !s.peek1(']') {
+					p.errUnexpTok(s, "expected comma or end of array")
+					s = s.skipUntil(",]")
+				}
 			}
-			setParent(expr, e)
-
-			e.Items = append(e.Items, expr)
-
-			s = s.consumeIgnored()
 
 			if s, ok = s.consume(","); !ok {
 				if s, ok = s.consume("]"); !ok {
-					p.errUnexpTok(s, "expected comma or end of array")
+					// Reached the end of the enclosing block
 					return stop(), nil
 				}
 				e.LocationEnd = locEnd(s)
@@ -2709,121 +4966,63 @@ func (p *Parser) parseValue(
 		s = s.consumeIgnored()
 		return s, e
 	} else if s, ok = s.consume("{"); ok {
-		// Object
-		o := &Object{LocRange: locRange(l)}
-		var ok bool
-		fieldNames := map[string]struct{}{}
-
-		for {
-			s = s.consumeIgnored()
-			var name []byte
-
-			if s.isEOF() {
-				p.errUnexpTok(s, "expected object field")
-				return stop(), nil
-			}
-
-			if s, ok = s.consume("}"); ok {
-				o.LocationEnd = locEnd(s)
-				break
-			}
-
-			s = s.consumeIgnored()
-
-			sBeforeName := s
-			fld := &ObjectField{
-				LocRange: locRange(s.Location),
-				Parent:   o,
-			}
-			if s, name = s.consumeName(); name == nil {
-				p.errUnexpTok(s, "expected object field name")
-				return stop(), nil
-			}
-			fld.Name = Name{
-				LocRange: LocRange{
-					Location:    sBeforeName.Location,
-					LocationEnd: locEnd(s),
-				},
-				Name: string(name),
-			}
+		// Object
+		o := &Object{LocRange: locRange(l)}
+		var ok bool
+		fieldNames := map[string]struct{}{}
 
-			if _, ok := fieldNames[fld.Name.Name]; ok {
-				p.newErr(locRange(sBeforeName.Location), "redeclared object field")
+		for {
+			s = s.consumeIgnored()
+			if s.isEOF() {
+				p.errUnexpTok(s, "expected object field")
 				return stop(), nil
 			}
 
-			fieldNames[fld.Name.Name] = struct{}{}
-
-			s = s.consumeIgnored()
+			if s, ok = s.consume("}"); ok {
+				o.LocationEnd = locEnd(s)
+				break
+			}
 
-			if s, ok = s.consume("="); ok {
-				// Has an associated variable name
+			if s, ok = s.consume("..."); ok {
+				// Open object
+				o.Open = true
 				s = s.consumeIgnored()
-
-				sBeforeDollar := s
-				if s, ok = s.consume("$"); !ok {
-					p.errUnexpTok(s, "expected variable name")
-					return stop(), nil
+				if s, ok = s.consume(","); ok {
+					s = s.consumeIgnored()
 				}
-
-				var name []byte
-				if s, name = s.consumeName(); name == nil {
-					p.errUnexpTok(s, "expected variable name")
-					return stop(), nil
-				}
-
-				if expect == expectValueInArray {
-					p.newErr(
-						locRange(sBeforeDollar.Location),
-						"declaration of variables inside "+
-							"arrays is prohibited",
-					)
+				if s, ok = s.consume("}"); !ok {
+					p.errUnexpTok(s, "expected end of object after '...'")
 					return stop(), nil
 				}
+				o.LocationEnd = locEnd(s)
+				break
+			}
 
-				def := &VariableDeclaration{
-					LocRange: LocRange{
-						Location:    sBeforeDollar.Location,
-						LocationEnd: locEnd(s),
-					},
-					Parent: fld,
-					Name:   string(name),
-				}
-				fld.AssociatedVariable = def
-				if _, ok := p.varDecls[def.Name]; ok {
-					p.errRedeclVar(def)
-				} else {
-					p.varDecls[def.Name] = def
-				}
-
+			sBeforeField := s
+			declared := len(p.vars.declared)
+			var fld *ObjectField
+			if s, fld = p.parseObjectField(
+				s, o, expect, fieldNames,
+			); s.stop() {
+				// Recover by skipping to the next field
+				p.vars.discard(declared)
+				p.incomplete[o] = struct{}{}
+				s = sBeforeField.skipUntil(",}")
+			} else {
+				o.Fields = append(o.Fields, fld)
 				s = s.consumeIgnored()
+				if !s.peek1(',') && !s.peek1('}') {
+					p.errUnexpTok(s, "expected comma or end of object")
+					p.incomplete[o] = struct{}{}
+					s = s.skipUntil(",}")
+				}
 			}
 
-			if s, ok = s.consume(":"); !ok {
-				p.errUnexpTok(s, "expected colon")
-				return stop(), nil
-			}
-			s = s.consumeIgnored()
 			if s.isEOF() {
-				p.errUnexpTok(s, "expected constraint")
-				return stop(), nil
-			}
-
-			var expr Expression
-			if s, expr = p.parseConstrLogicalOr(s, expectConstraint); s.stop() {
 				return stop(), nil
 			}
-			setParent(expr, fld)
-			fld.Constraint = expr
-			fld.LocationEnd = expr.GetLocation().LocationEnd
-			o.Fields = append(o.Fields, fld)
-			s = s.consumeIgnored()
-
 			if s, ok = s.consume(","); !ok {
-				if s, ok = s.consume("}"); !ok {
-					p.errUnexpTok(s, "expected comma or end of object")
-					return stop(), nil
-				}
+				s, _ = s.consume("}")
 				o.LocationEnd = locEnd(s)
 				break
 			}
@@ -2870,6 +5069,117 @@ func (p *Parser) parseValue(
 	}
 }
 
+func (p *Parser) parseObjectField(
+	s source,
+	o *Object,
+	expect expect,
+	fieldNames map[string]struct{},
+) (source, *ObjectField) {
+	var ok bool
+	var name []byte
+
+	sBeforeName := s
+	fld := &ObjectField{
+		LocRange: locRange(s.Location),
+		Parent:   o,
+	}
+	if s, name = s.consumeName(); name == nil {
+		p.errUnexpTok(s, "expected object field name")
+		return stop(), nil
+	}
+	fld.Name = Name{
+		LocRange: LocRange{
+			Location:    sBeforeName.Location,
+			LocationEnd: locEnd(s),
+		},
+		Name: string(name),
+	}
+
+	if _, ok := fieldNames[fld.Name.Name]; ok {
+		p.newErr(locRange(sBeforeName.Location), "redeclared object field")
+		return stop(), nil
+	}
+
+	fieldNames[fld.Name.Name] = struct{}{}
+
+	s = s.consumeIgnored()
+
+	if s, ok = s.consume("?"); ok {
+		fld.Optional = true
+		s = s.consumeIgnored()
+	}
+
+	declared := len(p.vars.declared)
+	if s, ok = s.consume("="); ok {
+		// Has an associated variable name
+		s = s.consumeIgnored()
+
+		sBeforeDollar := s
+		if s, ok = s.consume("$"); !ok {
+			p.errUnexpTok(s, "expected variable name")
+			return stop(), nil
+		}
+
+		var name []byte
+		if s, name = s.consumeName(); name == nil {
+			p.errUnexpTok(s, "expected variable name")
+			return stop(), nil
+		}
+
+		if expect == expectValueInArray {
+			p.newErr(
+				locRange(sBeforeDollar.Location),
+				"declaration of variables inside "+
+					"arrays is prohibited",
+			)
+			return stop(), nil
+		}
+
+		def := &VariableDeclaration{
+			LocRange: LocRange{
+				Location:    sBeforeDollar.Location,
+				LocationEnd: locEnd(s),
+			},
+			Parent: fld,
+			Name:   string(name),
+		}
+		fld.AssociatedVariable = def
+		if !p.vars.declare(def) {
+			p.errRedeclVar(def)
+		}
+
+		s = s.consumeIgnored()
+	}
+
+	if s, ok = s.consume(":"); !ok {
+		p.errUnexpTok(s, "expected colon")
+		return stop(), nil
+	}
+	s = s.consumeIgnored()
+	if s.isEOF() {
+		p.errUnexpTok(s, "expected constraint")
+		return stop(), nil
+	}
+
+	if n, ok := s.consumeAbsent(); ok {
+		fld.Absent = true
+		fld.LocationEnd = locEnd(n)
+		p.checkAbsent(fld.LocRange, fld.Optional, fld.AssociatedVariable)
+		// The variable has no value to refer to
+		p.vars.discard(declared)
+		return n.consumeIgnored(), fld
+	}
+
+	var expr Expression
+	if s, expr = p.parseConstrLogicalOr(s, expectConstraint); s.stop() {
+		return stop(), nil
+	}
+	setParent(expr, fld)
+	fld.Constraint = expr
+	fld.LocationEnd = expr.GetLocation().LocationEnd
+	return s, fld
+}
+
 func (p *Parser) parseExprUnary(
 	s source,
 	expect expect,
@@ -2881,7 +5191,7 @@ func (p *Parser) parseExprUnary(
 
 		s = s.consumeIgnored()
 
-		if s, e.Expression = p.parseValue(s, expect); s.stop() {
+		if s, e.Expression = p.parseOperand(s, expect); s.stop() {
 			return stop(), nil
 		}
 		setParent(e.Expression, e)
@@ -2902,7 +5212,7 @@ func (p *Parser) parseExprUnary(
 		s = s.consumeIgnored()
 
 		var expr Expression
-		if s, expr = p.parseValue(s, expect); s.stop() {
+		if s, expr = p.parseOperand(s, expect); s.stop() {
 			return stop(), nil
 		}
 
@@ -2916,7 +5226,90 @@ func (p *Parser) parseExprUnary(
 		s = s.consumeIgnored()
 		return s, e
 	}
-	return p.parseValue(s, expect)
+	return p.parseOperand(s, expect)
+}
+
+// parseOperand parses either a function call or a value.
+func (p *Parser) parseOperand(
+	s source,
+	expect expect,
+) (source, Expression) {
+	si := s
+	s, name := s.consumeName()
+	if name == nil || !s.peek1('(') {
+		return p.parseValue(si, expect)
+	}
+	e := &ExprCall{
+		LocRange: locRange(si.Location),
+		Function: Name{
+			LocRange: LocRange{
+				Location:    si.Location,
+				LocationEnd: locEnd(s),
+			},
+			Name: string(name),
+		},
+	}
+	s, _ = s.consume("(")
+	s = s.consumeIgnored()
+
+	for {
+		var ok bool
+		if s, ok = s.consume(")"); ok {
+			break
+		}
+		sBeforeArg := s
+		var expr Expression
+		if s, expr = p.parseExprLogicalOr(s, expectValue); s.stop() {
+			// Recover by skipping to the next argument
+			s = sBeforeArg.skipUntil(",)")
+		} else {
+			setParent(expr, e)
+			e.Arguments = append(e.Arguments, expr)
+			s = s.consumeIgnored()
+			if !s.peek1(',') && !s.peek1(')') {
+				p.errUnexpTok(s, "expected comma or end of argument list")
+				s = s.skipUntil(",)")
+			}
+		}
+		if s, ok = s.consume(","); ok {
+			s = s.consumeIgnored()
+		} else if !s.peek1(')') {
+			// Reached the end of the enclosing block
+			return stop(), nil
+		}
+	}
+	e.LocationEnd = locEnd(s)
+	e.Float = builtinIsFloat(e.Function.Name, e.Arguments)
+
+	s = s.consumeIgnored()
+	return s, e
+}
+
+// builtinArity returns the minimum and maximum number of arguments
+// of built-in function name. max is 0 if the function is variadic.
+// defined is false if there's no such built-in function.
+func builtinArity(name string) (min, max int, defined bool) {
+	switch name {
+	case "min", "max":
+		return 1, 0, true
+	case "abs", "ceil", "floor", "len":
+		return 1, 1, true
+	}
+	return 0, 0, false
+}
+
+// builtinIsFloat returns true if built-in function name
+// returns a float when called with args.
+func builtinIsFloat(name string, args []Expression) bool {
+	switch name {
+	case "min", "max", "abs":
+		for _, a := range args {
+			if a.IsFloat() {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 func (p *Parser) parseExprMultiplicative(
@@ -3334,6 +5727,48 @@ func (p *Parser) parseConstr(
 	var ok bool
 	var expr Expression
 
+	if n, ok := s.consumeKeyword("in"); ok {
+		return p.parseConstrSet(si, n, false)
+	} else if n, ok := s.consumeKeyword("not"); ok {
+		if n, ok = n.consumeIgnored().consumeKeyword("in"); ok {
+			return p.parseConstrSet(si, n, true)
+		}
+	} else if n, ok := s.consumeKeyword("unique"); ok {
+		e := &ConstrUnique{
+			LocRange: LocRange{
+				Location:    si.Location,
+				LocationEnd: locEnd(n),
+			},
+		}
+		return n.consumeIgnored(), e
+	} else if n, ok := s.consumeKeyword("contains"); ok {
+		e := &ConstrContains{LocRange: locRange(si.Location)}
+		n = n.consumeIgnored()
+
+		if n, e.Value = p.parseExprEquality(n, expectValue); n.stop() {
+			return stop(), nil
+		}
+		setParent(e.Value, e)
+		e.LocationEnd = e.Value.GetLocation().LocationEnd
+
+		return n.consumeIgnored(), e
+	} else if n, ok := s.consumeKeyword("sum"); ok {
+		e := &ConstrSum{LocRange: locRange(si.Location)}
+		n = n.consumeIgnored()
+
+		if n, e.Constraint = p.parseConstr(n, expect); n.stop() {
+			return stop(), nil
+		}
+		setParent(e.Constraint, e)
+		e.LocationEnd = e.Constraint.GetLocation().LocationEnd
+
+		return n, e
+	} else if n, ok := s.consumeKeyword("count"); ok {
+		return p.parseConstrCount(si, n.consumeIgnored(), expect)
+	} else if n, ok := s.consumeKeyword("is"); ok {
+		return p.parseConstrAlias(si, n.consumeIgnored(), expect)
+	}
+
 	if s, ok = s.consume("("); ok {
 		e := &ExprParentheses{LocRange: locRange(si.Location)}
 
@@ -3462,6 +5897,23 @@ func (p *Parser) parseConstr(
 
 		s = s.consumeIgnored()
 
+		return s, e
+	} else if s, ok = s.consume("~"); ok {
+		e := &ConstrMatches{
+			LocRange: locRange(si.Location),
+			Value:    expr,
+		}
+		s = s.consumeIgnored()
+
+		if s, expr = p.parseExprEquality(s, expectValue); s.stop() {
+			return stop(), nil
+		}
+		setParent(expr, e)
+		e.Value = expr
+		e.LocationEnd = expr.GetLocation().LocationEnd
+
+		s = s.consumeIgnored()
+
 		return s, e
 	} else if s, ok = s.consume("len"); ok {
 		s = s.consumeIgnored()
@@ -3709,6 +6161,10 @@ LOOP:
 type Error struct {
 	LocRange
 	Msg string
+
+	// File is the name of the imported file the error occurred in
+	// or empty if it occurred in the parsed template itself.
+	File string
 }
 
 func (e Error) IsErr() bool {
@@ -3719,6 +6175,9 @@ func (e Error) Error() string {
 	if !e.IsErr() {
 		return ""
 	}
+	if e.File != "" {
+		return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Column, e.Msg)
+	}
 	return fmt.Sprintf("%d:%d: %s", e.Line, e.Column, e.Msg)
 }
 
@@ -3738,14 +6197,20 @@ func (p *Parser) isNumeric(e Expression) bool {
 		*ConstrGreaterOrEqual, *ConstrLessOrEqual,
 		*ExprAddition, *ExprSubtraction,
 		*ExprMultiplication, *ExprDivision, *ExprModulo,
-		*ExprNumericNegation:
+		*ExprNumericNegation, *ExprCall:
 		return true
 	case *ConstrEquals:
 		return p.isNumeric(e.Value)
 	case *ConstrNotEquals:
 		return p.isNumeric(e.Value)
+	case *ConstrIn:
+		return allOf(e.Values, p.isNumeric)
+	case *ConstrNotIn:
+		return allOf(e.Values, p.isNumeric)
 	case *ExprParentheses:
 		return p.isNumeric(e.Expression)
+	case *ConstrAlias:
+		return p.isNumeric(e.Constraint)
 	}
 	return false
 }
@@ -3767,8 +6232,14 @@ func (p *Parser) isBoolean(e Expression) bool {
 		return p.isBoolean(e.Value)
 	case *ConstrNotEquals:
 		return p.isBoolean(e.Value)
+	case *ConstrIn:
+		return allOf(e.Values, p.isBoolean)
+	case *ConstrNotIn:
+		return allOf(e.Values, p.isBoolean)
 	case *ExprParentheses:
 		return p.isBoolean(e.Expression)
+	case *ConstrAlias:
+		return p.isBoolean(e.Constraint)
 	}
 	return false
 }
@@ -3791,6 +6262,8 @@ func (p *Parser) isAny(e Expression) bool {
 		return p.isAny(e.Value)
 	case *ExprParentheses:
 		return p.isAny(e.Expression)
+	case *ConstrAlias:
+		return p.isAny(e.Constraint)
 	}
 	return false
 }
@@ -3809,14 +6282,21 @@ func (p *Parser) isString(e Expression) bool {
 		*String,
 		*ConstrLenGreater, *ConstrLenLess,
 		*ConstrLenGreaterOrEqual, *ConstrLenLessOrEqual,
-		*ConstrLenEquals, *ConstrLenNotEquals:
+		*ConstrLenEquals, *ConstrLenNotEquals,
+		*ConstrMatches:
 		return true
 	case *ConstrEquals:
 		return p.isString(e.Value)
 	case *ConstrNotEquals:
 		return p.isString(e.Value)
+	case *ConstrIn:
+		return allOf(e.Values, p.isString)
+	case *ConstrNotIn:
+		return allOf(e.Values, p.isString)
 	case *ExprParentheses:
 		return p.isString(e.Expression)
+	case *ConstrAlias:
+		return p.isString(e.Constraint)
 	}
 	return false
 }
@@ -3837,8 +6317,14 @@ func (p *Parser) isEnum(e Expression) bool {
 		return p.isEnum(e.Value)
 	case *ConstrNotEquals:
 		return p.isEnum(e.Value)
+	case *ConstrIn:
+		return allOf(e.Values, p.isEnum)
+	case *ConstrNotIn:
+		return allOf(e.Values, p.isEnum)
 	case *ExprParentheses:
 		return p.isEnum(e.Expression)
+	case *ConstrAlias:
+		return p.isEnum(e.Constraint)
 	}
 	return false
 }
@@ -3858,8 +6344,14 @@ func (p *Parser) isNull(e Expression) bool {
 		return p.isNull(e.Value)
 	case *ConstrNotEquals:
 		return p.isNull(e.Value)
+	case *ConstrIn:
+		return anyOf(e.Values, p.isNull)
+	case *ConstrNotIn:
+		return anyOf(e.Values, p.isNull)
 	case *ExprParentheses:
 		return p.isNull(e.Expression)
+	case *ConstrAlias:
+		return p.isNull(e.Constraint)
 	}
 	return false
 }
@@ -3877,14 +6369,41 @@ func (p *Parser) isArray(e Expression) bool {
 		*Array,
 		*ConstrLenGreater, *ConstrLenLess,
 		*ConstrLenGreaterOrEqual, *ConstrLenLessOrEqual,
-		*ConstrLenEquals, *ConstrLenNotEquals:
+		*ConstrLenEquals, *ConstrLenNotEquals,
+		*ConstrUnique, *ConstrContains, *ConstrSum, *ConstrCount:
 		return true
 	case *ConstrEquals:
 		return p.isArray(e.Value)
 	case *ConstrNotEquals:
 		return p.isArray(e.Value)
+	case *ConstrIn:
+		return allOf(e.Values, p.isArray)
+	case *ConstrNotIn:
+		return allOf(e.Values, p.isArray)
 	case *ExprParentheses:
 		return p.isArray(e.Expression)
+	case *ConstrAlias:
+		return p.isArray(e.Constraint)
+	}
+	return false
+}
+
+// allOf returns true if is returns true for all of l.
+func allOf(l []Expression, is func(Expression) bool) bool {
+	for _, e := range l {
+		if !is(e) {
+			return false
+		}
+	}
+	return len(l) > 0
+}
+
+// anyOf returns true if is returns true for any of l.
+func anyOf(l []Expression, is func(Expression) bool) bool {
+	for _, e := range l {
+		if is(e) {
+			return true
+		}
 	}
 	return false
 }
@@ -3997,7 +6516,15 @@ func (p *Parser) assumeComparableValue(e Expression) (ok bool) {
 		*ConstrLenLessOrEqual,
 		*ConstrLenGreater,
 		*ConstrLenGreaterOrEqual,
-		*ConstrMap:
+		*ConstrMatches,
+		*ConstrIn,
+		*ConstrNotIn,
+		*ConstrUnique,
+		*ConstrContains,
+		*ConstrSum,
+		*ConstrCount,
+		*ConstrMap,
+		*ConstrAlias:
 		p.newErr(e.GetLocation(), "unexpected constraint in value definition")
 		return false
 	case *ConstrEquals:
@@ -4085,6 +6612,20 @@ func setParent(t, parent Expression) {
 		v.Parent = parent
 	case *ConstrLenGreaterOrEqual:
 		v.Parent = parent
+	case *ConstrMatches:
+		v.Parent = parent
+	case *ConstrIn:
+		v.Parent = parent
+	case *ConstrNotIn:
+		v.Parent = parent
+	case *ConstrUnique:
+		v.Parent = parent
+	case *ConstrContains:
+		v.Parent = parent
+	case *ConstrSum:
+		v.Parent = parent
+	case *ConstrCount:
+		v.Parent = parent
 	case *ExprLogicalAnd:
 		v.Parent = parent
 	case *ExprLogicalOr:
@@ -4093,16 +6634,34 @@ func setParent(t, parent Expression) {
 		v.Parent = parent
 	case *ExprNumericNegation:
 		v.Parent = parent
+	case *ExprCall:
+		v.Parent = parent
 	case *SelectionInlineFrag:
 		v.Parent = parent
 	case *SelectionField:
 		v.Parent = parent
 	case *Argument:
 		v.Parent = parent
+	case *Directive:
+		v.Parent = parent
+	case *ObjectField:
+		v.Parent = parent
 	case *SelectionMax:
 		v.Parent = parent
+	case *SelectionMin:
+		v.Parent = parent
+	case *SelectionExactly:
+		v.Parent = parent
+	case *SelectionRecurse:
+		v.Parent = parent
+	case *SelectionSpread:
+		v.Parent = parent
+	case *FragmentDefinition:
+		v.Parent = parent
 	case *ConstrMap:
 		v.Parent = parent
+	case *ConstrAlias:
+		v.Parent = parent
 	case *ConstrAny:
 		v.Parent = parent
 	default:
@@ -4178,6 +6737,20 @@ func setLocRange(t Expression, l LocRange) {
 		v.LocRange = l
 	case *ConstrLenGreaterOrEqual:
 		v.LocRange = l
+	case *ConstrMatches:
+		v.LocRange = l
+	case *ConstrIn:
+		v.LocRange = l
+	case *ConstrNotIn:
+		v.LocRange = l
+	case *ConstrUnique:
+		v.LocRange = l
+	case *ConstrContains:
+		v.LocRange = l
+	case *ConstrSum:
+		v.LocRange = l
+	case *ConstrCount:
+		v.LocRange = l
 	case *ExprLogicalAnd:
 		v.LocRange = l
 	case *ExprLogicalOr:
@@ -4186,16 +6759,32 @@ func setLocRange(t Expression, l LocRange) {
 		v.LocRange = l
 	case *ExprNumericNegation:
 		v.LocRange = l
+	case *ExprCall:
+		v.LocRange = l
 	case *SelectionInlineFrag:
 		v.LocRange = l
 	case *SelectionField:
 		v.LocRange = l
 	case *Argument:
 		v.LocRange = l
+	case *Directive:
+		v.LocRange = l
 	case *SelectionMax:
 		v.LocRange = l
+	case *SelectionMin:
+		v.LocRange = l
+	case *SelectionExactly:
+		v.LocRange = l
+	case *SelectionRecurse:
+		v.LocRange = l
+	case *SelectionSpread:
+		v.LocRange = l
+	case *FragmentDefinition:
+		v.LocRange = l
 	case *ConstrMap:
 		v.LocRange = l
+	case *ConstrAlias:
+		v.LocRange = l
 	case *ConstrAny:
 		v.LocRange = l
 	default:
@@ -4203,132 +6792,203 @@ func setLocRange(t Expression, l LocRange) {
 	}
 }
 
-func (p *Parser) validateInlineFrag(
-	frag *SelectionInlineFrag,
+func (p *Parser) validateInlineFrag(
+	frag *SelectionInlineFrag,
+	hostDef *ast.Definition,
+) (ok bool) {
+	if p.schema == nil {
+		if !p.validateCond(frag.TypeCondition, frag.Parent) {
+			return false
+		}
+		return p.validateSelSet(frag, nil)
+	}
+
+	def := p.condDef(frag.TypeCondition)
+	if def == nil || !p.validateCondOn(frag.TypeCondition, def, hostDef) {
+		return false
+	}
+	return p.validateSelSet(frag, def)
+}
+
+// validateFragments validates the type conditions and the selection sets
+// of the fragment definitions of o. The spreads of fragments with invalid
+// type conditions aren't validated.
+func (p *Parser) validateFragments(o *Operation) (ok bool) {
+	ok = true
+	for _, d := range o.Fragments {
+		var valid bool
+		p.inFile(d.File, func() {
+			if p.schema == nil {
+				valid = p.validateCond(d.TypeCondition, nil)
+			} else {
+				valid = p.condDef(d.TypeCondition) != nil
+			}
+		})
+		if !valid {
+			ok = false
+			p.invalidFrags[d] = struct{}{}
+		}
+	}
+	for _, d := range o.Fragments {
+		if _, invalid := p.invalidFrags[d]; invalid {
+			continue
+		}
+		p.inFile(d.File, func() {
+			if !p.validateSelSet(d, d.TypeCondition.TypeDef) {
+				ok = false
+			}
+		})
+	}
+	return ok
+}
+
+// validateSpread validates the type condition of the fragment of
+// spread s inside a selection set of type hostDef.
+func (p *Parser) validateSpread(
+	s *SelectionSpread,
 	hostDef *ast.Definition,
 ) (ok bool) {
-	if p.schema == nil {
-		if !p.validateCond(frag) {
-			return false
+	d := s.Fragment
+	if d == nil {
+		return false
+	}
+	if _, invalid := p.invalidFrags[d]; invalid {
+		return false
+	}
+	p.inFile(d.File, func() {
+		if p.schema == nil {
+			ok = p.validateCond(d.TypeCondition, s.Parent)
+		} else {
+			ok = p.validateCondOn(
+				d.TypeCondition, d.TypeCondition.TypeDef, hostDef,
+			)
 		}
-		return p.validateSelSet(frag, nil)
+	})
+	if !ok {
+		p.newErr(s.LocRange, fmt.Sprintf(
+			"invalid spread of fragment %q", s.Name.Name,
+		))
 	}
+	return ok
+}
 
-	def := p.schema.Types[frag.TypeCondition.TypeName]
+// condDef returns the definition of the type cond refers to.
+// Returns nil if the type is undefined or can't be conditioned on.
+func (p *Parser) condDef(cond TypeCondition) *ast.Definition {
+	def := p.schema.Types[cond.TypeName]
 	if def == nil {
-		p.errUndefType(
-			frag.TypeCondition.LocRange,
-			frag.TypeCondition.TypeName,
-		)
-		return false
+		p.errUndefType(cond.LocRange, cond.TypeName)
+		return nil
 	}
 
 	switch def.Kind {
 	case ast.Scalar:
-		p.errCondOnScalarType(frag)
-		return false
+		p.errCondOnScalarType(cond)
+		return nil
 	case ast.Enum:
-		p.errCondOnEnumType(frag)
-		return false
+		p.errCondOnEnumType(cond)
+		return nil
 	case ast.InputObject:
-		p.errCondOnInputType(frag)
-		return false
+		p.errCondOnInputType(cond)
+		return nil
+	}
+	return def
+}
+
+// validateCondOn returns true if a fragment conditioned on type def
+// can be used inside a selection set of type hostDef.
+func (p *Parser) validateCondOn(
+	cond TypeCondition,
+	def, hostDef *ast.Definition,
+) bool {
+	switch def.Kind {
 	case ast.Object:
 		if hostDef != nil && hostDef == def {
-			return p.validateSelSet(frag, def)
+			return true
 		}
 		for _, c := range def.Interfaces {
 			if c == hostDef.Name {
 				// condition Object implements the host interface
-				return p.validateSelSet(frag, def)
+				return true
 			}
 		}
 		for _, c := range hostDef.Types {
 			if c == def.Name {
 				// condition Object is part of the host union
-				return p.validateSelSet(frag, def)
+				return true
 			}
 		}
 	case ast.Interface:
 		if hostDef == def {
-			return p.validateSelSet(frag, def)
+			return true
 		}
 		impls := p.schema.GetPossibleTypes(def)
 		possibleTypes := p.schema.GetPossibleTypes(hostDef)
 		for _, t := range impls {
 			for _, ht := range possibleTypes {
 				if ht == t {
-					return p.validateSelSet(frag, def)
+					return true
 				}
 			}
 		}
 	case ast.Union:
 		if hostDef == def {
-			return p.validateSelSet(frag, def)
+			return true
 		}
 		impls := p.schema.GetPossibleTypes(def)
 		possibleTypes := p.schema.GetPossibleTypes(hostDef)
 		for _, t := range impls {
 			for _, ht := range possibleTypes {
 				if ht == t {
-					return p.validateSelSet(frag, def)
+					return true
 				}
 			}
 		}
 	}
-	p.errCanNeverBeOfType(
-		frag.TypeCondition.Location, hostDef.Name, def.Name,
-	)
+	p.errCanNeverBeOfType(cond.Location, hostDef.Name, def.Name)
 	return false
 }
 
-func (p *Parser) validateCond(frag *SelectionInlineFrag) (ok bool) {
-	ok = true
-	switch frag.TypeCondition.TypeName {
+// validateCond validates type condition cond in schemaless mode
+// where parent is the parent of the fragment.
+func (p *Parser) validateCond(cond TypeCondition, parent Expression) bool {
+	switch cond.TypeName {
 	case "String", "ID", "Boolean", "Int", "Float":
-		p.errCondOnScalarType(frag)
+		p.errCondOnScalarType(cond)
 		return false
 	case "Mutation", "Query", "Subscription":
-		for par := frag.Parent; par != nil; par = par.GetParent() {
+		for par := parent; par != nil; par = par.GetParent() {
 			switch par := par.(type) {
 			case *Operation:
-				if par.Type.String() != frag.TypeCondition.TypeName {
+				if par.Type.String() != cond.TypeName {
 					p.errCanNeverBeOfType(
-						frag.TypeCondition.Location,
+						cond.Location,
 						par.Type.String(),
-						frag.TypeCondition.TypeName,
+						cond.TypeName,
 					)
 					return false
 				}
-			case *SelectionInlineFrag:
-				if par.TypeCondition.TypeName != frag.TypeCondition.TypeName {
-					p.errCanNeverBeOfType(
-						frag.TypeCondition.Location,
-						par.TypeCondition.TypeName,
-						frag.TypeCondition.TypeName,
-					)
+			case *SelectionInlineFrag, *FragmentDefinition:
+				if t := fragTypeName(par); t != cond.TypeName {
+					p.errCanNeverBeOfType(cond.Location, t, cond.TypeName)
 					return false
 				}
 			}
 		}
 	default:
-		for par := frag.Parent; par != nil; par = par.GetParent() {
+		for par := parent; par != nil; par = par.GetParent() {
 			switch par := par.(type) {
 			case *Operation:
 				p.errCanNeverBeOfType(
-					frag.TypeCondition.Location,
+					cond.Location,
 					par.Type.String(),
-					frag.TypeCondition.TypeName,
+					cond.TypeName,
 				)
 				return false
-			case *SelectionInlineFrag:
-				switch par.TypeCondition.TypeName {
+			case *SelectionInlineFrag, *FragmentDefinition:
+				switch t := fragTypeName(par); t {
 				case "Query", "Mutation", "Subscription":
-					p.errCanNeverBeOfType(
-						frag.TypeCondition.Location,
-						par.TypeCondition.TypeName,
-						frag.TypeCondition.TypeName,
-					)
+					p.errCanNeverBeOfType(cond.Location, t, cond.TypeName)
 					return false
 				default:
 					return true
@@ -4338,30 +6998,39 @@ func (p *Parser) validateCond(frag *SelectionInlineFrag) (ok bool) {
 			}
 		}
 	}
-	return ok
+	return true
+}
+
+// fragTypeName returns the name of the type condition of
+// inline fragment or fragment definition e.
+func fragTypeName(e Expression) string {
+	switch e := e.(type) {
+	case *SelectionInlineFrag:
+		return e.TypeCondition.TypeName
+	case *FragmentDefinition:
+		return e.TypeCondition.TypeName
+	}
+	return ""
 }
 
-func (p *Parser) errCondOnScalarType(s *SelectionInlineFrag) {
-	p.errors = append(p.errors, Error{
-		LocRange: s.TypeCondition.LocRange,
-		Msg: "fragment can't condition on scalar type " +
-			s.TypeCondition.TypeName,
+func (p *Parser) errCondOnScalarType(c TypeCondition) {
+	p.addErr(Error{
+		LocRange: c.LocRange,
+		Msg:      "fragment can't condition on scalar type " + c.TypeName,
 	})
 }
 
-func (p *Parser) errCondOnEnumType(s *SelectionInlineFrag) {
-	p.errors = append(p.errors, Error{
-		LocRange: s.TypeCondition.LocRange,
-		Msg: "fragment can't condition on enum type " +
-			s.TypeCondition.TypeName,
+func (p *Parser) errCondOnEnumType(c TypeCondition) {
+	p.addErr(Error{
+		LocRange: c.LocRange,
+		Msg:      "fragment can't condition on enum type " + c.TypeName,
 	})
 }
 
-func (p *Parser) errCondOnInputType(s *SelectionInlineFrag) {
-	p.errors = append(p.errors, Error{
-		LocRange: s.TypeCondition.LocRange,
-		Msg: "fragment can't condition on input type " +
-			s.TypeCondition.TypeName,
+func (p *Parser) errCondOnInputType(c TypeCondition) {
+	p.addErr(Error{
+		LocRange: c.LocRange,
+		Msg:      "fragment can't condition on input type " + c.TypeName,
 	})
 }
 
@@ -4370,7 +7039,7 @@ func (p *Parser) errCanNeverBeOfType(
 	thisType,
 	cantBeOf string,
 ) {
-	p.errors = append(p.errors, Error{
+	p.addErr(Error{
 		LocRange: locRange(l),
 		Msg: "type " + thisType +
 			" can never be of type " + cantBeOf,
@@ -4421,10 +7090,96 @@ func (p *Parser) errCantApplyConstrLen(c Expression, expect *ast.Type) {
 		"it can't be applied to type "+expect.String())
 }
 
+func (p *Parser) errCantApplyConstrMatches(
+	c *ConstrMatches, expect *ast.Type,
+) {
+	p.newErr(c.GetLocation(), "pattern constraint '~' "+
+		"(matches regular expression) "+
+		"only supports type String and type ID, "+
+		"it can't be applied to type "+expect.String())
+}
+
+func (p *Parser) errExpectedPattern(actual Expression) {
+	td := actual.TypeDesignation()
+	p.addErr(Error{
+		LocRange: actual.GetLocation(),
+		Msg:      "expected string pattern but received " + td,
+	})
+}
+
+func (p *Parser) errFuncArgCount(e *ExprCall, min, max int) {
+	var expected string
+	switch {
+	case max < 1:
+		expected = fmt.Sprintf("at least %d", min)
+	case min == max:
+		expected = strconv.Itoa(min)
+	default:
+		expected = fmt.Sprintf("%d to %d", min, max)
+	}
+	s := "s"
+	if min == 1 && max <= 1 {
+		s = ""
+	}
+	p.newErr(e.LocRange, fmt.Sprintf(
+		"function %s expects %s argument%s but received %d",
+		e.Function.Name, expected, s, len(e.Arguments),
+	))
+}
+
+func (p *Parser) errExpectedStringOrArray(actual Expression) {
+	td := actual.TypeDesignation()
+	p.addErr(Error{
+		LocRange: actual.GetLocation(),
+		Msg:      "expected String or array but received " + td,
+	})
+}
+
+func (p *Parser) errExpectedSetValue(actual Expression) {
+	td := actual.TypeDesignation()
+	p.addErr(Error{
+		LocRange: actual.GetLocation(),
+		Msg:      "expected constant scalar value in set but received " + td,
+	})
+}
+
+func (p *Parser) errInvalidPattern(pattern *String, err error) {
+	msg := err.Error()
+	if err, ok := err.(*syntax.Error); ok {
+		msg = string(err.Code) + ": `" + err.Expr + "`"
+	}
+	p.addErr(Error{
+		LocRange: pattern.LocRange,
+		Msg:      "invalid regular expression: " + msg,
+	})
+}
+
+func (p *Parser) errCantApplyConstrCollection(
+	c Expression, expect *ast.Type,
+) {
+	var designation, description string
+	switch c.(type) {
+	case *ConstrUnique:
+		designation, description = "'unique'", "unique items"
+	case *ConstrContains:
+		designation, description = "'contains'", "contains item"
+	case *ConstrSum:
+		designation, description = "'sum'", "sum of items"
+	case *ConstrCount:
+		designation, description = "'count where'", "number of matching items"
+	default:
+		panic(fmt.Errorf("unhandled constraint type: %T", c))
+	}
+	p.newErr(c.GetLocation(), "collection constraint "+
+		designation+" ("+description+") "+
+		"only supports arrays, "+
+		"it can't be applied to type "+expect.String())
+}
+
 func (p *Parser) errMissingArg(
 	l LocRange, missingArgument *ast.ArgumentDefinition,
 ) {
-	p.errors = append(p.errors, Error{
+	p.addErr(Error{
 		LocRange: l,
 		Msg: fmt.Sprintf(
 			"argument %q of type %s is required but missing",
@@ -4437,7 +7192,7 @@ func (p *Parser) errMissingInputField(
 	l LocRange,
 	missingField *ast.FieldDefinition,
 ) {
-	p.errors = append(p.errors, Error{
+	p.addErr(Error{
 		LocRange: l,
 		Msg: fmt.Sprintf(
 			"field %q of type %q is required but missing",
@@ -4449,7 +7204,7 @@ func (p *Parser) errMissingInputField(
 func (p *Parser) errMissingSelSet(
 	l LocRange, fieldName, hostTypeName string,
 ) {
-	p.errors = append(p.errors, Error{
+	p.addErr(Error{
 		LocRange: l,
 		Msg: fmt.Sprintf(
 			"missing selection set for field %q of type %s",
@@ -4460,29 +7215,58 @@ func (p *Parser) errMissingSelSet(
 
 func (p *Parser) errExpectedNum(actual Expression) {
 	td := actual.TypeDesignation()
-	p.errors = append(p.errors, Error{
+	p.addErr(Error{
 		LocRange: actual.GetLocation(),
 		Msg:      "expected number but received " + td,
 	})
 }
 
+func (p *Parser) errRequiredArg(a *Argument, def *ast.ArgumentDefinition) {
+	p.addErr(Error{
+		LocRange: a.LocRange,
+		Msg: fmt.Sprintf(
+			"argument %q of type %s is required and can't be %s",
+			def.Name, def.Type, markerDesignation(a.Absent),
+		),
+	})
+}
+
+func (p *Parser) errRequiredInputField(
+	f *ObjectField, def *ast.FieldDefinition,
+) {
+	p.addErr(Error{
+		LocRange: f.LocRange,
+		Msg: fmt.Sprintf(
+			"field %q of type %q is required and can't be %s",
+			def.Name, def.Type, markerDesignation(f.Absent),
+		),
+	})
+}
+
+func markerDesignation(absent bool) string {
+	if absent {
+		return "absent"
+	}
+	return "optional"
+}
+
 func (p *Parser) errExpectedBool(actual Expression) {
 	td := actual.TypeDesignation()
-	p.errors = append(p.errors, Error{
+	p.addErr(Error{
 		LocRange: actual.GetLocation(),
 		Msg:      "expected type Boolean but received " + td,
 	})
 }
 
 func (p *Parser) errExpectedNumGotNull(l LocRange) {
-	p.errors = append(p.errors, Error{
+	p.addErr(Error{
 		LocRange: l,
 		Msg:      "expected number but received null",
 	})
 }
 
 func (p *Parser) errExpectedBoolGotNull(l LocRange) {
-	p.errors = append(p.errors, Error{
+	p.addErr(Error{
 		LocRange: l,
 		Msg:      "expected type Boolean but received null",
 	})
@@ -4492,7 +7276,7 @@ func (p *Parser) errUnexpType(
 	expected *ast.Type,
 	actual Expression,
 ) {
-	p.errors = append(p.errors, Error{
+	p.addErr(Error{
 		LocRange: actual.GetLocation(),
 		Msg: "expected type " +
 			expected.String() +
@@ -4506,7 +7290,7 @@ func (p *Parser) errUnexpNull(
 	expected *ast.Type,
 	null Expression,
 ) {
-	p.errors = append(p.errors, Error{
+	p.addErr(Error{
 		LocRange: l,
 		Msg: "expected type " +
 			expected.String() +
@@ -4516,7 +7300,7 @@ func (p *Parser) errUnexpNull(
 }
 
 func (p *Parser) errUndefEnumVal(e *Enum) {
-	p.errors = append(p.errors, Error{
+	p.addErr(Error{
 		LocRange: e.LocRange,
 		Msg:      fmt.Sprintf("undefined enum value %q", e.Value),
 	})
@@ -4525,7 +7309,7 @@ func (p *Parser) errUndefEnumVal(e *Enum) {
 func (p *Parser) errUndefFieldInType(
 	l LocRange, fieldName, typeName string,
 ) {
-	p.errors = append(p.errors, Error{
+	p.addErr(Error{
 		LocRange: l,
 		Msg: fmt.Sprintf(
 			"field %q is undefined in type %s",
@@ -4665,7 +7449,7 @@ func makeAppend[T any](a []T, b ...T) (new []T) {
 // and pushes an error onto the error stack.
 func (p *Parser) checkObjectVarRefs(o *Object) (ok bool) {
 	return traverse(o, func(e Expression) bool {
-		if e, ok := e.(*Variable); ok {
+		if e, ok := e.(*Variable); ok && e.Declaration != nil {
 			for pr := e.Parent; pr != nil; pr = pr.GetParent() {
 				switch pv := pr.(type) {
 				case *Argument:
@@ -4722,6 +7506,39 @@ func typeDesignationLen(e Expression) string {
 	return "String|array"
 }
 
+func typeDesignationCollection(e Expression) string {
+	switch p := e.GetParent().(type) {
+	case *Argument:
+		if p.Def != nil {
+			return p.Def.Type.String()
+		}
+	case *ObjectField:
+		if p.Def != nil {
+			return p.Def.Type.String()
+		}
+	}
+	return "array"
+}
+
+func typeDesignationSet(e Expression, values []Expression) string {
+	switch p := e.GetParent().(type) {
+	case *Argument:
+		if p.Def != nil {
+			return p.Def.Type.String()
+		}
+	case *ObjectField:
+		if p.Def != nil {
+			return p.Def.Type.String()
+		}
+	}
+	for _, v := range values {
+		if d := v.TypeDesignation(); d != "null" {
+			return d
+		}
+	}
+	return "null"
+}
+
 func typeDesignationRelational(e Expression) string {
 	switch p := e.GetParent().(type) {
 	case *Argument:
@@ -4751,6 +7568,8 @@ func find[T any](e Expression) (T, bool) {
 		return find[T](e.Value)
 	case *ExprParentheses:
 		return find[T](e.Expression)
+	case *ConstrAlias:
+		return find[T](e.Constraint)
 	case *ExprLogicalAnd:
 		for _, i := range e.Expressions {
 			if t, ok := find[T](i); ok {
@@ -4793,6 +7612,33 @@ func areTypesCompatible(a, b *ast.Type) bool {
 	return true
 }
 
+// UTF16 returns the start and end of l in src as UTF-16 positions.
+// src must be the source l refers to. end equals start if l has no end.
+func (l LocRange) UTF16(src []byte) (start, end UTF16Position) {
+	start = utf16Position(src, l.Index, l.Line)
+	if l.LineEnd == 0 {
+		return start, start
+	}
+	return start, utf16Position(src, l.IndexEnd, l.LineEnd)
+}
+
+func utf16Position(src []byte, index, line int) UTF16Position {
+	if index > len(src) {
+		index = len(src)
+	}
+	p := UTF16Position{Line: line - 1}
+	lineStart := bytes.LastIndexByte(src[:index], '\n') + 1
+	for _, r := range string(src[lineStart:index]) {
+		if r >= 0x10000 {
+			// Surrogate pair
+			p.Character += 2
+		} else {
+			p.Character++
+		}
+	}
+	return p
+}
+
 func locRange(l Location) LocRange { return LocRange{Location: l} }
 
 func locEnd(s source) LocationEnd {
@@ -4801,6 +7647,101 @@ func locEnd(s source) LocationEnd {
 	}
 }
 
+// parseConstrCount parses the constraint "count where (x) y"
+// starting at si with s positioned after the keyword "count".
+func (p *Parser) parseConstrCount(
+	si, s source, expect expect,
+) (source, Expression) {
+	var ok bool
+	if s, ok = s.consumeKeyword("where"); !ok {
+		p.errUnexpTok(s, "expected keyword 'where'")
+		return stop(), nil
+	}
+	s = s.consumeIgnored()
+	if s, ok = s.consume("("); !ok {
+		p.errUnexpTok(s, "expected parenthesized item constraint")
+		return stop(), nil
+	}
+	s = s.consumeIgnored()
+
+	e := &ConstrCount{LocRange: locRange(si.Location)}
+	if s, e.Where = p.parseConstrLogicalOr(
+		s, expectConstraintInArray,
+	); s.stop() {
+		return stop(), nil
+	}
+	setParent(e.Where, e)
+
+	if s, ok = s.consume(")"); !ok {
+		p.errUnexpTok(s, "missing closing parenthesis")
+		return stop(), nil
+	}
+	s = s.consumeIgnored()
+
+	if s, e.Constraint = p.parseConstr(s, expect); s.stop() {
+		return stop(), nil
+	}
+	setParent(e.Constraint, e)
+	e.LocationEnd = e.Constraint.GetLocation().LocationEnd
+
+	return s, e
+}
+
+// parseConstrSet parses the set of a set membership constraint
+// starting at si with s positioned after the keyword "in".
+func (p *Parser) parseConstrSet(
+	si, s source, not bool,
+) (source, Expression) {
+	s = s.consumeIgnored()
+	var ok bool
+	if s, ok = s.consume("["); !ok {
+		p.errUnexpTok(s, "expected set of values")
+		return stop(), nil
+	}
+	s = s.consumeIgnored()
+
+	var values []Expression
+	for {
+		if s, ok = s.consume("]"); ok {
+			break
+		}
+		sBeforeValue := s
+		var expr Expression
+		if s, expr = p.parseExprLogicalOr(s, expectValue); s.stop() {
+			// Recover by skipping to the next value
+			s = sBeforeValue.skipUntil(",]")
+		} else {
+			values = append(values, expr)
+			s = s.consumeIgnored()
+			if !s.peek1(',') && !s.peek1(']') {
+				p.errUnexpTok(s, "expected comma or end of set")
+				s = s.skipUntil(",]")
+			}
+		}
+		if s, ok = s.consume(","); ok {
+			s = s.consumeIgnored()
+		} else if !s.peek1(']') {
+			// Reached the end of the enclosing block
+			return stop(), nil
+		}
+	}
+
+	l := LocRange{Location: si.Location, LocationEnd: locEnd(s)}
+	s = s.consumeIgnored()
+	if not {
+		e := &ConstrNotIn{LocRange: l, Values: values}
+		for _, v := range values {
+			setParent(v, e)
+		}
+		return s, e
+	}
+	e := &ConstrIn{LocRange: l, Values: values}
+	for _, v := range values {
+		setParent(v, e)
+	}
+	return s, e
+}
+
 func (s source) lookaheadIsValOperator() bool {
 	var ok bool
 	if s, ok = s.consume("+"); ok {
@@ -4830,6 +7771,12 @@ func (s source) lookaheadIsValOperator() bool {
 }
 
 func getVarDeclConstraint(v *Variable) Expression {
+	if v.Declaration == nil {
+		// The value of an undefined variable may be of any type
+		return &ConstrAny{}
+	} else if v.Declaration.Parent == nil {
+		return nil
+	}
 	switch v := v.Declaration.Parent.(type) {
 	case *Argument:
 		return v.Constraint
@@ -4848,130 +7795,14 @@ func getTypeName(t *ast.Type) string {
 	return t.NamedType
 }
 
-// traverse returns true after BFS-traversing the entire tree under e
+// traverse returns true after traversing the entire tree under e
 // calling onExpression for every discovered expression.
+// Returns false as soon as onExpression returns false.
 func traverse(e Expression, onExpression func(Expression) bool) bool {
-	stack := make([]Expression, 0, 64)
-	push := func(expression Expression) {
-		stack = append(stack, expression)
-	}
-
-	push(e)
-	for len(stack) > 0 {
-		top := stack[len(stack)-1]
-		stack = stack[:len(stack)-1]
-		if !onExpression(top) {
-			return false
-		}
-
-		switch e := top.(type) {
-		case *Operation:
-			for _, f := range e.Selections {
-				push(f)
-			}
-		case *SelectionInlineFrag:
-			for _, f := range e.Selections {
-				push(f)
-			}
-		case *SelectionField:
-			for _, f := range e.Selections {
-				push(f)
-			}
-		case *SelectionMax:
-			for _, f := range e.Options.Selections {
-				push(f)
-			}
-		case *Argument:
-			push(e.Constraint)
-		case *ConstrEquals:
-			push(e.Value)
-		case *ConstrNotEquals:
-			push(e.Value)
-		case *ConstrGreater:
-			push(e.Value)
-		case *ConstrGreaterOrEqual:
-			push(e.Value)
-		case *ConstrLess:
-			push(e.Value)
-		case *ConstrLessOrEqual:
-			push(e.Value)
-		case *ConstrLenEquals:
-			push(e.Value)
-		case *ConstrLenNotEquals:
-			push(e.Value)
-		case *ConstrLenGreater:
-			push(e.Value)
-		case *ConstrLenLess:
-			push(e.Value)
-		case *ConstrLenGreaterOrEqual:
-			push(e.Value)
-		case *ConstrLenLessOrEqual:
-			push(e.Value)
-		case *ConstrMap:
-			push(e.Constraint)
-		case *ExprParentheses:
-			push(e.Expression)
-		case *ExprEqual:
-			push(e.Left)
-			push(e.Right)
-		case *ExprNotEqual:
-			push(e.Left)
-			push(e.Right)
-		case *ExprLogicalNegation:
-			push(e.Expression)
-		case *ExprNumericNegation:
-			push(e.Expression)
-		case *ExprLogicalOr:
-			for _, e := range e.Expressions {
-				push(e)
-			}
-		case *ExprLogicalAnd:
-			for _, e := range e.Expressions {
-				push(e)
-			}
-		case *ExprAddition:
-			push(e.AddendLeft)
-			push(e.AddendRight)
-		case *ExprSubtraction:
-			push(e.Minuend)
-			push(e.Subtrahend)
-		case *ExprMultiplication:
-			push(e.Multiplicant)
-			push(e.Multiplicator)
-		case *ExprDivision:
-			push(e.Dividend)
-			push(e.Divisor)
-		case *ExprModulo:
-			push(e.Dividend)
-			push(e.Divisor)
-		case *ExprGreater:
-			push(e.Left)
-			push(e.Right)
-		case *ExprGreaterOrEqual:
-			push(e.Left)
-			push(e.Right)
-		case *ExprLess:
-			push(e.Left)
-			push(e.Right)
-		case *ExprLessOrEqual:
-			push(e.Left)
-			push(e.Right)
-		case *Array:
-			for _, i := range e.Items {
-				push(i)
-			}
-		case *Variable:
-		case *Object:
-			for _, f := range e.Fields {
-				push(f)
-			}
-		case *ObjectField:
-			push(e.Constraint)
-		case *Number, *True, *False, *Null,
-			*Enum, *String, *ConstrAny:
-		default:
-			panic(fmt.Errorf("unhandled type: %T", top))
-		}
-	}
-	return true
+	ok := true
+	Walk(e, inspector(func(e Expression) bool {
+		ok = ok && onExpression(e)
+		return ok
+	}))
+	return ok
 }
@@ -934,11 +934,12 @@ func TestConsumeString(t *testing.T) {
 	}
 
 	run := test.New(t, func(t *testing.T, x T) {
-		a, n, ok := source{
+		a, n, ok, err := source{
 			s:        []byte(x.Input),
 			Location: x.Location,
 		}.consumeString()
 		require.Equal(t, x.ExpectOK, ok)
+		require.False(t, err.IsErr(), "unexpected error: %v", err)
 		require.Equal(t, x.ExpectString, string(n))
 		expect := source{
 			s:        []byte(x.Input),
@@ -986,7 +987,7 @@ func TestConsumeString(t *testing.T) {
 		startLoc(),
 		`"\\" `,
 		true,
-		`\\`,
+		`\`,
 		Location{4, 1, 5},
 	})
 	run(T{
@@ -1026,5 +1027,121 @@ func TestConsumeString(t *testing.T) {
 	})
 }
 
+func TestConsumeStringEscape(t *testing.T) {
+	type T struct {
+		Input        string
+		ExpectString string
+		ExpectErr    Error
+	}
+
+	run := test.New(t, func(t *testing.T, x T) {
+		a, n, ok, err := source{
+			s:        []byte(x.Input),
+			Location: startLoc(),
+		}.consumeString()
+		require.True(t, ok)
+		require.Equal(t, x.ExpectErr, err)
+		require.Equal(t, x.ExpectString, string(n))
+		require.Equal(t, len(x.Input), a.Index)
+	})
+
+	run(T{Input: `"\"\\\/\b\f\n\r\t"`, ExpectString: "\"\\/\b\f\n\r\t"})
+	run(T{Input: `"\u00e9\u00E9"`, ExpectString: "éé"})
+	run(T{Input: `"\u{1F600}\u{0000041}"`, ExpectString: "😀A"})
+	run(T{Input: `"\uD83D\uDE00"`, ExpectString: "😀"})
+	run(T{Input: "\"a\tb\"", ExpectString: "a\tb"})
+	run(T{
+		Input: `"a\x\q"`,
+		ExpectErr: Error{
+			LocRange: LocRange{
+				Location:    Location{2, 1, 3},
+				LocationEnd: LocationEnd{4, 1, 5},
+			},
+			Msg: "invalid escape sequence",
+		},
+	})
+	run(T{
+		Input: `"\u12x"`,
+		ExpectErr: Error{
+			LocRange: LocRange{
+				Location:    Location{1, 1, 2},
+				LocationEnd: LocationEnd{5, 1, 6},
+			},
+			Msg: "invalid Unicode escape sequence",
+		},
+	})
+	run(T{
+		Input: `"\uDE00"`,
+		ExpectErr: Error{
+			LocRange: LocRange{
+				Location:    Location{1, 1, 2},
+				LocationEnd: LocationEnd{7, 1, 8},
+			},
+			Msg: "invalid Unicode escape sequence",
+		},
+	})
+	run(T{
+		Input: `"\uD83D\u0041"`,
+		ExpectErr: Error{
+			LocRange: LocRange{
+				Location:    Location{1, 1, 2},
+				LocationEnd: LocationEnd{7, 1, 8},
+			},
+			Msg: "invalid Unicode escape sequence",
+		},
+	})
+	for _, input := range []string{`"\u{}"`, `"\u{110000}"`, `"\u{D800}"`} {
+		run(T{
+			Input: input,
+			ExpectErr: Error{
+				LocRange: LocRange{
+					Location: Location{1, 1, 2},
+					LocationEnd: LocationEnd{
+						len(input) - 1, 1, len(input),
+					},
+				},
+				Msg: "invalid Unicode escape sequence",
+			},
+		})
+	}
+}
+
+func TestConsumeBlockString(t *testing.T) {
+	type T struct {
+		Input        string
+		ExpectString string
+		ExpectAfter  Location
+	}
+
+	run := test.New(t, func(t *testing.T, x T) {
+		a, n, ok, err := source{
+			s:        []byte(x.Input),
+			Location: startLoc(),
+		}.consumeString()
+		require.True(t, ok)
+		require.False(t, err.IsErr(), "unexpected error: %v", err)
+		require.Equal(t, x.ExpectString, string(n))
+		require.Equal(t, x.ExpectAfter, a.Location)
+	})
+
+	run(T{`""""""`, "", Location{6, 1, 7}})
+	run(T{`"""a "b" \n"""`, `a "b" \n`, Location{14, 1, 15}})
+	run(T{`"""a \""" b"""`, `a """ b`, Location{14, 1, 15}})
+	run(T{
+		"\"\"\"\n\n    a\n      b\n\n    c\n  \n  \"\"\"",
+		"a\n  b\n\nc",
+		Location{34, 8, 6},
+	})
+	run(T{"\"\"\"  a\r\n  b\r  c\"\"\"", "  a\nb\nc", Location{18, 2, 11}})
+	run(T{"\"\"\"\t\ta\n\t\tb\"\"\"", "\t\ta\nb", Location{13, 2, 7}})
+
+	// Unterminated
+	_, _, ok, _ := source{
+		s:        []byte(`"""a""`),
+		Location: startLoc(),
+	}.consumeString()
+	require.False(t, ok)
+}
+
 // startLoc returns a location at the start of a file.
 func startLoc() Location { return Location{0, 1, 1} }
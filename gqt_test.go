@@ -8,6 +8,7 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"testing/fstest"
 
 	"github.com/graph-guard/gqt/v4"
 	"github.com/stretchr/testify/assert"
@@ -268,6 +269,246 @@ func TestParseVariables(t *testing.T) {
 	)
 }
 
+func TestParseDocument(t *testing.T) {
+	input := `meta { owner: "team-users", tags: ["users"] }
+	query GetUser { user(id=$id: *) { ...U } }
+
+	fragment U on User { id friends(first=$first: *) { id } }
+
+	query GetUsers { users(limit=$limit: *) { ...U } }`
+	doc, errs := gqt.ParseDocument([]byte(input))
+	require.Len(t, errs, 0, "unexpected errors: %v", errs)
+	require.Len(t, doc.Operations, 2)
+
+	get, list := doc.Operations[0], doc.Operations[1]
+	require.Equal(t, "GetUser", get.Name.Name)
+	require.Equal(t, &gqt.Metadata{
+		LocRange: gqt.LocRange{
+			Location:    gqt.Location{Index: 0, Line: 1, Column: 1},
+			LocationEnd: gqt.LocationEnd{IndexEnd: 45, LineEnd: 1, ColumnEnd: 46},
+		},
+		Owner: "team-users",
+		Tags:  []string{"users"},
+	}, get.Metadata)
+	require.Equal(t, "GetUsers", list.Name.Name)
+	require.Nil(t, list.Metadata)
+
+	// Every operation has its own variables and fragments
+	require.Len(t, get.Variables, 2)
+	require.Contains(t, get.Variables, "id")
+	require.Contains(t, get.Variables, "first")
+	require.Len(t, list.Variables, 2)
+	require.Contains(t, list.Variables, "limit")
+	require.Contains(t, list.Variables, "first")
+	require.NotEqual(t, get.Variables["first"], list.Variables["first"])
+
+	require.Len(t, get.Fragments, 1)
+	require.Len(t, list.Fragments, 1)
+	require.NotSame(t, get.Fragments[0], list.Fragments[0])
+	require.Equal(t, get, get.Fragments[0].Parent)
+	require.Equal(t, list, list.Fragments[0].Parent)
+	require.Equal(t,
+		get.Fragments[0].LocRange, list.Fragments[0].LocRange,
+	)
+}
+
+func TestParseDocumentErr(t *testing.T) {
+	for _, td := range []struct {
+		name   string
+		input  string
+		expect []string
+	}{
+		{
+			name:  "empty",
+			input: `fragment F on T { a }`,
+			expect: []string{"1:22: unexpected end of file, expected " +
+				"query, mutation, or subscription operation definition"},
+		},
+		{
+			name:  "anonymous",
+			input: `query { a } query B { a }`,
+			expect: []string{"1:1: anonymous operation must be " +
+				"the only operation in the document"},
+		},
+		{
+			name:   "redeclared_operation",
+			input:  `query A { a } mutation A { a }`,
+			expect: []string{`1:24: redeclared operation "A"`},
+		},
+		{
+			name:   "unused_fragment",
+			input:  `query A { a } fragment F on Query { a }`,
+			expect: []string{`1:24: fragment "F" is unused`},
+		},
+		{
+			name:   "redeclared_fragment",
+			input:  `query A { ...F } fragment F on Query { a } fragment F on Query { b }`,
+			expect: []string{`1:53: redeclared fragment "F"`},
+		},
+		{
+			name: "shared_fragment",
+			input: `query A { ...F } query B { ...F }
+			fragment F on Query { a(x: < "s") }`,
+			expect: []string{"2:33: expected number but received String"},
+		},
+		{
+			name:   "variable_scope",
+			input:  `query A { a(x=$x: *) } query B { b(y: $x) }`,
+			expect: []string{`1:39: undefined variable`},
+		},
+		{
+			name:  "undefined_variable_before_operation",
+			input: `query A { a(y: $z) } query B { b b }`,
+			expect: []string{
+				`1:16: undefined variable`,
+				`1:34: redeclared field "b"`,
+			},
+		},
+		{
+			name:   "redeclared_variable",
+			input:  `query A { a(x=$x: *) ...F } fragment F on Query { c(x=$x: *) }`,
+			expect: []string{`1:55: redeclared variable "x"`},
+		},
+	} {
+		t.Run(td.name, func(t *testing.T) {
+			doc, errs := gqt.ParseDocument([]byte(td.input))
+			compareErrors(t, td.expect, errs)
+			require.Nil(t, doc)
+		})
+	}
+}
+
+var importFS = fstest.MapFS{
+	"common.gqt": {Data: []byte(`import "lib/limits.gqt"
+		fragment UserSummary on User { id friends(limit: is Limit) { id } }
+		fragment Unused on User { id }`)},
+	"lib/limits.gqt": {Data: []byte(`constraint Limit = > 0 && <= 100`)},
+	"invalid.gqt": {Data: []byte(`constraint Name = len > 2
+		fragment F on User { unknown(x: is Name) }`)},
+	"cycle.gqt":     {Data: []byte(`import "common.gqt" import "cycle.gqt"`)},
+	"operation.gqt": {Data: []byte(`query { users { id } }`)},
+}
+
+const importSchema = `type Query { users(limit: Int): [User!]! }
+type User { id: ID! friends(limit: Int): [User!]! }`
+
+func TestParseImport(t *testing.T) {
+	p, err := gqt.NewParser([]gqt.Source{{Name: "schema", Content: importSchema}})
+	require.NoError(t, err)
+	p.SetFS(importFS)
+
+	opr, _, errs := p.Parse([]byte(`import "common.gqt"
+	import "cycle.gqt"
+	query { users(limit: is Limit) { ...UserSummary } }`))
+	require.Len(t, errs, 0, "unexpected errors: %v", errs)
+	require.Equal(t, []*gqt.Import{
+		{
+			LocRange: gqt.LocRange{
+				Location:    gqt.Location{Index: 0, Line: 1, Column: 1},
+				LocationEnd: gqt.LocationEnd{IndexEnd: 19, LineEnd: 1, ColumnEnd: 20},
+			},
+			Path: "common.gqt",
+		},
+		{
+			LocRange: gqt.LocRange{
+				Location:    gqt.Location{Index: 21, Line: 2, Column: 2},
+				LocationEnd: gqt.LocationEnd{IndexEnd: 39, LineEnd: 2, ColumnEnd: 20},
+			},
+			Path: "cycle.gqt",
+		},
+	}, opr.Imports)
+	require.Len(t, opr.ConstrAliases, 0)
+
+	// Unused imported fragments are no errors
+	require.Len(t, opr.Fragments, 2)
+	require.Equal(t, "UserSummary", opr.Fragments[0].Name.Name)
+	require.Equal(t, "common.gqt", opr.Fragments[0].File)
+	require.Equal(t, "Unused", opr.Fragments[1].Name.Name)
+
+	users := opr.Selections[0].(*gqt.SelectionField)
+	limit := users.Arguments[0].Constraint.(*gqt.ConstrAlias)
+	require.Equal(t, "Limit", limit.Name.Name)
+	require.Equal(t, "lib/limits.gqt", limit.File)
+	require.Equal(t, limit, limit.Constraint.GetParent())
+	require.Equal(t, opr.Fragments[0],
+		users.Selections[0].(*gqt.SelectionSpread).Fragment)
+
+	// Every reference to an alias holds its own copy of the constraint
+	friends := opr.Fragments[0].Selections[1].(*gqt.SelectionField)
+	l := friends.Arguments[0].Constraint.(*gqt.ConstrAlias)
+	require.NotSame(t, limit.Constraint, l.Constraint)
+	require.Equal(t, limit.Constraint.GetLocation(), l.Constraint.GetLocation())
+
+	doc, errs := p.ParseDocument([]byte(`import "common.gqt"
+	query A { users(limit: is Limit) { ...UserSummary } }
+	query B { users { id } }`))
+	require.Len(t, errs, 0, "unexpected errors: %v", errs)
+	require.Len(t, doc.Imports, 1)
+	require.Len(t, doc.Operations[0].Fragments, 1)
+	require.Equal(t, "common.gqt", doc.Operations[0].Fragments[0].File)
+	require.Len(t, doc.Operations[1].Fragments, 0)
+}
+
+func TestParseImportErr(t *testing.T) {
+	p, err := gqt.NewParser([]gqt.Source{{Name: "schema", Content: importSchema}})
+	require.NoError(t, err)
+	p.SetFS(importFS)
+
+	for _, td := range []struct {
+		name   string
+		input  string
+		expect []string
+	}{
+		{
+			name:   "not_found",
+			input:  `import "missing.gqt" query { users { id } }`,
+			expect: []string{`1:1: can't import "missing.gqt": file does not exist`},
+		},
+		{
+			name:   "invalid_path",
+			input:  `import "../common.gqt" query { users { id } }`,
+			expect: []string{`1:1: invalid import path "../common.gqt"`},
+		},
+		{
+			name:  "operation",
+			input: `import "operation.gqt" query { users { id } }`,
+			expect: []string{"operation.gqt:1:1: unexpected token, " +
+				"expected import, fragment, or constraint definition"},
+		},
+		{
+			name: "invalid",
+			input: `import "invalid.gqt"
+			query { users(limit: is Name) { ...F } }`,
+			expect: []string{
+				"invalid.gqt:1:19: length constraint 'len >' " +
+					"(length greater than) only supports arrays " +
+					"and type String, it can't be applied to type Int",
+				`invalid.gqt:2:24: field "unknown" is undefined in type User`,
+			},
+		},
+		{
+			name: "redeclared_fragment",
+			input: `import "common.gqt"
+			query { users { ...UserSummary } }
+			fragment UserSummary on User { id }`,
+			expect: []string{`3:13: redeclared fragment "UserSummary"`},
+		},
+		{
+			name: "redeclared_alias",
+			input: `import "common.gqt"
+			constraint Limit = *
+			query { users { id } }`,
+			expect: []string{`2:15: redeclared constraint alias "Limit"`},
+		},
+	} {
+		t.Run(td.name, func(t *testing.T) {
+			opr, _, errs := p.Parse([]byte(td.input))
+			compareErrors(t, td.expect, errs)
+			require.Nil(t, opr)
+		})
+	}
+}
+
 func TestParseNoSchema(t *testing.T) {
 	p, err := gqt.NewParser([]gqt.Source{})
 	require.NoError(t, err)
@@ -319,6 +560,38 @@ func TestErrorString(t *testing.T) {
 	e.Msg = "some error"
 	require.True(t, e.IsErr())
 	require.Equal(t, "1:1: some error", e.Error())
+
+	e.File = "common.gqt"
+	require.Equal(t, "common.gqt:1:1: some error", e.Error())
+}
+
+func TestLocRangeUTF16(t *testing.T) {
+	src := []byte("query { # ü😀\n  f(a: \"😀ü\", b: \"x\")\n}\n")
+	opr, _, errs := gqt.Parse(src)
+	require.Len(t, errs, 0, "unexpected errors: %v", errs)
+
+	args := opr.Selections[0].(*gqt.SelectionField).Arguments
+	a := args[0].Constraint.(*gqt.ConstrEquals).Value
+	require.Equal(t, gqt.LocRange{
+		Location:    gqt.Location{Index: 24, Line: 2, Column: 8},
+		LocationEnd: gqt.LocationEnd{IndexEnd: 32, LineEnd: 2, ColumnEnd: 12},
+	}, a.GetLocation())
+	start, end := a.GetLocation().UTF16(src)
+	require.Equal(t, gqt.UTF16Position{Line: 1, Character: 7}, start)
+	require.Equal(t, gqt.UTF16Position{Line: 1, Character: 12}, end)
+
+	b := args[1].Name.LocRange
+	require.Equal(t, 14, b.Column)
+	start, end = b.UTF16(src)
+	require.Equal(t, gqt.UTF16Position{Line: 1, Character: 14}, start)
+	require.Equal(t, gqt.UTF16Position{Line: 1, Character: 15}, end)
+
+	// Locations without end
+	start, end = gqt.LocRange{
+		Location: gqt.Location{Index: 16, Line: 1, Column: 13},
+	}.UTF16(src)
+	require.Equal(t, gqt.UTF16Position{Line: 0, Character: 13}, start)
+	require.Equal(t, start, end)
 }
 
 func TestNumber(t *testing.T) {
@@ -0,0 +1,1762 @@
+package gqt
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// WriteJSON writes o as JSON to w.
+// The output can be decoded using ReadJSON.
+func WriteJSON(w io.Writer, o *Operation) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(o)
+}
+
+// ReadJSON decodes an operation written by WriteJSON from r
+// in schemaless mode. See (*Parser).ReadJSON.
+func ReadJSON(r io.Reader) (
+	operation *Operation,
+	variables map[string]*VariableDeclaration,
+	err error,
+) {
+	return newParser().ReadJSON(r)
+}
+
+// ReadJSON decodes an operation written by WriteJSON from r and
+// rebuilds its abstract syntax tree including the parents of all
+// expressions and the variable declarations and their references.
+// The operation is validated against the schema of the parser the same
+// way Parse validates operations and an error is returned if it's invalid.
+func (p *Parser) ReadJSON(r io.Reader) (
+	operation *Operation,
+	variables map[string]*VariableDeclaration,
+	err error,
+) {
+	p.reset()
+
+	var n jsonNode
+	d := json.NewDecoder(r)
+	d.DisallowUnknownFields()
+	if err := d.Decode(&n); err != nil {
+		return nil, nil, fmt.Errorf("decoding JSON: %w", err)
+	}
+
+	o := &Operation{
+		LocRange:  LocRange(n.Location),
+		MaxDepth:  n.MaxDepth,
+		Metadata:  n.Metadata.metadata(),
+		Variables: p.vars.decls,
+	}
+	if n.Name.Name != "" {
+		o.Name = &Name{LocRange: LocRange(n.Name.Location), Name: n.Name.Name}
+	}
+	switch n.OperationType {
+	case "Query":
+		o.Type = OperationTypeQuery
+	case "Mutation":
+		o.Type = OperationTypeMutation
+	case "Subscription":
+		o.Type = OperationTypeSubscription
+	default:
+		return nil, nil, fmt.Errorf(
+			"unknown operation type %q", n.OperationType,
+		)
+	}
+	if o.SelectionSet, err = p.jsonSelectionSet(n.SelectionSet); err != nil {
+		return nil, nil, err
+	}
+	for _, n := range n.Fragments {
+		d, err := p.jsonFragment(n)
+		if err != nil {
+			return nil, nil, err
+		}
+		o.Fragments = append(o.Fragments, d)
+	}
+	for _, n := range n.Imports {
+		if n == nil {
+			return nil, nil, fmt.Errorf("missing import")
+		}
+		o.Imports = append(o.Imports, &Import{
+			LocRange: LocRange(n.Location),
+			Path:     n.Path,
+		})
+	}
+	for _, n := range n.Aliases {
+		d, err := p.jsonConstrAliasDef(n)
+		if err != nil {
+			return nil, nil, err
+		}
+		o.ConstrAliases = append(o.ConstrAliases, d)
+	}
+
+	Walk(o, inspector(func(e Expression) bool {
+		forEachChild(e, func(c Expression) { setParent(c, e) })
+		return true
+	}))
+	p.resolveFragments(o)
+
+	// Link variable declarations and references
+	for _, r := range p.vars.refs {
+		v, ok := p.vars.decls[r.Name.Name]
+		if !ok {
+			return nil, nil, errJSON(
+				r.LocRange, "undefined variable %q", r.Name.Name,
+			)
+		}
+		r.Declaration = v
+		v.References = append(v.References, r)
+	}
+
+	p.setTypes(o)
+	p.validate(o)
+
+	if len(p.errors) > 0 {
+		return nil, nil, p.sortedErrors()[0]
+	}
+	return o, o.Variables, nil
+}
+
+func (s LocRange) MarshalJSON() ([]byte, error) {
+	if s.ColumnEnd == 0 {
+		// Not a range
+		return json.Marshal(fmt.Sprintf("%d:%d:%d", s.Index, s.Line, s.Column))
+	}
+	return json.Marshal(fmt.Sprintf(
+		"%d:%d:%d-%d:%d:%d",
+		s.Index, s.Line, s.Column,
+		s.IndexEnd, s.LineEnd, s.ColumnEnd,
+	))
+}
+
+func (c TypeCondition) MarshalJSON() ([]byte, error) {
+	var t string
+	if c.TypeDef != nil {
+		t = c.TypeDef.Name
+	}
+	return json.Marshal(struct {
+		Location LocRange `json:"location"`
+		TypeName string   `json:"typeName"`
+		Type     string   `json:"type,omitempty"`
+	}{
+		Location: c.LocRange,
+		TypeName: c.TypeName,
+		Type:     t,
+	})
+}
+
+func (l ArgumentList) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Location  LocRange    `json:"location"`
+		Arguments []*Argument `json:"arguments,omitempty"`
+	}{
+		Location:  l.LocRange,
+		Arguments: l.Arguments,
+	})
+}
+
+func (s SelectionSet) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Location   LocRange    `json:"location"`
+		Selections []Selection `json:"selections,omitempty"`
+	}{
+		Location:   s.LocRange,
+		Selections: s.Selections,
+	})
+}
+
+func (o *Operation) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Location      LocRange                 `json:"location"`
+		Imports       []*Import                `json:"imports,omitempty"`
+		ConstrAliases []*ConstrAliasDefinition `json:"constraintAliases,omitempty"`
+		Metadata      *Metadata                `json:"metadata,omitempty"`
+		OperationType string                   `json:"operationType"`
+		Name          *Name                    `json:"name,omitempty"`
+		MaxDepth      int                      `json:"maxDepth,omitempty"`
+		SelectionSet  SelectionSet             `json:"selectionSet,omitempty"`
+		Fragments     []*FragmentDefinition    `json:"fragments,omitempty"`
+	}{
+		Location:      o.LocRange,
+		Imports:       o.Imports,
+		ConstrAliases: o.ConstrAliases,
+		Metadata:      o.Metadata,
+		OperationType: o.Type.String(),
+		Name:          o.Name,
+		MaxDepth:      o.MaxDepth,
+		SelectionSet:  o.SelectionSet,
+		Fragments:     o.Fragments,
+	})
+}
+
+func (m *Metadata) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Location    LocRange `json:"location"`
+		Description string   `json:"description,omitempty"`
+		Owner       string   `json:"owner,omitempty"`
+		Tags        []string `json:"tags,omitempty"`
+	}{
+		Location:    m.LocRange,
+		Description: m.Description,
+		Owner:       m.Owner,
+		Tags:        m.Tags,
+	})
+}
+
+func (i *Import) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Location LocRange `json:"location"`
+		Path     string   `json:"path"`
+	}{
+		Location: i.LocRange,
+		Path:     i.Path,
+	})
+}
+
+func (d *ConstrAliasDefinition) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Location   LocRange   `json:"location"`
+		Name       Name       `json:"name"`
+		Constraint Expression `json:"constraint"`
+	}{
+		Location:   d.LocRange,
+		Name:       d.Name,
+		Constraint: d.Constraint,
+	})
+}
+
+func (d *FragmentDefinition) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Location      LocRange      `json:"location"`
+		Name          Name          `json:"name"`
+		File          string        `json:"file,omitempty"`
+		TypeCondition TypeCondition `json:"typeCondition"`
+		SelectionSet  SelectionSet  `json:"selectionSet"`
+	}{
+		Location:      d.LocRange,
+		Name:          d.Name,
+		File:          d.File,
+		TypeCondition: d.TypeCondition,
+		SelectionSet:  d.SelectionSet,
+	})
+}
+
+func (c *ConstrAny) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Location       LocRange `json:"location"`
+		ConstraintType string   `json:"constraintType"`
+	}{
+		Location:       c.LocRange,
+		ConstraintType: "any",
+	})
+}
+
+func (c *ConstrEquals) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Location       LocRange   `json:"location"`
+		ConstraintType string     `json:"constraintType"`
+		Value          Expression `json:"value"`
+	}{
+		Location:       c.LocRange,
+		ConstraintType: "equals",
+		Value:          c.Value,
+	})
+}
+
+func (c *ConstrNotEquals) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Location       LocRange   `json:"location"`
+		ConstraintType string     `json:"constraintType"`
+		Value          Expression `json:"value"`
+	}{
+		Location:       c.LocRange,
+		ConstraintType: "notEquals",
+		Value:          c.Value,
+	})
+}
+
+func (c *ConstrLess) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Location       LocRange   `json:"location"`
+		ConstraintType string     `json:"constraintType"`
+		Value          Expression `json:"value"`
+	}{
+		Location:       c.LocRange,
+		ConstraintType: "lessThan",
+		Value:          c.Value,
+	})
+}
+
+func (c *ConstrLessOrEqual) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Location       LocRange   `json:"location"`
+		ConstraintType string     `json:"constraintType"`
+		Value          Expression `json:"value"`
+	}{
+		Location:       c.LocRange,
+		ConstraintType: "lessThanOrEquals",
+		Value:          c.Value,
+	})
+}
+
+func (c *ConstrGreater) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Location       LocRange   `json:"location"`
+		ConstraintType string     `json:"constraintType"`
+		Value          Expression `json:"value"`
+	}{
+		Location:       c.LocRange,
+		ConstraintType: "greaterThan",
+		Value:          c.Value,
+	})
+}
+
+func (c *ConstrGreaterOrEqual) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Location       LocRange   `json:"location"`
+		ConstraintType string     `json:"constraintType"`
+		Value          Expression `json:"value"`
+	}{
+		Location:       c.LocRange,
+		ConstraintType: "greaterThanOrEquals",
+		Value:          c.Value,
+	})
+}
+
+func (c *ConstrLenEquals) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Location       LocRange   `json:"location"`
+		ConstraintType string     `json:"constraintType"`
+		Value          Expression `json:"value"`
+	}{
+		Location:       c.LocRange,
+		ConstraintType: "lengthEquals",
+		Value:          c.Value,
+	})
+}
+
+func (c *ConstrLenNotEquals) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Location       LocRange   `json:"location"`
+		ConstraintType string     `json:"constraintType"`
+		Value          Expression `json:"value"`
+	}{
+		Location:       c.LocRange,
+		ConstraintType: "lengthNotEquals",
+		Value:          c.Value,
+	})
+}
+
+func (c *ConstrLenLess) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Location       LocRange   `json:"location"`
+		ConstraintType string     `json:"constraintType"`
+		Value          Expression `json:"value"`
+	}{
+		Location:       c.LocRange,
+		ConstraintType: "lengthLessThan",
+		Value:          c.Value,
+	})
+}
+
+func (c *ConstrLenLessOrEqual) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Location       LocRange   `json:"location"`
+		ConstraintType string     `json:"constraintType"`
+		Value          Expression `json:"value"`
+	}{
+		Location:       c.LocRange,
+		ConstraintType: "lengthLessThanOrEquals",
+		Value:          c.Value,
+	})
+}
+
+func (c *ConstrLenGreater) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Location       LocRange   `json:"location"`
+		ConstraintType string     `json:"constraintType"`
+		Value          Expression `json:"value"`
+	}{
+		Location:       c.LocRange,
+		ConstraintType: "lengthGreaterThan",
+		Value:          c.Value,
+	})
+}
+
+func (c *ConstrLenGreaterOrEqual) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Location       LocRange   `json:"location"`
+		ConstraintType string     `json:"constraintType"`
+		Value          Expression `json:"value"`
+	}{
+		Location:       c.LocRange,
+		ConstraintType: "lengthGreaterThanOrEquals",
+		Value:          c.Value,
+	})
+}
+
+func (c *ConstrMatches) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Location       LocRange   `json:"location"`
+		ConstraintType string     `json:"constraintType"`
+		Value          Expression `json:"value"`
+	}{
+		Location:       c.LocRange,
+		ConstraintType: "matches",
+		Value:          c.Value,
+	})
+}
+
+func (c *ConstrIn) MarshalJSON() ([]byte, error) {
+	values := c.Values
+	if values == nil {
+		values = []Expression{}
+	}
+	return json.Marshal(struct {
+		Location       LocRange     `json:"location"`
+		ConstraintType string       `json:"constraintType"`
+		Values         []Expression `json:"values"`
+	}{
+		Location:       c.LocRange,
+		ConstraintType: "in",
+		Values:         values,
+	})
+}
+
+func (c *ConstrNotIn) MarshalJSON() ([]byte, error) {
+	values := c.Values
+	if values == nil {
+		values = []Expression{}
+	}
+	return json.Marshal(struct {
+		Location       LocRange     `json:"location"`
+		ConstraintType string       `json:"constraintType"`
+		Values         []Expression `json:"values"`
+	}{
+		Location:       c.LocRange,
+		ConstraintType: "notIn",
+		Values:         values,
+	})
+}
+
+func (c *ConstrUnique) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Location       LocRange `json:"location"`
+		ConstraintType string   `json:"constraintType"`
+	}{
+		Location:       c.LocRange,
+		ConstraintType: "unique",
+	})
+}
+
+func (c *ConstrContains) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Location       LocRange   `json:"location"`
+		ConstraintType string     `json:"constraintType"`
+		Value          Expression `json:"value"`
+	}{
+		Location:       c.LocRange,
+		ConstraintType: "contains",
+		Value:          c.Value,
+	})
+}
+
+func (c *ConstrSum) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Location       LocRange   `json:"location"`
+		ConstraintType string     `json:"constraintType"`
+		Constraint     Expression `json:"constraint"`
+	}{
+		Location:       c.LocRange,
+		ConstraintType: "sum",
+		Constraint:     c.Constraint,
+	})
+}
+
+func (c *ConstrCount) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Location       LocRange   `json:"location"`
+		ConstraintType string     `json:"constraintType"`
+		Where          Expression `json:"where"`
+		Constraint     Expression `json:"constraint"`
+	}{
+		Location:       c.LocRange,
+		ConstraintType: "count",
+		Where:          c.Where,
+		Constraint:     c.Constraint,
+	})
+}
+
+func (c *ConstrMap) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Location       LocRange   `json:"location"`
+		ConstraintType string     `json:"constraintType"`
+		Constraint     Expression `json:"constraint"`
+	}{
+		Location:       c.LocRange,
+		ConstraintType: "map",
+		Constraint:     c.Constraint,
+	})
+}
+
+func (c *ConstrAlias) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Location       LocRange   `json:"location"`
+		ConstraintType string     `json:"constraintType"`
+		Name           Name       `json:"name"`
+		File           string     `json:"file,omitempty"`
+		Constraint     Expression `json:"constraint"`
+	}{
+		Location:       c.LocRange,
+		ConstraintType: "alias",
+		Name:           c.Name,
+		File:           c.File,
+		Constraint:     c.Constraint,
+	})
+}
+
+func (e *ExprParentheses) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Location       LocRange   `json:"location"`
+		ExpressionType string     `json:"expressionType"`
+		Expression     Expression `json:"expression"`
+	}{
+		Location:       e.LocRange,
+		ExpressionType: "parentheses",
+		Expression:     e.Expression,
+	})
+}
+
+func (e *ExprModulo) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Location       LocRange   `json:"location"`
+		ExpressionType string     `json:"expressionType"`
+		Float          bool       `json:"float"`
+		Dividend       Expression `json:"dividend"`
+		Divisor        Expression `json:"divisor"`
+	}{
+		Location:       e.LocRange,
+		ExpressionType: "modulo",
+		Float:          e.Float,
+		Dividend:       e.Dividend,
+		Divisor:        e.Divisor,
+	})
+}
+
+func (e *ExprDivision) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Location       LocRange   `json:"location"`
+		ExpressionType string     `json:"expressionType"`
+		Float          bool       `json:"float"`
+		Dividend       Expression `json:"dividend"`
+		Divisor        Expression `json:"divisor"`
+	}{
+		Location:       e.LocRange,
+		ExpressionType: "division",
+		Float:          e.Float,
+		Dividend:       e.Dividend,
+		Divisor:        e.Divisor,
+	})
+}
+
+func (e *ExprMultiplication) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Location       LocRange   `json:"location"`
+		ExpressionType string     `json:"expressionType"`
+		Float          bool       `json:"float"`
+		Multiplicant   Expression `json:"multiplicant"`
+		Multiplicator  Expression `json:"multiplicator"`
+	}{
+		Location:       e.LocRange,
+		ExpressionType: "multiplication",
+		Float:          e.Float,
+		Multiplicant:   e.Multiplicant,
+		Multiplicator:  e.Multiplicator,
+	})
+}
+
+func (e *ExprAddition) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Location       LocRange   `json:"location"`
+		ExpressionType string     `json:"expressionType"`
+		Float          bool       `json:"float"`
+		AddendLeft     Expression `json:"addendLeft"`
+		AddendRight    Expression `json:"addendRight"`
+	}{
+		Location:       e.LocRange,
+		ExpressionType: "addition",
+		Float:          e.Float,
+		AddendLeft:     e.AddendLeft,
+		AddendRight:    e.AddendRight,
+	})
+}
+
+func (e *ExprSubtraction) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Location       LocRange   `json:"location"`
+		ExpressionType string     `json:"expressionType"`
+		Float          bool       `json:"float"`
+		Minuend        Expression `json:"minuend"`
+		Subtrahend     Expression `json:"subtrahend"`
+	}{
+		Location:       e.LocRange,
+		ExpressionType: "subtraction",
+		Float:          e.Float,
+		Minuend:        e.Minuend,
+		Subtrahend:     e.Subtrahend,
+	})
+}
+
+func (e *ExprLogicalNegation) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Location       LocRange   `json:"location"`
+		ExpressionType string     `json:"expressionType"`
+		Expression     Expression `json:"expression"`
+	}{
+		Location:       e.LocRange,
+		ExpressionType: "logicalNegation",
+		Expression:     e.Expression,
+	})
+}
+
+func (e *ExprNumericNegation) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Location       LocRange   `json:"location"`
+		ExpressionType string     `json:"expressionType"`
+		Float          bool       `json:"float"`
+		Expression     Expression `json:"expression"`
+	}{
+		Location:       e.LocRange,
+		ExpressionType: "numericNegation",
+		Float:          e.Float,
+		Expression:     e.Expression,
+	})
+}
+
+func (e *ExprCall) MarshalJSON() ([]byte, error) {
+	args := e.Arguments
+	if args == nil {
+		args = []Expression{}
+	}
+	return json.Marshal(struct {
+		Location       LocRange     `json:"location"`
+		ExpressionType string       `json:"expressionType"`
+		Float          bool         `json:"float"`
+		Function       Name         `json:"function"`
+		Arguments      []Expression `json:"arguments"`
+	}{
+		Location:       e.LocRange,
+		ExpressionType: "call",
+		Float:          e.Float,
+		Function:       e.Function,
+		Arguments:      args,
+	})
+}
+
+func (e *ExprEqual) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Location       LocRange   `json:"location"`
+		ExpressionType string     `json:"expressionType"`
+		Left           Expression `json:"left"`
+		Right          Expression `json:"right"`
+	}{
+		Location:       e.LocRange,
+		ExpressionType: "equals",
+		Left:           e.Left,
+		Right:          e.Right,
+	})
+}
+
+func (e *ExprNotEqual) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Location       LocRange   `json:"location"`
+		ExpressionType string     `json:"expressionType"`
+		Left           Expression `json:"left"`
+		Right          Expression `json:"right"`
+	}{
+		Location:       e.LocRange,
+		ExpressionType: "notEquals",
+		Left:           e.Left,
+		Right:          e.Right,
+	})
+}
+
+func (e *ExprLess) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Location       LocRange   `json:"location"`
+		ExpressionType string     `json:"expressionType"`
+		Left           Expression `json:"left"`
+		Right          Expression `json:"right"`
+	}{
+		Location:       e.LocRange,
+		ExpressionType: "lessThan",
+		Left:           e.Left,
+		Right:          e.Right,
+	})
+}
+
+func (e *ExprLessOrEqual) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Location       LocRange   `json:"location"`
+		ExpressionType string     `json:"expressionType"`
+		Left           Expression `json:"left"`
+		Right          Expression `json:"right"`
+	}{
+		Location:       e.LocRange,
+		ExpressionType: "lessThanOrEquals",
+		Left:           e.Left,
+		Right:          e.Right,
+	})
+}
+
+func (e *ExprGreater) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Location       LocRange   `json:"location"`
+		ExpressionType string     `json:"expressionType"`
+		Left           Expression `json:"left"`
+		Right          Expression `json:"right"`
+	}{
+		Location:       e.LocRange,
+		ExpressionType: "greaterThan",
+		Left:           e.Left,
+		Right:          e.Right,
+	})
+}
+
+func (e *ExprGreaterOrEqual) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Location       LocRange   `json:"location"`
+		ExpressionType string     `json:"expressionType"`
+		Left           Expression `json:"left"`
+		Right          Expression `json:"right"`
+	}{
+		Location:       e.LocRange,
+		ExpressionType: "greaterThanOrEquals",
+		Left:           e.Left,
+		Right:          e.Right,
+	})
+}
+
+func (e *ExprLogicalAnd) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Location       LocRange     `json:"location"`
+		ExpressionType string       `json:"expressionType"`
+		Expressions    []Expression `json:"expressions"`
+	}{
+		Location:       e.LocRange,
+		ExpressionType: "logicalAND",
+		Expressions:    e.Expressions,
+	})
+}
+
+func (e *ExprLogicalOr) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Location       LocRange     `json:"location"`
+		ExpressionType string       `json:"expressionType"`
+		Expressions    []Expression `json:"expressions"`
+	}{
+		Location:       e.LocRange,
+		ExpressionType: "logicalOR",
+		Expressions:    e.Expressions,
+	})
+}
+
+func (e *True) MarshalJSON() ([]byte, error) {
+	var t string
+	if e.TypeDef != nil {
+		t = e.TypeDef.Name
+	}
+	return json.Marshal(struct {
+		Location       LocRange `json:"location"`
+		ExpressionType string   `json:"expressionType"`
+		Type           string   `json:"type,omitempty"`
+	}{
+		Location:       e.LocRange,
+		ExpressionType: "true",
+		Type:           t,
+	})
+}
+
+func (e *False) MarshalJSON() ([]byte, error) {
+	var t string
+	if e.TypeDef != nil {
+		t = e.TypeDef.Name
+	}
+	return json.Marshal(struct {
+		Location       LocRange `json:"location"`
+		ExpressionType string   `json:"expressionType"`
+		Type           string   `json:"type,omitempty"`
+	}{
+		Location:       e.LocRange,
+		ExpressionType: "false",
+		Type:           t,
+	})
+}
+
+func (n *Number) MarshalJSON() ([]byte, error) {
+	var t string
+	if n.TypeDef != nil {
+		t = n.TypeDef.Name
+	}
+	// The value is encoded as the literal string to preserve it exactly.
+	return json.Marshal(struct {
+		Location       LocRange `json:"location"`
+		ExpressionType string   `json:"expressionType"`
+		Type           string   `json:"type,omitempty"`
+		Value          string   `json:"value"`
+	}{
+		Location:       n.LocRange,
+		ExpressionType: "number",
+		Type:           t,
+		Value:          n.Value,
+	})
+}
+
+func (s *String) MarshalJSON() ([]byte, error) {
+	var t string
+	if s.TypeDef != nil {
+		t = s.TypeDef.Name
+	}
+	return json.Marshal(struct {
+		Location       LocRange `json:"location"`
+		ExpressionType string   `json:"expressionType"`
+		Type           string   `json:"type,omitempty"`
+		Value          string   `json:"value"`
+	}{
+		Location:       s.LocRange,
+		ExpressionType: "string",
+		Type:           t,
+		Value:          s.Value,
+	})
+}
+
+func (n *Null) MarshalJSON() ([]byte, error) {
+	var t string
+	if n.Type != nil {
+		t = n.Type.String()
+	}
+	return json.Marshal(struct {
+		Location       LocRange `json:"location"`
+		ExpressionType string   `json:"expressionType"`
+		Type           string   `json:"type,omitempty"`
+	}{
+		Location:       n.LocRange,
+		ExpressionType: "null",
+		Type:           t,
+	})
+}
+
+func (e *Enum) MarshalJSON() ([]byte, error) {
+	var t string
+	if e.TypeDef != nil {
+		t = e.TypeDef.Name
+	}
+	return json.Marshal(struct {
+		Location       LocRange `json:"location"`
+		ExpressionType string   `json:"expressionType"`
+		Value          string   `json:"value"`
+		Type           string   `json:"type,omitempty"`
+	}{
+		Location:       e.LocRange,
+		ExpressionType: "enum",
+		Value:          e.Value,
+		Type:           t,
+	})
+}
+
+func (a *Array) MarshalJSON() ([]byte, error) {
+	var t string
+	if a.Type != nil {
+		t = a.Type.String()
+	}
+	items := a.Items
+	if items == nil {
+		items = []Expression{}
+	}
+	return json.Marshal(struct {
+		Location       LocRange     `json:"location"`
+		ExpressionType string       `json:"expressionType"`
+		Type           string       `json:"type,omitempty"`
+		Items          []Expression `json:"items"`
+	}{
+		Location:       a.LocRange,
+		ExpressionType: "array",
+		Type:           t,
+		Items:          items,
+	})
+}
+
+func (o *Object) MarshalJSON() ([]byte, error) {
+	var t string
+	if o.TypeDef != nil {
+		t = o.TypeDef.Name
+	}
+	fields := o.Fields
+	if fields == nil {
+		fields = []*ObjectField{}
+	}
+	return json.Marshal(struct {
+		Location       LocRange       `json:"location"`
+		ExpressionType string         `json:"expressionType"`
+		Type           string         `json:"type,omitempty"`
+		Fields         []*ObjectField `json:"fields"`
+		Open           bool           `json:"open,omitempty"`
+	}{
+		Location:       o.LocRange,
+		ExpressionType: "object",
+		Type:           t,
+		Fields:         fields,
+		Open:           o.Open,
+	})
+}
+
+func (r *Variable) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Location       LocRange `json:"location"`
+		ExpressionType string   `json:"expressionType"`
+		Name           Name     `json:"name"`
+	}{
+		Location:       r.LocRange,
+		ExpressionType: "variableReference",
+		Name:           r.Name,
+	})
+}
+
+func (s *SelectionInlineFrag) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Location      LocRange      `json:"location"`
+		SelectionType string        `json:"selectionType"`
+		TypeCondition TypeCondition `json:"typeCondition"`
+		Directives    []*Directive  `json:"directives,omitempty"`
+		SelectionsSet SelectionSet  `json:"selectionSet,omitempty"`
+	}{
+		Location:      s.LocRange,
+		SelectionType: "inlineFragment",
+		TypeCondition: s.TypeCondition,
+		Directives:    s.Directives,
+		SelectionsSet: s.SelectionSet,
+	})
+}
+
+func (f *ObjectField) MarshalJSON() ([]byte, error) {
+	var t string
+	if f.Def != nil {
+		t = f.Def.Type.String()
+	}
+	type Var struct {
+		Location LocRange `json:"location"`
+		Name     string   `json:"name"`
+	}
+	var v *Var
+	if f.AssociatedVariable != nil {
+		v = &Var{
+			Location: f.AssociatedVariable.LocRange,
+			Name:     f.AssociatedVariable.Name,
+		}
+	}
+	return json.Marshal(struct {
+		Location   LocRange   `json:"location"`
+		Name       Name       `json:"name"`
+		Variable   *Var       `json:"variable,omitempty"`
+		Type       string     `json:"type,omitempty"`
+		Optional   bool       `json:"optional,omitempty"`
+		Absent     bool       `json:"absent,omitempty"`
+		Constraint Expression `json:"constraint,omitempty"`
+	}{
+		Location:   f.LocRange,
+		Name:       f.Name,
+		Variable:   v,
+		Type:       t,
+		Optional:   f.Optional,
+		Absent:     f.Absent,
+		Constraint: f.Constraint,
+	})
+}
+
+func (n Name) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Location LocRange `json:"location"`
+		Name     string   `json:"name"`
+	}{
+		Location: n.LocRange,
+		Name:     n.Name,
+	})
+}
+
+func (s *SelectionField) MarshalJSON() ([]byte, error) {
+	var t string
+	if s.Def != nil {
+		t = s.Def.Type.String()
+	}
+	return json.Marshal(struct {
+		Location      LocRange     `json:"location"`
+		SelectionType string       `json:"selectionType"`
+		Alias         *Alias       `json:"alias,omitempty"`
+		Name          Name         `json:"name"`
+		Type          string       `json:"type,omitempty"`
+		Required      bool         `json:"required,omitempty"`
+		ArgumentList  ArgumentList `json:"argumentList,omitempty"`
+		Directives    []*Directive `json:"directives,omitempty"`
+		SelectionSet  SelectionSet `json:"selectionSet,omitempty"`
+	}{
+		Location:      s.LocRange,
+		SelectionType: "field",
+		Alias:         s.Alias,
+		Name:          s.Name,
+		Type:          t,
+		Required:      s.Required,
+		ArgumentList:  s.ArgumentList,
+		Directives:    s.Directives,
+		SelectionSet:  s.SelectionSet,
+	})
+}
+
+func (d *Directive) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Location     LocRange     `json:"location"`
+		Name         Name         `json:"name"`
+		ArgumentList ArgumentList `json:"argumentList,omitempty"`
+	}{
+		Location:     d.LocRange,
+		Name:         d.Name,
+		ArgumentList: d.ArgumentList,
+	})
+}
+
+func (a *Alias) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Location LocRange `json:"location"`
+		Pattern  string   `json:"pattern"`
+		Limit    int      `json:"limit,omitempty"`
+	}{
+		Location: a.LocRange,
+		Pattern:  a.Pattern,
+		Limit:    a.Limit,
+	})
+}
+
+func (e *SelectionMax) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Location      LocRange     `json:"location"`
+		SelectionType string       `json:"selectionType"`
+		Limit         int          `json:"limit"`
+		Options       SelectionSet `json:"options"`
+	}{
+		Location:      e.LocRange,
+		SelectionType: "max",
+		Limit:         e.Limit,
+		Options:       e.Options,
+	})
+}
+
+func (e *SelectionMin) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Location      LocRange     `json:"location"`
+		SelectionType string       `json:"selectionType"`
+		Limit         int          `json:"limit"`
+		Options       SelectionSet `json:"options"`
+	}{
+		Location:      e.LocRange,
+		SelectionType: "min",
+		Limit:         e.Limit,
+		Options:       e.Options,
+	})
+}
+
+func (e *SelectionExactly) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Location      LocRange     `json:"location"`
+		SelectionType string       `json:"selectionType"`
+		Limit         int          `json:"limit"`
+		Options       SelectionSet `json:"options"`
+	}{
+		Location:      e.LocRange,
+		SelectionType: "exactly",
+		Limit:         e.Limit,
+		Options:       e.Options,
+	})
+}
+
+func (e *SelectionRecurse) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Location      LocRange `json:"location"`
+		SelectionType string   `json:"selectionType"`
+		Max           int      `json:"max"`
+	}{
+		Location:      e.LocRange,
+		SelectionType: "recurse",
+		Max:           e.Max,
+	})
+}
+
+func (e *SelectionSpread) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Location      LocRange `json:"location"`
+		SelectionType string   `json:"selectionType"`
+		Name          Name     `json:"name"`
+	}{
+		Location:      e.LocRange,
+		SelectionType: "spread",
+		Name:          e.Name,
+	})
+}
+
+func (a *Argument) MarshalJSON() ([]byte, error) {
+	var t string
+	if a.Def != nil {
+		t = a.Def.Type.String()
+	}
+	type Var struct {
+		Location LocRange `json:"location"`
+		Name     string   `json:"name"`
+	}
+	var v *Var
+	if a.AssociatedVariable != nil {
+		v = &Var{
+			Location: a.AssociatedVariable.LocRange,
+			Name:     a.AssociatedVariable.Name,
+		}
+	}
+	return json.Marshal(struct {
+		Location   LocRange   `json:"location"`
+		Name       Name       `json:"name"`
+		Variable   *Var       `json:"variable,omitempty"`
+		Type       string     `json:"type,omitempty"`
+		Optional   bool       `json:"optional,omitempty"`
+		Absent     bool       `json:"absent,omitempty"`
+		Constraint Expression `json:"constraint,omitempty"`
+	}{
+		Location:   a.LocRange,
+		Name:       a.Name,
+		Variable:   v,
+		Type:       t,
+		Optional:   a.Optional,
+		Absent:     a.Absent,
+		Constraint: a.Constraint,
+	})
+}
+
+func (e Error) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Location LocRange `json:"location"`
+		File     string   `json:"file,omitempty"`
+		Message  string   `json:"message"`
+	}{
+		Location: e.LocRange,
+		File:     e.File,
+		Message:  e.Msg,
+	})
+}
+
+func (v *VariableDeclaration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Location LocRange `json:"location"`
+		Name     string   `json:"name"`
+	}{
+		Location: v.LocRange,
+		Name:     v.Name,
+	})
+}
+
+// errJSON returns an error at location l of a decoded operation.
+func errJSON(l LocRange, format string, a ...any) error {
+	return Error{LocRange: l, Msg: fmt.Sprintf(format, a...)}
+}
+
+// jsonLocRange is a LocRange decoded from its string representation.
+type jsonLocRange LocRange
+
+func (l *jsonLocRange) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	var err error
+	if strings.Contains(s, "-") {
+		_, err = fmt.Sscanf(
+			s, "%d:%d:%d-%d:%d:%d",
+			&l.Index, &l.Line, &l.Column,
+			&l.IndexEnd, &l.LineEnd, &l.ColumnEnd,
+		)
+	} else {
+		_, err = fmt.Sscanf(s, "%d:%d:%d", &l.Index, &l.Line, &l.Column)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid location %q", s)
+	}
+	return nil
+}
+
+// jsonName is a decoded Name or variable declaration.
+type jsonAlias struct {
+	Location jsonLocRange `json:"location"`
+	Pattern  string       `json:"pattern"`
+	Limit    int          `json:"limit"`
+}
+
+func (n *jsonAlias) alias() *Alias {
+	if n == nil {
+		return nil
+	}
+	return &Alias{
+		LocRange: LocRange(n.Location),
+		Pattern:  n.Pattern,
+		Limit:    n.Limit,
+	}
+}
+
+type jsonMetadata struct {
+	Location    jsonLocRange `json:"location"`
+	Description string       `json:"description"`
+	Owner       string       `json:"owner"`
+	Tags        []string     `json:"tags"`
+}
+
+func (n *jsonMetadata) metadata() *Metadata {
+	if n == nil {
+		return nil
+	}
+	return &Metadata{
+		LocRange:    LocRange(n.Location),
+		Description: n.Description,
+		Owner:       n.Owner,
+		Tags:        n.Tags,
+	}
+}
+
+type jsonName struct {
+	Location jsonLocRange `json:"location"`
+	Name     string       `json:"name"`
+}
+
+type jsonSelectionSet struct {
+	Location   jsonLocRange `json:"location"`
+	Selections []*jsonNode  `json:"selections"`
+}
+
+// jsonNode is the union of all decoded expressions.
+// Fields that aren't used by any expression are ignored.
+type jsonNode struct {
+	Location       jsonLocRange `json:"location"`
+	OperationType  string       `json:"operationType"`
+	SelectionType  string       `json:"selectionType"`
+	ConstraintType string       `json:"constraintType"`
+	ExpressionType string       `json:"expressionType"`
+	Type           string       `json:"type"`
+
+	Metadata      *jsonMetadata `json:"metadata"`
+	Alias         *jsonAlias    `json:"alias"`
+	Name          jsonName      `json:"name"`
+	File          string        `json:"file"`
+	Path          string        `json:"path"`
+	Variable      *jsonName     `json:"variable"`
+	TypeCondition struct {
+		Location jsonLocRange `json:"location"`
+		TypeName string       `json:"typeName"`
+		Type     string       `json:"type"`
+	} `json:"typeCondition"`
+	ArgumentList jsonArgumentList `json:"argumentList"`
+	Directives   []*jsonNode      `json:"directives"`
+	SelectionSet jsonSelectionSet `json:"selectionSet"`
+	Fragments    []*jsonNode      `json:"fragments"`
+	Imports      []*jsonNode      `json:"imports"`
+	Aliases      []*jsonNode      `json:"constraintAliases"`
+	Options      jsonSelectionSet `json:"options"`
+	Required     bool             `json:"required"`
+	Limit        int              `json:"limit"`
+	Max          int              `json:"max"`
+	MaxDepth     int              `json:"maxDepth"`
+	Float        bool             `json:"float"`
+	Open         bool             `json:"open"`
+	Optional     bool             `json:"optional"`
+	Absent       bool             `json:"absent"`
+
+	// Value is either an expression or a string
+	Value json.RawMessage `json:"value"`
+
+	Constraint    *jsonNode   `json:"constraint"`
+	Where         *jsonNode   `json:"where"`
+	Expression    *jsonNode   `json:"expression"`
+	Expressions   []*jsonNode `json:"expressions"`
+	Left          *jsonNode   `json:"left"`
+	Right         *jsonNode   `json:"right"`
+	AddendLeft    *jsonNode   `json:"addendLeft"`
+	AddendRight   *jsonNode   `json:"addendRight"`
+	Minuend       *jsonNode   `json:"minuend"`
+	Subtrahend    *jsonNode   `json:"subtrahend"`
+	Multiplicant  *jsonNode   `json:"multiplicant"`
+	Multiplicator *jsonNode   `json:"multiplicator"`
+	Dividend      *jsonNode   `json:"dividend"`
+	Divisor       *jsonNode   `json:"divisor"`
+	Items         []*jsonNode `json:"items"`
+	Values        []*jsonNode `json:"values"`
+	Function      jsonName    `json:"function"`
+	Arguments     []*jsonNode `json:"arguments"`
+	Fields        []*jsonNode `json:"fields"`
+}
+
+func (p *Parser) jsonSelectionSet(n jsonSelectionSet) (SelectionSet, error) {
+	s := SelectionSet{LocRange: LocRange(n.Location)}
+	for _, n := range n.Selections {
+		sel, err := p.jsonSelection(n)
+		if err != nil {
+			return s, err
+		}
+		s.Selections = append(s.Selections, sel)
+	}
+	return s, nil
+}
+
+func (p *Parser) jsonFragment(n *jsonNode) (*FragmentDefinition, error) {
+	if n == nil {
+		return nil, fmt.Errorf("missing fragment definition")
+	}
+	d := &FragmentDefinition{
+		LocRange: LocRange(n.Location),
+		Name: Name{
+			LocRange: LocRange(n.Name.Location),
+			Name:     n.Name.Name,
+		},
+		TypeCondition: TypeCondition{
+			LocRange: LocRange(n.TypeCondition.Location),
+			TypeName: n.TypeCondition.TypeName,
+		},
+		File: n.File,
+	}
+	var err error
+	d.SelectionSet, err = p.jsonSelectionSet(n.SelectionSet)
+	return d, err
+}
+
+func (p *Parser) jsonConstrAliasDef(
+	n *jsonNode,
+) (*ConstrAliasDefinition, error) {
+	if n == nil {
+		return nil, fmt.Errorf("missing constraint alias definition")
+	}
+	// The variables of the constraint aren't linked to declarations
+	vars := p.vars
+	p.vars = newScope()
+	defer func() { p.vars = vars }()
+	c, err := p.jsonExpr(n.Constraint)
+	if err != nil {
+		return nil, err
+	}
+	p.setTypesExpr(c, nil)
+	return &ConstrAliasDefinition{
+		LocRange: LocRange(n.Location),
+		Name: Name{
+			LocRange: LocRange(n.Name.Location),
+			Name:     n.Name.Name,
+		},
+		Constraint: c,
+	}, nil
+}
+
+func (p *Parser) jsonSelection(n *jsonNode) (Selection, error) {
+	if n == nil {
+		return nil, fmt.Errorf("missing selection")
+	}
+	l := LocRange(n.Location)
+	var err error
+	switch n.SelectionType {
+	case "field":
+		e := &SelectionField{
+			LocRange: l,
+			Alias:    n.Alias.alias(),
+			Name: Name{
+				LocRange: LocRange(n.Name.Location),
+				Name:     n.Name.Name,
+			},
+			Required: n.Required,
+		}
+		if e.ArgumentList, err = p.jsonArgumentList(
+			n.ArgumentList,
+		); err != nil {
+			return nil, err
+		}
+		if e.Directives, err = p.jsonDirectives(n.Directives); err != nil {
+			return nil, err
+		}
+		e.SelectionSet, err = p.jsonSelectionSet(n.SelectionSet)
+		return e, err
+	case "inlineFragment":
+		e := &SelectionInlineFrag{
+			LocRange: l,
+			TypeCondition: TypeCondition{
+				LocRange: LocRange(n.TypeCondition.Location),
+				TypeName: n.TypeCondition.TypeName,
+			},
+		}
+		if e.Directives, err = p.jsonDirectives(n.Directives); err != nil {
+			return nil, err
+		}
+		e.SelectionSet, err = p.jsonSelectionSet(n.SelectionSet)
+		return e, err
+	case "max":
+		e := &SelectionMax{LocRange: l, Limit: n.Limit}
+		e.Options, err = p.jsonSelectionSet(n.Options)
+		return e, err
+	case "min":
+		e := &SelectionMin{LocRange: l, Limit: n.Limit}
+		e.Options, err = p.jsonSelectionSet(n.Options)
+		return e, err
+	case "exactly":
+		e := &SelectionExactly{LocRange: l, Limit: n.Limit}
+		e.Options, err = p.jsonSelectionSet(n.Options)
+		return e, err
+	case "recurse":
+		return &SelectionRecurse{LocRange: l, Max: n.Max}, nil
+	case "spread":
+		return &SelectionSpread{
+			LocRange: l,
+			Name: Name{
+				LocRange: LocRange(n.Name.Location),
+				Name:     n.Name.Name,
+			},
+		}, nil
+	}
+	return nil, errJSON(
+		l, "unknown selection type %q", n.SelectionType,
+	)
+}
+
+type jsonArgumentList struct {
+	Location  jsonLocRange `json:"location"`
+	Arguments []*jsonNode  `json:"arguments"`
+}
+
+func (p *Parser) jsonArgumentList(n jsonArgumentList) (ArgumentList, error) {
+	l := ArgumentList{LocRange: LocRange(n.Location)}
+	for _, n := range n.Arguments {
+		a, err := p.jsonArgument(n)
+		if err != nil {
+			return l, err
+		}
+		l.Arguments = append(l.Arguments, a)
+	}
+	return l, nil
+}
+
+func (p *Parser) jsonDirectives(l []*jsonNode) ([]*Directive, error) {
+	var dirs []*Directive
+	for _, n := range l {
+		if n == nil {
+			return nil, fmt.Errorf("missing directive")
+		}
+		d := &Directive{
+			LocRange: LocRange(n.Location),
+			Name: Name{
+				LocRange: LocRange(n.Name.Location),
+				Name:     n.Name.Name,
+			},
+		}
+		var err error
+		if d.ArgumentList, err = p.jsonArgumentList(n.ArgumentList); err != nil {
+			return nil, err
+		}
+		dirs = append(dirs, d)
+	}
+	return dirs, nil
+}
+
+func (p *Parser) jsonArgument(n *jsonNode) (*Argument, error) {
+	if n == nil {
+		return nil, fmt.Errorf("missing argument")
+	}
+	a := &Argument{
+		LocRange: LocRange(n.Location),
+		Name: Name{
+			LocRange: LocRange(n.Name.Location),
+			Name:     n.Name.Name,
+		},
+		Optional: n.Optional,
+		Absent:   n.Absent,
+	}
+	var err error
+	if a.AssociatedVariable, err = p.jsonVarDecl(n.Variable, a); err != nil {
+		return nil, err
+	}
+	if a.Absent {
+		p.checkAbsent(a.LocRange, a.Optional, a.AssociatedVariable)
+		return a, nil
+	}
+	a.Constraint, err = p.jsonExpr(n.Constraint)
+	return a, err
+}
+
+func (p *Parser) jsonObjectField(n *jsonNode) (*ObjectField, error) {
+	if n == nil {
+		return nil, fmt.Errorf("missing object field")
+	}
+	f := &ObjectField{
+		LocRange: LocRange(n.Location),
+		Name: Name{
+			LocRange: LocRange(n.Name.Location),
+			Name:     n.Name.Name,
+		},
+		Optional: n.Optional,
+		Absent:   n.Absent,
+	}
+	var err error
+	if f.AssociatedVariable, err = p.jsonVarDecl(n.Variable, f); err != nil {
+		return nil, err
+	}
+	if f.Absent {
+		p.checkAbsent(f.LocRange, f.Optional, f.AssociatedVariable)
+		return f, nil
+	}
+	f.Constraint, err = p.jsonExpr(n.Constraint)
+	return f, err
+}
+
+// jsonVarDecl returns the declaration of variable n associated
+// with parent, or nil if n is nil.
+func (p *Parser) jsonVarDecl(
+	n *jsonName, parent Expression,
+) (*VariableDeclaration, error) {
+	if n == nil {
+		return nil, nil
+	}
+	if _, ok := p.vars.decls[n.Name]; ok {
+		return nil, errJSON(
+			LocRange(n.Location), "redeclared variable %q", n.Name,
+		)
+	}
+	v := &VariableDeclaration{
+		LocRange: LocRange(n.Location),
+		Name:     n.Name,
+		Parent:   parent,
+	}
+	p.vars.decls[n.Name] = v
+	return v, nil
+}
+
+func (p *Parser) jsonExpr(n *jsonNode) (Expression, error) {
+	if n == nil {
+		return nil, fmt.Errorf("missing expression")
+	}
+	l := LocRange(n.Location)
+
+	var err error
+	expr := func(n *jsonNode) Expression {
+		if err != nil {
+			return nil
+		}
+		var e Expression
+		e, err = p.jsonExpr(n)
+		return e
+	}
+	exprs := func(n []*jsonNode) []Expression {
+		l := make([]Expression, len(n))
+		for i, n := range n {
+			l[i] = expr(n)
+		}
+		return l
+	}
+	value := func() Expression {
+		var v *jsonNode
+		if err == nil {
+			err = json.Unmarshal(n.Value, &v)
+		}
+		return expr(v)
+	}
+	str := func() string {
+		var v string
+		if err == nil {
+			err = json.Unmarshal(n.Value, &v)
+		}
+		return v
+	}
+
+	var e Expression
+	switch n.ConstraintType {
+	case "":
+	case "any":
+		e = &ConstrAny{LocRange: l}
+	case "equals":
+		e = &ConstrEquals{LocRange: l, Value: value()}
+	case "notEquals":
+		e = &ConstrNotEquals{LocRange: l, Value: value()}
+	case "lessThan":
+		e = &ConstrLess{LocRange: l, Value: value()}
+	case "lessThanOrEquals":
+		e = &ConstrLessOrEqual{LocRange: l, Value: value()}
+	case "greaterThan":
+		e = &ConstrGreater{LocRange: l, Value: value()}
+	case "greaterThanOrEquals":
+		e = &ConstrGreaterOrEqual{LocRange: l, Value: value()}
+	case "lengthEquals":
+		e = &ConstrLenEquals{LocRange: l, Value: value()}
+	case "lengthNotEquals":
+		e = &ConstrLenNotEquals{LocRange: l, Value: value()}
+	case "lengthLessThan":
+		e = &ConstrLenLess{LocRange: l, Value: value()}
+	case "lengthLessThanOrEquals":
+		e = &ConstrLenLessOrEqual{LocRange: l, Value: value()}
+	case "lengthGreaterThan":
+		e = &ConstrLenGreater{LocRange: l, Value: value()}
+	case "lengthGreaterThanOrEquals":
+		e = &ConstrLenGreaterOrEqual{LocRange: l, Value: value()}
+	case "matches":
+		e = &ConstrMatches{LocRange: l, Value: value()}
+	case "in":
+		e = &ConstrIn{LocRange: l, Values: exprs(n.Values)}
+	case "notIn":
+		e = &ConstrNotIn{LocRange: l, Values: exprs(n.Values)}
+	case "unique":
+		e = &ConstrUnique{LocRange: l}
+	case "contains":
+		e = &ConstrContains{LocRange: l, Value: value()}
+	case "sum":
+		e = &ConstrSum{LocRange: l, Constraint: expr(n.Constraint)}
+	case "count":
+		e = &ConstrCount{
+			LocRange:   l,
+			Where:      expr(n.Where),
+			Constraint: expr(n.Constraint),
+		}
+	case "map":
+		e = &ConstrMap{LocRange: l, Constraint: expr(n.Constraint)}
+	case "alias":
+		e = &ConstrAlias{
+			LocRange: l,
+			Name: Name{
+				LocRange: LocRange(n.Name.Location),
+				Name:     n.Name.Name,
+			},
+			File:       n.File,
+			Constraint: expr(n.Constraint),
+		}
+	default:
+		return nil, errJSON(
+			l, "unknown constraint type %q", n.ConstraintType,
+		)
+	}
+	if e != nil {
+		return e, err
+	}
+
+	switch n.ExpressionType {
+	case "parentheses":
+		e = &ExprParentheses{LocRange: l, Expression: expr(n.Expression)}
+	case "logicalNegation":
+		e = &ExprLogicalNegation{LocRange: l, Expression: expr(n.Expression)}
+	case "numericNegation":
+		e = &ExprNumericNegation{
+			LocRange: l, Expression: expr(n.Expression), Float: n.Float,
+		}
+	case "call":
+		e = &ExprCall{
+			LocRange: l,
+			Function: Name{
+				LocRange: LocRange(n.Function.Location),
+				Name:     n.Function.Name,
+			},
+			Arguments: exprs(n.Arguments),
+			Float:     n.Float,
+		}
+	case "modulo":
+		e = &ExprModulo{
+			LocRange: l,
+			Dividend: expr(n.Dividend),
+			Divisor:  expr(n.Divisor),
+			Float:    n.Float,
+		}
+	case "division":
+		e = &ExprDivision{
+			LocRange: l,
+			Dividend: expr(n.Dividend),
+			Divisor:  expr(n.Divisor),
+			Float:    n.Float,
+		}
+	case "multiplication":
+		e = &ExprMultiplication{
+			LocRange:      l,
+			Multiplicant:  expr(n.Multiplicant),
+			Multiplicator: expr(n.Multiplicator),
+			Float:         n.Float,
+		}
+	case "addition":
+		e = &ExprAddition{
+			LocRange:    l,
+			AddendLeft:  expr(n.AddendLeft),
+			AddendRight: expr(n.AddendRight),
+			Float:       n.Float,
+		}
+	case "subtraction":
+		e = &ExprSubtraction{
+			LocRange:   l,
+			Minuend:    expr(n.Minuend),
+			Subtrahend: expr(n.Subtrahend),
+			Float:      n.Float,
+		}
+	case "equals":
+		e = &ExprEqual{LocRange: l, Left: expr(n.Left), Right: expr(n.Right)}
+	case "notEquals":
+		e = &ExprNotEqual{LocRange: l, Left: expr(n.Left), Right: expr(n.Right)}
+	case "lessThan":
+		e = &ExprLess{LocRange: l, Left: expr(n.Left), Right: expr(n.Right)}
+	case "lessThanOrEquals":
+		e = &ExprLessOrEqual{
+			LocRange: l, Left: expr(n.Left), Right: expr(n.Right),
+		}
+	case "greaterThan":
+		e = &ExprGreater{LocRange: l, Left: expr(n.Left), Right: expr(n.Right)}
+	case "greaterThanOrEquals":
+		e = &ExprGreaterOrEqual{
+			LocRange: l, Left: expr(n.Left), Right: expr(n.Right),
+		}
+	case "logicalAND":
+		e = &ExprLogicalAnd{LocRange: l, Expressions: exprs(n.Expressions)}
+	case "logicalOR":
+		e = &ExprLogicalOr{LocRange: l, Expressions: exprs(n.Expressions)}
+	case "true":
+		e = &True{LocRange: l}
+	case "false":
+		e = &False{LocRange: l}
+	case "null":
+		e = &Null{LocRange: l}
+	case "number":
+		v := str()
+		e = &Number{
+			LocRange: l,
+			Value:    v,
+			isFloat:  strings.ContainsAny(v, ".eE"),
+		}
+	case "string":
+		e = &String{LocRange: l, Value: str()}
+	case "enum":
+		e = &Enum{LocRange: l, Value: str()}
+	case "array":
+		e = &Array{LocRange: l, Items: exprs(n.Items)}
+	case "object":
+		o := &Object{LocRange: l, Open: n.Open}
+		for _, n := range n.Fields {
+			if err != nil {
+				break
+			}
+			var f *ObjectField
+			f, err = p.jsonObjectField(n)
+			o.Fields = append(o.Fields, f)
+		}
+		e = o
+	case "variableReference":
+		v := &Variable{
+			LocRange: l,
+			Name: Name{
+				LocRange: LocRange(n.Name.Location),
+				Name:     n.Name.Name,
+			},
+		}
+		p.vars.refs = append(p.vars.refs, v)
+		e = v
+	default:
+		return nil, errJSON(
+			l, "unknown expression type %q", n.ExpressionType,
+		)
+	}
+	return e, err
+}
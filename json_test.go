@@ -0,0 +1,176 @@
+package gqt_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/graph-guard/gqt/v4"
+	"github.com/stretchr/testify/require"
+	yaml "gopkg.in/yaml.v3"
+)
+
+func TestJSONRoundTrip(t *testing.T) {
+	d, err := fs.ReadDir(testsFS, "tests")
+	require.NoError(t, err)
+
+	for _, do := range d {
+		fileName := do.Name()
+		if do.IsDir() || !strings.HasSuffix(fileName, ".yml") {
+			continue
+		}
+		f, err := testsFS.ReadFile(filepath.Join("tests", fileName))
+		require.NoError(t, err, "reading YAML test file")
+		t.Run(strings.TrimSuffix(fileName, ".yml"), func(t *testing.T) {
+			var ts struct {
+				Schema   string `yaml:"schema"`
+				Template string `yaml:"template"`
+			}
+			require.NoError(t, yaml.Unmarshal(f, &ts))
+
+			schemaAware, err := gqt.NewParser([]gqt.Source{
+				{Name: "schema.graphqls", Content: ts.Schema},
+			})
+			require.NoError(t, err)
+			schemaless, err := gqt.NewParser(nil)
+			require.NoError(t, err)
+
+			for _, p := range []*gqt.Parser{schemaAware, schemaless} {
+				opr, vars, errs := p.Parse([]byte(ts.Template))
+				if len(errs) > 0 {
+					continue
+				}
+				var j bytes.Buffer
+				require.NoError(t, gqt.WriteJSON(&j, opr))
+
+				decoded, decodedVars, err := p.ReadJSON(
+					bytes.NewReader(j.Bytes()),
+				)
+				require.NoError(t, err, "JSON:\n%s", j.String())
+
+				var y, yDecoded bytes.Buffer
+				require.NoError(t, gqt.WriteYAML(&y, opr))
+				require.NoError(t, gqt.WriteYAML(&yDecoded, decoded))
+				require.Equal(t, y.String(), yDecoded.String())
+
+				var j2 bytes.Buffer
+				require.NoError(t, gqt.WriteJSON(&j2, decoded))
+				require.Equal(t, j.String(), j2.String())
+
+				gqt.Walk(decoded, &parentChecker{t: t})
+
+				require.Len(t, decodedVars, len(vars))
+				for n, v := range vars {
+					dv := decodedVars[n]
+					require.NotNil(t, dv, "variable %q", n)
+					require.Equal(t, v.LocRange, dv.LocRange)
+					require.Len(t, dv.References, len(v.References))
+					for _, r := range dv.References {
+						require.Equal(t, dv, r.(*gqt.Variable).Declaration)
+					}
+					require.Equal(t, dv, variableOf(dv.Parent))
+				}
+			}
+		})
+	}
+}
+
+func variableOf(e gqt.Expression) *gqt.VariableDeclaration {
+	switch e := e.(type) {
+	case *gqt.Argument:
+		return e.AssociatedVariable
+	case *gqt.ObjectField:
+		return e.AssociatedVariable
+	}
+	return nil
+}
+
+func TestReadJSONErr(t *testing.T) {
+	for _, td := range []struct {
+		name   string
+		input  string
+		expect string
+	}{
+		{
+			name:   "syntax",
+			input:  `{`,
+			expect: "decoding JSON: unexpected EOF",
+		},
+		{
+			name:   "unknown_field",
+			input:  `{"operationType":"Query","foo":1}`,
+			expect: `decoding JSON: json: unknown field "foo"`,
+		},
+		{
+			name:   "operation_type",
+			input:  `{"operationType":"Foo"}`,
+			expect: `unknown operation type "Foo"`,
+		},
+		{
+			name:   "location",
+			input:  `{"operationType":"Query","location":"x"}`,
+			expect: `decoding JSON: invalid location "x"`,
+		},
+		{
+			name: "selection_type",
+			input: `{"operationType":"Query","selectionSet":{"selections":[
+				{"location":"8:1:9-9:1:10","selectionType":"foo"}
+			]}}`,
+			expect: `1:9: unknown selection type "foo"`,
+		},
+		{
+			name: "undefined_variable",
+			input: `{"operationType":"Query","selectionSet":{"selections":[
+				{"selectionType":"field","name":{"name":"f"},
+				"argumentList":{"arguments":[{"name":{"name":"a"},
+				"constraint":{"location":"10:1:11-12:1:13",
+				"constraintType":"equals","value":{
+				"location":"10:1:11-12:1:13",
+				"expressionType":"variableReference","name":{"name":"x"}
+				}}}]}}
+			]}}`,
+			expect: `1:11: undefined variable "x"`,
+		},
+		{
+			name: "invalid",
+			input: `{"operationType":"Query","selectionSet":{"selections":[
+				{"location":"8:1:9-9:1:10","selectionType":"field",
+				"name":{"location":"8:1:9-9:1:10","name":"f"},
+				"argumentList":{"arguments":[{"name":{"name":"a"},
+				"constraint":{"location":"10:1:11-12:1:13",
+				"constraintType":"lessThan","value":{
+				"location":"10:1:11-12:1:13",
+				"expressionType":"string","value":"x"
+				}}}]}}
+			]}}`,
+			expect: `1:11: expected number but received String`,
+		},
+	} {
+		t.Run(td.name, func(t *testing.T) {
+			opr, vars, err := gqt.ReadJSON(strings.NewReader(td.input))
+			require.Error(t, err)
+			require.Equal(t, td.expect, err.Error())
+			require.Nil(t, opr)
+			require.Nil(t, vars)
+		})
+	}
+}
+
+func TestErrorJSON(t *testing.T) {
+	l := gqt.LocRange{
+		Location:    gqt.Location{Index: 8, Line: 1, Column: 9},
+		LocationEnd: gqt.LocationEnd{IndexEnd: 9, LineEnd: 1, ColumnEnd: 10},
+	}
+	b, err := json.Marshal(gqt.Error{LocRange: l, Msg: "foo"})
+	require.NoError(t, err)
+	require.JSONEq(t, `{"location":"8:1:9-9:1:10","message":"foo"}`, string(b))
+
+	b, err = json.Marshal(gqt.Error{LocRange: l, Msg: "foo", File: "lib.gqt"})
+	require.NoError(t, err)
+	require.JSONEq(t,
+		`{"location":"8:1:9-9:1:10","file":"lib.gqt","message":"foo"}`,
+		string(b))
+}
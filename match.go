@@ -0,0 +1,1992 @@
+package gqt
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	ast "github.com/vektah/gqlparser/v2/ast"
+)
+
+// Match returns true if the operation of the GraphQL request document doc
+// selected by operationName is accepted by the template operation op,
+// otherwise returns false. operationName can be empty if doc contains
+// only a single operation. variables provides the values of the variables
+// used by the request (the "variables" object of a GraphQL request).
+//
+// A request is accepted when:
+//
+//   - the operation type matches the template operation type.
+//   - all selected fields and inline fragments are defined in the template
+//     and all required fields are selected.
+//   - the number of selected options of every max, min and exactly set
+//     is within its limits.
+//   - all arguments are defined in the template and all template arguments
+//     are either provided or have a default value defined in the schema.
+//   - all argument values satisfy the argument constraints.
+//
+// Template variables ($name) are bound to the values of the arguments
+// and object fields they're associated with. A variable bound to
+// different values in the same request is a mismatch.
+//
+// Match returns an error if the request is malformed, for example
+// if the operation can't be found or a fragment is undefined.
+// Use MatchReport to find out why a request was rejected.
+func Match(
+	op *Operation,
+	doc *ast.QueryDocument,
+	operationName string,
+	variables map[string]any,
+) (bool, error) {
+	m, o, err := newMatcher(doc, operationName, variables)
+	if err != nil {
+		return false, err
+	}
+	ok := m.match(op, o)
+	if m.err != nil {
+		return false, m.err
+	}
+	return ok, nil
+}
+
+// MatchReport is similar to Match but instead of stopping at the first
+// mismatch it returns a report listing all violations.
+// The request is accepted if the report contains no violations.
+func MatchReport(
+	op *Operation,
+	doc *ast.QueryDocument,
+	operationName string,
+	variables map[string]any,
+) (*Report, error) {
+	m, o, err := newMatcher(doc, operationName, variables)
+	if err != nil {
+		return nil, err
+	}
+	m.report = &Report{}
+	m.match(op, o)
+	if m.err != nil {
+		return nil, m.err
+	}
+	return m.report, nil
+}
+
+// Report is the result of matching a request against a template.
+type Report struct {
+	Violations []Violation
+}
+
+// Accepted returns true if the request was accepted.
+func (r *Report) Accepted() bool { return len(r.Violations) < 1 }
+
+// Violation describes why a request was rejected.
+type Violation struct {
+	// Expression is the template node that was violated.
+	// For undefined fields and arguments it's the node hosting them.
+	Expression Expression
+
+	// Path is the path to the violating part of the request,
+	// for example: "user.friends.limit" or "user.pictures.tags[3]".
+	// Aliased fields are represented by their alias.
+	Path string
+
+	// Value is the actual value of the request, which is either
+	// nil, bool, int64, float64, string, []any or map[string]any.
+	// Value is nil for violations of the selection structure.
+	Value any
+
+	// Type is the type designation of the violated expression.
+	Type string
+
+	// Msg is the human readable description of the violation.
+	Msg string
+
+	// File is the name of the imported file defining Expression
+	// or empty if it's defined in the template itself.
+	File string
+}
+
+func (v Violation) Error() string {
+	l := v.Expression.GetLocation()
+	loc := fmt.Sprintf("%d:%d", l.Line, l.Column)
+	if v.File != "" {
+		loc = v.File + ":" + loc
+	}
+	if v.Path == "" {
+		return fmt.Sprintf("%s: %s", loc, v.Msg)
+	}
+	return fmt.Sprintf("%s (%s): %s", v.Path, loc, v.Msg)
+}
+
+// matcher matches a GraphQL request against a template
+// by walking the template's abstract syntax tree.
+type matcher struct {
+	doc     *ast.QueryDocument
+	in      input
+	vars    map[*VariableDeclaration]value
+	checks  []check
+	spreads []string
+	err     error
+
+	// op is the template operation being matched.
+	op *Operation
+
+	// depth is the number of fields enclosing the current selection set.
+	depth int
+
+	// recursions counts the levels of every recursion
+	// enclosing the current selection set.
+	recursions map[*SelectionRecurse]int
+
+	// report is nil unless all violations are to be reported.
+	report *Report
+}
+
+// check is a deferred constraint check.
+// Constraints are checked only after all variables are bound.
+type check struct {
+	Constraint Expression
+	Value      value
+	Path       *path
+}
+
+func newMatcher(
+	doc *ast.QueryDocument,
+	operationName string,
+	variables map[string]any,
+) (*matcher, *ast.OperationDefinition, error) {
+	o, err := findOperation(doc, operationName, variables)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &matcher{
+		doc: doc,
+		in: input{
+			vars: variables,
+			defs: o.VariableDefinitions,
+		},
+		vars: make(map[*VariableDeclaration]value),
+	}, o, nil
+}
+
+// findOperation returns the operation called operationName from doc.
+// Returns an error if the operation can't be found or
+// if variables contains values of unsupported types.
+func findOperation(
+	doc *ast.QueryDocument,
+	operationName string,
+	variables map[string]any,
+) (*ast.OperationDefinition, error) {
+	if doc == nil {
+		return nil, fmt.Errorf("missing query document")
+	}
+	o := doc.Operations.ForName(operationName)
+	if o == nil {
+		if operationName == "" {
+			return nil, fmt.Errorf(
+				"operation name is required for documents " +
+					"with multiple operations",
+			)
+		}
+		return nil, fmt.Errorf("operation %q not found", operationName)
+	}
+	if err := checkVariables(variables); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// violation records a violation if a report is requested.
+// Returns true if matching should continue.
+func (m *matcher) violation(
+	e Expression, p *path, v value, msg string,
+) (proceed bool) {
+	if m.report == nil {
+		return false
+	}
+	x := Violation{
+		Expression: e,
+		Path:       p.String(),
+		Type:       e.TypeDesignation(),
+		Msg:        msg,
+		File:       fileOf(e),
+	}
+	if f, ok := e.(*ObjectField); ok {
+		// Like arguments, object fields are designated
+		// by the type of their constraint.
+		if f.Absent {
+			x.Type = "absent"
+		} else {
+			x.Type = f.Constraint.TypeDesignation()
+		}
+	}
+	if v.kind != valueUndefined {
+		x.Value = m.in.toAny(v)
+	}
+	m.report.Violations = append(m.report.Violations, x)
+	return true
+}
+
+func (m *matcher) match(op *Operation, o *ast.OperationDefinition) bool {
+	m.op = op
+	if op.Type != operationType(o.Operation) {
+		m.violation(op, nil, value{}, fmt.Sprintf(
+			"expected %s operation", strings.ToLower(op.Type.String()),
+		))
+		return false
+	}
+	ok := m.matchSelSet(op, op.SelectionSet, op.Type.String(), o.SelectionSet, nil)
+	if !ok && m.report == nil {
+		return false
+	}
+	for _, c := range m.checks {
+		if !m.check(c.Constraint, c.Value) {
+			ok = false
+			if m.report == nil {
+				return false
+			}
+			m.explain(c.Constraint, c.Value, c.Path)
+		}
+	}
+	return ok
+}
+
+func (m *matcher) matchSelSet(
+	host Expression,
+	set SelectionSet,
+	hostType string,
+	sels ast.SelectionSet,
+	p *path,
+) bool {
+	selected := map[Expression]struct{}{}
+	keys := map[*SelectionField][]string{}
+	ok := m.matchSelections(host, set, hostType, sels, p, selected, keys)
+	if !ok && m.report == nil {
+		return false
+	}
+	if !m.checkSelected(set, hostType, selected, p) {
+		if ok = false; m.report == nil {
+			return false
+		}
+	}
+	if !m.checkAliasLimits(set, hostType, keys, p) {
+		ok = false
+	}
+	return ok
+}
+
+// checkSelected records violations for all required fields of set
+// that weren't selected and all max, min and exactly sets of which
+// the number of selected options is out of limits.
+// Returns false if there are any.
+func (m *matcher) checkSelected(
+	set SelectionSet,
+	hostType string,
+	selected map[Expression]struct{},
+	p *path,
+) (ok bool) {
+	ok = true
+	for _, s := range set.Selections {
+		var msg string
+		switch s := s.(type) {
+		case *SelectionField:
+			if _, sel := selected[s]; s.Required && !sel {
+				msg = fmt.Sprintf("field %q is required", s.Name.Name)
+			}
+		case *SelectionMax:
+			if n := countSelected(s.Options, selected); n > s.Limit {
+				msg = fmt.Sprintf(
+					"%d options selected, max %d allowed", n, s.Limit,
+				)
+			}
+		case *SelectionMin:
+			if n := countSelected(s.Options, selected); n < s.Limit {
+				msg = fmt.Sprintf(
+					"%d options selected, min %d required", n, s.Limit,
+				)
+			}
+		case *SelectionExactly:
+			if n := countSelected(s.Options, selected); n != s.Limit {
+				msg = fmt.Sprintf(
+					"%d options selected, exactly %d required", n, s.Limit,
+				)
+			}
+		case *SelectionSpread:
+			if s.merged(hostType) && !m.checkSelected(
+				s.Fragment.SelectionSet, hostType, selected, p,
+			) {
+				if ok = false; m.report == nil {
+					return false
+				}
+			}
+		}
+		if msg != "" {
+			ok = false
+			if !m.violation(s, p, value{}, msg) {
+				return false
+			}
+		}
+	}
+	return ok
+}
+
+// checkAliasLimits records violations for all fields of set that were
+// selected under more distinct response keys than their alias allows.
+// Returns false if any limit is exceeded.
+func (m *matcher) checkAliasLimits(
+	set SelectionSet,
+	hostType string,
+	keys map[*SelectionField][]string,
+	p *path,
+) (ok bool) {
+	ok = true
+	for _, s := range set.Selections {
+		switch s := s.(type) {
+		case *SelectionField:
+			if s.Alias == nil || s.Alias.Limit < 1 {
+				continue
+			}
+			if n := len(keys[s]); n > s.Alias.Limit {
+				ok = false
+				if !m.violation(s, p, value{}, fmt.Sprintf(
+					"field %q selected %d times, max %d allowed",
+					s.Name.Name, n, s.Alias.Limit,
+				)) {
+					return false
+				}
+			}
+		case *SelectionRecurse:
+			f := recursiveField(s)
+			if f.Alias == nil || f.Alias.Limit < 1 {
+				continue
+			}
+			if n := len(keys[f]); n > f.Alias.Limit {
+				ok = false
+				if !m.violation(f, p, value{}, fmt.Sprintf(
+					"field %q selected %d times, max %d allowed",
+					f.Name.Name, n, f.Alias.Limit,
+				)) {
+					return false
+				}
+			}
+		case optionSet:
+			if !m.checkAliasLimits(*s.options(), hostType, keys, p) {
+				if ok = false; m.report == nil {
+					return false
+				}
+			}
+		case *SelectionSpread:
+			if s.merged(hostType) && !m.checkAliasLimits(
+				s.Fragment.SelectionSet, hostType, keys, p,
+			) {
+				if ok = false; m.report == nil {
+					return false
+				}
+			}
+		}
+	}
+	return ok
+}
+
+// alias checks the response key of field rf selected as f against
+// the alias pattern of f and records it in keys.
+func (m *matcher) alias(
+	f *SelectionField,
+	rf *ast.Field,
+	p *path,
+	keys map[*SelectionField][]string,
+) bool {
+	key := responseKey(rf)
+	if key != rf.Name && !f.Alias.matches(key) {
+		m.violation(f, p, value{}, fmt.Sprintf(
+			"alias %q doesn't match pattern %q", key, f.Alias.Pattern,
+		))
+		return false
+	}
+	for _, k := range keys[f] {
+		if k == key {
+			return true
+		}
+	}
+	keys[f] = append(keys[f], key)
+	return true
+}
+
+// recurse matches field rf against the field r recurses into
+// unless the recursion is already r.Max levels deep.
+func (m *matcher) recurse(
+	r *SelectionRecurse,
+	rf *ast.Field,
+	p *path,
+	keys map[*SelectionField][]string,
+) bool {
+	if m.recursions[r] >= r.Max {
+		m.violation(r, p, value{}, fmt.Sprintf(
+			"max recursion depth %d exceeded", r.Max,
+		))
+		return false
+	}
+	f := recursiveField(r)
+	if f.Alias != nil && !m.alias(f, rf, p, keys) {
+		return false
+	}
+	if m.recursions == nil {
+		m.recursions = make(map[*SelectionRecurse]int)
+	}
+	m.recursions[r]++
+	ok := m.matchField(f, rf, p)
+	m.recursions[r]--
+	return ok
+}
+
+// matchSelections matches sels against set adding all
+// selected template selections to selected.
+func (m *matcher) matchSelections(
+	host Expression,
+	set SelectionSet,
+	hostType string,
+	sels ast.SelectionSet,
+	p *path,
+	selected map[Expression]struct{},
+	keys map[*SelectionField][]string,
+) (ok bool) {
+	ok = true
+	for _, s := range sels {
+		switch s := s.(type) {
+		case *ast.Field:
+			fp := p.field(s)
+			f := findField(set, hostType, s.Name)
+			if f == nil {
+				if r := findRecurse(set, s.Name); r != nil {
+					if !m.recurse(r, s, fp, keys) {
+						if ok = false; m.report == nil {
+							return false
+						}
+					}
+					continue
+				}
+				ok = false
+				if !m.violation(host, fp, value{}, fmt.Sprintf(
+					"field %q is not allowed", s.Name,
+				)) {
+					return false
+				}
+				continue
+			}
+			selected[f] = struct{}{}
+			if f.Alias != nil && !m.alias(f, s, fp, keys) {
+				if ok = false; m.report == nil {
+					return false
+				}
+				continue
+			}
+			if !m.matchField(f, s, fp) {
+				if ok = false; m.report == nil {
+					return false
+				}
+			}
+		case *ast.InlineFragment:
+			if !m.matchFrag(
+				host, set, hostType, s.TypeCondition, s.Directives,
+				s.SelectionSet, p, selected, keys,
+			) {
+				if ok = false; m.report == nil {
+					return false
+				}
+			}
+		case *ast.FragmentSpread:
+			d := m.doc.Fragments.ForName(s.Name)
+			if d == nil {
+				m.err = fmt.Errorf("fragment %q is undefined", s.Name)
+				return false
+			}
+			for _, n := range m.spreads {
+				if n == s.Name {
+					m.err = fmt.Errorf("fragment %q spreads itself", s.Name)
+					return false
+				}
+			}
+			m.spreads = append(m.spreads, s.Name)
+			fok := m.matchFrag(
+				host, set, hostType, d.TypeCondition, s.Directives,
+				d.SelectionSet, p, selected, keys,
+			)
+			m.spreads = m.spreads[:len(m.spreads)-1]
+			if m.err != nil {
+				return false
+			}
+			if !fok {
+				if ok = false; m.report == nil {
+					return false
+				}
+			}
+		}
+	}
+	return ok
+}
+
+// matchFrag matches the selections and the directives dirs of a fragment
+// with the type condition typeCond. Fragments without a type condition
+// and fragments conditioned on the host type are merged into the host
+// selection set and may only use the directives of the template's inline
+// fragment conditioned on the host type.
+func (m *matcher) matchFrag(
+	host Expression,
+	set SelectionSet,
+	hostType, typeCond string,
+	dirs ast.DirectiveList,
+	sels ast.SelectionSet,
+	p *path,
+	selected map[Expression]struct{},
+	keys map[*SelectionField][]string,
+) bool {
+	if typeCond == "" || typeCond == hostType {
+		ok := true
+		if len(dirs) > 0 {
+			frag, fragDirs := host, []*Directive(nil)
+			if f := findInlineFrag(set, hostType, hostType); f != nil {
+				frag, fragDirs = f, f.Directives
+			}
+			if ok = m.matchDirectives(frag, fragDirs, dirs, p); !ok &&
+				m.report == nil {
+				return false
+			}
+		}
+		if !m.matchSelections(host, set, hostType, sels, p, selected, keys) {
+			ok = false
+		}
+		return ok
+	}
+	var frag Expression
+	var fragDirs []*Directive
+	var fragSet SelectionSet
+	if f := findInlineFrag(set, hostType, typeCond); f != nil {
+		frag, fragDirs, fragSet = f, f.Directives, f.SelectionSet
+	} else if s := findSpread(set, hostType, typeCond); s != nil {
+		frag, fragSet = s, s.Fragment.SelectionSet
+	} else {
+		m.violation(host, p, value{}, fmt.Sprintf(
+			"fragment on type %s is not allowed", typeCond,
+		))
+		return false
+	}
+	selected[frag] = struct{}{}
+	ok := m.matchDirectives(frag, fragDirs, dirs, p)
+	if !ok && m.report == nil {
+		return false
+	}
+	if !m.matchSelSet(frag, fragSet, typeCond, sels, p) {
+		ok = false
+	}
+	return ok
+}
+
+func (m *matcher) matchField(
+	f *SelectionField, rf *ast.Field, p *path,
+) (ok bool) {
+	if m.op.MaxDepth > 0 && m.depth >= m.op.MaxDepth {
+		m.violation(m.op, p, value{}, fmt.Sprintf(
+			"max depth %d exceeded", m.op.MaxDepth,
+		))
+		return false
+	}
+	m.depth++
+	defer func() { m.depth-- }()
+
+	ok = true
+	if !m.matchArguments(f, f.Arguments, rf.Arguments, p) {
+		if ok = false; m.report == nil {
+			return false
+		}
+	}
+	if !m.matchDirectives(f, f.Directives, rf.Directives, p) {
+		if ok = false; m.report == nil {
+			return false
+		}
+	}
+	if !m.matchSelSet(f, f.SelectionSet, fieldTypeName(f), rf.SelectionSet, p) {
+		ok = false
+	}
+	return ok
+}
+
+// matchDirectives matches the directives rd of a request field or
+// inline fragment against the directives dirs allowed by host.
+func (m *matcher) matchDirectives(
+	host Expression, dirs []*Directive, rd ast.DirectiveList, p *path,
+) (ok bool) {
+	ok = true
+	for _, d := range rd {
+		dp := p.name("@" + d.Name)
+		x := findDirective(dirs, d.Name)
+		if x == nil {
+			ok = false
+			if !m.violation(host, dp, value{}, fmt.Sprintf(
+				"directive %q is not allowed", "@"+d.Name,
+			)) {
+				return false
+			}
+			continue
+		}
+		if !m.matchArguments(x, x.Arguments, d.Arguments, dp) {
+			if ok = false; m.report == nil {
+				return false
+			}
+		}
+	}
+	return ok
+}
+
+// matchArguments matches the arguments rargs of a request field
+// or directive against the arguments args of host.
+func (m *matcher) matchArguments(
+	host Expression, args []*Argument, rargs ast.ArgumentList, p *path,
+) (ok bool) {
+	ok = true
+	for _, a := range rargs {
+		if findArgument(args, a.Name) == nil {
+			ok = false
+			if !m.violation(host, p.name(a.Name), m.in.fromAST(a.Value),
+				fmt.Sprintf("argument %q is not allowed", a.Name)) {
+				return false
+			}
+		}
+	}
+	for _, a := range args {
+		ap := p.name(a.Name.Name)
+		var v value
+		if ra := rargs.ForName(a.Name.Name); ra != nil {
+			v = m.in.fromAST(ra.Value)
+		}
+		if a.Absent {
+			if v.kind != valueUndefined {
+				ok = false
+				if !m.violation(a, ap, v, "argument must be absent") {
+					return false
+				}
+			}
+			continue
+		}
+		if v.kind == valueUndefined {
+			if a.Def == nil || a.Def.DefaultValue == nil {
+				if a.Optional {
+					continue
+				}
+				ok = false
+				if !m.violation(a, ap, v, "missing argument") {
+					return false
+				}
+				continue
+			}
+			v = m.in.fromAST(a.Def.DefaultValue)
+		}
+		if a.AssociatedVariable != nil && !m.bind(a.AssociatedVariable, v, ap) {
+			if ok = false; m.report == nil {
+				return false
+			}
+		}
+		if !m.bindObjectVars(a.Constraint, v, ap) {
+			if ok = false; m.report == nil {
+				return false
+			}
+		}
+		m.checks = append(m.checks, check{
+			Constraint: a.Constraint,
+			Value:      v,
+			Path:       ap,
+		})
+	}
+	return ok
+}
+
+// bind binds v to the variable d and returns true,
+// returns false if d is already bound to a different value.
+func (m *matcher) bind(d *VariableDeclaration, v value, p *path) bool {
+	if b, ok := m.vars[d]; ok {
+		if !m.in.equal(b, v) {
+			m.violation(d.Parent, p, v, fmt.Sprintf(
+				"conflicting values for variable %q", d.Name,
+			))
+			return false
+		}
+		return true
+	}
+	m.vars[d] = v
+	return true
+}
+
+// bindObjectVars binds the values of the fields of object v to
+// the variables associated with the object fields in constraint e.
+func (m *matcher) bindObjectVars(e Expression, v value, p *path) bool {
+	switch e := e.(type) {
+	case *ConstrEquals:
+		o, ok := e.Value.(*Object)
+		if !ok || v.kind != valueObject {
+			return true
+		}
+		for _, f := range o.Fields {
+			fv, fp := m.objectField(f, v), p.name(f.Name.Name)
+			if fv.kind == valueUndefined {
+				continue
+			}
+			if f.AssociatedVariable != nil &&
+				!m.bind(f.AssociatedVariable, fv, fp) {
+				return false
+			}
+			if !m.bindObjectVars(f.Constraint, fv, fp) {
+				return false
+			}
+		}
+	case *ExprParentheses:
+		return m.bindObjectVars(e.Expression, v, p)
+	case *ConstrAlias:
+		return m.bindObjectVars(e.Constraint, v, p)
+	case *ExprLogicalAnd:
+		for _, e := range e.Expressions {
+			if !m.bindObjectVars(e, v, p) {
+				return false
+			}
+		}
+	case *ExprLogicalOr:
+		for _, e := range e.Expressions {
+			if !m.bindObjectVars(e, v, p) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// objectField returns the value of field f of object v
+// or the default value if the field isn't provided.
+// Returns an undefined value if neither exist.
+func (m *matcher) objectField(f *ObjectField, v value) value {
+	fv := m.in.field(v, f.Name.Name)
+	if fv.kind == valueUndefined && f.Def != nil && f.Def.DefaultValue != nil {
+		return m.in.fromAST(f.Def.DefaultValue)
+	}
+	return fv
+}
+
+// check returns true if v satisfies the constraint e.
+func (m *matcher) check(e Expression, v value) bool {
+	switch e := e.(type) {
+	case *ConstrAny:
+		return true
+	case *ConstrEquals:
+		return m.checkEq(e.Value, v)
+	case *ConstrNotEquals:
+		return !m.checkEq(e.Value, v)
+	case *ConstrLess:
+		c, ok := m.compare(v, e.Value)
+		return ok && c < 0
+	case *ConstrLessOrEqual:
+		c, ok := m.compare(v, e.Value)
+		return ok && c <= 0
+	case *ConstrGreater:
+		c, ok := m.compare(v, e.Value)
+		return ok && c > 0
+	case *ConstrGreaterOrEqual:
+		c, ok := m.compare(v, e.Value)
+		return ok && c >= 0
+	case *ConstrLenEquals:
+		c, ok := m.compareLen(v, e.Value)
+		return ok && c == 0
+	case *ConstrLenNotEquals:
+		c, ok := m.compareLen(v, e.Value)
+		return ok && c != 0
+	case *ConstrLenLess:
+		c, ok := m.compareLen(v, e.Value)
+		return ok && c < 0
+	case *ConstrLenLessOrEqual:
+		c, ok := m.compareLen(v, e.Value)
+		return ok && c <= 0
+	case *ConstrLenGreater:
+		c, ok := m.compareLen(v, e.Value)
+		return ok && c > 0
+	case *ConstrLenGreaterOrEqual:
+		c, ok := m.compareLen(v, e.Value)
+		return ok && c >= 0
+	case *ConstrMatches:
+		re := e.Regexp()
+		return re != nil && v.kind == valueString && re.MatchString(v.s)
+	case *ConstrIn:
+		set := e.set
+		if set == nil {
+			set, _ = newValueSet(e.Values)
+		}
+		return set.contains(v)
+	case *ConstrNotIn:
+		set := e.set
+		if set == nil {
+			set, _ = newValueSet(e.Values)
+		}
+		return !set.contains(v)
+	case *ConstrUnique:
+		return v.kind == valueList && m.in.unique(v)
+	case *ConstrContains:
+		if v.kind != valueList {
+			return false
+		}
+		for i, l := 0, m.in.len(v); i < l; i++ {
+			if m.checkEq(e.Value, m.in.index(v, i)) {
+				return true
+			}
+		}
+		return false
+	case *ConstrSum:
+		sum, ok := m.in.sum(v)
+		return ok && m.check(e.Constraint, sum)
+	case *ConstrCount:
+		if v.kind != valueList {
+			return false
+		}
+		n := 0
+		for i, l := 0, m.in.len(v); i < l; i++ {
+			if m.check(e.Where, m.in.index(v, i)) {
+				n++
+			}
+		}
+		return m.check(e.Constraint, value{kind: valueInt, i: int64(n)})
+	case *ConstrMap:
+		if v.kind != valueList {
+			return false
+		}
+		for i, l := 0, m.in.len(v); i < l; i++ {
+			if !m.check(e.Constraint, m.in.index(v, i)) {
+				return false
+			}
+		}
+		return true
+	case *ExprParentheses:
+		return m.check(e.Expression, v)
+	case *ConstrAlias:
+		return m.check(e.Constraint, v)
+	case *ExprLogicalAnd:
+		for _, e := range e.Expressions {
+			if !m.check(e, v) {
+				return false
+			}
+		}
+		return true
+	case *ExprLogicalOr:
+		for _, e := range e.Expressions {
+			if m.check(e, v) {
+				return true
+			}
+		}
+		return false
+	}
+	panic(fmt.Errorf("unhandled constraint type: %T", e))
+}
+
+// checkEq returns true if v is equal to the value expression x.
+// Arrays and objects are checked item by item and field by field
+// since they can contain constraints.
+func (m *matcher) checkEq(x Expression, v value) bool {
+	switch x := x.(type) {
+	case *Array:
+		if v.kind != valueList || m.in.len(v) != len(x.Items) {
+			return false
+		}
+		for i, item := range x.Items {
+			if !m.check(item, m.in.index(v, i)) {
+				return false
+			}
+		}
+		return true
+	case *Object:
+		return m.checkObject(x, v)
+	}
+	r, ok := m.eval(x)
+	return ok && m.in.equal(r, v)
+}
+
+func (m *matcher) checkObject(o *Object, v value) bool {
+	if v.kind != valueObject {
+		return false
+	}
+	for _, f := range o.Fields {
+		if f.Absent {
+			if m.in.field(v, f.Name.Name).kind != valueUndefined {
+				return false
+			}
+			continue
+		}
+		fv := m.objectField(f, v)
+		if fv.kind == valueUndefined {
+			if f.Optional {
+				continue
+			}
+			// Missing object field
+			return false
+		}
+		if !m.check(f.Constraint, fv) {
+			return false
+		}
+	}
+	if o.Open {
+		return true
+	}
+	ok := true
+	m.in.eachField(v, func(name string, _ value) bool {
+		if findObjectField(o.Fields, name) == nil {
+			// Undefined object field
+			ok = false
+		}
+		return ok
+	})
+	return ok
+}
+
+// explain records violations for the failed check of constraint e
+// against value v descending into the most specific failing constraints.
+func (m *matcher) explain(e Expression, v value, p *path) {
+	switch e := e.(type) {
+	case *ExprParentheses:
+		m.explain(e.Expression, v, p)
+		return
+	case *ConstrAlias:
+		m.explain(e.Constraint, v, p)
+		return
+	case *ExprLogicalAnd:
+		for _, x := range e.Expressions {
+			if !m.check(x, v) {
+				m.explain(x, v, p)
+			}
+		}
+		return
+	case *ConstrMap:
+		if v.kind != valueList {
+			break
+		}
+		for i, l := 0, m.in.len(v); i < l; i++ {
+			if x := m.in.index(v, i); !m.check(e.Constraint, x) {
+				m.explain(e.Constraint, x, p.index(i))
+			}
+		}
+		return
+	case *ConstrEquals:
+		switch x := e.Value.(type) {
+		case *Array:
+			if v.kind != valueList || m.in.len(v) != len(x.Items) {
+				break
+			}
+			for i, item := range x.Items {
+				if iv := m.in.index(v, i); !m.check(item, iv) {
+					m.explain(item, iv, p.index(i))
+				}
+			}
+			return
+		case *Object:
+			if v.kind != valueObject {
+				break
+			}
+			for _, f := range x.Fields {
+				fv, fp := m.objectField(f, v), p.name(f.Name.Name)
+				if f.Absent {
+					fv = m.in.field(v, f.Name.Name)
+					if fv.kind != valueUndefined {
+						m.violation(f, fp, fv, "object field must be absent")
+					}
+				} else if fv.kind == valueUndefined {
+					if !f.Optional {
+						m.violation(f, fp, fv, "missing object field")
+					}
+				} else if !m.check(f.Constraint, fv) {
+					m.explain(f.Constraint, fv, fp)
+				}
+			}
+			m.in.eachField(v, func(name string, fv value) bool {
+				if !x.Open && findObjectField(x.Fields, name) == nil {
+					m.violation(x, p.name(name), fv, fmt.Sprintf(
+						"object field %q is not allowed", name,
+					))
+				}
+				return true
+			})
+			return
+		}
+	}
+	m.violation(e, p, v, "value violates constraint")
+}
+
+// compare compares number v to the result of expression e.
+func (m *matcher) compare(v value, e Expression) (int, bool) {
+	r, ok := m.eval(e)
+	if !ok || !r.isNum() || !v.isNum() {
+		return 0, false
+	}
+	return compareNum(v, r), true
+}
+
+// compareLen compares the length of v to the result of expression e.
+func (m *matcher) compareLen(v value, e Expression) (int, bool) {
+	l, ok := m.in.length(v)
+	if !ok {
+		return 0, false
+	}
+	r, ok := m.eval(e)
+	if !ok || !r.isNum() {
+		return 0, false
+	}
+	return compareNum(value{kind: valueInt, i: int64(l)}, r), true
+}
+
+// eval evaluates value expression e.
+// Returns false if e can't be evaluated.
+func (m *matcher) eval(e Expression) (value, bool) {
+	switch e := e.(type) {
+	case *Number:
+		return numberValue(e), true
+	case *String:
+		return value{kind: valueString, s: e.Value}, true
+	case *Enum:
+		return value{kind: valueEnum, s: e.Value}, true
+	case *True:
+		return value{kind: valueBool, b: true}, true
+	case *False:
+		return value{kind: valueBool, b: false}, true
+	case *Null:
+		return value{kind: valueNull}, true
+	case *Array:
+		l := make([]value, len(e.Items))
+		for i, x := range e.Items {
+			var ok bool
+			if l[i], ok = m.eval(x); !ok {
+				return value{}, false
+			}
+		}
+		return value{kind: valueList, list: l}, true
+	case *Variable:
+		v, ok := m.vars[e.Declaration]
+		return v, ok
+	case *ConstrEquals:
+		return m.eval(e.Value)
+	case *ExprParentheses:
+		return m.eval(e.Expression)
+	case *ExprEqual:
+		l, r, ok := m.eval2(e.Left, e.Right)
+		return boolValue(ok && m.in.equal(l, r)), ok
+	case *ExprNotEqual:
+		l, r, ok := m.eval2(e.Left, e.Right)
+		return boolValue(ok && !m.in.equal(l, r)), ok
+	case *ExprLess:
+		c, ok := m.evalCompare(e.Left, e.Right)
+		return boolValue(c < 0), ok
+	case *ExprLessOrEqual:
+		c, ok := m.evalCompare(e.Left, e.Right)
+		return boolValue(c <= 0), ok
+	case *ExprGreater:
+		c, ok := m.evalCompare(e.Left, e.Right)
+		return boolValue(c > 0), ok
+	case *ExprGreaterOrEqual:
+		c, ok := m.evalCompare(e.Left, e.Right)
+		return boolValue(c >= 0), ok
+	case *ExprLogicalNegation:
+		v, ok := m.eval(e.Expression)
+		if !ok || v.kind != valueBool {
+			return value{}, false
+		}
+		return boolValue(!v.b), true
+	case *ExprLogicalAnd:
+		for _, e := range e.Expressions {
+			v, ok := m.eval(e)
+			if !ok || v.kind != valueBool {
+				return value{}, false
+			} else if !v.b {
+				return boolValue(false), true
+			}
+		}
+		return boolValue(true), true
+	case *ExprLogicalOr:
+		for _, e := range e.Expressions {
+			v, ok := m.eval(e)
+			if !ok || v.kind != valueBool {
+				return value{}, false
+			} else if v.b {
+				return boolValue(true), true
+			}
+		}
+		return boolValue(false), true
+	case *ExprNumericNegation:
+		v, ok := m.eval(e.Expression)
+		if !ok {
+			return value{}, false
+		}
+		return negate(v)
+	case *ExprCall:
+		return m.in.call(
+			e.Function.Name, len(e.Arguments),
+			func(i int) (value, bool) { return m.eval(e.Arguments[i]) },
+		)
+	case *ExprAddition:
+		l, r, ok := m.eval2(e.AddendLeft, e.AddendRight)
+		if !ok {
+			return value{}, false
+		}
+		return arithmetic(opAdd, l, r)
+	case *ExprSubtraction:
+		l, r, ok := m.eval2(e.Minuend, e.Subtrahend)
+		if !ok {
+			return value{}, false
+		}
+		return arithmetic(opSub, l, r)
+	case *ExprMultiplication:
+		l, r, ok := m.eval2(e.Multiplicant, e.Multiplicator)
+		if !ok {
+			return value{}, false
+		}
+		return arithmetic(opMul, l, r)
+	case *ExprDivision:
+		l, r, ok := m.eval2(e.Dividend, e.Divisor)
+		if !ok {
+			return value{}, false
+		}
+		return arithmetic(opDiv, l, r)
+	case *ExprModulo:
+		l, r, ok := m.eval2(e.Dividend, e.Divisor)
+		if !ok {
+			return value{}, false
+		}
+		return arithmetic(opMod, l, r)
+	}
+	return value{}, false
+}
+
+func (m *matcher) eval2(a, b Expression) (l, r value, ok bool) {
+	if l, ok = m.eval(a); !ok {
+		return value{}, value{}, false
+	}
+	if r, ok = m.eval(b); !ok {
+		return value{}, value{}, false
+	}
+	return l, r, true
+}
+
+func (m *matcher) evalCompare(a, b Expression) (int, bool) {
+	l, r, ok := m.eval2(a, b)
+	if !ok || !l.isNum() || !r.isNum() {
+		return 0, false
+	}
+	return compareNum(l, r), true
+}
+
+func operationType(o ast.Operation) OperationType {
+	switch o {
+	case ast.Query:
+		return OperationTypeQuery
+	case ast.Mutation:
+		return OperationTypeMutation
+	case ast.Subscription:
+		return OperationTypeSubscription
+	}
+	return 0
+}
+
+// responseKey returns the alias of field f or its name if it has none.
+func responseKey(f *ast.Field) string {
+	if f.Alias != "" {
+		return f.Alias
+	}
+	return f.Name
+}
+
+// fieldTypeName returns the name of the type of field f
+// or an empty string in schemaless mode.
+func fieldTypeName(f *SelectionField) string {
+	if f.Def != nil {
+		return getTypeName(f.Def.Type)
+	}
+	return ""
+}
+
+// findField returns the field called name from set of type hostType
+// including the options of max, min and exactly sets and the selections
+// of merged fragments.
+func findField(set SelectionSet, hostType, name string) *SelectionField {
+	for _, s := range set.Selections {
+		switch s := s.(type) {
+		case *SelectionField:
+			if s.Name.Name == name {
+				return s
+			}
+		case optionSet:
+			if f := findField(*s.options(), hostType, name); f != nil {
+				return f
+			}
+		case *SelectionSpread:
+			if !s.merged(hostType) {
+				continue
+			}
+			f := findField(s.Fragment.SelectionSet, hostType, name)
+			if f != nil {
+				return f
+			}
+		}
+	}
+	return nil
+}
+
+// findRecurse returns the recursion into field name from set.
+func findRecurse(set SelectionSet, name string) *SelectionRecurse {
+	for _, s := range set.Selections {
+		if r, ok := s.(*SelectionRecurse); ok {
+			if f := recursiveField(r); f != nil && f.Name.Name == name {
+				return r
+			}
+		}
+	}
+	return nil
+}
+
+// findInlineFrag returns the inline fragment conditioned on typeName
+// from set of type hostType including the options of max, min and
+// exactly sets and the selections of merged fragments.
+func findInlineFrag(
+	set SelectionSet, hostType, typeName string,
+) *SelectionInlineFrag {
+	for _, s := range set.Selections {
+		var f *SelectionInlineFrag
+		switch s := s.(type) {
+		case *SelectionInlineFrag:
+			if s.TypeCondition.TypeName == typeName {
+				return s
+			}
+		case optionSet:
+			f = findInlineFrag(*s.options(), hostType, typeName)
+		case *SelectionSpread:
+			if s.merged(hostType) {
+				f = findInlineFrag(s.Fragment.SelectionSet, hostType, typeName)
+			}
+		}
+		if f != nil {
+			return f
+		}
+	}
+	return nil
+}
+
+// findSpread returns the spread of the fragment conditioned on typeName
+// from set of type hostType unless the fragment is merged into set.
+func findSpread(set SelectionSet, hostType, typeName string) *SelectionSpread {
+	for _, s := range set.Selections {
+		s, ok := s.(*SelectionSpread)
+		if !ok {
+			continue
+		}
+		if !s.merged(hostType) {
+			if s.Fragment.TypeCondition.TypeName == typeName {
+				return s
+			}
+		} else if f := findSpread(
+			s.Fragment.SelectionSet, hostType, typeName,
+		); f != nil {
+			return f
+		}
+	}
+	return nil
+}
+
+func findDirective(dirs []*Directive, name string) *Directive {
+	for _, d := range dirs {
+		if d.Name.Name == name {
+			return d
+		}
+	}
+	return nil
+}
+
+func findArgument(args []*Argument, name string) *Argument {
+	for _, a := range args {
+		if a.Name.Name == name {
+			return a
+		}
+	}
+	return nil
+}
+
+func findObjectField(fields []*ObjectField, name string) *ObjectField {
+	for _, f := range fields {
+		if f.Name.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// countSelected returns the number of options in set that are selected.
+func countSelected(set SelectionSet, selected map[Expression]struct{}) int {
+	n := 0
+	for _, o := range set.Selections {
+		if _, ok := selected[o]; ok {
+			n++
+		}
+	}
+	return n
+}
+
+type valueKind int8
+
+const (
+	valueUndefined valueKind = iota
+	valueNull
+	valueInt
+	valueFloat
+	valueString
+	valueEnum
+	valueBool
+	valueList
+	valueObject
+)
+
+// value is either an input value of a request or the result of
+// the evaluation of a template expression.
+// Lists and objects are not copied, instead they reference either
+// the query document, the variables or the evaluated template array.
+type value struct {
+	kind valueKind
+	i    int64
+	f    float64
+	s    string
+	b    bool
+	doc  *ast.Value // List or object from the query document
+	vars any        // []any or map[string]any from the variables
+	list []value    // Evaluated template array
+}
+
+func (v value) isNum() bool {
+	return v.kind == valueInt || v.kind == valueFloat
+}
+
+func (v value) float() float64 {
+	if v.kind == valueInt {
+		return float64(v.i)
+	}
+	return v.f
+}
+
+func boolValue(b bool) value { return value{kind: valueBool, b: b} }
+
+func numberValue(n *Number) value {
+	if n.TypeDef != nil {
+		// Numbers of custom scalar types are not parsed by the parser
+		if i, err := strconv.ParseInt(n.Value, 10, 64); err == nil {
+			return value{kind: valueInt, i: i}
+		}
+		f, _ := strconv.ParseFloat(n.Value, 64)
+		return value{kind: valueFloat, f: f}
+	}
+	if n.isFloat {
+		return value{kind: valueFloat, f: n.vf}
+	}
+	return value{kind: valueInt, i: int64(n.vi)}
+}
+
+// input provides access to the input values of a request.
+type input struct {
+	vars map[string]any
+	defs ast.VariableDefinitionList
+}
+
+// fromAST returns the value of v resolving variable references.
+// Returns an undefined value for variables that are neither provided
+// nor have a default value.
+func (in input) fromAST(v *ast.Value) value {
+	if v == nil {
+		return value{}
+	}
+	switch v.Kind {
+	case ast.Variable:
+		if x, ok := in.vars[v.Raw]; ok {
+			return fromAny(x)
+		}
+		if d := in.defs.ForName(v.Raw); d != nil && d.DefaultValue != nil {
+			return in.fromAST(d.DefaultValue)
+		}
+		return value{}
+	case ast.IntValue:
+		if i, err := strconv.ParseInt(v.Raw, 10, 64); err == nil {
+			return value{kind: valueInt, i: i}
+		}
+		f, _ := strconv.ParseFloat(v.Raw, 64)
+		return value{kind: valueFloat, f: f}
+	case ast.FloatValue:
+		f, _ := strconv.ParseFloat(v.Raw, 64)
+		return value{kind: valueFloat, f: f}
+	case ast.StringValue, ast.BlockValue:
+		return value{kind: valueString, s: v.Raw}
+	case ast.EnumValue:
+		return value{kind: valueEnum, s: v.Raw}
+	case ast.BooleanValue:
+		return boolValue(v.Raw == "true")
+	case ast.NullValue:
+		return value{kind: valueNull}
+	case ast.ListValue:
+		return value{kind: valueList, doc: v}
+	case ast.ObjectValue:
+		return value{kind: valueObject, doc: v}
+	}
+	return value{}
+}
+
+// fromAny returns the value of x which must be a value
+// accepted by checkVariables.
+// Enum values provided through variables are represented as strings.
+func fromAny(v any) value {
+	switch x := v.(type) {
+	case nil:
+		return value{kind: valueNull}
+	case bool:
+		return boolValue(x)
+	case string:
+		return value{kind: valueString, s: x}
+	case int:
+		return value{kind: valueInt, i: int64(x)}
+	case int8:
+		return value{kind: valueInt, i: int64(x)}
+	case int16:
+		return value{kind: valueInt, i: int64(x)}
+	case int32:
+		return value{kind: valueInt, i: int64(x)}
+	case int64:
+		return value{kind: valueInt, i: x}
+	case uint8:
+		return value{kind: valueInt, i: int64(x)}
+	case uint16:
+		return value{kind: valueInt, i: int64(x)}
+	case uint32:
+		return value{kind: valueInt, i: int64(x)}
+	case float32:
+		return value{kind: valueFloat, f: float64(x)}
+	case float64:
+		return value{kind: valueFloat, f: x}
+	case json.Number:
+		if i, err := x.Int64(); err == nil {
+			return value{kind: valueInt, i: i}
+		}
+		f, _ := x.Float64()
+		return value{kind: valueFloat, f: f}
+	case []any:
+		// Keep the original interface value to avoid reboxing the slice
+		return value{kind: valueList, vars: v}
+	case map[string]any:
+		return value{kind: valueObject, vars: v}
+	}
+	return value{}
+}
+
+// checkVariables returns an error if vars contains values
+// of unsupported types.
+func checkVariables(vars map[string]any) error {
+	for n, v := range vars {
+		if err := checkVariable(v); err != nil {
+			return fmt.Errorf("variable %q: %w", n, err)
+		}
+	}
+	return nil
+}
+
+func checkVariable(x any) error {
+	switch x := x.(type) {
+	case nil, bool, string, float32, float64, json.Number,
+		int, int8, int16, int32, int64, uint8, uint16, uint32:
+		return nil
+	case []any:
+		for _, i := range x {
+			if err := checkVariable(i); err != nil {
+				return err
+			}
+		}
+		return nil
+	case map[string]any:
+		for _, i := range x {
+			if err := checkVariable(i); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported value type %T", x)
+}
+
+// len returns the number of items of list v.
+func (in input) len(v value) int {
+	switch {
+	case v.doc != nil:
+		return len(v.doc.Children)
+	case v.vars != nil:
+		return len(v.vars.([]any))
+	}
+	return len(v.list)
+}
+
+// index returns item i of list v.
+// Undefined variables inside lists are null.
+func (in input) index(v value, i int) (x value) {
+	switch {
+	case v.doc != nil:
+		x = in.fromAST(v.doc.Children[i].Value)
+	case v.vars != nil:
+		x = fromAny(v.vars.([]any)[i])
+	default:
+		x = v.list[i]
+	}
+	if x.kind == valueUndefined {
+		x.kind = valueNull
+	}
+	return x
+}
+
+// field returns field name of object v.
+// Returns an undefined value if the field isn't provided.
+func (in input) field(v value, name string) value {
+	switch {
+	case v.kind != valueObject:
+		return value{}
+	case v.doc != nil:
+		for _, c := range v.doc.Children {
+			if c.Name == name {
+				return in.fromAST(c.Value)
+			}
+		}
+		return value{}
+	}
+	x, ok := v.vars.(map[string]any)[name]
+	if !ok {
+		return value{}
+	}
+	return fromAny(x)
+}
+
+// eachField calls fn for every provided field of object v
+// until fn returns false.
+func (in input) eachField(v value, fn func(name string, v value) bool) {
+	switch {
+	case v.kind != valueObject:
+	case v.doc != nil:
+		for _, c := range v.doc.Children {
+			x := in.fromAST(c.Value)
+			if x.kind == valueUndefined {
+				continue
+			}
+			if !fn(c.Name, x) {
+				return
+			}
+		}
+	default:
+		for n, x := range v.vars.(map[string]any) {
+			if !fn(n, fromAny(x)) {
+				return
+			}
+		}
+	}
+}
+
+// length returns the byte length of a string or
+// the number of items of a list.
+func (in input) length(v value) (int, bool) {
+	switch v.kind {
+	case valueString:
+		return len(v.s), true
+	case valueList:
+		return in.len(v), true
+	}
+	return 0, false
+}
+
+// equal returns true if a and b are deeply equal.
+// Numbers are compared by value regardless of their type,
+// enum values are compared to strings by their name.
+func (in input) equal(a, b value) bool {
+	switch {
+	case a.isNum() && b.isNum():
+		return compareNum(a, b) == 0
+	case (a.kind == valueString || a.kind == valueEnum) &&
+		(b.kind == valueString || b.kind == valueEnum):
+		return a.s == b.s
+	case a.kind != b.kind:
+		return false
+	}
+	switch a.kind {
+	case valueNull, valueUndefined:
+		return true
+	case valueBool:
+		return a.b == b.b
+	case valueList:
+		l := in.len(a)
+		if l != in.len(b) {
+			return false
+		}
+		for i := 0; i < l; i++ {
+			if !in.equal(in.index(a, i), in.index(b, i)) {
+				return false
+			}
+		}
+		return true
+	case valueObject:
+		na, nb := 0, 0
+		in.eachField(b, func(string, value) bool { nb++; return true })
+		eq := true
+		in.eachField(a, func(name string, x value) bool {
+			na++
+			eq = in.equal(x, in.field(b, name))
+			return eq
+		})
+		return eq && na == nb
+	}
+	return false
+}
+
+// unique returns true if no two items of list v are deeply equal.
+func (in input) unique(v value) bool {
+	l := in.len(v)
+	for i := 0; i < l; i++ {
+		x := in.index(v, i)
+		for j := i + 1; j < l; j++ {
+			if in.equal(x, in.index(v, j)) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// sum returns the sum of the items of list v.
+// Returns false if v isn't a list or any of its items isn't a number.
+func (in input) sum(v value) (value, bool) {
+	if v.kind != valueList {
+		return value{}, false
+	}
+	s := value{kind: valueInt}
+	for i, l := 0, in.len(v); i < l; i++ {
+		var ok bool
+		if s, ok = arithmetic(opAdd, s, in.index(v, i)); !ok {
+			return value{}, false
+		}
+	}
+	return s, true
+}
+
+// compareNum returns -1 if a < b, 1 if a > b, otherwise 0.
+func compareNum(a, b value) int {
+	if a.kind == valueInt && b.kind == valueInt {
+		switch {
+		case a.i < b.i:
+			return -1
+		case a.i > b.i:
+			return 1
+		}
+		return 0
+	}
+	switch af, bf := a.float(), b.float(); {
+	case af < bf:
+		return -1
+	case af > bf:
+		return 1
+	}
+	return 0
+}
+
+type arithmeticOp int8
+
+const (
+	opAdd arithmeticOp = iota
+	opSub
+	opMul
+	opDiv
+	opMod
+)
+
+// arithmetic returns the result of applying op to a and b.
+// Returns false if either of the operands isn't a number
+// or in case of a division by zero.
+func arithmetic(op arithmeticOp, a, b value) (value, bool) {
+	if !a.isNum() || !b.isNum() {
+		return value{}, false
+	}
+	if a.kind == valueInt && b.kind == valueInt {
+		var r int64
+		switch op {
+		case opAdd:
+			r = a.i + b.i
+		case opSub:
+			r = a.i - b.i
+		case opMul:
+			r = a.i * b.i
+		case opDiv:
+			if b.i == 0 {
+				return value{}, false
+			}
+			r = a.i / b.i
+		case opMod:
+			if b.i == 0 {
+				return value{}, false
+			}
+			r = a.i % b.i
+		}
+		return value{kind: valueInt, i: r}, true
+	}
+	var r float64
+	switch af, bf := a.float(), b.float(); op {
+	case opAdd:
+		r = af + bf
+	case opSub:
+		r = af - bf
+	case opMul:
+		r = af * bf
+	case opDiv:
+		if bf == 0 {
+			return value{}, false
+		}
+		r = af / bf
+	case opMod:
+		if bf == 0 {
+			return value{}, false
+		}
+		r = math.Mod(af, bf)
+	}
+	return value{kind: valueFloat, f: r}, true
+}
+
+func negate(v value) (value, bool) {
+	switch v.kind {
+	case valueInt:
+		v.i = -v.i
+		return v, true
+	case valueFloat:
+		v.f = -v.f
+		return v, true
+	}
+	return value{}, false
+}
+
+// call returns the result of built-in function name applied to
+// n arguments provided by arg. Returns false if any of the arguments
+// can't be evaluated or is of an unsupported type.
+func (in input) call(
+	name string, n int, arg func(i int) (value, bool),
+) (value, bool) {
+	switch name {
+	case "min", "max":
+		var r value
+		float := false
+		for i := 0; i < n; i++ {
+			v, ok := arg(i)
+			if !ok || !v.isNum() {
+				return value{}, false
+			}
+			float = float || v.kind == valueFloat
+			if i == 0 ||
+				(name == "min" && compareNum(v, r) < 0) ||
+				(name == "max" && compareNum(v, r) > 0) {
+				r = v
+			}
+		}
+		if float && r.kind == valueInt {
+			r = value{kind: valueFloat, f: float64(r.i)}
+		}
+		return r, n > 0
+	}
+	if n != 1 {
+		return value{}, false
+	}
+	v, ok := arg(0)
+	if !ok {
+		return value{}, false
+	}
+	switch name {
+	case "abs":
+		switch {
+		case v.kind == valueFloat:
+			return value{kind: valueFloat, f: math.Abs(v.f)}, true
+		case v.kind != valueInt, v.i == math.MinInt64:
+			return value{}, false
+		case v.i < 0:
+			v.i = -v.i
+		}
+		return v, true
+	case "ceil", "floor":
+		if v.kind == valueInt {
+			return v, true
+		} else if v.kind != valueFloat {
+			return value{}, false
+		}
+		f := math.Floor(v.f)
+		if name == "ceil" {
+			f = math.Ceil(v.f)
+		}
+		if f < math.MinInt64 || f >= math.MaxInt64 {
+			return value{kind: valueFloat, f: f}, true
+		}
+		return value{kind: valueInt, i: int64(f)}, true
+	case "len":
+		l, ok := in.length(v)
+		if !ok {
+			return value{}, false
+		}
+		return value{kind: valueInt, i: int64(l)}, true
+	}
+	return value{}, false
+}
+
+// setKey is the hashable representation of a scalar value.
+// Numbers are represented as integers where possible and enum values
+// as strings to preserve the semantics of input.equal.
+type setKey struct {
+	kind valueKind
+	i    int64
+	f    float64
+	s    string
+}
+
+func setKeyOf(v value) (k setKey, ok bool) {
+	switch v.kind {
+	case valueInt:
+		return setKey{kind: valueInt, i: v.i}, true
+	case valueFloat:
+		if i := int64(v.f); float64(i) == v.f {
+			return setKey{kind: valueInt, i: i}, true
+		}
+		return setKey{kind: valueFloat, f: v.f}, true
+	case valueString, valueEnum:
+		return setKey{kind: valueString, s: v.s}, true
+	case valueBool:
+		k = setKey{kind: valueBool}
+		if v.b {
+			k.i = 1
+		}
+		return k, true
+	case valueNull:
+		return setKey{kind: valueNull}, true
+	}
+	return setKey{}, false
+}
+
+// valueSet is the hashed set of values of a set membership constraint.
+type valueSet map[setKey]struct{}
+
+// newValueSet returns the set of the constant scalar values l.
+// Returns false if any of l isn't a constant scalar value.
+func newValueSet(l []Expression) (valueSet, bool) {
+	s := make(valueSet, len(l))
+	for _, e := range l {
+		v, ok := constantValue(e)
+		if !ok {
+			return nil, false
+		}
+		s.add(v)
+	}
+	return s, true
+}
+
+// add adds v to s and returns false if s already contains v.
+func (s valueSet) add(v value) bool {
+	k, _ := setKeyOf(v)
+	if _, ok := s[k]; ok {
+		return false
+	}
+	s[k] = struct{}{}
+	return true
+}
+
+func (s valueSet) contains(v value) bool {
+	k, ok := setKeyOf(v)
+	if !ok {
+		return false
+	}
+	_, ok = s[k]
+	return ok
+}
+
+// constantValue returns the value of e if e is a constant scalar value.
+func constantValue(e Expression) (value, bool) {
+	switch e := e.(type) {
+	case *Number:
+		return numberValue(e), true
+	case *String:
+		return value{kind: valueString, s: e.Value}, true
+	case *Enum:
+		return value{kind: valueEnum, s: e.Value}, true
+	case *True:
+		return boolValue(true), true
+	case *False:
+		return boolValue(false), true
+	case *Null:
+		return value{kind: valueNull}, true
+	}
+	return value{}, false
+}
+
+// path is a path to a part of the request.
+// Paths are only converted to strings when a violation is reported.
+type path struct {
+	parent *path
+	key    string
+	idx    int
+}
+
+func (p *path) field(f *ast.Field) *path {
+	if f.Alias != "" {
+		return p.name(f.Alias)
+	}
+	return p.name(f.Name)
+}
+
+func (p *path) name(n string) *path { return &path{parent: p, key: n} }
+
+func (p *path) index(i int) *path { return &path{parent: p, idx: i} }
+
+func (p *path) String() string {
+	if p == nil {
+		return ""
+	}
+	var b strings.Builder
+	var write func(p *path)
+	write = func(p *path) {
+		if p.parent != nil {
+			write(p.parent)
+		}
+		if p.key == "" {
+			b.WriteByte('[')
+			b.WriteString(strconv.Itoa(p.idx))
+			b.WriteByte(']')
+			return
+		}
+		if p.parent != nil {
+			b.WriteByte('.')
+		}
+		b.WriteString(p.key)
+	}
+	write(p)
+	return b.String()
+}
+
+// toAny returns the Go representation of v.
+func (in input) toAny(v value) any {
+	switch v.kind {
+	case valueInt:
+		return v.i
+	case valueFloat:
+		return v.f
+	case valueString, valueEnum:
+		return v.s
+	case valueBool:
+		return v.b
+	case valueList:
+		l := make([]any, in.len(v))
+		for i := range l {
+			l[i] = in.toAny(in.index(v, i))
+		}
+		return l
+	case valueObject:
+		o := map[string]any{}
+		in.eachField(v, func(name string, x value) bool {
+			o[name] = in.toAny(x)
+			return true
+		})
+		return o
+	}
+	return nil
+}
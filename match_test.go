@@ -0,0 +1,490 @@
+package gqt_test
+
+import (
+	"bytes"
+	"embed"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/graph-guard/gqt/v4"
+	"github.com/graph-guard/gqt/v4/internal/test"
+	"github.com/stretchr/testify/require"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/parser"
+	yaml "gopkg.in/yaml.v3"
+)
+
+//go:embed tests_match
+var testsMatchFS embed.FS
+
+type MatchRequest struct {
+	Query            string         `yaml:"query"`
+	OperationName    string         `yaml:"operationName"`
+	Variables        map[string]any `yaml:"variables"`
+	Expect           bool           `yaml:"expect"`
+	ExpectSchemaless *bool          `yaml:"expect(schemaless)"`
+}
+
+type MatchTest struct {
+	Schema   string         `yaml:"schema"`
+	Template string         `yaml:"template"`
+	Requests []MatchRequest `yaml:"requests"`
+}
+
+// forEachMatchTest calls fn for every test file in tests_match.
+func forEachMatchTest(t *testing.T, fn func(t *testing.T, ts MatchTest)) {
+	d, err := fs.ReadDir(testsMatchFS, "tests_match")
+	require.NoError(t, err)
+
+	for _, do := range d {
+		fileName := do.Name()
+		if do.IsDir() || !strings.HasSuffix(fileName, ".yml") {
+			continue
+		}
+		f, err := testsMatchFS.ReadFile(filepath.Join("tests_match", fileName))
+		require.NoError(t, err, "reading YAML test file")
+		t.Run(strings.TrimSuffix(fileName, ".yml"), func(t *testing.T) {
+			var ts MatchTest
+			d := yaml.NewDecoder(bytes.NewReader(f))
+			d.KnownFields(true)
+			if err := d.Decode(&ts); err != nil {
+				t.Fatal("parsing YAML test definition", err)
+			}
+			fn(t, ts)
+		})
+	}
+}
+
+// parseMatchTemplates returns the template of ts
+// parsed in schema-aware and schemaless mode.
+func parseMatchTemplates(
+	t *testing.T, ts MatchTest,
+) (schema, schemaless *gqt.Operation) {
+	p, err := gqt.NewParser([]gqt.Source{
+		{Name: "schema.graphqls", Content: ts.Schema},
+	})
+	require.NoError(t, err, "unexpected error while parsing schema")
+	schema, _, errs := p.Parse([]byte(ts.Template))
+	require.Len(t, errs, 0, "unexpected errors: %v", errs)
+	schemaless, _, errs = gqt.Parse([]byte(ts.Template))
+	require.Len(t, errs, 0, "unexpected errors(schemaless): %v", errs)
+	return schema, schemaless
+}
+
+func parseQuery(t *testing.T, query string) *ast.QueryDocument {
+	doc, err := parser.ParseQuery(&ast.Source{Input: query})
+	require.NoError(t, err, "parsing query")
+	return doc
+}
+
+func compile(t *testing.T, opr *gqt.Operation) *gqt.Program {
+	p, err := gqt.Compile(opr)
+	require.NoError(t, err)
+	return p
+}
+
+func (r MatchRequest) expect(schemaless bool) bool {
+	if schemaless && r.ExpectSchemaless != nil {
+		return *r.ExpectSchemaless
+	}
+	return r.Expect
+}
+
+func TestMatch(t *testing.T) {
+	forEachMatchTest(t, func(t *testing.T, ts MatchTest) {
+		opr, oprSchemaless := parseMatchTemplates(t, ts)
+		// Optimize modifies the operation in place
+		optimized, _ := parseMatchTemplates(t, ts)
+		prgSchemaless := compile(t, oprSchemaless)
+		programs := []*gqt.Program{
+			compile(t, opr),
+			compile(t, gqt.Optimize(optimized).(*gqt.Operation)),
+			prgSchemaless,
+		}
+		for _, r := range ts.Requests {
+			doc := parseQuery(t, r.Query)
+			ok, err := gqt.Match(opr, doc, r.OperationName, r.Variables)
+			require.NoError(t, err)
+			require.Equal(t, r.expect(false), ok, "query: %s", r.Query)
+
+			ok, err = gqt.Match(oprSchemaless, doc, r.OperationName, r.Variables)
+			require.NoError(t, err)
+			require.Equal(t, r.expect(true), ok, "query(schemaless): %s", r.Query)
+
+			for _, p := range programs {
+				ok, err = p.Match(doc, r.OperationName, r.Variables)
+				require.NoError(t, err)
+				require.Equal(t, r.expect(p == prgSchemaless), ok,
+					"query(program): %s", r.Query)
+			}
+
+			rep, err := gqt.MatchReport(opr, doc, r.OperationName, r.Variables)
+			require.NoError(t, err)
+			require.Equal(t, r.expect(false), rep.Accepted(),
+				"query(report): %s; violations: %v", r.Query, rep.Violations)
+		}
+	})
+}
+
+// TestProgramDifferential checks that programs agree with Match
+// on every request regardless of the expected results.
+func TestProgramDifferential(t *testing.T) {
+	forEachMatchTest(t, func(t *testing.T, ts MatchTest) {
+		opr, oprSchemaless := parseMatchTemplates(t, ts)
+		for _, o := range []*gqt.Operation{opr, oprSchemaless} {
+			p := compile(t, o)
+			for _, r := range ts.Requests {
+				doc := parseQuery(t, r.Query)
+				expect, expectErr := gqt.Match(
+					o, doc, r.OperationName, r.Variables,
+				)
+				ok, err := p.Match(doc, r.OperationName, r.Variables)
+				require.Equal(t, expectErr, err, "query: %s", r.Query)
+				require.Equal(t, expect, ok, "query: %s", r.Query)
+			}
+		}
+	})
+}
+
+func TestMatchErr(t *testing.T) {
+	opr, _, errs := gqt.Parse([]byte(`query { f(a: *) }`))
+	require.Len(t, errs, 0)
+
+	for _, x := range []struct {
+		query         string
+		operationName string
+		variables     map[string]any
+		expect        string
+	}{
+		{
+			query: `query A { f(a: 1) } query B { f(a: 2) }`,
+			expect: "operation name is required for documents " +
+				"with multiple operations",
+		},
+		{
+			query:         `query A { f(a: 1) }`,
+			operationName: "B",
+			expect:        `operation "B" not found`,
+		},
+		{
+			query:  `{ ...F }`,
+			expect: `fragment "F" is undefined`,
+		},
+		{
+			query:  `{ ...F } fragment F on Query { ...F }`,
+			expect: `fragment "F" spreads itself`,
+		},
+		{
+			query:     `query ($a: Int) { f(a: $a) }`,
+			variables: map[string]any{"a": struct{}{}},
+			expect:    `variable "a": unsupported value type struct {}`,
+		},
+	} {
+		doc := parseQuery(t, x.query)
+		ok, err := gqt.Match(opr, doc, x.operationName, x.variables)
+		require.False(t, ok)
+		require.Error(t, err)
+		require.Equal(t, x.expect, err.Error())
+
+		ok, err = compile(t, opr).Match(doc, x.operationName, x.variables)
+		require.False(t, ok)
+		require.Error(t, err)
+		require.Equal(t, x.expect, err.Error())
+	}
+}
+
+func TestCompileErr(t *testing.T) {
+	p, err := gqt.Compile(nil)
+	require.Error(t, err)
+	require.Equal(t, "missing operation", err.Error())
+	require.Nil(t, p)
+}
+
+func TestProgramAllocs(t *testing.T) {
+	if raceEnabled {
+		// sync.Pool randomly drops items when the race detector is enabled
+		t.Skip("skipping allocation test in race mode")
+	}
+	opr, _, errs := gqt.Parse(benchMatchTemplate)
+	require.Len(t, errs, 0, "unexpected errors: %v", errs)
+	p := compile(t, gqt.Optimize(opr).(*gqt.Operation))
+	doc := parseQuery(t, benchMatchQuery)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		ok, err := p.Match(doc, "", benchMatchVariables)
+		if err != nil || !ok {
+			t.Fatal("unexpected mismatch", err)
+		}
+	})
+	require.Zero(t, allocs)
+}
+
+func TestMatchReport(t *testing.T) {
+	type Violation struct {
+		Location gqt.Location
+		Path     string
+		Value    any
+		Type     string
+		Msg      string
+	}
+	type T struct {
+		template  string
+		query     string
+		variables map[string]any
+		expect    []Violation
+	}
+	f := test.New(t, func(t *testing.T, x T) {
+		opr, _, errs := gqt.Parse([]byte(x.template))
+		require.Len(t, errs, 0, "unexpected errors: %v", errs)
+		rep, err := gqt.MatchReport(opr, parseQuery(t, x.query), "", x.variables)
+		require.NoError(t, err)
+		actual := make([]Violation, len(rep.Violations))
+		for i, v := range rep.Violations {
+			actual[i] = Violation{
+				Location: v.Expression.GetLocation().Location,
+				Path:     v.Path,
+				Value:    v.Value,
+				Type:     v.Type,
+				Msg:      v.Msg,
+			}
+		}
+		require.Equal(t, x.expect, actual)
+		require.Equal(t, len(x.expect) < 1, rep.Accepted())
+	})
+
+	f(T{
+		template: `query { user(id: *) { name } }`,
+		query:    `{ user(id: 1) { name } }`,
+		expect:   []Violation{},
+	})
+	f(T{
+		template: `query { user(id: *) { name } }`,
+		query:    `mutation { user(id: 1) { name } }`,
+		expect: []Violation{{
+			Location: gqt.Location{Index: 0, Line: 1, Column: 1},
+			Type:     "Query",
+			Msg:      "expected query operation",
+		}},
+	})
+	f(T{
+		template: `query { user(id: *) { name } }`,
+		query:    `{ user(id: 1, x: 2) { name u: age } }`,
+		expect: []Violation{
+			{
+				Location: gqt.Location{Index: 8, Line: 1, Column: 9},
+				Path:     "user.x",
+				Value:    int64(2),
+				Msg:      `argument "x" is not allowed`,
+			},
+			{
+				Location: gqt.Location{Index: 8, Line: 1, Column: 9},
+				Path:     "user.u",
+				Msg:      `field "age" is not allowed`,
+			},
+		},
+	})
+	f(T{
+		template: `query { user(id: *, limit: < 10) { name } }`,
+		query:    `{ user { name } }`,
+		expect: []Violation{
+			{
+				Location: gqt.Location{Index: 13, Line: 1, Column: 14},
+				Path:     "user.id",
+				Type:     "*",
+				Msg:      "missing argument",
+			},
+			{
+				Location: gqt.Location{Index: 20, Line: 1, Column: 21},
+				Path:     "user.limit",
+				Type:     "number",
+				Msg:      "missing argument",
+			},
+		},
+	})
+	f(T{
+		template: `query {
+			user(friends: [ ... {limit: < 10, tags: [ ... len <= 3 ]} ]) {
+				name
+			}
+		}`,
+		query: `query ($l: Int) {
+			user(friends: [
+				{limit: 5, tags: ["a"]},
+				{limit: $l, tags: ["a", "b", "cdef"]}
+			]) { name }
+		}`,
+		variables: map[string]any{"l": 20},
+		expect: []Violation{
+			{
+				Location: gqt.Location{Index: 39, Line: 2, Column: 32},
+				Path:     "user.friends[1].limit",
+				Value:    int64(20),
+				Type:     "number",
+				Msg:      "value violates constraint",
+			},
+			{
+				Location: gqt.Location{Index: 57, Line: 2, Column: 50},
+				Path:     "user.friends[1].tags[2]",
+				Value:    "cdef",
+				Type:     "String|array",
+				Msg:      "value violates constraint",
+			},
+		},
+	})
+	f(T{
+		template: `query { f(o: {a: *, b: *}) }`,
+		query:    `{ f(o: {a: 1, c: true}) }`,
+		expect: []Violation{
+			{
+				Location: gqt.Location{Index: 20, Line: 1, Column: 21},
+				Path:     "f.o.b",
+				Type:     "*",
+				Msg:      "missing object field",
+			},
+			{
+				Location: gqt.Location{Index: 13, Line: 1, Column: 14},
+				Path:     "f.o.c",
+				Value:    true,
+				Type:     "{a:*,b:*}",
+				Msg:      `object field "c" is not allowed`,
+			},
+		},
+	})
+	f(T{
+		template: `query { a*[1]: f(x: *) }`,
+		query:    `{ a1: f(x: 1) a2: f(x: 2) b: f(x: 3) }`,
+		expect: []Violation{
+			{
+				Location: gqt.Location{Index: 8, Line: 1, Column: 9},
+				Path:     "b",
+				Msg:      `alias "b" doesn't match pattern "a*"`,
+			},
+			{
+				Location: gqt.Location{Index: 8, Line: 1, Column: 9},
+				Msg:      `field "f" selected 2 times, max 1 allowed`,
+			},
+		},
+	})
+	f(T{
+		template: `query { f(a?: *, b: absent, o: {c?: *, d: absent}) }`,
+		query:    `{ f(b: 1, o: {d: "x"}) }`,
+		expect: []Violation{
+			{
+				Location: gqt.Location{Index: 17, Line: 1, Column: 18},
+				Path:     "f.b",
+				Value:    int64(1),
+				Type:     "absent",
+				Msg:      "argument must be absent",
+			},
+			{
+				Location: gqt.Location{Index: 39, Line: 1, Column: 40},
+				Path:     "f.o.d",
+				Value:    "x",
+				Type:     "absent",
+				Msg:      "object field must be absent",
+			},
+		},
+	})
+	f(T{
+		template: `query { f @a(x: < 2) { ... on T @b { g } } }`,
+		query:    `{ f @a(x: 3) @c { ... on T @b(y: 1) { g } } }`,
+		expect: []Violation{
+			{
+				Location: gqt.Location{Index: 8, Line: 1, Column: 9},
+				Path:     "f.@c",
+				Msg:      `directive "@c" is not allowed`,
+			},
+			{
+				Location: gqt.Location{Index: 32, Line: 1, Column: 33},
+				Path:     "f.@b.y",
+				Value:    int64(1),
+				Msg:      `argument "y" is not allowed`,
+			},
+			{
+				Location: gqt.Location{Index: 16, Line: 1, Column: 17},
+				Path:     "f.@a.x",
+				Value:    int64(3),
+				Type:     "number",
+				Msg:      "value violates constraint",
+			},
+		},
+	})
+	f(T{
+		template: `query maxDepth 3 { a { b { c ...recurse(max: 1) } } }`,
+		query:    `{ a { b { b { c } x: b { b { c } } } } }`,
+		expect: []Violation{
+			{
+				Location: gqt.Location{Index: 0, Line: 1, Column: 1},
+				Path:     "a.b.b.c",
+				Type:     "Query",
+				Msg:      "max depth 3 exceeded",
+			},
+			{
+				Location: gqt.Location{Index: 29, Line: 1, Column: 30},
+				Path:     "a.b.x.b",
+				Msg:      "max recursion depth 1 exceeded",
+			},
+		},
+	})
+	f(T{
+		template: `query { f(o: {a: < 2, ...}) }`,
+		query:    `{ f(o: {a: 3, c: true}) }`,
+		expect: []Violation{
+			{
+				Location: gqt.Location{Index: 17, Line: 1, Column: 18},
+				Path:     "f.o.a",
+				Value:    int64(3),
+				Type:     "number",
+				Msg:      "value violates constraint",
+			},
+		},
+	})
+	f(T{
+		template: `query { a(x=$x: *) b(y: $x) }`,
+		query:    `{ a(x: 1) b(y: 2) }`,
+		expect: []Violation{{
+			Location: gqt.Location{Index: 24, Line: 1, Column: 25},
+			Path:     "b.y",
+			Value:    int64(2),
+			Type:     "*",
+			Msg:      "value violates constraint",
+		}},
+	})
+	f(T{
+		template: `query { max 1 { a b } }`,
+		query:    `{ a b }`,
+		expect: []Violation{{
+			Location: gqt.Location{Index: 8, Line: 1, Column: 9},
+			Msg:      "2 options selected, max 1 allowed",
+		}},
+	})
+	f(T{
+		template: `query { id! min 1 { a b } exactly 1 { c d } }`,
+		query:    `{ c d }`,
+		expect: []Violation{{
+			Location: gqt.Location{Index: 8, Line: 1, Column: 9},
+			Msg:      "field \"id\" is required",
+		}, {
+			Location: gqt.Location{Index: 12, Line: 1, Column: 13},
+			Msg:      "0 options selected, min 1 required",
+		}, {
+			Location: gqt.Location{Index: 26, Line: 1, Column: 27},
+			Msg:      "2 options selected, exactly 1 required",
+		}},
+	})
+	f(T{
+		template: `query { a { ...F } } fragment F on Query { id! b }`,
+		query:    `{ a { b c } }`,
+		expect: []Violation{{
+			Location: gqt.Location{Index: 8, Line: 1, Column: 9},
+			Path:     "a.c",
+			Msg:      "field \"c\" is not allowed",
+		}, {
+			Location: gqt.Location{Index: 43, Line: 1, Column: 44},
+			Path:     "a",
+			Msg:      "field \"id\" is required",
+		}},
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"math"
 	"strconv"
+	"strings"
 )
 
 // Optimize recursively reduces all constant expressions inside e.
@@ -13,23 +14,54 @@ import (
 func Optimize(e Expression) Expression {
 	switch e := e.(type) {
 	case *Operation:
+		for i, x := range e.Selections {
+			e.Selections[i] = Optimize(x)
+		}
+		for _, d := range e.Fragments {
+			Optimize(d)
+		}
+		return e
+	case *FragmentDefinition:
 		for i, x := range e.Selections {
 			e.Selections[i] = Optimize(x)
 		}
 		return e
 	case *SelectionInlineFrag:
+		for _, d := range e.Directives {
+			Optimize(d)
+		}
 		for i, x := range e.Selections {
 			e.Selections[i] = Optimize(x)
 		}
 		return e
+	case optionSet:
+		options := e.options()
+		for i, x := range options.Selections {
+			options.Selections[i] = Optimize(x)
+		}
+		return e
 	case *SelectionField:
 		for i, x := range e.Arguments {
-			e.Arguments[i].Constraint = Optimize(x.Constraint)
+			if !x.Absent {
+				e.Arguments[i].Constraint = Optimize(x.Constraint)
+			}
+		}
+		for _, d := range e.Directives {
+			Optimize(d)
 		}
 		for i, x := range e.Selections {
 			e.Selections[i] = Optimize(x)
 		}
 		return e
+	case *Directive:
+		for i, x := range e.Arguments {
+			if !x.Absent {
+				e.Arguments[i].Constraint = Optimize(x.Constraint)
+			}
+		}
+		return e
+	case *SelectionRecurse, *SelectionSpread:
+		return e
 	case *ConstrAny:
 		return e
 	case *ConstrEquals:
@@ -68,9 +100,30 @@ func Optimize(e Expression) Expression {
 	case *ConstrLenLessOrEqual:
 		e.Value = Optimize(e.Value)
 		return e
+	case *ConstrMatches:
+		e.Value = Optimize(e.Value)
+		return e
+	case *ConstrIn, *ConstrNotIn:
+		// Sets only contain constants
+		return e
+	case *ConstrUnique:
+		return e
+	case *ConstrContains:
+		e.Value = Optimize(e.Value)
+		return e
+	case *ConstrSum:
+		e.Constraint = Optimize(e.Constraint)
+		return e
+	case *ConstrCount:
+		e.Where = Optimize(e.Where)
+		e.Constraint = Optimize(e.Constraint)
+		return e
 	case *ConstrMap:
 		e.Constraint = Optimize(e.Constraint)
 		return e
+	case *ConstrAlias:
+		e.Constraint = Optimize(e.Constraint)
+		return e
 	case *ExprParentheses:
 		e.Expression = Optimize(e.Expression)
 		setLocRange(e.Expression, e.LocRange)
@@ -130,10 +183,42 @@ func Optimize(e Expression) Expression {
 		e.Expression = Optimize(e.Expression)
 		if n, ok := e.Expression.(*Number); ok {
 			n.vf, n.vi = -n.vf, -n.vi
+			if n.isFloat {
+				n.Value = formatFloat(n.vf)
+			} else {
+				n.Value = strconv.Itoa(n.vi)
+			}
 			setParent(n, e.Parent)
 			return n
 		}
 		return e
+	case *ExprCall:
+		for i, x := range e.Arguments {
+			e.Arguments[i] = Optimize(x)
+		}
+		v, ok := input{}.call(
+			e.Function.Name, len(e.Arguments),
+			func(i int) (value, bool) {
+				switch x := e.Arguments[i].(type) {
+				case *Number, *String:
+					return constantValue(x)
+				case *Array:
+					l := make([]value, len(x.Items))
+					return value{kind: valueList, list: l}, true
+				}
+				return value{}, false
+			},
+		)
+		if !ok {
+			return e
+		}
+		n := &Number{Parent: e.Parent, LocRange: e.LocRange}
+		if v.kind == valueFloat {
+			n.Value, n.isFloat, n.vf = formatFloat(v.f), true, v.f
+		} else {
+			n.Value, n.vi = strconv.FormatInt(v.i, 10), int(v.i)
+		}
+		return n
 	case *ExprLogicalOr:
 		for i, x := range e.Expressions {
 			e.Expressions[i] = Optimize(x)
@@ -212,7 +297,7 @@ func Optimize(e Expression) Expression {
 				return &Number{
 					Parent:   e.Parent,
 					LocRange: e.LocRange,
-					Value:    fmt.Sprintf("%f", res),
+					Value:    formatFloat(res),
 					isFloat:  true,
 					vf:       res,
 				}
@@ -236,7 +321,7 @@ func Optimize(e Expression) Expression {
 				return &Number{
 					Parent:   e.Parent,
 					LocRange: e.LocRange,
-					Value:    fmt.Sprintf("%f", res),
+					Value:    formatFloat(res),
 					isFloat:  true,
 					vf:       res,
 				}
@@ -260,7 +345,7 @@ func Optimize(e Expression) Expression {
 				return &Number{
 					Parent:   e.Parent,
 					LocRange: e.LocRange,
-					Value:    fmt.Sprintf("%f", res),
+					Value:    formatFloat(res),
 					isFloat:  true,
 					vf:       res,
 				}
@@ -289,7 +374,7 @@ func Optimize(e Expression) Expression {
 				return &Number{
 					Parent:   e.Parent,
 					LocRange: e.LocRange,
-					Value:    fmt.Sprintf("%f", res),
+					Value:    formatFloat(res),
 					isFloat:  true,
 					vf:       res,
 				}
@@ -318,7 +403,7 @@ func Optimize(e Expression) Expression {
 				return &Number{
 					Parent:   e.Parent,
 					LocRange: e.LocRange,
-					Value:    fmt.Sprintf("%f", res),
+					Value:    formatFloat(res),
 					isFloat:  true,
 					vf:       res,
 				}
@@ -418,9 +503,17 @@ func Optimize(e Expression) Expression {
 		}
 		return e
 	case *Variable:
-		if c, ok := getVarDeclConstraint(e).(*ConstrEquals); ok {
-			cv := Optimize(c.Value)
-			return cv
+		c := getVarDeclConstraint(e)
+		for p, ok := c.(*ExprParentheses); ok; p, ok = c.(*ExprParentheses) {
+			c = p.Expression
+		}
+		if c, ok := c.(*ConstrEquals); ok {
+			// Only constants are inlined, a variable referring to
+			// another variable is kept since inlining it could produce
+			// a comparison of a variable with itself
+			if cv := Optimize(c.Value); isConstant(cv) {
+				return cv
+			}
 		}
 		return e
 	case *Enum:
@@ -429,13 +522,51 @@ func Optimize(e Expression) Expression {
 		return e
 	case *Object:
 		for i, x := range e.Fields {
-			e.Fields[i].Constraint = Optimize(x.Constraint)
+			if !x.Absent {
+				e.Fields[i].Constraint = Optimize(x.Constraint)
+			}
 		}
 		return e
 	}
 	panic(fmt.Errorf("unhandled type: %T", e))
 }
 
+// isConstant returns true if e is a value that doesn't refer to variables.
+func isConstant(e Expression) bool {
+	switch e := e.(type) {
+	case *String, *Number, *True, *False, *Enum, *Null:
+		return true
+	case *Array:
+		for _, x := range e.Items {
+			if c, ok := x.(*ConstrEquals); !ok || !isConstant(c.Value) {
+				return false
+			}
+		}
+		return true
+	case *Object:
+		if e.Open {
+			return false
+		}
+		for _, f := range e.Fields {
+			c, ok := f.Constraint.(*ConstrEquals)
+			if f.Absent || f.Optional || !ok || !isConstant(c.Value) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// formatFloat returns the shortest source of f that's parsed as Float f.
+func formatFloat(f float64) string {
+	s := strconv.FormatFloat(f, 'f', -1, 64)
+	if !strings.ContainsRune(s, '.') {
+		s += ".0"
+	}
+	return s
+}
+
 func isFloatOrInt(e Expression) bool {
 	_, ok := e.(*Number)
 	return ok
@@ -460,10 +591,12 @@ func getFloat(e Expression) float64 {
 }
 
 func comparableAndEqual(left, right Expression) (comparable, equal bool) {
-	if v, ok := right.(*Variable); ok {
-		if _, ok := getVarDeclConstraint(v).(*ConstrEquals); !ok {
-			return false, false
-		}
+	switch right.(type) {
+	case *String, *Number, *True, *False, *Enum, *Null, *Array:
+	default:
+		// Variables and expressions that couldn't be reduced
+		// are not comparable at optimization time.
+		return false, false
 	}
 
 	switch l := left.(type) {
@@ -0,0 +1,236 @@
+package gqt
+
+import (
+	"math/bits"
+
+	ast "github.com/vektah/gqlparser/v2/ast"
+)
+
+// TemplateSet is a set of template operations indexed by operation type
+// and the field paths of their selection sets, which allows finding
+// the templates accepting a request without evaluating all of them.
+//
+// TemplateSet is safe for concurrent use by multiple goroutines
+// as long as no templates are added concurrently.
+type TemplateSet struct {
+	templates []*Operation
+	programs  []*Program
+	roots     map[OperationType]*trieNode
+}
+
+// trieNode is a node of a trie over the field paths of templates.
+type trieNode struct {
+	children map[string]*trieNode
+
+	// templates is the set of templates that allow the path.
+	templates bitset
+
+	// recursive is the set of templates that recurse at the path
+	// and therefore allow any path below it.
+	recursive bitset
+}
+
+func newTrieNode() *trieNode {
+	return &trieNode{children: map[string]*trieNode{}}
+}
+
+// NewTemplateSet creates a new template set containing templates.
+// Returns an error if any of the templates fails to compile.
+func NewTemplateSet(templates ...*Operation) (*TemplateSet, error) {
+	s := &TemplateSet{roots: map[OperationType]*trieNode{}}
+	for _, t := range templates {
+		if err := s.Add(t); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Add adds template op to the set.
+// Returns an error if op fails to compile.
+func (s *TemplateSet) Add(op *Operation) error {
+	p, err := Compile(op)
+	if err != nil {
+		return err
+	}
+	id := len(s.templates)
+	s.templates = append(s.templates, op)
+	s.programs = append(s.programs, p)
+
+	root := s.roots[op.Type]
+	if root == nil {
+		root = newTrieNode()
+		s.roots[op.Type] = root
+	}
+	root.templates.set(id)
+	indexSelSet(root, op.SelectionSet, id)
+	return nil
+}
+
+// indexSelSet adds the paths of all fields in set to node n.
+// Inline fragments are not indexed. The selections of spread fragments
+// are indexed as if they were merged into set regardless of their type
+// condition, which only adds candidates.
+func indexSelSet(n *trieNode, set SelectionSet, id int) {
+	for _, s := range set.Selections {
+		switch s := s.(type) {
+		case *SelectionField:
+			c := n.children[s.Name.Name]
+			if c == nil {
+				c = newTrieNode()
+				n.children[s.Name.Name] = c
+			}
+			c.templates.set(id)
+			indexSelSet(c, s.SelectionSet, id)
+		case optionSet:
+			indexSelSet(n, *s.options(), id)
+		case *SelectionSpread:
+			indexSelSet(n, s.Fragment.SelectionSet, id)
+		case *SelectionRecurse:
+			n.recursive.set(id)
+		}
+	}
+}
+
+// Len returns the number of templates in the set.
+func (s *TemplateSet) Len() int { return len(s.templates) }
+
+// Match returns all templates of the set accepting the operation
+// of the GraphQL request document doc selected by operationName
+// in the order they were added. See Match for details.
+//
+// Only templates that allow all field paths selected by the request
+// are evaluated. Fields inside of fragments are not taken into account
+// when looking up the candidates and are checked during evaluation only.
+// Match returns an error if the request is malformed.
+func (s *TemplateSet) Match(
+	doc *ast.QueryDocument,
+	operationName string,
+	variables map[string]any,
+) ([]*Operation, error) {
+	o, err := findOperation(doc, operationName, variables)
+	if err != nil {
+		return nil, err
+	}
+	root := s.roots[operationType(o.Operation)]
+	if root == nil {
+		return nil, nil
+	}
+	candidates := root.templates.clone()
+	if !lookupSelSet(root, o.SelectionSet, candidates, nil) {
+		return nil, nil
+	}
+	var matched []*Operation
+	for w, word := range candidates {
+		for ; word != 0; word &= word - 1 {
+			id := w*64 + bits.TrailingZeros64(word)
+			ok, err := s.programs[id].Match(doc, operationName, variables)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				matched = append(matched, s.templates[id])
+			}
+		}
+	}
+	return matched, nil
+}
+
+// lookupSelSet removes all templates from candidates that don't allow
+// the field paths of sels except for the templates in recursive,
+// which allow any path. Returns false if there are no candidates left.
+func lookupSelSet(
+	n *trieNode, sels ast.SelectionSet, candidates, recursive bitset,
+) bool {
+	for _, s := range sels {
+		switch s := s.(type) {
+		case *ast.Field:
+			c := n.children[s.Name]
+			if c == nil {
+				if !candidates.intersect(recursive) {
+					return false
+				}
+				continue
+			}
+			if !candidates.intersectUnion(c.templates, recursive) {
+				return false
+			}
+			r := recursive
+			if len(c.recursive) > 0 {
+				r = c.recursive.union(recursive)
+			}
+			if !lookupSelSet(c, s.SelectionSet, candidates, r) {
+				return false
+			}
+		case *ast.InlineFragment:
+			if s.TypeCondition != "" {
+				// Fragments with type conditions may refer to
+				// inline fragments of the template.
+				continue
+			}
+			if !lookupSelSet(n, s.SelectionSet, candidates, recursive) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// bitset is a set of template ids.
+type bitset []uint64
+
+func (b *bitset) set(i int) {
+	for len(*b) <= i/64 {
+		*b = append(*b, 0)
+	}
+	(*b)[i/64] |= 1 << (i % 64)
+}
+
+func (b bitset) clone() bitset {
+	c := make(bitset, len(b))
+	copy(c, b)
+	return c
+}
+
+// union returns a new set containing the elements of both b and x.
+func (b bitset) union(x bitset) bitset {
+	if len(b) < len(x) {
+		b, x = x, b
+	}
+	u := b.clone()
+	for i := range x {
+		u[i] |= x[i]
+	}
+	return u
+}
+
+// intersectUnion removes all elements from b that are neither in x nor in y.
+// Returns false if b is empty after the intersection.
+func (b bitset) intersectUnion(x, y bitset) (nonEmpty bool) {
+	for i := range b {
+		var u uint64
+		if i < len(x) {
+			u = x[i]
+		}
+		if i < len(y) {
+			u |= y[i]
+		}
+		b[i] &= u
+		nonEmpty = nonEmpty || b[i] != 0
+	}
+	return nonEmpty
+}
+
+// intersect removes all elements from b that aren't in x.
+// Returns false if b is empty after the intersection.
+func (b bitset) intersect(x bitset) (nonEmpty bool) {
+	for i := range b {
+		if i < len(x) {
+			b[i] &= x[i]
+		} else {
+			b[i] = 0
+		}
+		nonEmpty = nonEmpty || b[i] != 0
+	}
+	return nonEmpty
+}
@@ -0,0 +1,160 @@
+package gqt_test
+
+import (
+	"testing"
+
+	"github.com/graph-guard/gqt/v4"
+	"github.com/graph-guard/gqt/v4/internal/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTemplateSet(t *testing.T) {
+	var templates []*gqt.Operation
+	for _, src := range []string{
+		`query { user(id: *) { name } }`,
+		`query { user(id: *) { name email } }`,
+		`query { user(id: < 10) { name friends(limit: < 5) { name } } }`,
+		`query { posts(limit: *) { title } }`,
+		`mutation { createPost(title: *) { id } }`,
+		`query { user(id: *) { max 1 { email phone } } }`,
+	} {
+		opr, _, errs := gqt.Parse([]byte(src))
+		require.Len(t, errs, 0, "unexpected errors: %v", errs)
+		templates = append(templates, opr)
+	}
+	s, err := gqt.NewTemplateSet(templates...)
+	require.NoError(t, err)
+	require.Equal(t, len(templates), s.Len())
+
+	type T struct {
+		query     string
+		variables map[string]any
+		expect    []int
+	}
+	f := test.New(t, func(t *testing.T, x T) {
+		m, err := s.Match(parseQuery(t, x.query), "", x.variables)
+		require.NoError(t, err)
+		var expect []*gqt.Operation
+		for _, i := range x.expect {
+			expect = append(expect, templates[i])
+		}
+		require.Equal(t, expect, m)
+	})
+
+	f(T{query: `{ user(id: 1) { name } }`, expect: []int{0, 1, 2}})
+	f(T{query: `{ user(id: 20) { name } }`, expect: []int{0, 1}})
+	f(T{query: `{ user(id: 1) { name email } }`, expect: []int{1}})
+	f(T{query: `{ user(id: 1) { email } }`, expect: []int{1, 5}})
+	f(T{query: `{ user(id: 1) { email phone } }`, expect: nil})
+	f(T{query: `{ user(id: 1) { ... { name } } }`, expect: []int{0, 1, 2}})
+	f(T{
+		query:  `{ user(id: 1) { friends(limit: 2) { name } } }`,
+		expect: []int{2},
+	})
+	f(T{query: `{ user(id: 1) { friends(limit: 2) { id } } }`, expect: nil})
+	f(T{query: `{ posts(limit: 10) { title } }`, expect: []int{3}})
+	f(T{query: `{ posts(limit: 10) { title } user(id: 1) { name } }`})
+	f(T{query: `mutation { createPost(title: "x") { id } }`, expect: []int{4}})
+	f(T{query: `subscription { user(id: 1) { name } }`, expect: nil})
+	f(T{
+		query:     `query ($id: ID) { user(id: $id) { name } }`,
+		variables: map[string]any{"id": 5},
+		expect:    []int{0, 1, 2},
+	})
+}
+
+func TestTemplateSetRecursion(t *testing.T) {
+	var templates []*gqt.Operation
+	for _, src := range []string{
+		`query { user(id: *) { friends(limit: *) { name } } }`,
+		`query { user(id: *) { friends(limit: *) { name ...recurse(max: 2) } } }`,
+		`query { user(id: *) { friends(limit: *) { friends(limit: *) { id } } } }`,
+	} {
+		opr, _, errs := gqt.Parse([]byte(src))
+		require.Len(t, errs, 0, "unexpected errors: %v", errs)
+		templates = append(templates, opr)
+	}
+	s, err := gqt.NewTemplateSet(templates...)
+	require.NoError(t, err)
+
+	type T struct {
+		query  string
+		expect []int
+	}
+	f := test.New(t, func(t *testing.T, x T) {
+		m, err := s.Match(parseQuery(t, x.query), "", nil)
+		require.NoError(t, err)
+		var expect []*gqt.Operation
+		for _, i := range x.expect {
+			expect = append(expect, templates[i])
+		}
+		require.Equal(t, expect, m)
+	})
+
+	f(T{
+		query:  `{ user(id: 1) { friends(limit: 2) { name } } }`,
+		expect: []int{0, 1},
+	})
+	f(T{
+		query:  `{ user(id: 1) { friends(limit: 2) { friends(limit: 2) { id } } } }`,
+		expect: []int{2},
+	})
+	f(T{
+		query: `{ user(id: 1) { friends(limit: 2) {
+			friends(limit: 2) { friends(limit: 2) { name } }
+		} } }`,
+		expect: []int{1},
+	})
+	f(T{
+		query: `{ user(id: 1) { friends(limit: 2) {
+			friends(limit: 2) { friends(limit: 2) { friends(limit: 2) { name } } }
+		} } }`,
+		expect: nil,
+	})
+}
+
+func TestTemplateSetErr(t *testing.T) {
+	s, err := gqt.NewTemplateSet()
+	require.NoError(t, err)
+	require.Zero(t, s.Len())
+
+	m, err := s.Match(nil, "", nil)
+	require.Error(t, err)
+	require.Equal(t, "missing query document", err.Error())
+	require.Nil(t, m)
+
+	_, err = gqt.NewTemplateSet(nil)
+	require.Error(t, err)
+	require.Equal(t, "missing operation", err.Error())
+}
+
+// TestTemplateSetMatchTests makes sure the template set accepts
+// exactly the same templates as Match for all match test requests.
+func TestTemplateSetMatchTests(t *testing.T) {
+	var templates []*gqt.Operation
+	var tests []MatchTest
+	forEachMatchTest(t, func(t *testing.T, ts MatchTest) {
+		_, opr := parseMatchTemplates(t, ts)
+		templates = append(templates, opr)
+		tests = append(tests, ts)
+	})
+	s, err := gqt.NewTemplateSet(templates...)
+	require.NoError(t, err)
+
+	for _, ts := range tests {
+		for _, r := range ts.Requests {
+			doc := parseQuery(t, r.Query)
+			var expect []*gqt.Operation
+			for _, opr := range templates {
+				ok, err := gqt.Match(opr, doc, r.OperationName, r.Variables)
+				require.NoError(t, err)
+				if ok {
+					expect = append(expect, opr)
+				}
+			}
+			m, err := s.Match(doc, r.OperationName, r.Variables)
+			require.NoError(t, err)
+			require.Equal(t, expect, m, "query: %s", r.Query)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package gqt
+
+import "fmt"
+
+// Visitor visits the expressions of an abstract syntax tree.
+// See Walk.
+type Visitor interface {
+	// Enter is called before the children of e are visited.
+	// The children of e are skipped if Enter returns false.
+	Enter(e Expression) (visitChildren bool)
+
+	// Leave is called after the children of e were visited
+	// or skipped.
+	Leave(e Expression)
+}
+
+// Walk traverses the tree under e in depth-first order calling v.Enter
+// before (pre-order) and v.Leave after (post-order) visiting the children
+// of every expression including e. Children are visited in the order
+// of their appearance in the source.
+func Walk(e Expression, v Visitor) {
+	if v.Enter(e) {
+		forEachChild(e, func(c Expression) { Walk(c, v) })
+	}
+	v.Leave(e)
+}
+
+// Rewrite replaces every expression x of the tree under e by fn(x) and
+// returns fn(e). The tree is rewritten in post-order, which means that
+// fn is called for an expression after its children were replaced.
+// fn must return x to keep it unchanged.
+//
+// The parent of every replacement is set to the expression containing it
+// and the children of a replacement are assigned to it, as well as
+// the associated variable declaration of a replaced argument or object field.
+// A replacement of e itself inherits the parent of e, yet the parent isn't
+// updated to refer to it. fn must return an expression that's allowed at
+// the position of x, which is an *Argument in argument lists,
+// a *Directive in directive lists, an *ObjectField in objects,
+// a *FragmentDefinition in the fragment definitions of an operation
+// and a Selection in selection sets, otherwise Rewrite panics.
+// Spreads keep referring to the replaced fragment definitions.
+func Rewrite(e Expression, fn func(Expression) Expression) Expression {
+	forEachChildRef(e, func(x *Expression) {
+		*x = Rewrite(*x, fn)
+		setParent(*x, e)
+	})
+
+	r := fn(e)
+	if r == e {
+		return r
+	}
+	if p := e.GetParent(); p != nil {
+		setParent(r, p)
+	}
+	forEachChild(r, func(c Expression) { setParent(c, r) })
+	switch r := r.(type) {
+	case *Argument:
+		if r.AssociatedVariable != nil {
+			r.AssociatedVariable.Parent = r
+		}
+	case *ObjectField:
+		if r.AssociatedVariable != nil {
+			r.AssociatedVariable.Parent = r
+		}
+	}
+	return r
+}
+
+// forEachChild calls fn for every child of e
+// in the order of their appearance in the source.
+func forEachChild(e Expression, fn func(Expression)) {
+	forEachChildRef(e, func(x *Expression) { fn(*x) })
+}
+
+// forEachChildRef calls fn with a reference to every child of e
+// in the order of their appearance in the source. The child is replaced
+// by the expression fn assigns to the reference, which panics
+// if the expression isn't allowed at the position of the child.
+func forEachChildRef(e Expression, fn func(*Expression)) {
+	switch e := e.(type) {
+	case *Operation:
+		// Fragments may be defined before and after the operation
+		for i, x := range e.Fragments {
+			if x.Location.Index < e.Location.Index {
+				ref(&e.Fragments[i], fn)
+			}
+		}
+		refs(e.Selections, fn)
+		for i, x := range e.Fragments {
+			if x.Location.Index >= e.Location.Index {
+				ref(&e.Fragments[i], fn)
+			}
+		}
+	case *FragmentDefinition:
+		refs(e.Selections, fn)
+	case *SelectionInlineFrag:
+		refs(e.Directives, fn)
+		refs(e.Selections, fn)
+	case optionSet:
+		refs(e.options().Selections, fn)
+	case *SelectionField:
+		refs(e.Arguments, fn)
+		refs(e.Directives, fn)
+		refs(e.Selections, fn)
+	case *Directive:
+		refs(e.Arguments, fn)
+	case *Argument:
+		if !e.Absent {
+			fn(&e.Constraint)
+		}
+	case *ObjectField:
+		if !e.Absent {
+			fn(&e.Constraint)
+		}
+	case *Object:
+		refs(e.Fields, fn)
+	case *Array:
+		refs(e.Items, fn)
+	case *ConstrEquals:
+		fn(&e.Value)
+	case *ConstrNotEquals:
+		fn(&e.Value)
+	case *ConstrLess:
+		fn(&e.Value)
+	case *ConstrLessOrEqual:
+		fn(&e.Value)
+	case *ConstrGreater:
+		fn(&e.Value)
+	case *ConstrGreaterOrEqual:
+		fn(&e.Value)
+	case *ConstrLenEquals:
+		fn(&e.Value)
+	case *ConstrLenNotEquals:
+		fn(&e.Value)
+	case *ConstrLenLess:
+		fn(&e.Value)
+	case *ConstrLenLessOrEqual:
+		fn(&e.Value)
+	case *ConstrLenGreater:
+		fn(&e.Value)
+	case *ConstrLenGreaterOrEqual:
+		fn(&e.Value)
+	case *ConstrMatches:
+		fn(&e.Value)
+	case *ConstrIn:
+		refs(e.Values, fn)
+	case *ConstrNotIn:
+		refs(e.Values, fn)
+	case *ConstrContains:
+		fn(&e.Value)
+	case *ConstrSum:
+		fn(&e.Constraint)
+	case *ConstrCount:
+		fn(&e.Where)
+		fn(&e.Constraint)
+	case *ConstrMap:
+		fn(&e.Constraint)
+	case *ConstrAlias:
+		fn(&e.Constraint)
+	case *ExprParentheses:
+		fn(&e.Expression)
+	case *ExprLogicalNegation:
+		fn(&e.Expression)
+	case *ExprNumericNegation:
+		fn(&e.Expression)
+	case *ExprCall:
+		refs(e.Arguments, fn)
+	case *ExprLogicalOr:
+		refs(e.Expressions, fn)
+	case *ExprLogicalAnd:
+		refs(e.Expressions, fn)
+	case *ExprEqual:
+		fn(&e.Left)
+		fn(&e.Right)
+	case *ExprNotEqual:
+		fn(&e.Left)
+		fn(&e.Right)
+	case *ExprLess:
+		fn(&e.Left)
+		fn(&e.Right)
+	case *ExprLessOrEqual:
+		fn(&e.Left)
+		fn(&e.Right)
+	case *ExprGreater:
+		fn(&e.Left)
+		fn(&e.Right)
+	case *ExprGreaterOrEqual:
+		fn(&e.Left)
+		fn(&e.Right)
+	case *ExprAddition:
+		fn(&e.AddendLeft)
+		fn(&e.AddendRight)
+	case *ExprSubtraction:
+		fn(&e.Minuend)
+		fn(&e.Subtrahend)
+	case *ExprMultiplication:
+		fn(&e.Multiplicant)
+		fn(&e.Multiplicator)
+	case *ExprDivision:
+		fn(&e.Dividend)
+		fn(&e.Divisor)
+	case *ExprModulo:
+		fn(&e.Dividend)
+		fn(&e.Divisor)
+	case *Variable, *Number, *True, *False, *Null,
+		*Enum, *String, *ConstrAny, *ConstrUnique, *SelectionRecurse,
+		*SelectionSpread:
+	default:
+		panic(fmt.Errorf("unhandled type: %T", e))
+	}
+}
+
+// ref calls fn with a reference to the child at x of type T
+// and assigns the replacement to x only if it's a different expression,
+// which keeps walking the tree free of writes.
+func ref[T Expression](x *T, fn func(*Expression)) {
+	c := Expression(*x)
+	fn(&c)
+	if c != Expression(*x) {
+		*x = c.(T)
+	}
+}
+
+// refs calls ref for every child in l.
+func refs[T Expression](l []T, fn func(*Expression)) {
+	for i := range l {
+		ref(&l[i], fn)
+	}
+}
+
+// inspector is a Visitor calling itself on enter.
+type inspector func(Expression) bool
+
+func (f inspector) Enter(e Expression) bool { return f(e) }
+func (f inspector) Leave(Expression)        {}
@@ -0,0 +1,180 @@
+package gqt_test
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/graph-guard/gqt/v4"
+	"github.com/stretchr/testify/require"
+)
+
+// recorder is a gqt.Visitor recording the order of visits.
+type recorder struct {
+	log  []string
+	skip func(gqt.Expression) bool
+}
+
+func (r *recorder) Enter(e gqt.Expression) bool {
+	r.log = append(r.log, fmt.Sprintf("enter %T", e))
+	return r.skip == nil || !r.skip(e)
+}
+
+func (r *recorder) Leave(e gqt.Expression) {
+	r.log = append(r.log, fmt.Sprintf("leave %T", e))
+}
+
+func TestWalk(t *testing.T) {
+	opr, _, errs := gqt.Parse([]byte(
+		`query { a(x: 1 + 2, y: {z: [*]}) { b } }`,
+	))
+	require.Len(t, errs, 0, "unexpected errors: %v", errs)
+
+	var r recorder
+	gqt.Walk(opr, &r)
+	require.Equal(t, []string{
+		"enter *gqt.Operation",
+		"enter *gqt.SelectionField",
+		"enter *gqt.Argument",
+		"enter *gqt.ConstrEquals",
+		"enter *gqt.ExprAddition",
+		"enter *gqt.Number",
+		"leave *gqt.Number",
+		"enter *gqt.Number",
+		"leave *gqt.Number",
+		"leave *gqt.ExprAddition",
+		"leave *gqt.ConstrEquals",
+		"leave *gqt.Argument",
+		"enter *gqt.Argument",
+		"enter *gqt.ConstrEquals",
+		"enter *gqt.Object",
+		"enter *gqt.ObjectField",
+		"enter *gqt.ConstrEquals",
+		"enter *gqt.Array",
+		"enter *gqt.ConstrAny",
+		"leave *gqt.ConstrAny",
+		"leave *gqt.Array",
+		"leave *gqt.ConstrEquals",
+		"leave *gqt.ObjectField",
+		"leave *gqt.Object",
+		"leave *gqt.ConstrEquals",
+		"leave *gqt.Argument",
+		"enter *gqt.SelectionField",
+		"leave *gqt.SelectionField",
+		"leave *gqt.SelectionField",
+		"leave *gqt.Operation",
+	}, r.log)
+}
+
+func TestWalkSkip(t *testing.T) {
+	opr, _, errs := gqt.Parse([]byte(`query { a(x: 1) { b } c }`))
+	require.Len(t, errs, 0, "unexpected errors: %v", errs)
+
+	r := recorder{skip: func(e gqt.Expression) bool {
+		_, ok := e.(*gqt.SelectionField)
+		return ok
+	}}
+	gqt.Walk(opr, &r)
+	require.Equal(t, []string{
+		"enter *gqt.Operation",
+		"enter *gqt.SelectionField",
+		"leave *gqt.SelectionField",
+		"enter *gqt.SelectionField",
+		"leave *gqt.SelectionField",
+		"leave *gqt.Operation",
+	}, r.log)
+}
+
+// parentChecker is a gqt.Visitor checking that
+// the parent of every expression is the enclosing expression.
+type parentChecker struct {
+	t     *testing.T
+	stack []gqt.Expression
+}
+
+func (c *parentChecker) Enter(e gqt.Expression) bool {
+	if len(c.stack) > 0 {
+		require.Equal(c.t, c.stack[len(c.stack)-1], e.GetParent(),
+			"parent of %T", e)
+	}
+	c.stack = append(c.stack, e)
+	return true
+}
+
+func (c *parentChecker) Leave(gqt.Expression) {
+	c.stack = c.stack[:len(c.stack)-1]
+}
+
+func TestRewrite(t *testing.T) {
+	opr, _, errs := gqt.Parse([]byte(
+		`query { a(x: 1 + 2, y: {z: [1, -3]}) { b } max 1 { c d } }`,
+	))
+	require.Len(t, errs, 0, "unexpected errors: %v", errs)
+
+	// Replace all numbers by their doubles and all arguments
+	// named y by arguments named z.
+	var visited int
+	r := gqt.Rewrite(opr, func(e gqt.Expression) gqt.Expression {
+		visited++
+		switch e := e.(type) {
+		case *gqt.Number:
+			i, _ := e.Int()
+			return &gqt.Number{
+				LocRange: e.LocRange,
+				Value:    strconv.Itoa(i * 2),
+			}
+		case *gqt.Argument:
+			if e.Name.Name == "y" {
+				c := *e
+				c.Name.Name = "z"
+				return &c
+			}
+		}
+		return e
+	})
+	require.Equal(t, opr, r)
+	require.Equal(t, 21, visited)
+	gqt.Walk(r, &parentChecker{t: t})
+
+	var b bytes.Buffer
+	require.NoError(t, gqt.Format(&b, r, gqt.FormatOptions{}))
+	require.Equal(t, "query {\n"+
+		"  a(x: 2 + 4, z: {z: [2, -6]}) {\n"+
+		"    b\n"+
+		"  }\n"+
+		"  max 1 {\n"+
+		"    c\n"+
+		"    d\n"+
+		"  }\n"+
+		"}\n", b.String())
+}
+
+func TestRewriteRoot(t *testing.T) {
+	opr, _, errs := gqt.Parse([]byte(`query { a(x: 1 + 2) }`))
+	require.Len(t, errs, 0, "unexpected errors: %v", errs)
+
+	arg := opr.Selections[0].(*gqt.SelectionField).Arguments[0]
+	eq := arg.Constraint.(*gqt.ConstrEquals)
+	r := gqt.Rewrite(eq.Value, func(e gqt.Expression) gqt.Expression {
+		if _, ok := e.(*gqt.ExprAddition); ok {
+			return &gqt.True{LocRange: e.GetLocation()}
+		}
+		return e
+	})
+	require.IsType(t, &gqt.True{}, r)
+	require.Equal(t, eq, r.GetParent())
+}
+
+func TestRewritePanic(t *testing.T) {
+	opr, _, errs := gqt.Parse([]byte(`query { a(x: 1) }`))
+	require.Len(t, errs, 0, "unexpected errors: %v", errs)
+	require.Panics(t, func() {
+		gqt.Rewrite(opr, func(e gqt.Expression) gqt.Expression {
+			if _, ok := e.(*gqt.Argument); ok {
+				return &gqt.True{}
+			}
+			return e
+		})
+	})
+}
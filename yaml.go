@@ -64,13 +64,77 @@ func (s SelectionSet) MarshalYAML() (any, error) {
 
 func (o *Operation) MarshalYAML() (any, error) {
 	return struct {
-		Location      LocRange     `yaml:"location"`
-		OperationType string       `yaml:"operationType"`
-		SelectionSet  SelectionSet `yaml:"selectionSet,omitempty"`
+		Location      LocRange                 `yaml:"location"`
+		Imports       []*Import                `yaml:"imports,omitempty"`
+		ConstrAliases []*ConstrAliasDefinition `yaml:"constraintAliases,omitempty"`
+		Metadata      *Metadata                `yaml:"metadata,omitempty"`
+		OperationType string                   `yaml:"operationType"`
+		Name          *Name                    `yaml:"name,omitempty"`
+		MaxDepth      int                      `yaml:"maxDepth,omitempty"`
+		SelectionSet  SelectionSet             `yaml:"selectionSet,omitempty"`
+		Fragments     []*FragmentDefinition    `yaml:"fragments,omitempty"`
 	}{
 		Location:      o.LocRange,
+		Imports:       o.Imports,
+		ConstrAliases: o.ConstrAliases,
+		Metadata:      o.Metadata,
 		OperationType: o.Type.String(),
+		Name:          o.Name,
+		MaxDepth:      o.MaxDepth,
 		SelectionSet:  o.SelectionSet,
+		Fragments:     o.Fragments,
+	}, nil
+}
+
+func (m *Metadata) MarshalYAML() (any, error) {
+	return struct {
+		Location    LocRange `yaml:"location"`
+		Description string   `yaml:"description,omitempty"`
+		Owner       string   `yaml:"owner,omitempty"`
+		Tags        []string `yaml:"tags,omitempty"`
+	}{
+		Location:    m.LocRange,
+		Description: m.Description,
+		Owner:       m.Owner,
+		Tags:        m.Tags,
+	}, nil
+}
+
+func (i *Import) MarshalYAML() (any, error) {
+	return struct {
+		Location LocRange `yaml:"location"`
+		Path     string   `yaml:"path"`
+	}{
+		Location: i.LocRange,
+		Path:     i.Path,
+	}, nil
+}
+
+func (d *ConstrAliasDefinition) MarshalYAML() (any, error) {
+	return struct {
+		Location   LocRange   `yaml:"location"`
+		Name       Name       `yaml:"name"`
+		Constraint Expression `yaml:"constraint"`
+	}{
+		Location:   d.LocRange,
+		Name:       d.Name,
+		Constraint: d.Constraint,
+	}, nil
+}
+
+func (d *FragmentDefinition) MarshalYAML() (any, error) {
+	return struct {
+		Location      LocRange      `yaml:"location"`
+		Name          Name          `yaml:"name"`
+		File          string        `yaml:"file,omitempty"`
+		TypeCondition TypeCondition `yaml:"typeCondition"`
+		SelectionSet  SelectionSet  `yaml:"selectionSet"`
+	}{
+		Location:      d.LocRange,
+		Name:          d.Name,
+		File:          d.File,
+		TypeCondition: d.TypeCondition,
+		SelectionSet:  d.SelectionSet,
 	}, nil
 }
 
@@ -228,6 +292,90 @@ func (c *ConstrLenGreaterOrEqual) MarshalYAML() (any, error) {
 	}, nil
 }
 
+func (c *ConstrMatches) MarshalYAML() (any, error) {
+	return struct {
+		Location       LocRange   `yaml:"location"`
+		ConstraintType string     `yaml:"constraintType"`
+		Value          Expression `yaml:"value"`
+	}{
+		Location:       c.LocRange,
+		ConstraintType: "matches",
+		Value:          c.Value,
+	}, nil
+}
+
+func (c *ConstrIn) MarshalYAML() (any, error) {
+	return struct {
+		Location       LocRange     `yaml:"location"`
+		ConstraintType string       `yaml:"constraintType"`
+		Values         []Expression `yaml:"values"`
+	}{
+		Location:       c.LocRange,
+		ConstraintType: "in",
+		Values:         c.Values,
+	}, nil
+}
+
+func (c *ConstrNotIn) MarshalYAML() (any, error) {
+	return struct {
+		Location       LocRange     `yaml:"location"`
+		ConstraintType string       `yaml:"constraintType"`
+		Values         []Expression `yaml:"values"`
+	}{
+		Location:       c.LocRange,
+		ConstraintType: "notIn",
+		Values:         c.Values,
+	}, nil
+}
+
+func (c *ConstrUnique) MarshalYAML() (any, error) {
+	return struct {
+		Location       LocRange `yaml:"location"`
+		ConstraintType string   `yaml:"constraintType"`
+	}{
+		Location:       c.LocRange,
+		ConstraintType: "unique",
+	}, nil
+}
+
+func (c *ConstrContains) MarshalYAML() (any, error) {
+	return struct {
+		Location       LocRange   `yaml:"location"`
+		ConstraintType string     `yaml:"constraintType"`
+		Value          Expression `yaml:"value"`
+	}{
+		Location:       c.LocRange,
+		ConstraintType: "contains",
+		Value:          c.Value,
+	}, nil
+}
+
+func (c *ConstrSum) MarshalYAML() (any, error) {
+	return struct {
+		Location       LocRange   `yaml:"location"`
+		ConstraintType string     `yaml:"constraintType"`
+		Constraint     Expression `yaml:"constraint"`
+	}{
+		Location:       c.LocRange,
+		ConstraintType: "sum",
+		Constraint:     c.Constraint,
+	}, nil
+}
+
+func (c *ConstrCount) MarshalYAML() (any, error) {
+	return struct {
+		Location       LocRange   `yaml:"location"`
+		ConstraintType string     `yaml:"constraintType"`
+		Where          Expression `yaml:"where"`
+		Constraint     Expression `yaml:"constraint"`
+	}{
+		Location:       c.LocRange,
+		ConstraintType: "count",
+		Where:          c.Where,
+		Constraint:     c.Constraint,
+	}, nil
+}
+
 func (c *ConstrMap) MarshalYAML() (any, error) {
 	return struct {
 		Location       LocRange   `yaml:"location"`
@@ -240,6 +388,22 @@ func (c *ConstrMap) MarshalYAML() (any, error) {
 	}, nil
 }
 
+func (c *ConstrAlias) MarshalYAML() (any, error) {
+	return struct {
+		Location       LocRange   `yaml:"location"`
+		ConstraintType string     `yaml:"constraintType"`
+		Name           Name       `yaml:"name"`
+		File           string     `yaml:"file,omitempty"`
+		Constraint     Expression `yaml:"constraint"`
+	}{
+		Location:       c.LocRange,
+		ConstraintType: "alias",
+		Name:           c.Name,
+		File:           c.File,
+		Constraint:     c.Constraint,
+	}, nil
+}
+
 func (e *ExprParentheses) MarshalYAML() (any, error) {
 	return struct {
 		Location       LocRange   `yaml:"location"`
@@ -356,6 +520,22 @@ func (e *ExprNumericNegation) MarshalYAML() (any, error) {
 	}, nil
 }
 
+func (e *ExprCall) MarshalYAML() (any, error) {
+	return struct {
+		Location       LocRange     `yaml:"location"`
+		ExpressionType string       `yaml:"expressionType"`
+		Float          bool         `yaml:"float"`
+		Function       Name         `yaml:"function"`
+		Arguments      []Expression `yaml:"arguments"`
+	}{
+		Location:       e.LocRange,
+		ExpressionType: "call",
+		Float:          e.Float,
+		Function:       e.Function,
+		Arguments:      e.Arguments,
+	}, nil
+}
+
 func (e *ExprEqual) MarshalYAML() (any, error) {
 	return struct {
 		Location       LocRange   `yaml:"location"`
@@ -611,11 +791,13 @@ func (o *Object) MarshalYAML() (any, error) {
 		ExpressionType string         `yaml:"expressionType"`
 		Type           string         `yaml:"type,omitempty"`
 		Fields         []*ObjectField `yaml:"fields"`
+		Open           bool           `yaml:"open,omitempty"`
 	}{
 		Location:       o.LocRange,
 		ExpressionType: "object",
 		Type:           t,
 		Fields:         o.Fields,
+		Open:           o.Open,
 	}, nil
 }
 
@@ -636,11 +818,13 @@ func (s *SelectionInlineFrag) MarshalYAML() (any, error) {
 		Location      LocRange      `yaml:"location"`
 		SelectionType string        `yaml:"selectionType"`
 		TypeCondition TypeCondition `yaml:"typeCondition"`
+		Directives    []*Directive  `yaml:"directives,omitempty"`
 		SelectionsSet SelectionSet  `yaml:"selectionSet,omitempty"`
 	}{
 		Location:      s.LocRange,
 		SelectionType: "inlineFragment",
 		TypeCondition: s.TypeCondition,
+		Directives:    s.Directives,
 		SelectionsSet: s.SelectionSet,
 	}, nil
 }
@@ -666,12 +850,16 @@ func (f *ObjectField) MarshalYAML() (any, error) {
 		Name       Name       `yaml:"name"`
 		Variable   *Var       `yaml:"variable,omitempty"`
 		Type       string     `yaml:"type,omitempty"`
-		Constraint Expression `yaml:"constraint"`
+		Optional   bool       `yaml:"optional,omitempty"`
+		Absent     bool       `yaml:"absent,omitempty"`
+		Constraint Expression `yaml:"constraint,omitempty"`
 	}{
 		Location:   f.LocRange,
 		Name:       f.Name,
 		Variable:   v,
 		Type:       t,
+		Optional:   f.Optional,
+		Absent:     f.Absent,
 		Constraint: f.Constraint,
 	}, nil
 }
@@ -694,20 +882,50 @@ func (s *SelectionField) MarshalYAML() (any, error) {
 	return struct {
 		Location      LocRange     `yaml:"location"`
 		SelectionType string       `yaml:"selectionType"`
+		Alias         *Alias       `yaml:"alias,omitempty"`
 		Name          Name         `yaml:"name"`
 		Type          string       `yaml:"type,omitempty"`
+		Required      bool         `yaml:"required,omitempty"`
 		ArgumentList  ArgumentList `yaml:"argumentList,omitempty"`
+		Directives    []*Directive `yaml:"directives,omitempty"`
 		SelectionSet  SelectionSet `yaml:"selectionSet,omitempty"`
 	}{
 		Location:      s.LocRange,
 		SelectionType: "field",
+		Alias:         s.Alias,
 		Name:          s.Name,
 		Type:          t,
+		Required:      s.Required,
 		ArgumentList:  s.ArgumentList,
+		Directives:    s.Directives,
 		SelectionSet:  s.SelectionSet,
 	}, nil
 }
 
+func (d *Directive) MarshalYAML() (any, error) {
+	return struct {
+		Location     LocRange     `yaml:"location"`
+		Name         Name         `yaml:"name"`
+		ArgumentList ArgumentList `yaml:"argumentList,omitempty"`
+	}{
+		Location:     d.LocRange,
+		Name:         d.Name,
+		ArgumentList: d.ArgumentList,
+	}, nil
+}
+
+func (a *Alias) MarshalYAML() (any, error) {
+	return struct {
+		Location LocRange `yaml:"location"`
+		Pattern  string   `yaml:"pattern"`
+		Limit    int      `yaml:"limit,omitempty"`
+	}{
+		Location: a.LocRange,
+		Pattern:  a.Pattern,
+		Limit:    a.Limit,
+	}, nil
+}
+
 func (e *SelectionMax) MarshalYAML() (any, error) {
 	return struct {
 		Location      LocRange     `yaml:"location"`
@@ -722,6 +940,58 @@ func (e *SelectionMax) MarshalYAML() (any, error) {
 	}, nil
 }
 
+func (e *SelectionMin) MarshalYAML() (any, error) {
+	return struct {
+		Location      LocRange     `yaml:"location"`
+		SelectionType string       `yaml:"selectionType"`
+		Limit         int          `yaml:"limit"`
+		Options       SelectionSet `yaml:"options"`
+	}{
+		Location:      e.LocRange,
+		SelectionType: "min",
+		Limit:         e.Limit,
+		Options:       e.Options,
+	}, nil
+}
+
+func (e *SelectionExactly) MarshalYAML() (any, error) {
+	return struct {
+		Location      LocRange     `yaml:"location"`
+		SelectionType string       `yaml:"selectionType"`
+		Limit         int          `yaml:"limit"`
+		Options       SelectionSet `yaml:"options"`
+	}{
+		Location:      e.LocRange,
+		SelectionType: "exactly",
+		Limit:         e.Limit,
+		Options:       e.Options,
+	}, nil
+}
+
+func (e *SelectionRecurse) MarshalYAML() (any, error) {
+	return struct {
+		Location      LocRange `yaml:"location"`
+		SelectionType string   `yaml:"selectionType"`
+		Max           int      `yaml:"max"`
+	}{
+		Location:      e.LocRange,
+		SelectionType: "recurse",
+		Max:           e.Max,
+	}, nil
+}
+
+func (e *SelectionSpread) MarshalYAML() (any, error) {
+	return struct {
+		Location      LocRange `yaml:"location"`
+		SelectionType string   `yaml:"selectionType"`
+		Name          Name     `yaml:"name"`
+	}{
+		Location:      e.LocRange,
+		SelectionType: "spread",
+		Name:          e.Name,
+	}, nil
+}
+
 func (a *Argument) MarshalYAML() (any, error) {
 	var t string
 	if a.Def != nil {
@@ -743,12 +1013,16 @@ func (a *Argument) MarshalYAML() (any, error) {
 		Name       Name       `yaml:"name"`
 		Variable   *Var       `yaml:"variable,omitempty"`
 		Type       string     `yaml:"type,omitempty"`
-		Constraint Expression `yaml:"constraint"`
+		Optional   bool       `yaml:"optional,omitempty"`
+		Absent     bool       `yaml:"absent,omitempty"`
+		Constraint Expression `yaml:"constraint,omitempty"`
 	}{
 		Location:   a.LocRange,
 		Name:       a.Name,
 		Variable:   v,
 		Type:       t,
+		Optional:   a.Optional,
+		Absent:     a.Absent,
 		Constraint: a.Constraint,
 	}, nil
 }